@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/internal/appconfig"
+	"github.com/funcube-dev/go/service/combine"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/posflag"
+	flag "github.com/spf13/pflag"
+)
+
+func main() {
+	config := readConfiguration(os.Args[1:])
+	log.Printf("Using Config:\n%s\n", appconfig.SprintSafe(config))
+
+	settings, err := loadSettings(config)
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+	service, err := combine.New(settings)
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	ctx, cancel := fcio.NotifyContext(context.Background())
+	defer cancel()
+	if err := service.Run(ctx); err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// loadSettings the combine settings from the configuration
+func loadSettings(config *koanf.Koanf) (combine.Config, error) {
+	settings := combine.DefaultConfig()
+	err := config.Unmarshal("", &settings)
+	return settings, err
+}
+
+func readConfiguration(args []string) *koanf.Koanf {
+	var konf = koanf.New(".")
+
+	_ = konf.Load(env.Provider("COMB_", ".", func(s string) string {
+		return strings.Replace(strings.ToLower(
+			strings.TrimPrefix(s, "COMB_")), "_", ".", -1)
+	}), nil)
+
+	for _, fileName := range []string{"/config/fccombine.conf", "./fccombine.conf"} {
+		if _, err := os.Stat(fileName); err == nil {
+			if err := konf.Load(file.Provider(fileName), toml.Parser()); err != nil {
+				log.Fatalf("error loading config: %v", err)
+			}
+		}
+	}
+
+	flags := flag.NewFlagSet("fccombine", flag.ExitOnError)
+	flags.String("bindaddress", "0.0.0.0", "Address to bind for TCP listen sockets")
+	flags.Int("dataport", int(0xFC0A), "Port for incomming soft symbol blocks from the receivers")
+	flags.Int("commandport", int(0xFC0B), "Port for incomming commands")
+	flags.StringSlice("connectlocations", []string{}, "Locations (host:port) to send the frames decoded to, fcwarehouse for one")
+	flags.Float64("window", 2, "Seconds each block is held for copies from the other receivers before it is decoded")
+	flags.Float64("alignment", 1, "Seconds apart the receivers' copies of a block can start, by their clocks")
+	flags.Float64("minsync", 0.4, "Sync vector correlation (0 to 1) below which a block is not used")
+	flags.Float64("shutdowntimeout", 5, "Seconds to spend sending frames already decoded when asked to stop")
+	_ = flags.Parse(args)
+
+	if err := konf.Load(posflag.Provider(flags, ".", konf), nil); err != nil {
+		log.Fatalf("error loading config: %v", err)
+	}
+
+	return konf
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/funcube-dev/go/service/combine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSettings(t *testing.T) {
+	settings, err := loadSettings(readConfiguration(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, combine.DefaultConfig(), settings, "flag defaults match the service defaults")
+
+	settings, err = loadSettings(readConfiguration([]string{"--connectlocations", "warehouse:64518", "--minsync", "0.5"}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"warehouse:64518"}, settings.ConnectLocations)
+	assert.Equal(t, 0.5, settings.MinSync)
+}
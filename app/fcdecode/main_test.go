@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/funcube-dev/go/internal/appconfig"
+	"github.com/funcube-dev/go/service/decode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSettings(t *testing.T) {
+	settings, err := loadSettings(readConfiguration(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, decode.DefaultConfig(), settings, "flag defaults match the service defaults")
+
+	settings, err = loadSettings(readConfiguration([]string{"--exclude", "145936000,145937000", "--connectlocations", "warehouse:64518"}))
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{145936000, 145937000}, settings.Exclude)
+	assert.Equal(t, []string{"warehouse:64518"}, settings.ConnectLocations)
+}
+
+func TestConfigSprintSafe(t *testing.T) {
+	printed := appconfig.SprintSafe(readConfiguration([]string{"--hubstation", "G0ABC", "--hubkey", "secret"}))
+	assert.Contains(t, printed, "hubstation -> G0ABC\n")
+	assert.Contains(t, printed, "hubkey -> ********et\n")
+	assert.NotContains(t, printed, "secret")
+}
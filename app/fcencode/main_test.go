@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/funcube-dev/go/service/encode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSettings(t *testing.T) {
+	settings, err := loadSettings(readConfiguration(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, encode.DefaultConfig(), settings, "flag defaults match the service defaults")
+
+	settings, err = loadSettings(readConfiguration([]string{"--limetxserver", "lime.local", "--loopfile"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "lime.local", settings.LimeTxServer)
+	assert.True(t, settings.LoopFile)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/internal/appconfig"
+	"github.com/funcube-dev/go/service/hub"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/posflag"
+	flag "github.com/spf13/pflag"
+)
+
+func main() {
+	config := readConfiguration(os.Args[1:])
+	log.Printf("Using Config:\n%s\n", appconfig.SprintSafe(config))
+
+	settings, err := loadSettings(config)
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+	service, err := hub.New(settings)
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	ctx, cancel := fcio.NotifyContext(context.Background())
+	defer cancel()
+	if err := service.Run(ctx); err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// loadSettings the hub settings from the configuration
+func loadSettings(config *koanf.Koanf) (hub.Config, error) {
+	settings := hub.DefaultConfig()
+	err := config.Unmarshal("", &settings)
+	return settings, err
+}
+
+func readConfiguration(args []string) *koanf.Koanf {
+	var konf = koanf.New(".")
+
+	_ = konf.Load(env.Provider("HUB_", ".", func(s string) string {
+		return strings.Replace(strings.ToLower(
+			strings.TrimPrefix(s, "HUB_")), "_", ".", -1)
+	}), nil)
+
+	for _, fileName := range []string{"/config/fchub.conf", "./fchub.conf"} {
+		if _, err := os.Stat(fileName); err == nil {
+			if err := konf.Load(file.Provider(fileName), toml.Parser()); err != nil {
+				log.Fatalf("error loading config: %v", err)
+			}
+		}
+	}
+
+	flags := flag.NewFlagSet("fchub", flag.ExitOnError)
+	flags.String("siteid", "", "Site Id for data warehouse, frames heard best by a station without its own are submitted as it")
+	flags.String("authcode", "", "Authentication code for data warehouse")
+	flags.String("url", "http://data.amsat-uk.org/", "Url for submitting to data warehouse")
+	flags.Int("retryattempts", 3, "Number of warehouse submission retries before moving on to next frame")
+	flags.Int("retrywaitseconds", 60, "Time to wait between retry attempts")
+	flags.String("bindaddress", "0.0.0.0", "Address to bind for TCP listen sockets")
+	flags.Int("dataport", int(0xFC08), "Port for incomming station records from fcdecode")
+	flags.Int("commandport", int(0xFC09), "Port for incomming commands")
+	flags.Float64("window", 5, "Seconds each frame is held for copies from the other stations before it is submitted")
+	flags.Float64("replaywindow", 600, "Seconds either side of the hub's clock a station record must be timed, a record received again within them is rejected as replayed")
+	flags.Float64("passgap", 600, "Seconds without a frame from any station that end a pass")
+	flags.Int("history", 100, "Number of frames submitted kept for the api")
+	flags.Float64("shutdowntimeout", 5, "Seconds to spend sending frames already received when asked to stop")
+	_ = flags.Parse(args)
+
+	if err := konf.Load(posflag.Provider(flags, ".", konf), nil); err != nil {
+		log.Fatalf("error loading config: %v", err)
+	}
+
+	return konf
+}
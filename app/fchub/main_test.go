@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/funcube-dev/go/internal/appconfig"
+	"github.com/funcube-dev/go/service/hub"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSettings(t *testing.T) {
+	settings, err := loadSettings(readConfiguration(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, hub.DefaultConfig(), settings, "flag defaults match the service defaults")
+
+	config := readConfiguration([]string{"--siteid", "HUB", "--window", "2.5"})
+	conf := filepath.Join(t.TempDir(), "fchub.conf")
+	station := "[stations.G0ABC]\nkey = \"abc-key\"\nsiteid = \"G0ABC\"\nauthcode = \"abcsecret\"\n"
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(station), 0o600))
+	assert.NoError(t, config.Load(file.Provider(conf), toml.Parser()))
+	settings, err = loadSettings(config)
+	assert.NoError(t, err)
+	assert.Equal(t, "HUB", settings.SiteID)
+	assert.Equal(t, 2.5, settings.Window)
+	assert.Equal(t, map[string]hub.StationConfig{"G0ABC": {Key: "abc-key", SiteID: "G0ABC", AuthCode: "abcsecret"}}, settings.Stations)
+
+	printed := appconfig.SprintSafe(config)
+	assert.Contains(t, printed, "stations.G0ABC.siteid -> G0ABC")
+	assert.False(t, strings.Contains(printed, "abc-key") || strings.Contains(printed, "abcsecret"), printed)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/funcube-dev/go/fcorbit"
+	"github.com/funcube-dev/go/internal/appconfig"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/posflag"
+	flag "github.com/spf13/pflag"
+)
+
+func main() {
+	config := readConfiguration(os.Args[1:])
+	log.Printf("Using Config:\n%s\n", appconfig.SprintSafe(config))
+
+	settings, err := loadSettings(config)
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if settings.Output != "" {
+		f, err := os.Create(settings.Output)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", settings.Output, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	dataset, stats, err := fcorbit.Export(settings, out)
+	if err != nil {
+		log.Fatalf("Failed to export: %v", err)
+	}
+	log.Printf("Rebuilt %s from %d frames in %d cycles: %d records, %d missing, %d conflicting",
+		dataset.Name, stats.Frames, stats.Cycles, dataset.Records, dataset.Missing, dataset.Conflicts)
+}
+
+// loadSettings the export settings from the configuration
+func loadSettings(config *koanf.Koanf) (fcorbit.Config, error) {
+	settings := fcorbit.DefaultConfig()
+	err := config.Unmarshal("", &settings)
+	return settings, err
+}
+
+func readConfiguration(args []string) *koanf.Koanf {
+	var konf = koanf.New(".")
+
+	_ = konf.Load(env.Provider("ORB_", ".", func(s string) string {
+		return strings.Replace(strings.ToLower(
+			strings.TrimPrefix(s, "ORB_")), "_", ".", -1)
+	}), nil)
+
+	for _, fileName := range []string{"/config/fcorbit.conf", "./fcorbit.conf"} {
+		if _, err := os.Stat(fileName); err == nil {
+			if err := konf.Load(file.Provider(fileName), toml.Parser()); err != nil {
+				log.Fatalf("error loading config: %v", err)
+			}
+		}
+	}
+
+	flags := flag.NewFlagSet("fcorbit", flag.ExitOnError)
+	flags.String("store", "", "Path of an fcstore file to read the frames from (fcdecode, fctelem or fcwarehouse store.path)")
+	flags.String("from", "", "Read stored frames received from this time (RFC3339)")
+	flags.String("to", "", "Read stored frames received before this time (RFC3339)")
+	flags.Int("satellite", -1, "Satellite id (frame header) of the frames to use, -1 when the frames are of one satellite")
+	flags.StringSlice("files", []string{}, "Paths of funcubebin files to read the frames from, in the order received")
+	flags.String("filestart", "", "Time the first frame of the files was received (RFC3339), the rest follow a frame interval apart")
+	flags.String("station", "", "Station that received the files' frames")
+	flags.String("dataset", "wholeorbit", "Dataset to rebuild, wholeorbit or highres")
+	flags.String("format", "csv", "Format to export, csv or json")
+	flags.String("output", "", "Path of the file to export to, stdout if empty")
+	_ = flags.Parse(args)
+
+	if err := konf.Load(posflag.Provider(flags, ".", konf), nil); err != nil {
+		log.Fatalf("error loading config: %v", err)
+	}
+
+	return konf
+}
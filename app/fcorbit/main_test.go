@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/funcube-dev/go/fcorbit"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSettings(t *testing.T) {
+	settings, err := loadSettings(readConfiguration(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fcorbit.DefaultConfig(), settings, "flag defaults match the export defaults")
+
+	settings, err = loadSettings(readConfiguration([]string{"--files", "a.funcubebin,b.funcubebin", "--dataset", "highres", "--satellite", "2"}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.funcubebin", "b.funcubebin"}, settings.Files)
+	assert.Equal(t, "highres", settings.Dataset)
+	assert.Equal(t, 2, settings.Satellite)
+
+	// the layout's fields from a configuration file
+	name := filepath.Join(t.TempDir(), "fcorbit.conf")
+	assert.NoError(t, ioutil.WriteFile(name, []byte("[layout.highres]\nfields = [\"kind:8\", \"seconds:64\"]\n"), 0644))
+	config := readConfiguration(nil)
+	assert.NoError(t, config.Load(file.Provider(name), toml.Parser()))
+	settings, err = loadSettings(config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kind:8", "seconds:64"}, settings.Layout.HighRes.Fields)
+	assert.Equal(t, fcorbit.DefaultLayout().HighRes.FrameTypes, settings.Layout.HighRes.FrameTypes)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/funcube-dev/go/service/decode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSettings(t *testing.T) {
+	settings, err := loadSettings(readConfiguration(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, decode.DefaultConfig(), settings, "flag defaults match the service defaults")
+
+	settings, err = loadSettings(readConfiguration([]string{"--exclude", "145936000,145937000", "--connectlocations", "warehouse:64518"}))
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{145936000, 145937000}, settings.Exclude)
+	assert.Equal(t, []string{"warehouse:64518"}, settings.ConnectLocations)
+
+	settings, err = loadSettings(readConfiguration([]string{"--store.path", "/data/frames.log"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/frames.log", settings.Store.Path)
+	assert.Equal(t, 30.0, settings.Store.MaxAge, "the rest of the store table keep their defaults")
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/funcube-dev/go/service/warehouse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSettings(t *testing.T) {
+	settings, err := loadSettings(readConfiguration(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, warehouse.DefaultConfig(), settings, "flag defaults match the service defaults")
+
+	settings, err = loadSettings(readConfiguration([]string{"--siteid", "G0ABC", "--dataport", "1234", "--shutdowntimeout", "2.5"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "G0ABC", settings.SiteID)
+	assert.Equal(t, 1234, settings.DataPort)
+	assert.Equal(t, 2.5, settings.ShutdownTimeout)
+
+	settings, err = loadSettings(readConfiguration([]string{"--store.path", "/data/frames.log"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/frames.log", settings.Store.Path)
+}
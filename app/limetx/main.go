@@ -1,20 +1,14 @@
 package main
 
 import (
-	"bytes"
-	"container/list"
-	"encoding/binary"
-	"fmt"
-	"github.com/funcube-dev/go/fcio"
-	"io"
+	"context"
 	"log"
-	"net"
 	"os"
 	"strings"
-	"time"
 
-	"github.com/brian-armstrong/gpio"
-	"github.com/funcube-dev/limedrv"
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/internal/appconfig"
+	"github.com/funcube-dev/go/service/limetx"
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/toml"
 	"github.com/knadh/koanf/providers/env"
@@ -23,271 +17,35 @@ import (
 	flag "github.com/spf13/pflag"
 )
 
-var config = readConfiguration()
-var readerQueue = list.New()
-var transmitChan = make(chan []float32, 8)
-var lime *limedrv.LMSDevice
-var txGpioPin *gpio.Pin
-
 func main() {
-	log.Printf("Using Config:\n%s\n", config.Sprint())
-
-	gpioPinID := config.Int("gpio")
-	if gpioPinID > 0 {
-		pin := gpio.NewOutput(uint(gpioPinID), false)
-		txGpioPin = &pin
-		defer txGpioPin.Cleanup() // odd but works, defer is function, not block scoped
-	}
-
-	devices := limedrv.GetDevices()
-	if len(devices) == 0 {
-		log.Fatalln("No lime device found, restart container if device added after start or check udev rules and that usbdev package is installed")
-	}
-
-	d := devices[0]
-	if len(devices) > 1 {
-		log.Printf("Found %d limes, using: %v", len(devices), d)
-	}
-
-	log.Printf("Opening %s\n", d.DeviceName)
-	lime = limedrv.Open(d) // Open the selected device
-	defer lime.Close()     // Defer the close of the device
-
-	log.Printf("Temp:%f/n", lime.GetTemperature())
-	log.Println(lime.String())
-
-	fileName := config.String("file")
-	if len(fileName) > 0 {
-		txfile, err := os.Open(fileName)
-		if err != nil {
-			log.Fatalf("Failed to open file %s error:%v", fileName, err)
-		}
-		reader, err := fcio.NewReadSeekCloser(txfile)
-		if err != nil {
-			log.Fatalf("Failed to get reader from file %s error:%v", fileName, err)
-		}
-		readerQueue.PushBack(reader)
-		transmitStart()
-	}
-
-	go fillTransmitChannel()
-	go listen()
-	commandListen()
-}
-
-func listen() {
-	host := config.String("bindaddress")
-	port := config.String("sampleport")
-	hostport := net.JoinHostPort(host, port)
-	log.Println("Opening listen socket...")
-	lsock, err := net.Listen("tcp4", hostport)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer lsock.Close()
-	log.Printf("Listening on socket: %s", hostport)
-
-	for {
-		c, err := lsock.Accept()
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		handleConnection(c)
-	}
-}
-
-func commandListen() {
-	host := config.String("bindaddress")
-	port := config.String("commandport")
-	hostport := net.JoinHostPort(host, port)
-	log.Println("Opening command listen socket...")
-	lsock, err := net.Listen("tcp4", hostport)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer lsock.Close()
-	log.Printf("Listening for commands on socket: %s", hostport)
-
-	for {
-		c, err := lsock.Accept()
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		handleCommandConnection(c)
-	}
-}
-
-func transmitStart() {
-	txch := lime.TXChannels[config.Int("channel")] // limedrv.ChannelA by default
-
-	sampleRate := config.Float64("rate")
-	oversample := config.Int("oversample")
-	frequency := config.Float64("frequency")
-	antennaName := config.String("antenna")
-	txGain := config.Float64("gain")
-	lpf := config.Float64("lpf")
-	calibrationDelay := time.Duration(config.Float64("calibrationdelay")) * time.Second
-
-	if txGpioPin != nil {
-		log.Printf("Set gpio Low...")
-		txGpioPin.Low()
-	}
-
-	log.Printf("Set Sample rate:%f oversample:%d", sampleRate, oversample)
-	lime.SetSampleRate(sampleRate, oversample)
+	config := readConfiguration(os.Args[1:])
+	log.Printf("Using Config:\n%s\n", appconfig.SprintSafe(config))
 
-	// Set lpf starts calibration, so delay before starting tx
-	txch.Enable().
-		SetAntennaByName(antennaName). // limedrv.BAND2 by default
-		SetGainNormalized(txGain).
-		SetCenterFrequency(frequency).
-		SetLPF(lpf)
-
-	log.Println("Starting Calibration Delay:", calibrationDelay)
-	time.Sleep(calibrationDelay)
-	log.Println("Stopping Calibration Delay:", calibrationDelay)
-
-	if txGpioPin != nil {
-		log.Printf("Set gpio High...")
-		txGpioPin.High()
-	}
-
-	log.Printf("Setting callback...")
-	lime.SetTXCallback(realSampleCallback)
-	log.Printf("Starting...")
-	lime.Start()
-}
-
-func transmitStop() {
-	txch := lime.TXChannels[config.Int("channel")] // limedrv.ChannelA by default
-
-	if txGpioPin != nil {
-		log.Printf("Set gpio Low...")
-		txGpioPin.Low()
-	}
-
-	lime.Stop()
-	txch.Disable()
-	log.Println("Stopped Transmit")
-}
-
-func handleConnection(c net.Conn) {
-	log.Printf("Connection from: %v", c)
-
-	tc, err := fcio.NewTimedConn(c, time.Second)
+	settings, err := loadSettings(config)
 	if err != nil {
-		log.Printf("Failed to create TimedConn, ignoring error:%v", err)
-		return
+		log.Fatalf("error reading config: %v", err)
 	}
-	reader, err := fcio.NewReadSeekCloser(tc)
+	service, err := limetx.New(settings)
 	if err != nil {
-		log.Printf("Failed to create reader, ignoring error:%v", err)
-		return
+		log.Fatalf("Failed to start: %v", err)
 	}
-	readerQueue.PushBack(reader)
-}
-
-func handleCommandConnection(c net.Conn) {
-	log.Printf("Connection from: %v", c)
-	defer c.Close()
-}
-
-func fillTransmitChannel() {
-	loopFile := config.Bool("loopfile")
-	idleSeconds := 0
-	idleTimeout := config.Int("idletimeout")
-	for {
-		// if there's nothing to read from wait then try again
-		if readerQueue.Len() == 0 {
-			time.Sleep(time.Second)
-			fmt.Printf(".")
-			if lime.IsRunning() {
-				if idleSeconds++; idleSeconds > idleTimeout {
-					transmitStop()
-				}
-			}
-			continue
-		}
-		if !lime.IsRunning() {
-			transmitStart()
-		}
-		idleSeconds = 0
-
-		src := readerQueue.Front().Value.(*fcio.ReadSeekCloser)
-
-		raw := make([]byte, 4096)
-		bytesRead, err := src.Read(raw)
-		if err == io.EOF {
-			if loopFile && src.CanSeek() {
-				fmt.Printf("|")
-				lastRead := bytesRead
-				src.Seek(0, 0)
-				bytesRead, err = src.Read(raw[lastRead:])
-				bytesRead += lastRead
-			} else {
-				fmt.Printf("^")
-				readerQueue.Remove(readerQueue.Front())
-				src.Close()
-				err = nil
-			}
-		}
-		if err != nil {
-			panic(err)
-		}
-
-		if bytesRead == 0 {
-			continue
-		}
-
-		reader := bytes.NewReader(raw[:bytesRead])
-		// create sample buffer based on data read
-		samples := make([]float32, int(bytesRead/4))
 
-		// fill sample buffer
-		for i := 0; i < len(samples); i++ {
-			err = binary.Read(reader, binary.LittleEndian, &samples[i])
-			if err != nil {
-				panic(err)
-			}
-		}
-		fmt.Printf(">")
-		transmitChan <- samples
+	ctx, cancel := fcio.NotifyContext(context.Background())
+	defer cancel()
+	if err := service.Run(ctx); err != nil {
+		log.Fatalf("%v", err)
 	}
+	log.Println("Shutdown complete")
 }
 
-func realSampleCallback(data []complex64, channel int) int {
-	var samples []float32
-	sampleCount := 0
-	select {
-	case samples = <-transmitChan:
-		sampleCount = len(samples)
-	default:
-		time.Sleep(time.Millisecond * 50)
-		sampleCount = 0
-	}
-
-	if sampleCount == 0 {
-		//pdata = (pdata)[:0]
-		return 0
-	}
-	fmt.Printf("<")
-
-	// resize output buffer based on data read
-	//data = (data)[:sampleCount]
-	//data := pdata
-
-	// fill output buffer
-	for i := 0; i < sampleCount; i++ {
-		data[i] = complex64(complex(samples[i], 0.0))
-	}
-	return sampleCount
+// loadSettings the limetx settings, with any [channels.<name>] tables, from the configuration
+func loadSettings(config *koanf.Koanf) (limetx.Config, error) {
+	settings := limetx.DefaultConfig()
+	err := config.Unmarshal("", &settings)
+	return settings, err
 }
 
-func readConfiguration() *koanf.Koanf {
+func readConfiguration(args []string) *koanf.Koanf {
 	var konf = koanf.New(".")
 
 	konf.Load(env.Provider("LTX_", ".", func(s string) string {
@@ -303,24 +61,42 @@ func readConfiguration() *koanf.Koanf {
 		}
 	}
 
-	flag.Float64P("frequency", "f", float64(145.893e6), "Transmit frequency in Hz")
-	flag.Float64("rate", float64(48000.0), "Audio sample rate Hz")
-	flag.Int("oversample", int(32), "Oversampling rate [1,2,4,8,16,32], when multiplied by the sample rate must be within Lime limits")
-	flag.StringP("antenna", "a", limedrv.BAND2, "Name of lime transmit antenna")
-	flag.Int("channel", limedrv.ChannelA, "Name of lime transmit channel")
-	flag.Float64("lpf", float64(5e6), "Bandwidth of lime analogue filter")
-	flag.Float64P("gain", "g", float64(0.5), "Gain 0..1 (lime normalized gain)")
-	flag.Float64P("calibrationdelay", "d", float64(5.0), "Delay before starting transmission to allow calibration to complete")
-	flag.String("bindaddress", "0.0.0.0", "Address to bind for TCP listen sockets")
-	flag.Int("sampleport", int(0xFC04), "Port for incomming samples")
-	flag.Int("commandport", int(0xFC05), "Port for incomming commands")
-	flag.String("file", "", "Path to dbpsk file to transmit (float32 LE 48000Khz)")
-	flag.Bool("loopfile", false, "Send the file in an endless loop")
-	flag.Int("idletimeout", 30, "Seconds of no data before stopping transmission")
-	flag.Int("gpio", -1, "Raspberry PI GPIO pin to toggle, high when transmitting, low when idle (default -1 dont toggle")
-	flag.Parse()
-
-	if err := konf.Load(posflag.Provider(flag.CommandLine, ".", konf), nil); err != nil {
+	flags := flag.NewFlagSet("limetx", flag.ExitOnError)
+	flags.Float64P("frequency", "f", float64(145.893e6), "Transmit frequency in Hz")
+	flags.Float64("rate", float64(48000.0), "Audio sample rate Hz")
+	flags.Int("oversample", int(32), "Oversampling rate [1,2,4,8,16,32], when multiplied by the sample rate must be within Lime limits")
+	flags.StringP("antenna", "a", limetx.BAND2, "Name of lime transmit antenna")
+	flags.Int("channel", limetx.ChannelA, "Number of lime transmit channel (0 A, 1 B), [channels.a] and [channels.b] config tables drive both with their own settings")
+	flags.Float64("lpf", float64(5e6), "Bandwidth of lime analogue filter")
+	flags.Float64P("gain", "g", float64(0.5), "Gain 0..1 (lime normalized gain)")
+	flags.Float64P("calibrationdelay", "d", float64(5.0), "Delay before starting transmission to allow calibration to complete")
+	flags.String("bindaddress", "0.0.0.0", "Address to bind for TCP listen sockets")
+	flags.Int("sampleport", int(0xFC04), "Port for incomming samples")
+	flags.Int("commandport", int(0xFC05), "Port for incomming commands")
+	flags.String("file", "", "Path to dbpsk file to transmit (float32 LE 48000Khz)")
+	flags.Bool("loopfile", false, "Send the file in an endless loop")
+	flags.Int("idletimeout", 30, "Seconds of no data before stopping transmission")
+	flags.Float64("prebuffer", float64(1.0), "Seconds of samples to buffer before starting transmission")
+	flags.Float64("buffer", float64(5.0), "Seconds of samples the transmit buffer can hold")
+	flags.Int("gpio", -1, "Raspberry PI GPIO pin to toggle, high when transmitting, low when idle (default -1 dont toggle")
+	flags.Float64("maxtxseconds", 0, "Longest continuous transmission in seconds before the interlock stops it (0 no limit)")
+	flags.Float64("txcooldown", 60, "Seconds to wait after the continuous transmission limit before transmitting again")
+	flags.Float64("maxdutycycle", 0, "Largest fraction (0..1) of dutywindow that can be spent transmitting (0 no limit)")
+	flags.Float64("dutywindow", 600, "Rolling window in seconds the duty cycle is measured over")
+	flags.Float64("maxtemperature", 0, "Lime temperature in C that stops transmission (0 no limit)")
+	flags.Float64("temperaturepoll", 5, "Seconds between lime temperature readings (0 only read at startup)")
+	flags.Float64("pttlead", 0.1, "Seconds between setting the gpio high and starting the sample stream")
+	flags.Float64("ptttail", 0.1, "Seconds between stopping the sample stream and setting the gpio low")
+	flags.Float64("rampms", 5, "Milliseconds of raised cosine ramp at the start and end of transmission (0 no ramp)")
+	flags.Bool("requirearm", false, "Only transmit after ARM is sent to the command port")
+	flags.Bool("simulategpio", false, "Log gpio changes instead of toggling a Raspberry PI pin")
+	flags.String("device", "", "Serial or name (or part of either) of the lime to use, empty uses the first found")
+	flags.String("backend", "lime", "Transmitter to use, lime or sink (writes samples to sinkfile at the sample rate, no hardware needed)")
+	flags.Float64("shutdowntimeout", 5, "Seconds to wait for transmission to stop cleanly when asked to stop, the gpio is dropped regardless after it")
+	flags.String("sinkfile", "", "Path of file the sink backend writes, .wav for a float IQ wav, otherwise raw float32 IQ, empty discards samples")
+	_ = flags.Parse(args)
+
+	if err := konf.Load(posflag.Provider(flags, ".", konf), nil); err != nil {
 		log.Fatalf("error loading config: %v", err)
 	}
 
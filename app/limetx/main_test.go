@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/service/limetx"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSettings(t *testing.T) {
+	settings, err := loadSettings(readConfiguration(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, limetx.DefaultConfig(), settings, "flag defaults match the service defaults")
+
+	settings, err = loadSettings(readConfiguration([]string{"-f", "435000000", "--backend", "sink"}))
+	assert.NoError(t, err)
+	assert.Equal(t, 435000000.0, settings.Frequency)
+	assert.Equal(t, "sink", settings.Backend)
+}
+
+func TestLoadSettings_Channels(t *testing.T) {
+	confFile := filepath.Join(t.TempDir(), "limetx.conf")
+	conf := "[channels.a]\nfrequency = 435000000.0\n[channels.b]\nsampleport = 64520\ngain = 0.8\n"
+	if err := ioutil.WriteFile(confFile, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := readConfiguration(nil)
+	if err := config.Load(file.Provider(confFile), toml.Parser()); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := loadSettings(config)
+	assert.NoError(t, err)
+	if assert.Len(t, settings.Channels, 2) {
+		a, b := settings.Channels["a"], settings.Channels["b"]
+		if assert.NotNil(t, a.Frequency) {
+			assert.Equal(t, 435000000.0, *a.Frequency)
+		}
+		assert.Nil(t, a.Gain, "falls back to the top level gain")
+		assert.Nil(t, a.SamplePort)
+		if assert.NotNil(t, b.SamplePort) && assert.NotNil(t, b.Gain) {
+			assert.Equal(t, 64520, *b.SamplePort)
+			assert.Equal(t, 0.8, *b.Gain)
+		}
+	}
+}
+
+func TestLoadSettings_TLS(t *testing.T) {
+	confFile := filepath.Join(t.TempDir(), "limetx.conf")
+	conf := "[tls]\ncert = \"/certs/limetx.pem\"\nkey = \"/certs/limetx-key.pem\"\nca = \"/certs/ca.pem\"\n"
+	if err := ioutil.WriteFile(confFile, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := readConfiguration(nil)
+	if err := config.Load(file.Provider(confFile), toml.Parser()); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := loadSettings(config)
+	assert.NoError(t, err)
+	assert.Equal(t, fcio.TLSConfig{Cert: "/certs/limetx.pem", Key: "/certs/limetx-key.pem", CA: "/certs/ca.pem"}, settings.TLS)
+}
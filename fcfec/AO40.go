@@ -0,0 +1,189 @@
+// Package fcfec the AO40 forward error correction FUNcube frames are sent with, for decoding soft
+// symbols outside the FUNcubeLib
+package fcfec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// FrameSize the bytes of a frame, as fclib.BLOCK_SIZE
+	FrameSize = 256
+	// FECBlockSize the bytes of an encoded frame, as fclib.FEC_BLOCK_SIZE
+	FECBlockSize = 650
+	// BlockSymbols the channel symbols of an encoded frame, a bit each
+	BlockSymbols = FECBlockSize * 8
+	// SyncSymbols the symbols of the sync vector, the first of each row of the interleaver
+	SyncSymbols = rows
+	// SymbolRate the channel symbols sent a second
+	SymbolRate = 1200
+
+	rows    = 65
+	columns = 80
+	// coded the bytes of a frame and its two interleaved Reed-Solomon codewords' parity
+	coded = FrameSize + 2*rsRoots
+)
+
+var (
+	scrambler [coded]byte
+	// syncVector the sync symbols, +1 or -1
+	syncVector [SyncSymbols]float32
+)
+
+func init() {
+	// the CCSDS pseudo random sequence, x^8+x^7+x^5+x^3+1 from all ones
+	sr := 0xff
+	for i := range scrambler {
+		for b := 0; b < 8; b++ {
+			scrambler[i] = scrambler[i]<<1 | byte(sr&1)
+			sr = sr>>1 | parity(sr&0xa9)<<7
+		}
+	}
+	sr = 0x7f
+	for i := range syncVector {
+		syncVector[i] = -1
+		if sr&0x40 != 0 {
+			syncVector[i] = 1
+		}
+		sr = (sr<<1 | parity(sr&0x48)) & 0x7f
+	}
+}
+
+// symbolIndex where the nth convolutionally encoded symbol is sent, down the columns of the
+// interleaver after the first, read out a row at a time
+func symbolIndex(n int) int {
+	return (n%rows)*columns + 1 + n/rows
+}
+
+// Encode a frame into its FECBlockSize bytes, the channel symbols in the order sent, the first in
+// the top bit
+func Encode(frame []byte) ([]byte, error) {
+	if len(frame) != FrameSize {
+		return nil, fmt.Errorf("frame of %d bytes, not %d", len(frame), FrameSize)
+	}
+	data := make([]byte, coded)
+	copy(data, frame)
+	for i, codeword := range splitCodewords(data) {
+		parity := rsParity(codeword[:FrameSize/2])
+		for j, p := range parity {
+			data[FrameSize+2*j+i] = p
+		}
+	}
+	input := make([]byte, 0, coded*8+convTail)
+	for i, d := range data {
+		d ^= scrambler[i]
+		for b := 7; b >= 0; b-- {
+			input = append(input, d>>uint(b)&1)
+		}
+	}
+	input = append(input, make([]byte, convTail)...)
+
+	block := make([]byte, FECBlockSize)
+	set := func(index int) { block[index/8] |= 0x80 >> uint(index%8) }
+	for i, s := range syncVector {
+		if s > 0 {
+			set(i * columns)
+		}
+	}
+	for n, symbol := range convEncode(input) {
+		if symbol != 0 {
+			set(symbolIndex(n))
+		}
+	}
+	return block, nil
+}
+
+// Symbols the channel symbols of an encoded block as soft symbols of +1 or -1
+func Symbols(block []byte) []float32 {
+	soft := make([]float32, 0, len(block)*8)
+	for _, b := range block {
+		for i := 7; i >= 0; i-- {
+			soft = append(soft, float32(int(b>>uint(i)&1)*2-1))
+		}
+	}
+	return soft
+}
+
+// splitCodewords the two interleaved codewords, even bytes then odd
+func splitCodewords(data []byte) [2][]byte {
+	var codewords [2][]byte
+	for i, d := range data {
+		codewords[i%2] = append(codewords[i%2], d)
+	}
+	return codewords
+}
+
+// ErrUncorrectable the frame had more errors than the Reed-Solomon codes correct
+var ErrUncorrectable = errors.New("uncorrectable frame")
+
+// Decode the frame from the BlockSymbols soft symbols of a block, starting with its first sync
+// symbol, positive for a 1 and negative for a 0, the magnitude the confidence and 0 an erasure.
+// Returns the Reed-Solomon bytes corrected
+func Decode(soft []float32) ([]byte, int, error) {
+	if len(soft) != BlockSymbols {
+		return nil, 0, fmt.Errorf("%d soft symbols, not %d", len(soft), BlockSymbols)
+	}
+	deinterleaved := make([]float32, 2*(coded*8+convTail))
+	for n := range deinterleaved {
+		deinterleaved[n] = soft[symbolIndex(n)]
+	}
+	decoded := viterbi(deinterleaved)
+	data := make([]byte, coded)
+	for i := range data {
+		var d byte
+		for _, bit := range decoded[8*i : 8*i+8] {
+			d = d<<1 | bit
+		}
+		data[i] = d ^ scrambler[i]
+	}
+
+	corrected := 0
+	for i, codeword := range splitCodewords(data) {
+		n, err := rsCorrect(codeword)
+		if err != nil {
+			return nil, 0, ErrUncorrectable
+		}
+		corrected += n
+		for j, c := range codeword {
+			data[2*j+i] = c
+		}
+	}
+	return data[:FrameSize], corrected, nil
+}
+
+// FindSync the offset into soft at which a block's sync vector correlates best, and the
+// correlation, from -1 to 1, negative when the symbols are inverted
+func FindSync(soft []float32) (int, float64) {
+	best, bestCorrelation := 0, 0.0
+	for offset := 0; offset+BlockSymbols <= len(soft); offset++ {
+		var sum, magnitude float64
+		for i, s := range syncVector {
+			v := float64(soft[offset+i*columns])
+			sum += v * float64(s)
+			magnitude += math.Abs(v)
+		}
+		if magnitude == 0 {
+			continue
+		}
+		if correlation := sum / magnitude; math.Abs(correlation) > math.Abs(bestCorrelation) {
+			best, bestCorrelation = offset, correlation
+		}
+	}
+	return best, bestCorrelation
+}
+
+// SyncStats the mean and variance of the soft symbols of a block's sync vector, taken as the
+// symbols sent, the signal and the noise
+func SyncStats(block []float32) (mean, variance float64) {
+	var sum, squares float64
+	for i, s := range syncVector {
+		v := float64(block[i*columns] * s)
+		sum += v
+		squares += v * v
+	}
+	mean = sum / SyncSymbols
+	variance = squares/SyncSymbols - mean*mean
+	return mean, variance
+}
@@ -0,0 +1,170 @@
+package fcfec
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFrame(seed int64) []byte {
+	frame := make([]byte, FrameSize)
+	rand.New(rand.NewSource(seed)).Read(frame)
+	return frame
+}
+
+// noisy the symbols with gaussian noise of sigma added
+func noisy(rng *rand.Rand, symbols []float32, sigma float64) []float32 {
+	out := make([]float32, len(symbols))
+	for i, s := range symbols {
+		out[i] = s + float32(rng.NormFloat64()*sigma)
+	}
+	return out
+}
+
+func TestEncodeDecode(t *testing.T) {
+	assert.Equal(t, []byte{0xff, 0x48, 0x0e, 0xc0, 0x9a}, scrambler[:5])
+
+	frame := testFrame(1)
+	block, err := Encode(frame)
+	assert.NoError(t, err)
+	assert.Len(t, block, FECBlockSize)
+
+	decoded, corrected, err := Decode(Symbols(block))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, corrected)
+	assert.Equal(t, frame, decoded)
+
+	// hard symbol errors are corrected by the convolutional code, bursts by the Reed-Solomon
+	soft := Symbols(block)
+	rng := rand.New(rand.NewSource(2))
+	for _, i := range rng.Perm(BlockSymbols)[:200] {
+		soft[i] = -soft[i]
+	}
+	decoded, _, err = Decode(soft)
+	assert.NoError(t, err)
+	assert.Equal(t, frame, decoded)
+	soft = Symbols(block)
+	for i := 1000; i < 1400; i++ {
+		soft[i] = 0
+	}
+	decoded, _, err = Decode(soft)
+	assert.NoError(t, err)
+	assert.Equal(t, frame, decoded)
+
+	// noise
+	decoded, _, err = Decode(noisy(rng, Symbols(block), 0.6))
+	assert.NoError(t, err)
+	assert.Equal(t, frame, decoded)
+	_, _, err = Decode(noisy(rng, Symbols(block), 3))
+	assert.Equal(t, ErrUncorrectable, err)
+
+	_, err = Encode(frame[:100])
+	assert.Error(t, err)
+	_, _, err = Decode(soft[:100])
+	assert.Error(t, err)
+}
+
+// karnEncode an encoder written after Karn's AO-40 reference encoder rather than Encode: a byte
+// at a time, each bit shifted into the encoder giving parity(POLYA) and !parity(POLYB), each
+// symbol written a row (80 symbols) down the interleaver from the top of column 1, wrapping to
+// the top of the next column
+func karnEncode(frame []byte) []byte {
+	block := make([]byte, FECBlockSize)
+	sr := 0x7f
+	for i := 0; i < 65; i++ {
+		if sr&64 != 0 {
+			block[10*i] |= 0x80
+		}
+		sr = sr<<1 | parity(sr&0x48)
+	}
+	bindex := 1
+	interleave := func(symbol int) {
+		if symbol != 0 {
+			block[bindex/8] |= 0x80 >> uint(bindex%8)
+		}
+		if bindex += 80; bindex >= 5200 {
+			bindex -= 5199
+		}
+	}
+	conv := 0
+	encodeBit := func(bit int) {
+		conv = conv<<1 | bit
+		interleave(parity(conv & 79))
+		if parity(conv&109) == 0 {
+			interleave(1)
+		} else {
+			interleave(0)
+		}
+	}
+
+	codewords := [2][]byte{}
+	for i := 0; i < FrameSize; i += 2 {
+		codewords[0] = append(codewords[0], frame[i])
+		codewords[1] = append(codewords[1], frame[i+1])
+	}
+	data := append([]byte{}, frame...)
+	parity0, parity1 := rsParity(codewords[0]), rsParity(codewords[1])
+	for i := range parity0 {
+		data = append(data, parity0[i], parity1[i])
+	}
+	for n, c := range data {
+		c ^= scrambler[n]
+		for i := 7; i >= 0; i-- {
+			encodeBit(int(c>>uint(i)) & 1)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		encodeBit(0)
+	}
+	return block
+}
+
+func TestConvEncode(t *testing.T) {
+	// polys 79 and -109: zeros give the 0101 idle pattern, a one the taps, the polyB ones inverted
+	assert.Equal(t, []byte{0, 1, 0, 1, 0, 1}, convEncode([]byte{0, 0, 0}))
+	assert.Equal(t, []byte{1, 0, 1, 1, 1, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1},
+		convEncode([]byte{1, 0, 0, 0, 0, 0, 0, 0}))
+}
+
+func TestDecode_Reference(t *testing.T) {
+	frame := testFrame(3)
+	block := karnEncode(frame)
+	encoded, err := Encode(frame)
+	assert.NoError(t, err)
+	assert.Equal(t, block, encoded)
+
+	decoded, corrected, err := Decode(Symbols(block))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, corrected)
+	assert.Equal(t, frame, decoded)
+
+	// the symbols of the uninverted code do not decode
+	uninverted := Symbols(block)
+	for n := 1; n < 2*(coded*8+convTail); n += 2 {
+		uninverted[symbolIndex(n)] = -uninverted[symbolIndex(n)]
+	}
+	_, _, err = Decode(uninverted)
+	assert.Equal(t, ErrUncorrectable, err)
+}
+
+func TestFindSync(t *testing.T) {
+	block, _ := Encode(testFrame(3))
+	rng := rand.New(rand.NewSource(4))
+	window := noisy(rng, make([]float32, WindowSymbols), 1)
+	copy(window[37:], noisy(rng, Symbols(block), 0.5))
+
+	offset, correlation := FindSync(window)
+	assert.Equal(t, 37, offset)
+	assert.Greater(t, correlation, 0.5)
+	mean, variance := SyncStats(window[offset:])
+	assert.InDelta(t, 1, mean, 0.2)
+	assert.InDelta(t, 0.25, variance, 0.1)
+
+	for i := range window {
+		window[i] = -window[i]
+	}
+	offset, correlation = FindSync(window)
+	assert.Equal(t, 37, offset)
+	assert.Less(t, correlation, -0.5, "inverted")
+}
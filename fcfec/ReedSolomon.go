@@ -0,0 +1,200 @@
+package fcfec
+
+import "errors"
+
+// the CCSDS Reed-Solomon (255,223) code AO40 uses, shortened to (160,128)
+const (
+	rsRoots  = 32
+	rsFCR    = 112   // first consecutive root, in powers of the primitive element
+	rsPrim   = 11    // primitive element, as a power of alpha
+	rsPoly   = 0x187 // field generator polynomial
+	rsLength = 160   // codeword length once shortened
+)
+
+var (
+	gfExp [512]byte // alpha to the power of the index, doubled up to save a modulo
+	gfLog [256]int  // the power of alpha giving each element, gfLog[0] unused
+	// rsGenerator the generator polynomial's coefficients, highest power first
+	rsGenerator [rsRoots + 1]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= rsPoly
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+
+	// the product of (x - root) over the roots, root j being alpha^((fcr+j)*prim)
+	generator := []byte{1}
+	for j := 0; j < rsRoots; j++ {
+		root := gfExp[((rsFCR+j)*rsPrim)%255]
+		next := make([]byte, len(generator)+1)
+		for i, c := range generator {
+			next[i] ^= c
+			next[i+1] ^= gfMul(c, root)
+		}
+		generator = next
+	}
+	copy(rsGenerator[:], generator)
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+255-gfLog[b]]
+}
+
+// gfPow alpha to the power of n, n may be negative
+func gfPow(n int) byte {
+	n %= 255
+	if n < 0 {
+		n += 255
+	}
+	return gfExp[n]
+}
+
+// rsParity the parity bytes of a codeword holding data, data of up to rsLength-rsRoots bytes
+func rsParity(data []byte) [rsRoots]byte {
+	var parity [rsRoots]byte
+	for _, d := range data {
+		feedback := d ^ parity[0]
+		copy(parity[:], parity[1:])
+		parity[rsRoots-1] = 0
+		if feedback != 0 {
+			for i := range parity {
+				parity[i] ^= gfMul(feedback, rsGenerator[i+1])
+			}
+		}
+	}
+	return parity
+}
+
+var errUncorrectable = errors.New("uncorrectable")
+
+// rsCorrect corrects up to rsRoots/2 bytes of the codeword in place, returning how many were
+// corrected, codeword[0] is the highest power
+func rsCorrect(codeword []byte) (int, error) {
+	n := len(codeword)
+	var syndromes [rsRoots]byte
+	clean := true
+	for j := range syndromes {
+		root := gfPow((rsFCR + j) * rsPrim)
+		var s byte
+		for _, c := range codeword {
+			s = gfMul(s, root) ^ c
+		}
+		syndromes[j] = s
+		clean = clean && s == 0
+	}
+	if clean {
+		return 0, nil
+	}
+
+	// Berlekamp-Massey for the error locator, lowest power first
+	locator := []byte{1}
+	previous := []byte{1}
+	length, shift := 0, 1
+	var lastDiscrepancy byte = 1
+	for k := 0; k < rsRoots; k++ {
+		discrepancy := syndromes[k]
+		for i := 1; i <= length && i < len(locator); i++ {
+			discrepancy ^= gfMul(locator[i], syndromes[k-i])
+		}
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+		scale := gfDiv(discrepancy, lastDiscrepancy)
+		next := make([]byte, maxInt(len(locator), len(previous)+shift))
+		copy(next, locator)
+		for i, c := range previous {
+			next[i+shift] ^= gfMul(scale, c)
+		}
+		if 2*length <= k {
+			previous, locator = locator, next
+			length = k + 1 - length
+			lastDiscrepancy = discrepancy
+			shift = 1
+		} else {
+			locator = next
+			shift++
+		}
+	}
+	for len(locator) > 1 && locator[len(locator)-1] == 0 {
+		locator = locator[:len(locator)-1]
+	}
+	if len(locator)-1 != length || length > rsRoots/2 {
+		return 0, errUncorrectable
+	}
+
+	// the evaluator, syndromes times locator to below x^rsRoots
+	evaluator := make([]byte, rsRoots)
+	for i := range evaluator {
+		for j := 0; j <= i && j < len(locator); j++ {
+			evaluator[i] ^= gfMul(locator[j], syndromes[i-j])
+		}
+	}
+
+	// Chien search over the positions of the shortened codeword, Forney for the values, the
+	// locator of the byte at power p is alpha^(prim*p)
+	type fix struct {
+		index int
+		value byte
+	}
+	var fixes []fix
+	for p := 0; p < n; p++ {
+		inverse := gfPow(-rsPrim * p)
+		if evaluate(locator, inverse) != 0 {
+			continue
+		}
+		// the formal derivative keeps the odd powers
+		var derivative byte
+		for i := 1; i < len(locator); i += 2 {
+			derivative ^= gfMul(locator[i], gfPow(-rsPrim*p*(i-1)))
+		}
+		if derivative == 0 {
+			return 0, errUncorrectable
+		}
+		value := gfMul(gfPow(rsPrim*p*(1-rsFCR)), gfDiv(evaluate(evaluator, inverse), derivative))
+		fixes = append(fixes, fix{index: n - 1 - p, value: value})
+	}
+	if len(fixes) != length {
+		return 0, errUncorrectable
+	}
+	for _, f := range fixes {
+		codeword[f.index] ^= f.value
+	}
+	return len(fixes), nil
+}
+
+// evaluate the polynomial, lowest power first, at x
+func evaluate(poly []byte, x byte) byte {
+	var result byte
+	for i := len(poly) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ poly[i]
+	}
+	return result
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
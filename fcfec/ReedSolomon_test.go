@@ -0,0 +1,37 @@
+package fcfec
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReedSolomon(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, rsLength-rsRoots)
+	rng.Read(data)
+	parity := rsParity(data)
+	codeword := append(append([]byte{}, data...), parity[:]...)
+
+	corrected, err := rsCorrect(append([]byte{}, codeword...))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, corrected)
+
+	// up to 16 bytes anywhere are corrected
+	damaged := append([]byte{}, codeword...)
+	for _, i := range rng.Perm(rsLength)[:rsRoots/2] {
+		damaged[i] ^= byte(1 + rng.Intn(255))
+	}
+	corrected, err = rsCorrect(damaged)
+	assert.NoError(t, err)
+	assert.Equal(t, rsRoots/2, corrected)
+	assert.Equal(t, codeword, damaged)
+
+	// more are not
+	for _, i := range rng.Perm(rsLength)[:rsRoots/2+4] {
+		damaged[i] ^= byte(1 + rng.Intn(255))
+	}
+	_, err = rsCorrect(damaged)
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package fcfec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	softMagic        = "FCB1"
+	receiverNameSize = 16
+
+	// WindowSymbols the soft symbols of a SoftBlock, a block's worth and a row of the interleaver
+	// either side, so the block can start anywhere in the first 2 rows
+	WindowSymbols = BlockSymbols + 2*columns
+	// SoftBlockSize the bytes of each SoftBlock sent: magic, receiver name, time of the first
+	// symbol and the symbols
+	SoftBlockSize = len(softMagic) + receiverNameSize + 8 + WindowSymbols
+)
+
+// SoftBlock the soft symbols a receiver demodulated around a block, as sent to a combiner
+type SoftBlock struct {
+	Receiver string    // up to 16 bytes
+	Time     time.Time // the first symbol was received, to the millisecond
+	// Symbols positive for a 1 and negative for a 0, the magnitude the confidence and 0 an erasure
+	Symbols []int8
+}
+
+// Encode the block
+func (b SoftBlock) Encode() ([]byte, error) {
+	if b.Receiver == "" || len(b.Receiver) > receiverNameSize {
+		return nil, fmt.Errorf("receiver name %q must be 1 to %d bytes", b.Receiver, receiverNameSize)
+	}
+	if len(b.Symbols) != WindowSymbols {
+		return nil, fmt.Errorf("%d soft symbols, not %d", len(b.Symbols), WindowSymbols)
+	}
+	data := make([]byte, 0, SoftBlockSize)
+	data = append(data, softMagic...)
+	name := make([]byte, receiverNameSize)
+	copy(name, b.Receiver)
+	data = append(data, name...)
+	var millis [8]byte
+	binary.BigEndian.PutUint64(millis[:], uint64(b.Time.UnixNano()/int64(time.Millisecond)))
+	data = append(data, millis[:]...)
+	for _, s := range b.Symbols {
+		data = append(data, byte(s))
+	}
+	return data, nil
+}
+
+// IsSoftBlock whether data starts as a SoftBlock does, for resynchronising a stream of them
+func IsSoftBlock(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(softMagic))
+}
+
+// DecodeSoftBlock the block encoded in data
+func DecodeSoftBlock(data []byte) (SoftBlock, error) {
+	if len(data) != SoftBlockSize || !IsSoftBlock(data) {
+		return SoftBlock{}, errors.New("not a soft block")
+	}
+	name := data[len(softMagic) : len(softMagic)+receiverNameSize]
+	fields := data[len(softMagic)+receiverNameSize:]
+	millis := int64(binary.BigEndian.Uint64(fields))
+	symbols := make([]int8, WindowSymbols)
+	for i, s := range fields[8:] {
+		symbols[i] = int8(s)
+	}
+	return SoftBlock{
+		Receiver: string(bytes.TrimRight(name, "\x00")),
+		Time:     time.Unix(0, millis*int64(time.Millisecond)).UTC(),
+		Symbols:  symbols,
+	}, nil
+}
@@ -0,0 +1,34 @@
+package fcfec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftBlock(t *testing.T) {
+	block := SoftBlock{
+		Receiver: "G0ABC",
+		Time:     time.Date(2020, 6, 1, 12, 0, 0, 123e6, time.UTC),
+		Symbols:  make([]int8, WindowSymbols),
+	}
+	block.Symbols[0], block.Symbols[WindowSymbols-1] = -127, 127
+	data, err := block.Encode()
+	assert.NoError(t, err)
+	assert.Len(t, data, SoftBlockSize)
+	assert.True(t, IsSoftBlock(data))
+
+	decoded, err := DecodeSoftBlock(data)
+	assert.NoError(t, err)
+	assert.Equal(t, block, decoded)
+
+	_, err = DecodeSoftBlock(data[:100])
+	assert.Error(t, err)
+	block.Receiver = "a-receiver-name-too-long"
+	_, err = block.Encode()
+	assert.Error(t, err)
+	block.Receiver, block.Symbols = "G0ABC", block.Symbols[:BlockSymbols]
+	_, err = block.Encode()
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package fcfec
+
+import "math/bits"
+
+// the rate 1/2 constraint length 7 convolutional code AO40 uses, the polyB symbol inverted
+const (
+	polyA     = 0x4f
+	polyB     = 0x6d
+	convState = 64 // 6 bits of memory
+	convTail  = 6  // zero bits flushing the encoder back to state 0
+)
+
+func parity(x int) int {
+	return bits.OnesCount(uint(x)) & 1
+}
+
+// convEncode the two symbols of each bit, bits in order, one a byte
+func convEncode(input []byte) []byte {
+	symbols := make([]byte, 0, 2*len(input))
+	sr := 0
+	for _, b := range input {
+		sr = (sr<<1 | int(b&1)) & 0x7f
+		symbols = append(symbols, byte(parity(sr&polyA)), byte(1-parity(sr&polyB)))
+	}
+	return symbols
+}
+
+// viterbi the most likely bits given two soft symbols each, positive for a 1 and negative for a
+// 0, the encoder starting and ending (after the tail) in state 0, the tail is dropped
+func viterbi(soft []float32) []byte {
+	steps := len(soft) / 2
+	metrics := make([]float32, convState)
+	next := make([]float32, convState)
+	const unreachable = -1e30
+	for i := range metrics {
+		metrics[i] = unreachable
+	}
+	metrics[0] = 0
+	// decisions[step] bit n is set when state n was reached from the predecessor with the top bit set
+	decisions := make([]uint64, steps)
+
+	for step := 0; step < steps; step++ {
+		a, b := soft[2*step], soft[2*step+1]
+		var decided uint64
+		for n := 0; n < convState; n++ {
+			best := float32(unreachable)
+			for high := 0; high < 2; high++ {
+				prev := n>>1 | high<<5
+				if metrics[prev] == unreachable {
+					continue
+				}
+				sr := prev<<1 | n&1
+				metric := metrics[prev] + branch(a, parity(sr&polyA)) + branch(b, 1-parity(sr&polyB))
+				if metric > best {
+					best = metric
+					if high == 1 {
+						decided |= 1 << uint(n)
+					} else {
+						decided &^= 1 << uint(n)
+					}
+				}
+			}
+			next[n] = best
+		}
+		decisions[step] = decided
+		metrics, next = next, metrics
+	}
+
+	out := make([]byte, steps)
+	state := 0
+	for step := steps - 1; step >= 0; step-- {
+		out[step] = byte(state & 1)
+		high := int(decisions[step]>>uint(state)) & 1
+		state = state>>1 | high<<5
+	}
+	if steps < convTail {
+		return nil
+	}
+	return out[:steps-convTail]
+}
+
+func branch(soft float32, symbol int) float32 {
+	if symbol == 1 {
+		return soft
+	}
+	return -soft
+}
@@ -0,0 +1,109 @@
+package fcio
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	unixScheme = "unix://"
+	pipeScheme = "pipe://"
+)
+
+// JoinAddress the address of port on host, as net.JoinHostPort for a TCP host, an IPv6 host may
+// be bracketed or not. A unix:///dir host gives the socket unix:///dir/<port>.sock and a
+// pipe://name host the in-process pipe pipe://name:<port>, so a bindaddress and connectaddress
+// pair work the same for every transport
+func JoinAddress(host, port string) string {
+	switch {
+	case strings.HasPrefix(host, unixScheme):
+		return unixScheme + path.Join(strings.TrimPrefix(host, unixScheme), port+".sock")
+	case strings.HasPrefix(host, pipeScheme):
+		return host + ":" + port
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), port)
+}
+
+// CheckAddress checks an address can be dialled, a TCP address needs a port and an IPv6 host in
+// brackets, [2001:db8::1]:64514
+func CheckAddress(address string) error {
+	network, addr := SplitAddress(address, "tcp")
+	if network != "tcp" {
+		if addr == "" {
+			return fmt.Errorf("invalid address %q: missing %s name", address, network)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("invalid address %q: %v", address, err)
+	}
+	return nil
+}
+
+// SplitAddress the network and address to listen on or dial, unix:///path gives the unix socket
+// path, pipe://name the in-process pipe name, anything else is a TCP host:port on network
+func SplitAddress(address, network string) (string, string) {
+	switch {
+	case strings.HasPrefix(address, unixScheme):
+		return "unix", strings.TrimPrefix(address, unixScheme)
+	case strings.HasPrefix(address, pipeScheme):
+		return "pipe", strings.TrimPrefix(address, pipeScheme)
+	}
+	return network, address
+}
+
+// Listen listens on the address, a host:port on network, a unix:// socket or a pipe://, the
+// connections accepted use TLS if config is not nil. On the tcp network an unspecified host,
+// 0.0.0.0 or ::, listens on both IPv4 and IPv6
+func Listen(network, address string, config *tls.Config) (net.Listener, error) {
+	var lsock net.Listener
+	var err error
+	switch network, address = SplitAddress(address, network); network {
+	case "pipe":
+		lsock, err = listenPipe(address)
+	case "unix":
+		removeStaleSocket(address)
+		fallthrough
+	default:
+		lsock, err = net.Listen(network, address)
+	}
+	if err != nil || config == nil {
+		return lsock, err
+	}
+	return tls.NewListener(lsock, config), nil
+}
+
+// removeStaleSocket removes a socket file left by a crash, one still answering is left alone
+func removeStaleSocket(address string) {
+	info, err := os.Stat(address)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", address, time.Second); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(address)
+}
+
+// Dial connects to the address, a host:port on network, a unix:// socket or a pipe://, giving up
+// after the timeout or once ctx is done
+func Dial(ctx context.Context, network, address string, timeout time.Duration) (net.Conn, error) {
+	network, address = SplitAddress(address, network)
+	if network == "pipe" {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return dialPipe(ctx, address)
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	return dialer.DialContext(ctx, network, address)
+}
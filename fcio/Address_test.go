@@ -0,0 +1,185 @@
+package fcio
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcio/tlstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinAddress(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:64514", JoinAddress("127.0.0.1", "64514"))
+	assert.Equal(t, "[::1]:64514", JoinAddress("::1", "64514"))
+	assert.Equal(t, "[::1]:64514", JoinAddress("[::1]", "64514"))
+	assert.Equal(t, "unix:///run/funcube/64514.sock", JoinAddress("unix:///run/funcube", "64514"))
+	assert.Equal(t, "pipe://station:64514", JoinAddress("pipe://station", "64514"))
+}
+
+func TestSplitAddress(t *testing.T) {
+	for address, want := range map[string][2]string{
+		"encodeserver:64514":             {"tcp", "encodeserver:64514"},
+		"unix:///run/funcube/64514.sock": {"unix", "/run/funcube/64514.sock"},
+		"pipe://station:64514":           {"pipe", "station:64514"},
+	} {
+		network, addr := SplitAddress(address, "tcp")
+		assert.Equal(t, want, [2]string{network, addr}, address)
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	for _, address := range []string{"encodeserver:64514", "127.0.0.1:64514", "[::1]:64514", "[fe80::1%eth0]:64514",
+		"unix:///run/funcube/64514.sock", "pipe://station:64514"} {
+		assert.NoError(t, CheckAddress(address), address)
+	}
+	for _, address := range []string{"encodeserver", "::1:64514", "unix://", "pipe://"} {
+		assert.Error(t, CheckAddress(address), address)
+	}
+}
+
+// skipWithoutIPv6 skips the test where there is no IPv6 loopback, as in some containers
+func skipWithoutIPv6(t *testing.T) {
+	lsock, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	lsock.Close()
+}
+
+// writeFrames writes each frame through a ReconnectingWriter to the address, a connection each
+func writeFrames(t *testing.T, address string, config *WriterOptions, frames ...string) {
+	options := WriterOptions{}
+	if config != nil {
+		options = *config
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	w, err := NewReconnectingWriter(ctx, address, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range frames {
+		_, err := w.Write([]byte(frame))
+		assert.NoError(t, err)
+		w.EndFrame()
+	}
+}
+
+func TestListen_Unix(t *testing.T) {
+	address := JoinAddress("unix://"+t.TempDir(), "64514")
+
+	// a socket file left behind by a crash doesn't stop the next listen
+	stale, err := Listen("tcp", address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	lsock, err := Listen("tcp", address, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer lsock.Close()
+	received := accepted(lsock)
+	writeFrames(t, address, nil, "one", "two")
+	assert.Equal(t, "one", string(<-received))
+	assert.Equal(t, "two", string(<-received))
+
+	// one still in use is left alone
+	_, err = Listen("tcp", address, nil)
+	assert.Error(t, err)
+}
+
+func TestListen_Pipe(t *testing.T) {
+	address := JoinAddress("pipe://test", "64514")
+	lsock, err := Listen("tcp", address, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, address, lsock.Addr().String())
+	received := accepted(lsock)
+	writeFrames(t, address, nil, "one", "two")
+	assert.Equal(t, "one", string(<-received))
+	assert.Equal(t, "two", string(<-received))
+
+	_, err = Listen("tcp", address, nil)
+	assert.Error(t, err, "already in use")
+	lsock.Close()
+	_, ok := <-received
+	assert.False(t, ok, "accept ends once closed")
+
+	// nothing listening
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = Dial(ctx, "tcp", address, time.Second)
+	assert.Error(t, err)
+
+	// the name is free again
+	lsock, err = Listen("tcp", address, nil)
+	assert.NoError(t, err)
+	lsock.Close()
+}
+
+func TestListen_PipeTLS(t *testing.T) {
+	files := tlstest.Write(t, t.TempDir())
+	server, _ := TLSConfig{Cert: files.ServerCert, Key: files.ServerKey, CA: files.CA}.ServerConfig()
+	client, _ := TLSConfig{Cert: files.ClientCert, Key: files.ClientKey, CA: files.CA}.ClientConfig()
+
+	// the server is expected to present a certificate for localhost
+	address := JoinAddress("pipe://tls", "64514")
+	lsock, err := Listen("tcp", address, server)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer lsock.Close()
+	received := accepted(lsock)
+	writeFrames(t, address, &WriterOptions{TLS: client}, "secret")
+	assert.Equal(t, "secret", string(<-received))
+}
+
+func TestListen_UnixPath(t *testing.T) {
+	// a listener dialled through its path directly, as in a connectlocations entry
+	path := filepath.Join(t.TempDir(), "fcencode.sock")
+	lsock, err := Listen("tcp4", "unix://"+path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer lsock.Close()
+	received := accepted(lsock)
+	writeFrames(t, "unix://"+path, nil, "frame")
+	assert.Equal(t, "frame", string(<-received))
+}
+
+func TestListen_DualStack(t *testing.T) {
+	skipWithoutIPv6(t)
+
+	// the default bindaddress is reached over both families
+	lsock, err := Listen("tcp", JoinAddress("0.0.0.0", "0"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+	port := strconv.Itoa(lsock.Addr().(*net.TCPAddr).Port)
+	received := accepted(lsock)
+	writeFrames(t, JoinAddress("127.0.0.1", port), nil, "ipv4")
+	assert.Equal(t, "ipv4", string(<-received))
+	writeFrames(t, JoinAddress("::1", port), nil, "ipv6")
+	assert.Equal(t, "ipv6", string(<-received))
+}
+
+func TestListen_IPv6(t *testing.T) {
+	skipWithoutIPv6(t)
+	lsock, err := Listen("tcp", JoinAddress("[::1]", "0"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+	received := accepted(lsock)
+	writeFrames(t, lsock.Addr().String(), nil, "frame")
+	assert.Equal(t, "frame", string(<-received))
+}
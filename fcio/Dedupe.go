@@ -0,0 +1,152 @@
+package fcio
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DedupeStats counts what a Dedupe has passed on
+type DedupeStats struct {
+	Frames     uint64 `json:"frames"`     // frames added
+	Forwarded  uint64 `json:"forwarded"`  // frames forwarded, the best copy of each
+	Suppressed uint64 `json:"suppressed"` // duplicates not forwarded
+	Replaced   uint64 `json:"replaced"`   // held copies replaced by one with fewer errors
+}
+
+// Dedupe suppresses duplicate frames, such as the same frame decoded by workers on adjacent peaks
+// or sent by several stations. Each frame is held for the window and only the copy with the
+// fewest errors is forwarded, copies arriving for another window after are suppressed too
+type Dedupe struct {
+	mu        sync.Mutex
+	window    time.Duration
+	key       func(frame []byte) string
+	forward   func(frame []byte, errors int, value interface{})
+	held      map[string]*heldFrame
+	order     []*heldFrame // held by first arrival
+	forwarded map[string]time.Time
+	expiry    []forwardedFrame // forwarded in the order they expire
+	stats     DedupeStats
+	now       func() time.Time
+}
+
+type forwardedFrame struct {
+	key   string
+	until time.Time
+}
+
+type heldFrame struct {
+	key    string
+	frame  []byte
+	errors int
+	value  interface{}
+	due    time.Time
+}
+
+// NewDedupe creates a Dedupe holding frames for window (0 forwards every frame at once), key
+// gives a frame's identity (nil its content) and forward, which must not block, is called with
+// the frames, their error counts and values in the order they first arrived
+func NewDedupe(window time.Duration, key func(frame []byte) string, forward func(frame []byte, errors int, value interface{})) *Dedupe {
+	if key == nil {
+		key = func(frame []byte) string { return string(frame) }
+	}
+	return &Dedupe{
+		window:    window,
+		key:       key,
+		forward:   forward,
+		held:      make(map[string]*heldFrame),
+		forwarded: make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// Add offers a frame and the errors corrected decoding it (0 if unknown, the first copy is kept),
+// value is forwarded with the copy kept, such as what else is known of it
+func (d *Dedupe) Add(frame []byte, errors int, value interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.Frames++
+	now := d.now()
+	key := d.key(frame)
+	if h, ok := d.held[key]; ok {
+		d.stats.Suppressed++
+		if errors < h.errors {
+			h.frame, h.errors, h.value = frame, errors, value
+			d.stats.Replaced++
+		}
+		return
+	}
+	if until, ok := d.forwarded[key]; ok && now.Before(until) {
+		d.stats.Suppressed++
+		return
+	}
+	h := &heldFrame{key: key, frame: frame, errors: errors, value: value, due: now.Add(d.window)}
+	d.held[key] = h
+	d.order = append(d.order, h)
+	d.forwardDue(now)
+}
+
+// Run forwards the frames as their window passes until ctx is done, call Flush after for any
+// still held
+func (d *Dedupe) Run(ctx context.Context) {
+	interval := d.window / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.mu.Lock()
+			d.forwardDue(d.now())
+			d.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Flush forwards every frame held without waiting for its window
+func (d *Dedupe) Flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.forwardDue(time.Time{})
+}
+
+// forwardDue forwards the frames held until before now, all if now is zero, the lock held
+func (d *Dedupe) forwardDue(now time.Time) {
+	for len(d.order) > 0 {
+		h := d.order[0]
+		if !now.IsZero() && now.Before(h.due) {
+			break
+		}
+		d.order[0] = nil
+		d.order = d.order[1:]
+		delete(d.held, h.key)
+		until := h.due.Add(d.window)
+		d.forwarded[h.key] = until
+		d.expiry = append(d.expiry, forwardedFrame{key: h.key, until: until})
+		d.stats.Forwarded++
+		d.forward(h.frame, h.errors, h.value)
+	}
+	if now.IsZero() {
+		return
+	}
+	// forwarded in the order they were due, so they expire in order too
+	for len(d.expiry) > 0 && !now.Before(d.expiry[0].until) {
+		f := d.expiry[0]
+		if d.forwarded[f.key].Equal(f.until) {
+			delete(d.forwarded, f.key)
+		}
+		d.expiry[0] = forwardedFrame{}
+		d.expiry = d.expiry[1:]
+	}
+}
+
+// Stats the counts so far
+func (d *Dedupe) Stats() DedupeStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
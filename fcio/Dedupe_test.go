@@ -0,0 +1,110 @@
+package fcio
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// forwarded collects the frames a Dedupe forwards
+type forwarded struct {
+	mu     sync.Mutex
+	frames []string
+}
+
+func (f *forwarded) forward(frame []byte, errors int, value interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.frames = append(f.frames, string(frame))
+}
+
+func (f *forwarded) get() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.frames...)
+}
+
+func TestDedupe_BestCopy(t *testing.T) {
+	var out forwarded
+	// frames are the same frame if their first byte is
+	d := NewDedupe(2*time.Second, func(frame []byte) string { return string(frame[:1]) }, out.forward)
+	clock := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return clock }
+
+	d.Add([]byte("a-worker0"), 3, nil)
+	d.Add([]byte("b-worker0"), 0, nil)
+	clock = clock.Add(time.Second)
+	d.Add([]byte("a-worker1"), 1, nil)
+	d.Add([]byte("a-worker2"), 2, nil)
+	assert.Empty(t, out.get(), "held for the window")
+
+	clock = clock.Add(time.Second)
+	d.Add([]byte("c-worker0"), 0, nil)
+	assert.Equal(t, []string{"a-worker1", "b-worker0"}, out.get(), "the fewest errors, in the order first seen")
+
+	// a late copy is suppressed, until the window after forwarding has passed
+	clock = clock.Add(time.Second)
+	d.Add([]byte("a-station2"), 0, nil)
+	clock = clock.Add(time.Second)
+	d.Add([]byte("a-pass2"), 5, nil)
+	d.Flush()
+	assert.Equal(t, []string{"a-worker1", "b-worker0", "c-worker0", "a-pass2"}, out.get())
+	assert.Equal(t, DedupeStats{Frames: 7, Forwarded: 4, Suppressed: 3, Replaced: 1}, d.Stats())
+}
+
+func TestDedupe_Expiry(t *testing.T) {
+	var out forwarded
+	d := NewDedupe(time.Second, func(frame []byte) string { return string(frame[:1]) }, out.forward)
+	clock := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return clock }
+
+	// only the frames forwarded within the window are remembered
+	for i := 0; i < 100; i++ {
+		d.Add([]byte{byte(i)}, 0, nil)
+		clock = clock.Add(time.Second)
+	}
+	assert.Len(t, d.forwarded, 1)
+	assert.Len(t, d.expiry, 1)
+
+	// forwarded again once expired, a frame is remembered until its latest window passes
+	d.Add([]byte{98}, 0, nil)
+	d.Flush()
+	clock = clock.Add(time.Second)
+	d.Add([]byte{98}, 0, nil)
+	assert.Equal(t, uint64(1), d.Stats().Suppressed)
+	assert.Len(t, out.get(), 101)
+}
+
+func TestDedupe_NoWindow(t *testing.T) {
+	var out forwarded
+	d := NewDedupe(0, nil, out.forward)
+	d.Add([]byte("frame"), 1, nil)
+	d.Add([]byte("frame"), 0, nil)
+	assert.Equal(t, []string{"frame", "frame"}, out.get(), "forwarded at once")
+	assert.Equal(t, DedupeStats{Frames: 2, Forwarded: 2}, d.Stats())
+}
+
+func TestDedupe_Run(t *testing.T) {
+	var out forwarded
+	d := NewDedupe(50*time.Millisecond, nil, out.forward)
+	ctx, cancel := context.WithCancel(context.Background())
+	var group Group
+	group.Go(func() { d.Run(ctx) })
+
+	d.Add([]byte("frame"), 0, nil)
+	d.Add([]byte("frame"), 0, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(out.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"frame"}, out.get())
+
+	cancel()
+	assert.True(t, group.Wait(5*time.Second))
+	d.Add([]byte("shutdown"), 0, nil)
+	d.Flush()
+	assert.Equal(t, []string{"frame", "shutdown"}, out.get())
+}
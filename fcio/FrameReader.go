@@ -0,0 +1,92 @@
+package fcio
+
+import (
+	"errors"
+	"io"
+)
+
+// FrameStats counts what a FrameReader has read
+type FrameStats struct {
+	Frames     uint64 // whole frames read
+	Partial    uint64 // frames cut short by the end of the reader or an error
+	Misaligned uint64 // times the frames failed validation and had to be resynchronised
+	Skipped    uint64 // bytes skipped resynchronising
+}
+
+// FrameReader reads fixed size frames, carrying on after short reads until each frame is whole,
+// so a frame split across TCP segments is never lost or left misaligning the frames after it
+type FrameReader struct {
+	reader    io.Reader
+	size      int
+	validate  func(frame []byte) bool
+	buf       []byte
+	resyncing bool
+	stats     FrameStats
+}
+
+// NewFrameReader creates a FrameReader of size byte frames, validate (nil accepts every frame)
+// checks a frame header or magic, frames failing it are resynchronised a byte at a time
+func NewFrameReader(reader io.Reader, size int, validate func(frame []byte) bool) (*FrameReader, error) {
+	if reader == nil {
+		return nil, errors.New("invalid reader (io.Reader) parameter")
+	}
+	if size <= 0 {
+		return nil, errors.New("invalid frame size")
+	}
+	return &FrameReader{
+		reader:   reader,
+		size:     size,
+		validate: validate,
+		buf:      make([]byte, 0, size),
+	}, nil
+}
+
+// ReadFrame reads the next whole frame. At the end of the reader it returns io.EOF, or
+// io.ErrUnexpectedEOF if part of a frame was read, the part is kept and completed by the next
+// call (after seeking back to the start of a looped file), Drop discards it
+func (fr *FrameReader) ReadFrame() ([]byte, error) {
+	for {
+		n, err := io.ReadFull(fr.reader, fr.buf[len(fr.buf):fr.size])
+		fr.buf = fr.buf[:len(fr.buf)+n]
+		if err != nil {
+			if len(fr.buf) == 0 {
+				return nil, err
+			}
+			fr.stats.Partial++
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		if fr.validate == nil || fr.validate(fr.buf) {
+			frame := make([]byte, fr.size)
+			copy(frame, fr.buf)
+			fr.buf = fr.buf[:0]
+			fr.resyncing = false
+			fr.stats.Frames++
+			return frame, nil
+		}
+
+		// misaligned, slide along a byte and try again
+		if !fr.resyncing {
+			fr.resyncing = true
+			fr.stats.Misaligned++
+		}
+		fr.stats.Skipped++
+		copy(fr.buf, fr.buf[1:])
+		fr.buf = fr.buf[:fr.size-1]
+	}
+}
+
+// Drop discards the part of a frame read so far, returns the number of bytes dropped
+func (fr *FrameReader) Drop() int {
+	dropped := len(fr.buf)
+	fr.buf = fr.buf[:0]
+	return dropped
+}
+
+// Stats counts of the frames read so far
+func (fr *FrameReader) Stats() FrameStats {
+	return fr.stats
+}
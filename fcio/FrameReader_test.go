@@ -0,0 +1,83 @@
+package fcio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameReader_ShortReads(t *testing.T) {
+	// a byte at a time still makes whole frames
+	data := append(frames(1, 2), frames(2, 1)...)
+	fr, err := NewFrameReader(iotest.OneByteReader(bytes.NewReader(data)), 4, nil)
+	assert.NoError(t, err)
+	for _, want := range [][]byte{frames(1, 1), frames(1, 1), frames(2, 1)} {
+		frame, err := fr.ReadFrame()
+		assert.NoError(t, err)
+		assert.Equal(t, want, frame)
+	}
+	_, err = fr.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, FrameStats{Frames: 3}, fr.Stats())
+}
+
+func TestFrameReader_Partial(t *testing.T) {
+	fr, _ := NewFrameReader(bytes.NewReader([]byte{1, 1, 1, 1, 2, 2}), 4, nil)
+	frame, err := fr.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, frames(1, 1), frame)
+	_, err = fr.ReadFrame()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, uint64(1), fr.Stats().Partial)
+	assert.Equal(t, 2, fr.Drop())
+	_, err = fr.ReadFrame()
+	assert.Equal(t, io.EOF, err, "nothing left once dropped")
+}
+
+func TestFrameReader_PartialContinued(t *testing.T) {
+	// the partial frame is kept and completed from the start again, as a looped file
+	reader := bytes.NewReader([]byte{1, 2, 3, 4, 5, 6})
+	fr, _ := NewFrameReader(reader, 4, nil)
+	fr.ReadFrame()
+	_, err := fr.ReadFrame()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	reader.Seek(0, io.SeekStart)
+	frame, err := fr.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{5, 6, 1, 2}, frame)
+}
+
+func TestFrameReader_Resync(t *testing.T) {
+	// frames start with 0xAA, three stray bytes misalign the second frame
+	valid := func(frame []byte) bool { return frame[0] == 0xAA }
+	data := []byte{0xAA, 1, 1, 1, 9, 9, 9, 0xAA, 2, 2, 2, 0xAA, 3, 3, 3}
+	fr, _ := NewFrameReader(bytes.NewReader(data), 4, valid)
+	var got [][]byte
+	for {
+		frame, err := fr.ReadFrame()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		got = append(got, frame)
+	}
+	assert.Equal(t, [][]byte{{0xAA, 1, 1, 1}, {0xAA, 2, 2, 2}, {0xAA, 3, 3, 3}}, got)
+	assert.Equal(t, FrameStats{Frames: 3, Misaligned: 1, Skipped: 3}, fr.Stats())
+}
+
+func TestFrameReader_Error(t *testing.T) {
+	failed := errors.New("failed")
+	fr, _ := NewFrameReader(io.MultiReader(bytes.NewReader([]byte{1, 2}), iotest.ErrReader(failed)), 4, nil)
+	_, err := fr.ReadFrame()
+	assert.Equal(t, failed, err)
+	assert.Equal(t, uint64(1), fr.Stats().Partial)
+
+	_, err = NewFrameReader(nil, 4, nil)
+	assert.Error(t, err)
+	_, err = NewFrameReader(bytes.NewReader(nil), 0, nil)
+	assert.Error(t, err)
+}
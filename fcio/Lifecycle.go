@@ -0,0 +1,112 @@
+package fcio
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// NotifyContext returns a context that is cancelled when the process is asked to stop
+// (SIGINT or SIGTERM, as sent by docker stop), call cancel to release the signal handler
+func NotifyContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v, shutting down", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Sleep waits for the duration, returns false straight away if the context is done first
+func Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// CloseWhenDone closes c once the context is done, unblocking any Accept or Read waiting on it,
+// call the returned stop when finished with c to release the watching goroutine
+func CloseWhenDone(ctx context.Context, c io.Closer) (stop func()) {
+	finished := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-finished:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(finished) })
+		<-done
+	}
+}
+
+// Group tracks goroutines so shutdown can wait for them to finish
+type Group struct {
+	wg sync.WaitGroup
+}
+
+// Go runs fn in a goroutine tracked by the group
+func (g *Group) Go(fn func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn()
+	}()
+}
+
+// Wait waits for the goroutines to finish, returns false if the timeout passed first
+func (g *Group) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Drain waits for each group in turn (inputs first, then whatever drains them) sharing the timeout,
+// once it passes abandon is called so anything still draining gives up, grace is then allowed for
+// them to return, returns false if goroutines were still running at the end
+func Drain(timeout, grace time.Duration, abandon context.CancelFunc, groups ...*Group) bool {
+	deadline := time.Now().Add(timeout)
+	for _, g := range groups {
+		if !g.Wait(time.Until(deadline)) {
+			break
+		}
+	}
+	abandon()
+	for _, g := range groups {
+		if !g.Wait(grace) {
+			return false
+		}
+	}
+	return true
+}
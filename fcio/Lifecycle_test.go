@@ -0,0 +1,98 @@
+package fcio
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyContext(t *testing.T) {
+	ctx, cancel := NotifyContext(context.Background())
+	defer cancel()
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled by SIGTERM")
+	}
+}
+
+func TestSleep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.True(t, Sleep(ctx, time.Millisecond))
+
+	cancel()
+	start := time.Now()
+	assert.False(t, Sleep(ctx, time.Minute))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestCloseWhenDone(t *testing.T) {
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := CloseWhenDone(ctx, lsock)
+	defer stop()
+
+	accepted := make(chan error)
+	go func() {
+		_, err := lsock.Accept()
+		accepted <- err
+	}()
+	cancel()
+	select {
+	case err := <-accepted:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept not unblocked")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	g.Go(func() { <-release })
+	assert.False(t, g.Wait(10*time.Millisecond))
+	close(release)
+	assert.True(t, g.Wait(time.Second))
+}
+
+func TestDrain(t *testing.T) {
+	var inputs, outputs Group
+	drainCtx, abandon := context.WithCancel(context.Background())
+	queue := make(chan int, 4)
+	inputs.Go(func() {
+		queue <- 1
+		close(queue)
+	})
+	drained := 0
+	outputs.Go(func() {
+		for range queue {
+			drained++
+		}
+	})
+	assert.True(t, Drain(time.Second, time.Second, abandon, &inputs, &outputs))
+	assert.Equal(t, 1, drained)
+	assert.Error(t, drainCtx.Err(), "abandon is always called")
+
+	// an output that never drains is abandoned at the deadline
+	var stuck Group
+	drainCtx, abandon = context.WithCancel(context.Background())
+	stuck.Go(func() { <-drainCtx.Done() })
+	start := time.Now()
+	assert.True(t, Drain(50*time.Millisecond, time.Second, abandon, &stuck))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	var hung Group
+	release := make(chan struct{})
+	hung.Go(func() { <-release })
+	assert.False(t, Drain(10*time.Millisecond, 10*time.Millisecond, func() {}, &hung))
+	close(release)
+}
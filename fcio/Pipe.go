@@ -0,0 +1,103 @@
+package fcio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// pipes the in-process pipe listeners by name, for services embedded in one binary
+var pipes = struct {
+	sync.Mutex
+	listeners map[string]*pipeListener
+}{listeners: map[string]*pipeListener{}}
+
+// pipeListener accepts the in-process connections dialled to its name, each is a net.Pipe
+type pipeListener struct {
+	name  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return pipeScheme + string(a) }
+
+// pipeConn a net.Pipe end addressed by the pipe name
+type pipeConn struct {
+	net.Conn
+	name pipeAddr
+}
+
+func (c pipeConn) LocalAddr() net.Addr  { return c.name }
+func (c pipeConn) RemoteAddr() net.Addr { return c.name }
+
+func listenPipe(name string) (net.Listener, error) {
+	pipes.Lock()
+	defer pipes.Unlock()
+	if _, ok := pipes.listeners[name]; ok {
+		return nil, fmt.Errorf("pipe %s already in use", name)
+	}
+	l := &pipeListener{
+		name:  name,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	pipes.listeners[name] = l
+	return l, nil
+}
+
+func dialPipe(ctx context.Context, name string) (net.Conn, error) {
+	pipes.Lock()
+	l, ok := pipes.listeners[name]
+	pipes.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("nothing listening on pipe %s", name)
+	}
+
+	client, server := net.Pipe()
+	select {
+	case l.conns <- pipeConn{server, pipeAddr(name)}:
+		return pipeConn{client, pipeAddr(name)}, nil
+	case <-l.done:
+		client.Close()
+		server.Close()
+		return nil, fmt.Errorf("pipe %s closed", name)
+	case <-ctx.Done():
+		client.Close()
+		server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Accept waits for the next connection dialled to the pipe
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, errors.New("use of closed pipe listener")
+	}
+}
+
+// Close stops listening, the name can then be listened on again
+func (l *pipeListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		pipes.Lock()
+		defer pipes.Unlock()
+		if pipes.listeners[l.name] == l {
+			delete(pipes.listeners, l.name)
+		}
+	})
+	return nil
+}
+
+// Addr the pipe:// address
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.name)
+}
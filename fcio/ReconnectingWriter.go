@@ -0,0 +1,239 @@
+package fcio
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// ConnState state of a ReconnectingWriter's connection
+type ConnState int
+
+const (
+	// Connecting dialling the address
+	Connecting ConnState = iota
+	// Connected ready to write
+	Connected
+	// Failed the dial failed, it is retried after a backoff
+	Failed
+	// Disconnected the connection was closed, at a frame boundary, after a write failed or on Close
+	Disconnected
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Failed:
+		return "failed"
+	case Disconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// ConnEvent a change in the state of a ReconnectingWriter's connection
+type ConnEvent struct {
+	Address string
+	State   ConnState
+	Err     error         // why it failed or disconnected, nil for a frame boundary or Close
+	Retry   time.Duration // backoff before the next dial when Failed
+}
+
+func (e ConnEvent) String() string {
+	switch {
+	case e.State == Failed:
+		return fmt.Sprintf("Failed to connect to %s: %v, retry in %v", e.Address, e.Err, e.Retry)
+	case e.Err != nil:
+		return fmt.Sprintf("%s %s: %v", e.Address, e.State, e.Err)
+	}
+	return fmt.Sprintf("%s %s", e.Address, e.State)
+}
+
+// WriterOptions how a ReconnectingWriter connects and writes
+type WriterOptions struct {
+	Network      string        // for a host:port address, "tcp" if empty
+	DialTimeout  time.Duration // 5 seconds if 0
+	WriteTimeout time.Duration // deadline for each write, a stalled connection is dropped and redialled, 0 never
+	MinBackoff   time.Duration // wait after the first failed dial, doubled for each failure after, 1 second if 0
+	MaxBackoff   time.Duration // cap on the wait, a minute if 0
+	KeepAlive    bool          // keep the connection open across EndFrame rather than closing it
+	TLS          *tls.Config   // TLS is used when set, the handshake must complete within the dial timeout
+	OnState      func(ConnEvent)
+}
+
+// ReconnectingWriter writes to an address (a host:port, unix:// socket or pipe://), dialling it when needed and redialling with backoff
+// after failures, until its context is done. It is not safe for concurrent use
+type ReconnectingWriter struct {
+	ctx         context.Context
+	address     string
+	options     WriterOptions
+	conn        net.Conn
+	stopClosing func()
+	backoff     time.Duration
+}
+
+// NewReconnectingWriter creates a writer to address, nothing is dialled until the first Write,
+// once ctx is done writes fail and a write blocked on a stalled connection is unblocked
+func NewReconnectingWriter(ctx context.Context, address string, options WriterOptions) (*ReconnectingWriter, error) {
+	if ctx == nil {
+		return nil, errors.New("invalid ctx (context.Context) parameter")
+	}
+	if address == "" {
+		return nil, errors.New("invalid address parameter")
+	}
+	if options.Network == "" {
+		options.Network = "tcp"
+	}
+	if options.DialTimeout <= 0 {
+		options.DialTimeout = 5 * time.Second
+	}
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = time.Second
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = time.Minute
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		options.MaxBackoff = options.MinBackoff
+	}
+	return &ReconnectingWriter{
+		ctx:     ctx,
+		address: address,
+		options: options,
+	}, nil
+}
+
+// Write writes all of p, connecting first if need be, a write that fails part way is sent again from
+// the start over a new connection so that p is never split across connections, only returns an error
+// once the context is done
+func (w *ReconnectingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if err := w.ctx.Err(); err != nil {
+			return written, err
+		}
+		if w.conn == nil {
+			if err := w.connect(); err != nil {
+				return written, err
+			}
+		}
+		if w.options.WriteTimeout > 0 {
+			w.conn.SetWriteDeadline(time.Now().Add(w.options.WriteTimeout))
+		}
+		n, err := w.conn.Write(p[written:])
+		written += n
+		if err != nil {
+			if w.ctx.Err() != nil {
+				err = w.ctx.Err()
+			}
+			w.disconnect(err)
+			written = 0
+			continue
+		}
+		w.backoff = 0
+	}
+	return written, nil
+}
+
+// EndFrame marks a frame boundary, the connection is closed unless KeepAlive is set
+func (w *ReconnectingWriter) EndFrame() {
+	if !w.options.KeepAlive {
+		w.disconnect(nil)
+	}
+}
+
+// Close closes the connection, if any, the writer can still be written to while its context lasts
+func (w *ReconnectingWriter) Close() error {
+	return w.disconnect(nil)
+}
+
+// connect dials until connected, backing off after each failure, or the context is done
+func (w *ReconnectingWriter) connect() error {
+	for {
+		w.emit(ConnEvent{State: Connecting})
+		conn, err := w.dial()
+		if err == nil {
+			w.conn = conn
+			w.stopClosing = CloseWhenDone(w.ctx, conn)
+			w.emit(ConnEvent{State: Connected})
+			return nil
+		}
+		if w.ctx.Err() != nil {
+			return w.ctx.Err()
+		}
+
+		if w.backoff == 0 {
+			w.backoff = w.options.MinBackoff
+		} else if w.backoff *= 2; w.backoff > w.options.MaxBackoff {
+			w.backoff = w.options.MaxBackoff
+		}
+		w.emit(ConnEvent{State: Failed, Err: err, Retry: w.backoff})
+		if !Sleep(w.ctx, w.backoff) {
+			return w.ctx.Err()
+		}
+	}
+}
+
+// dial connects to the address, completing the TLS handshake if configured
+func (w *ReconnectingWriter) dial() (net.Conn, error) {
+	conn, err := Dial(w.ctx, w.options.Network, w.address, w.options.DialTimeout)
+	if err != nil || w.options.TLS == nil {
+		return conn, err
+	}
+
+	// servers on unix sockets and pipes are expected to present a certificate for localhost
+	config := w.options.TLS
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = "localhost"
+		if network, _ := SplitAddress(w.address, w.options.Network); network == w.options.Network {
+			config.ServerName, _, _ = net.SplitHostPort(w.address)
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+	stop := CloseWhenDone(w.ctx, conn)
+	defer stop()
+	tlsConn.SetDeadline(time.Now().Add(w.options.DialTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// disconnect closes the connection if there is one, err is why
+func (w *ReconnectingWriter) disconnect(err error) error {
+	if w.conn == nil {
+		return nil
+	}
+	w.stopClosing()
+	closeErr := w.conn.Close()
+	w.conn = nil
+	w.emit(ConnEvent{State: Disconnected, Err: err})
+	return closeErr
+}
+
+// DefaultWriteTimeout a connection stalled for longer is dropped and redialled
+const DefaultWriteTimeout = 10 * time.Second
+
+// LogConnEvent logs failed dials and dropped connections, the frame boundary reconnects are routine
+func LogConnEvent(event ConnEvent) {
+	if event.Err != nil {
+		log.Println(event)
+	}
+}
+
+func (w *ReconnectingWriter) emit(event ConnEvent) {
+	if w.options.OnState != nil {
+		event.Address = w.address
+		w.options.OnState(event)
+	}
+}
@@ -0,0 +1,246 @@
+package fcio
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// eventLog collects the events of a writer
+type eventLog struct {
+	mu     sync.Mutex
+	events []ConnEvent
+}
+
+func (l *eventLog) add(event ConnEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) states() []ConnState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var states []ConnState
+	for _, event := range l.events {
+		states = append(states, event.State)
+	}
+	return states
+}
+
+// accepted reads everything sent on each connection accepted, in order
+func accepted(lsock net.Listener) chan []byte {
+	received := make(chan []byte, 16)
+	go func() {
+		defer close(received)
+		for {
+			c, err := lsock.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := ioutil.ReadAll(c)
+			c.Close()
+			received <- data
+		}
+	}()
+	return received
+}
+
+func TestReconnectingWriter_EndFrame(t *testing.T) {
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+	received := accepted(lsock)
+
+	var events eventLog
+	w, err := NewReconnectingWriter(context.Background(), lsock.Addr().String(), WriterOptions{OnState: events.add})
+	assert.NoError(t, err)
+
+	// a frame boundary before anything is written has nothing to close
+	w.EndFrame()
+	for _, frame := range []string{"one", "two"} {
+		n, err := w.Write([]byte(frame))
+		assert.NoError(t, err)
+		assert.Equal(t, len(frame), n)
+		w.EndFrame()
+		assert.Equal(t, frame, string(<-received), "a connection per frame")
+	}
+	assert.Equal(t, []ConnState{Connecting, Connected, Disconnected, Connecting, Connected, Disconnected}, events.states())
+	assert.NoError(t, w.Close())
+}
+
+func TestReconnectingWriter_KeepAlive(t *testing.T) {
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+	received := accepted(lsock)
+
+	w, _ := NewReconnectingWriter(context.Background(), lsock.Addr().String(), WriterOptions{KeepAlive: true})
+	w.Write([]byte("one"))
+	w.EndFrame()
+	w.Write([]byte("two"))
+	w.Close()
+	assert.Equal(t, "onetwo", string(<-received), "one connection across frames")
+}
+
+func TestReconnectingWriter_Backoff(t *testing.T) {
+	// nothing listening to begin with
+	lsock, _ := net.Listen("tcp4", "127.0.0.1:0")
+	addr := lsock.Addr().String()
+	lsock.Close()
+
+	var events eventLog
+	w, _ := NewReconnectingWriter(context.Background(), addr, WriterOptions{
+		MinBackoff: 20 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+		OnState:    events.add,
+	})
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("late"))
+		written <- err
+	}()
+	time.Sleep(300 * time.Millisecond)
+	lsock, err := net.Listen("tcp4", addr)
+	if err != nil {
+		t.Skip("port taken since", err)
+	}
+	defer lsock.Close()
+	received := accepted(lsock)
+
+	assert.NoError(t, <-written, "written once listening")
+	w.Close()
+	assert.Equal(t, "late", string(<-received))
+
+	var retries []time.Duration
+	events.mu.Lock()
+	for _, event := range events.events {
+		if event.State == Failed {
+			assert.Error(t, event.Err)
+			retries = append(retries, event.Retry)
+		}
+	}
+	events.mu.Unlock()
+	if assert.True(t, len(retries) >= 3) {
+		assert.Equal(t, []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond}, retries[:3],
+			"doubled up to the cap")
+	}
+}
+
+func TestReconnectingWriter_WriteTimeout(t *testing.T) {
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+	conns := make(chan net.Conn, 4)
+	go func() {
+		for {
+			c, err := lsock.Accept()
+			if err != nil {
+				close(conns)
+				return
+			}
+			conns <- c
+		}
+	}()
+
+	// the first connection never reads, the write is dropped at its deadline and redialled
+	var events eventLog
+	ctx, cancel := context.WithCancel(context.Background())
+	w, _ := NewReconnectingWriter(ctx, lsock.Addr().String(), WriterOptions{
+		WriteTimeout: 100 * time.Millisecond,
+		MinBackoff:   10 * time.Millisecond,
+		OnState:      events.add,
+	})
+	written := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 64<<20))
+		written <- err
+	}()
+	stalled := <-conns
+	defer stalled.Close()
+	second := <-conns
+	defer second.Close()
+	assert.Contains(t, events.states(), Disconnected)
+
+	// the context ends the write blocked on the second, also never read
+	cancel()
+	select {
+	case err := <-written:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("write not abandoned")
+	}
+}
+
+func TestReconnectingWriter_Resend(t *testing.T) {
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+
+	// the first connection never reads, so the frame is only part written when it is dropped,
+	// the second reads everything sent on it
+	stalled := make(chan net.Conn, 1)
+	received := make(chan []byte, 1)
+	go func() {
+		c, err := lsock.Accept()
+		if err != nil {
+			return
+		}
+		stalled <- c
+		c, err = lsock.Accept()
+		if err != nil {
+			return
+		}
+		data, _ := ioutil.ReadAll(c)
+		c.Close()
+		received <- data
+	}()
+
+	var events eventLog
+	var w *ReconnectingWriter
+	w, _ = NewReconnectingWriter(context.Background(), lsock.Addr().String(), WriterOptions{
+		WriteTimeout: 100 * time.Millisecond,
+		MinBackoff:   10 * time.Millisecond,
+		OnState: func(event ConnEvent) {
+			// however slowly the second is read
+			if event.State == Disconnected {
+				w.options.WriteTimeout = 0
+			}
+			events.add(event)
+		},
+	})
+	frame := make([]byte, 16<<20)
+	for i := range frame {
+		frame[i] = byte(i)
+	}
+	n, err := w.Write(frame)
+	assert.NoError(t, err)
+	assert.Equal(t, len(frame), n)
+	w.Close()
+	(<-stalled).Close()
+	assert.Contains(t, events.states(), Disconnected)
+	assert.Equal(t, frame, <-received, "the whole frame sent again from the start")
+}
+
+func TestNewReconnectingWriter_Errors(t *testing.T) {
+	_, err := NewReconnectingWriter(context.Background(), "", WriterOptions{})
+	assert.Error(t, err)
+	w, err := NewReconnectingWriter(context.Background(), "localhost:1", WriterOptions{MinBackoff: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp", w.options.Network)
+	assert.Equal(t, time.Minute, w.options.MaxBackoff, "cap no lower than the first wait")
+}
@@ -0,0 +1,335 @@
+package fcio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduling how a SourceQueue chooses the source of the next chunk
+type Scheduling int
+
+const (
+	// Fair takes a chunk from each source with one ready in turn, sources with a higher priority first
+	Fair Scheduling = iota
+	// Sequential takes every chunk of one source before moving on to the next, highest priority then
+	// oldest first, for sample streams that must not be interleaved
+	Sequential
+)
+
+// SourceOptions how a source added to a SourceQueue is read
+type SourceOptions struct {
+	Name        string
+	Priority    int           // higher is taken from first
+	Loop        bool          // go back to the start at the end, if the source can seek
+	ReadTimeout time.Duration // a source with a SetReadDeadline (a net.Conn) ends if a read takes longer, 0 never
+
+	// Validate checks the header or magic of each whole frame (align equal to the chunk size),
+	// frames failing it are resynchronised, nil accepts every frame
+	Validate func(frame []byte) bool
+}
+
+// sourceBuffer chunks each source can read ahead
+const sourceBuffer = 4
+
+// SourceQueue reads any number of sources concurrently, each in its own goroutine, and hands
+// their data on in chunks, so a slow source never holds up the others
+type SourceQueue struct {
+	chunkSize  int
+	align      int
+	scheduling Scheduling
+
+	mu      sync.Mutex
+	sources []*source
+	current *source
+	served  uint64
+	closed  bool
+	signal  chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type source struct {
+	options    SourceOptions
+	reader     io.ReadCloser
+	chunks     chan []byte
+	head       []byte
+	ended      bool
+	lastServed uint64
+	finished   int32 // 1 once the reading goroutine has finished
+}
+
+// NewSourceQueue creates a queue handing on chunks of at most chunkSize bytes, always a multiple
+// of align bytes, the bytes left at the end of a source that do not make up align are dropped.
+// An align of chunkSize gives whole frames
+func NewSourceQueue(chunkSize, align int, scheduling Scheduling) *SourceQueue {
+	if align <= 0 || align > chunkSize {
+		align = chunkSize
+	}
+	return &SourceQueue{
+		chunkSize:  chunkSize,
+		align:      align,
+		scheduling: scheduling,
+		signal:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+// Add starts reading a source, it is closed once it ends or the queue is closed
+func (q *SourceQueue) Add(reader io.ReadCloser, options SourceOptions) error {
+	if reader == nil {
+		return errors.New("invalid reader (io.ReadCloser) parameter")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		reader.Close()
+		return errors.New("source queue closed")
+	}
+	src := &source{
+		options: options,
+		reader:  reader,
+		chunks:  make(chan []byte, sourceBuffer),
+	}
+	q.sources = append(q.sources, src)
+	q.wg.Add(1)
+	go q.read(src)
+	return nil
+}
+
+// Len number of sources still open or with chunks not yet taken
+func (q *SourceQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	count := 0
+	for _, src := range q.sources {
+		if src.head != nil || len(src.chunks) > 0 || atomic.LoadInt32(&src.finished) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// Next waits for the next chunk, returns false if the context is done or the queue closed first
+func (q *SourceQueue) Next(ctx context.Context) ([]byte, bool) {
+	for {
+		q.mu.Lock()
+		chunk := q.take()
+		q.mu.Unlock()
+		if chunk != nil {
+			return chunk, true
+		}
+		select {
+		case <-q.signal:
+		case <-ctx.Done():
+			return nil, false
+		case <-q.done:
+			return nil, false
+		}
+	}
+}
+
+// Close stops reading and closes every source, waiting for their goroutines to finish
+func (q *SourceQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.done)
+	for _, src := range q.sources {
+		src.reader.Close()
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sources = nil
+	q.current = nil
+}
+
+// take the next chunk by the scheduling, nil if none is ready, called holding mu
+func (q *SourceQueue) take() []byte {
+	// peek a chunk from each source and drop those that have ended and been emptied
+	remaining := q.sources[:0]
+	for _, src := range q.sources {
+		if src.head == nil && !src.ended {
+			select {
+			case chunk, ok := <-src.chunks:
+				if ok {
+					src.head = chunk
+				} else {
+					src.ended = true
+				}
+			default:
+			}
+		}
+		if src.ended && src.head == nil {
+			continue
+		}
+		remaining = append(remaining, src)
+	}
+	for i := len(remaining); i < len(q.sources); i++ {
+		q.sources[i] = nil
+	}
+	q.sources = remaining
+
+	var next *source
+	switch q.scheduling {
+	case Sequential:
+		if q.current == nil || (q.current.ended && q.current.head == nil) {
+			q.current = nil
+			for _, src := range q.sources {
+				if q.current == nil || src.options.Priority > q.current.options.Priority {
+					q.current = src
+				}
+			}
+		}
+		next = q.current
+	default:
+		for _, src := range q.sources {
+			if src.head == nil {
+				continue
+			}
+			if next == nil || src.options.Priority > next.options.Priority ||
+				(src.options.Priority == next.options.Priority && src.lastServed < next.lastServed) {
+				next = src
+			}
+		}
+	}
+	if next == nil || next.head == nil {
+		return nil
+	}
+
+	chunk := next.head
+	next.head = nil
+	q.served++
+	next.lastServed = q.served
+	return chunk
+}
+
+// notify wakes Next
+func (q *SourceQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// read reads a source into chunks until it ends, loops if asked, or the queue is closed
+func (q *SourceQueue) read(src *source) {
+	defer q.wg.Done()
+	defer q.notify()
+	defer close(src.chunks)
+	defer atomic.StoreInt32(&src.finished, 1)
+	defer src.reader.Close()
+
+	seeker, _ := src.reader.(io.Seeker)
+	conn, _ := src.reader.(interface{ SetReadDeadline(time.Time) error })
+	if q.align == q.chunkSize {
+		q.readFrames(src, seeker, conn)
+		return
+	}
+
+	var buffered []byte
+	raw := make([]byte, q.chunkSize)
+	readSinceStart := 0
+	for {
+		if conn != nil && src.options.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(src.options.ReadTimeout))
+		}
+		n, err := src.reader.Read(raw)
+		readSinceStart += n
+		buffered = append(buffered, raw[:n]...)
+
+		// hand on as much as makes up whole multiples of align
+		for len(buffered) >= q.align {
+			size := len(buffered)
+			if size > q.chunkSize {
+				size = q.chunkSize
+			}
+			size -= size % q.align
+			chunk := make([]byte, size)
+			copy(chunk, buffered)
+			buffered = buffered[size:]
+			select {
+			case src.chunks <- chunk:
+				q.notify()
+			case <-q.done:
+				return
+			}
+		}
+
+		if err == io.EOF && src.options.Loop && seeker != nil && readSinceStart > 0 {
+			if _, err = seeker.Seek(0, io.SeekStart); err == nil {
+				readSinceStart = 0
+				continue
+			}
+		}
+		if err != nil {
+			if err != io.EOF && !isTimeout(err) && !q.isClosed() {
+				log.Printf("Source %s failed: %v", src.options.Name, err)
+			}
+			return
+		}
+	}
+}
+
+// readFrames reads a source a whole frame at a time through a FrameReader, reporting partial and
+// misaligned frames once it ends
+func (q *SourceQueue) readFrames(src *source, seeker io.Seeker, conn interface{ SetReadDeadline(time.Time) error }) {
+	frames, _ := NewFrameReader(src.reader, q.chunkSize, src.options.Validate)
+	defer func() {
+		if dropped := frames.Drop(); dropped > 0 {
+			log.Printf("Source %s: dropped partial frame of %d bytes", src.options.Name, dropped)
+		}
+		if stats := frames.Stats(); stats.Misaligned > 0 {
+			log.Printf("Source %s: resynchronised %d misaligned frames, skipped %d bytes",
+				src.options.Name, stats.Misaligned, stats.Skipped)
+		}
+	}()
+
+	for {
+		if conn != nil && src.options.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(src.options.ReadTimeout))
+		}
+		frame, err := frames.ReadFrame()
+		if err == nil {
+			select {
+			case src.chunks <- frame:
+				q.notify()
+			case <-q.done:
+				return
+			}
+			continue
+		}
+
+		// a looped file carries a partial frame on from its end to its start, an empty one stops
+		if (err == io.EOF || err == io.ErrUnexpectedEOF) && src.options.Loop && seeker != nil {
+			if end, serr := seeker.Seek(0, io.SeekCurrent); serr == nil && end > 0 {
+				if _, err = seeker.Seek(0, io.SeekStart); err == nil {
+					continue
+				}
+			}
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !isTimeout(err) && !q.isClosed() {
+			log.Printf("Source %s failed: %v", src.options.Name, err)
+		}
+		return
+	}
+}
+
+func (q *SourceQueue) isClosed() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,231 @@
+package fcio
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// frames count 4 byte frames of the value
+func frames(value byte, count int) []byte {
+	return bytes.Repeat([]byte{value}, 4*count)
+}
+
+func bufferSource(data []byte) *ReadSeekCloser {
+	reader, _ := NewReadSeekCloser(ioutil.NopCloser(bytes.NewReader(data)))
+	return reader
+}
+
+// takeAll takes chunks until none arrives for a while
+func takeAll(q *SourceQueue) [][]byte {
+	var chunks [][]byte
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		chunk, ok := q.Next(ctx)
+		cancel()
+		if !ok {
+			return chunks
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestSourceQueue_Fair(t *testing.T) {
+	q := NewSourceQueue(4, 4, Fair)
+	defer q.Close()
+	a, b := frames(1, 3), frames(2, 3)
+
+	// both are read ahead before anything is taken, then taken from in turn
+	q.Add(bufferSource(a), SourceOptions{Name: "a"})
+	q.Add(bufferSource(b), SourceOptions{Name: "b"})
+	time.Sleep(100 * time.Millisecond)
+	var order []byte
+	for _, chunk := range takeAll(q) {
+		order = append(order, chunk[0])
+	}
+	assert.Equal(t, []byte{1, 2, 1, 2, 1, 2}, order)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestSourceQueue_Priority(t *testing.T) {
+	q := NewSourceQueue(4, 4, Fair)
+	defer q.Close()
+	q.Add(bufferSource(frames(1, 2)), SourceOptions{Name: "low"})
+	q.Add(bufferSource(frames(2, 2)), SourceOptions{Name: "high", Priority: 1})
+	time.Sleep(100 * time.Millisecond)
+	var order []byte
+	for _, chunk := range takeAll(q) {
+		order = append(order, chunk[0])
+	}
+	assert.Equal(t, []byte{2, 2, 1, 1}, order)
+}
+
+func TestSourceQueue_Sequential(t *testing.T) {
+	// a chunk of at most 8 bytes aligned to 4, the odd bytes at the end are dropped
+	q := NewSourceQueue(8, 4, Sequential)
+	defer q.Close()
+	q.Add(bufferSource(append(frames(1, 3), 9)), SourceOptions{Name: "first"})
+	q.Add(bufferSource(frames(2, 2)), SourceOptions{Name: "second"})
+	time.Sleep(100 * time.Millisecond)
+	var all []byte
+	for _, chunk := range takeAll(q) {
+		assert.True(t, len(chunk) <= 8 && len(chunk)%4 == 0)
+		all = append(all, chunk...)
+	}
+	assert.Equal(t, append(frames(1, 3), frames(2, 2)...), all, "every chunk of a source before the next")
+}
+
+func TestSourceQueue_Loop(t *testing.T) {
+	// a 6 byte file looped makes whole 4 byte frames across the end
+	file := filepath.Join(t.TempDir(), "loop.bin")
+	if err := ioutil.WriteFile(file, []byte{1, 2, 3, 4, 5, 6}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewSourceQueue(4, 4, Fair)
+	q.Add(f, SourceOptions{Name: file, Loop: true})
+	var all []byte
+	for i := 0; i < 3; i++ {
+		chunk, ok := q.Next(context.Background())
+		assert.True(t, ok)
+		all = append(all, chunk...)
+	}
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 1, 2, 3, 4, 5, 6}, all)
+	q.Close()
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestSourceQueue_SlowConnection(t *testing.T) {
+	before := runtime.NumGoroutine()
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+
+	q := NewSourceQueue(4, 4, Fair)
+	// a client that sends half a frame then stalls doesn't hold up the others, it is dropped at its read deadline
+	slow, err := net.Dial("tcp4", lsock.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	slow.Write([]byte{7, 7})
+	c, _ := lsock.Accept()
+	q.Add(c, SourceOptions{Name: "slow", ReadTimeout: 200 * time.Millisecond})
+	q.Add(bufferSource(frames(1, 1)), SourceOptions{Name: "fast"})
+
+	chunk, ok := q.Next(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, frames(1, 1), chunk)
+	assert.Equal(t, 1, q.Len(), "slow still open")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, q.Len(), "slow dropped at its deadline")
+
+	q.Close()
+	lsock.Close()
+	slow.Close()
+	deadline = time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "goroutines leaked")
+}
+
+func TestSourceQueue_Concurrent(t *testing.T) {
+	q := NewSourceQueue(256, 256, Fair)
+	const sources, count = 16, 20
+
+	// sources added from many goroutines while the frames are taken, run with -race
+	var added sync.WaitGroup
+	for i := 0; i < sources; i++ {
+		added.Add(1)
+		go func(i int) {
+			defer added.Done()
+			q.Add(bufferSource(bytes.Repeat([]byte{byte(i)}, 256*count)), SourceOptions{})
+		}(i)
+	}
+	received := map[byte]int{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for total := 0; total < sources*count; total++ {
+		chunk, ok := q.Next(ctx)
+		if !assert.True(t, ok, "timed out after %d frames", total) {
+			break
+		}
+		assert.Len(t, chunk, 256)
+		received[chunk[0]]++
+	}
+	added.Wait()
+	for i := 0; i < sources; i++ {
+		assert.Equal(t, count, received[byte(i)])
+	}
+	q.Close()
+}
+
+func TestSourceQueue_Close(t *testing.T) {
+	before := runtime.NumGoroutine()
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+	client, err := net.Dial("tcp4", lsock.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	c, _ := lsock.Accept()
+
+	// a source blocked reading, and one blocked handing on chunks nobody takes
+	q := NewSourceQueue(4, 4, Fair)
+	q.Add(c, SourceOptions{Name: "idle"})
+	q.Add(bufferSource(frames(1, 100)), SourceOptions{Name: "full"})
+	time.Sleep(100 * time.Millisecond)
+
+	taken := make(chan bool)
+	go func() {
+		_, ok := q.Next(context.Background())
+		for ok {
+			_, ok = q.Next(context.Background())
+		}
+		taken <- ok
+	}()
+	q.Close()
+	assert.False(t, <-taken, "Next returns once closed")
+	assert.Equal(t, 0, q.Len())
+	assert.Error(t, q.Add(bufferSource(frames(1, 1)), SourceOptions{}))
+
+	lsock.Close()
+	client.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "goroutines leaked")
+}
+
+func TestSourceQueue_Validate(t *testing.T) {
+	// a stray byte between frames is skipped to the next valid frame
+	q := NewSourceQueue(4, 4, Fair)
+	defer q.Close()
+	data := append(append(frames(1, 1), 9), frames(1, 2)...)
+	q.Add(bufferSource(data), SourceOptions{Name: "stray", Validate: func(frame []byte) bool { return frame[0] == 1 }})
+	assert.Equal(t, [][]byte{frames(1, 1), frames(1, 1), frames(1, 1)}, takeAll(q))
+}
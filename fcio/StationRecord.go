@@ -0,0 +1,152 @@
+package fcio
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	stationMagic     = "FCS1"
+	stationNameSize  = 16
+	stationFrameSize = 256
+
+	// StationRecordSize the bytes of each StationRecord sent: magic, station name, decode time,
+	// error count, frame and the signature of them all
+	StationRecordSize = len(stationMagic) + stationNameSize + 8 + 2 + stationFrameSize + sha256.Size
+
+	// unknownErrors the error count sent for one not known
+	unknownErrors = 0xffff
+)
+
+// StationRecord a 256 byte frame decoded by a receive station, as sent to an aggregation hub,
+// signed with the station's key so the hub knows which station heard it
+type StationRecord struct {
+	Station string    // up to 16 bytes
+	Time    time.Time // decoded, to the millisecond
+	Errors  int       // corrected decoding the frame, -1 if not known
+	Frame   []byte
+}
+
+// Encode the record signed with key
+func (r StationRecord) Encode(key []byte) ([]byte, error) {
+	if r.Station == "" || len(r.Station) > stationNameSize {
+		return nil, fmt.Errorf("station name %q must be 1 to %d bytes", r.Station, stationNameSize)
+	}
+	if len(r.Frame) != stationFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes, not %d", len(r.Frame), stationFrameSize)
+	}
+	// not known is sent as 0xffff, more errors than fit as 0xfffe
+	errorCount := r.Errors
+	switch {
+	case errorCount < 0:
+		errorCount = unknownErrors
+	case errorCount >= unknownErrors:
+		errorCount = unknownErrors - 1
+	}
+	data := make([]byte, 0, StationRecordSize)
+	data = append(data, stationMagic...)
+	name := make([]byte, stationNameSize)
+	copy(name, r.Station)
+	data = append(data, name...)
+	var fields [10]byte
+	binary.BigEndian.PutUint64(fields[:8], uint64(r.Time.UnixNano()/int64(time.Millisecond)))
+	binary.BigEndian.PutUint16(fields[8:], uint16(errorCount))
+	data = append(data, fields[:]...)
+	data = append(data, r.Frame...)
+	return append(data, signStationRecord(data, key)...), nil
+}
+
+// IsStationRecord whether data starts as a StationRecord does, for resynchronising a stream of them
+func IsStationRecord(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(stationMagic))
+}
+
+// DecodeStationRecord checks the record was signed with the key of the station named, keys gives
+// the key of each station known, nil for one that is not
+func DecodeStationRecord(data []byte, keys func(station string) []byte) (StationRecord, error) {
+	if len(data) != StationRecordSize || !IsStationRecord(data) {
+		return StationRecord{}, errors.New("not a station record")
+	}
+	name := data[len(stationMagic) : len(stationMagic)+stationNameSize]
+	station := string(bytes.TrimRight(name, "\x00"))
+	key := keys(station)
+	if key == nil {
+		return StationRecord{}, fmt.Errorf("unknown station %q", station)
+	}
+	signed := data[:StationRecordSize-sha256.Size]
+	if !hmac.Equal(data[len(signed):], signStationRecord(signed, key)) {
+		return StationRecord{}, fmt.Errorf("bad signature from station %q", station)
+	}
+	fields := data[len(stationMagic)+stationNameSize:]
+	millis := int64(binary.BigEndian.Uint64(fields))
+	errorCount := int(binary.BigEndian.Uint16(fields[8:]))
+	if errorCount == unknownErrors {
+		errorCount = -1
+	}
+	frame := make([]byte, stationFrameSize)
+	copy(frame, fields[10:])
+	return StationRecord{
+		Station: station,
+		Time:    time.Unix(0, millis*int64(time.Millisecond)).UTC(),
+		Errors:  errorCount,
+		Frame:   frame,
+	}, nil
+}
+
+func signStationRecord(data, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// ReplayGuard rejects StationRecords replayed: those whose time is not within a window either side
+// of the clock and, as a record's signature proves only who sent it, those received already within
+// the window
+type ReplayGuard struct {
+	window time.Duration
+	now    func() time.Time
+	mu     sync.Mutex
+	seen   map[string]bool // the signatures of the records within the window
+	order  []seenRecord    // the signatures in the order received, for expiring them
+}
+
+type seenRecord struct {
+	signature string
+	time      time.Time
+}
+
+// NewReplayGuard creates a ReplayGuard accepting records timed within window of the clock
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{window: window, now: time.Now, seen: make(map[string]bool)}
+}
+
+// Check the record decoded from data is not a replay, remembering it until it falls out of the window
+func (g *ReplayGuard) Check(data []byte, r StationRecord) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	if r.Time.Before(now.Add(-g.window)) || r.Time.After(now.Add(g.window)) {
+		return fmt.Errorf("record from station %q timed %s, not within %s of %s", r.Station,
+			r.Time.Format(time.RFC3339), g.window, now.UTC().Format(time.RFC3339))
+	}
+
+	// the records received in time order, near enough, the front ones expire first
+	for len(g.order) > 0 && g.order[0].time.Before(now.Add(-g.window)) {
+		delete(g.seen, g.order[0].signature)
+		g.order[0] = seenRecord{}
+		g.order = g.order[1:]
+	}
+	signature := string(data[len(data)-sha256.Size:])
+	if g.seen[signature] {
+		return fmt.Errorf("record from station %q replayed", r.Station)
+	}
+	g.seen[signature] = true
+	g.order = append(g.order, seenRecord{signature: signature, time: r.Time})
+	return nil
+}
@@ -0,0 +1,95 @@
+package fcio
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStationRecord(t *testing.T) {
+	keys := func(station string) []byte {
+		if station == "G0ABC" {
+			return []byte("secret")
+		}
+		return nil
+	}
+	record := StationRecord{
+		Station: "G0ABC",
+		Time:    time.Date(2020, 6, 1, 12, 0, 0, 123e6, time.UTC),
+		Errors:  7,
+		Frame:   bytes.Repeat([]byte{0xfc}, 256),
+	}
+	data, err := record.Encode([]byte("secret"))
+	assert.NoError(t, err)
+	assert.Len(t, data, StationRecordSize)
+	assert.True(t, IsStationRecord(data))
+
+	decoded, err := DecodeStationRecord(data, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, record, decoded)
+
+	// an error count not known stays so, one too large to send is as large as can be
+	for errors, want := range map[int]int{-1: -1, 0xfffe: 0xfffe, 70000: 0xfffe} {
+		counted := record
+		counted.Errors = errors
+		encoded, _ := counted.Encode([]byte("secret"))
+		decoded, err := DecodeStationRecord(encoded, keys)
+		assert.NoError(t, err)
+		assert.Equal(t, want, decoded.Errors)
+	}
+
+	// signed with another key, tampered with, or from a station not known
+	forged, _ := record.Encode([]byte("guess"))
+	_, err = DecodeStationRecord(forged, keys)
+	assert.EqualError(t, err, `bad signature from station "G0ABC"`)
+	data[30]++
+	_, err = DecodeStationRecord(data, keys)
+	assert.Error(t, err)
+	record.Station = "M0XYZ"
+	other, _ := record.Encode([]byte("secret"))
+	_, err = DecodeStationRecord(other, keys)
+	assert.EqualError(t, err, `unknown station "M0XYZ"`)
+	_, err = DecodeStationRecord(record.Frame, keys)
+	assert.Error(t, err)
+
+	record.Station = "a-station-name-too-long"
+	_, err = record.Encode([]byte("secret"))
+	assert.Error(t, err)
+	record.Station, record.Frame = "G0ABC", record.Frame[:200]
+	_, err = record.Encode([]byte("secret"))
+	assert.Error(t, err)
+}
+
+func TestReplayGuard(t *testing.T) {
+	clock := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	guard := NewReplayGuard(time.Minute)
+	guard.now = func() time.Time { return clock }
+	encode := func(at time.Time, value byte) ([]byte, StationRecord) {
+		record := StationRecord{Station: "G0ABC", Time: at, Frame: bytes.Repeat([]byte{value}, 256)}
+		data, err := record.Encode([]byte("secret"))
+		assert.NoError(t, err)
+		return data, record
+	}
+
+	data, record := encode(clock.Add(-30*time.Second), 1)
+	assert.NoError(t, guard.Check(data, record))
+	assert.EqualError(t, guard.Check(data, record), `record from station "G0ABC" replayed`)
+	other, otherRecord := encode(clock.Add(30*time.Second), 2)
+	assert.NoError(t, guard.Check(other, otherRecord))
+
+	// outside the window either side
+	old, oldRecord := encode(clock.Add(-2*time.Minute), 3)
+	assert.Error(t, guard.Check(old, oldRecord))
+	early, earlyRecord := encode(clock.Add(2*time.Minute), 4)
+	assert.Error(t, guard.Check(early, earlyRecord))
+
+	// replayed once out of the window, the signatures seen are expired
+	clock = clock.Add(time.Minute)
+	assert.Error(t, guard.Check(data, record))
+	newer, newerRecord := encode(clock, 5)
+	assert.NoError(t, guard.Check(newer, newerRecord))
+	assert.Len(t, guard.seen, 2)
+	assert.Len(t, guard.order, 2)
+}
@@ -0,0 +1,91 @@
+package fcio
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig settings for TLS on the links between services, the koanf tags match the tls table
+// of the service configuration files. Cert and Key identify this end of a link, CA verifies the
+// other end, so listeners with a CA only accept clients presenting a certificate it signed
+type TLSConfig struct {
+	Cert       string `koanf:"cert"`       // PEM certificate file
+	Key        string `koanf:"key"`        // PEM private key file
+	CA         string `koanf:"ca"`         // PEM certificate authorities trusted to sign the other end
+	ServerName string `koanf:"servername"` // name dialled servers must present, the host dialled if empty
+}
+
+// Enabled is TLS configured
+func (c TLSConfig) Enabled() bool {
+	return c.Cert != "" || c.Key != "" || c.CA != ""
+}
+
+// ServerConfig the tls.Config for listeners, nil if TLS is not enabled, a listener needs a
+// certificate and with a CA requires and verifies client certificates
+func (c TLSConfig) ServerConfig() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	if c.Cert == "" || c.Key == "" {
+		return nil, errors.New("tls needs a cert and key to listen")
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if err := c.loadCertificate(config); err != nil {
+		return nil, err
+	}
+	if c.CA != "" {
+		pool, err := c.loadCA()
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientConfig the tls.Config for dialers, nil if TLS is not enabled, servers are verified
+// against the CA (the system roots if none) and the certificate, if any, is presented to them
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: c.ServerName}
+	if c.Cert != "" || c.Key != "" {
+		if err := c.loadCertificate(config); err != nil {
+			return nil, err
+		}
+	}
+	if c.CA != "" {
+		pool, err := c.loadCA()
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+func (c TLSConfig) loadCertificate(config *tls.Config) error {
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return fmt.Errorf("failed to load tls cert %s and key %s: %v", c.Cert, c.Key, err)
+	}
+	config.Certificates = []tls.Certificate{cert}
+	return nil
+}
+
+func (c TLSConfig) loadCA() (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(c.CA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tls ca %s: %v", c.CA, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in tls ca %s", c.CA)
+	}
+	return pool, nil
+}
@@ -0,0 +1,103 @@
+package fcio
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcio/tlstest"
+	"github.com/stretchr/testify/assert"
+)
+
+// tlsListener listens with the server certificate, clients must present one signed by the CA
+func tlsListener(t *testing.T, files tlstest.Files) (net.Listener, chan []byte) {
+	server, err := TLSConfig{Cert: files.ServerCert, Key: files.ServerKey, CA: files.CA}.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lsock, err := Listen("tcp4", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lsock, accepted(lsock)
+}
+
+// send writes the frame through a ReconnectingWriter with the client config
+func send(t *testing.T, address string, client TLSConfig, frame string) {
+	config, err := client.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	w, _ := NewReconnectingWriter(ctx, address, WriterOptions{TLS: config})
+	_, err = w.Write([]byte(frame))
+	assert.NoError(t, err)
+	w.Close()
+}
+
+func TestTLS_MutualAuth(t *testing.T) {
+	files := tlstest.Write(t, t.TempDir())
+	lsock, received := tlsListener(t, files)
+	defer lsock.Close()
+
+	send(t, lsock.Addr().String(), TLSConfig{Cert: files.ClientCert, Key: files.ClientKey, CA: files.CA}, "trusted")
+	assert.Equal(t, "trusted", string(<-received))
+
+	// signed by someone else, the server drops the connection without reading anything
+	send(t, lsock.Addr().String(), TLSConfig{Cert: files.StrangerCert, Key: files.StrangerKey, CA: files.CA}, "stranger")
+	assert.Empty(t, <-received)
+
+	// no certificate at all, nor TLS
+	c, err := net.Dial("tcp4", lsock.Addr().String())
+	if assert.NoError(t, err) {
+		c.Write([]byte("plaintext"))
+		c.Close()
+	}
+	assert.Empty(t, <-received)
+}
+
+func TestTLS_UntrustedServer(t *testing.T) {
+	files := tlstest.Write(t, t.TempDir())
+	lsock, _ := tlsListener(t, files)
+	defer lsock.Close()
+
+	// the client only trusts another CA, so never connects
+	other := tlstest.Write(t, t.TempDir())
+	config, _ := TLSConfig{Cert: files.ClientCert, Key: files.ClientKey, CA: other.CA}.ClientConfig()
+	var events eventLog
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	w, _ := NewReconnectingWriter(ctx, lsock.Addr().String(), WriterOptions{TLS: config, OnState: events.add})
+	_, err := w.Write([]byte("frame"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Contains(t, events.states(), Failed)
+	assert.NotContains(t, events.states(), Connected)
+}
+
+func TestTLSConfig(t *testing.T) {
+	files := tlstest.Write(t, t.TempDir())
+
+	config, err := TLSConfig{}.ServerConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, config, "not enabled")
+	config, err = TLSConfig{}.ClientConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, config, "not enabled")
+
+	// a client only verifying the server needs no certificate of its own, a server always does
+	config, err = TLSConfig{CA: files.CA}.ClientConfig()
+	assert.NoError(t, err)
+	assert.NotNil(t, config.RootCAs)
+	_, err = TLSConfig{CA: files.CA}.ServerConfig()
+	assert.Error(t, err)
+
+	_, err = TLSConfig{Cert: files.ServerCert, Key: files.ClientKey}.ServerConfig()
+	assert.Error(t, err, "mismatched key")
+	_, err = TLSConfig{Cert: files.ServerCert, Key: files.ServerKey, CA: filepath.Join(t.TempDir(), "missing.pem")}.ServerConfig()
+	assert.Error(t, err)
+	_, err = TLSConfig{CA: files.ServerKey}.ClientConfig()
+	assert.Error(t, err, "no certificates in the ca")
+}
@@ -0,0 +1,110 @@
+package fcio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+const wavHeaderSize = 44
+
+// WavWriter writes 32 bit float WAV files, complex samples are written as two channels (I and Q)
+type WavWriter struct {
+	writer     io.WriteSeeker
+	sampleRate uint32
+	channels   uint16
+	dataSize   uint32
+	buf        []byte
+}
+
+// NewWavWriter creates a WavWriter and writes a placeholder header, sizes are filled in by Close
+func NewWavWriter(writer io.WriteSeeker, sampleRate int, channels int) (*WavWriter, error) {
+	if writer == nil {
+		return nil, errors.New("invalid writer (io.WriteSeeker) parameter")
+	}
+	if sampleRate <= 0 || channels <= 0 {
+		return nil, errors.New("invalid sample rate or channel count")
+	}
+	ww := &WavWriter{
+		writer:     writer,
+		sampleRate: uint32(sampleRate),
+		channels:   uint16(channels),
+	}
+	if err := ww.writeHeader(); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// WriteIQ writes complex samples, the WavWriter must have been created with two channels
+func (ww *WavWriter) WriteIQ(samples []complex64) error {
+	if ww.channels != 2 {
+		return errors.New("IQ samples need a two channel wav file")
+	}
+	ww.grow(len(samples) * 8)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint32(ww.buf[i*8:], math.Float32bits(real(s)))
+		binary.LittleEndian.PutUint32(ww.buf[i*8+4:], math.Float32bits(imag(s)))
+	}
+	return ww.writeData(ww.buf[:len(samples)*8])
+}
+
+// WriteSamples writes interleaved samples, one per channel per frame
+func (ww *WavWriter) WriteSamples(samples []float32) error {
+	ww.grow(len(samples) * 4)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint32(ww.buf[i*4:], math.Float32bits(s))
+	}
+	return ww.writeData(ww.buf[:len(samples)*4])
+}
+
+// Close fills in the header sizes, the underlying writer is left open
+func (ww *WavWriter) Close() error {
+	if _, err := ww.writer.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := ww.writeHeader(); err != nil {
+		return err
+	}
+	_, err := ww.writer.Seek(0, io.SeekEnd)
+	return err
+}
+
+// DataSize number of bytes of sample data written
+func (ww *WavWriter) DataSize() int {
+	return int(ww.dataSize)
+}
+
+func (ww *WavWriter) grow(size int) {
+	if cap(ww.buf) < size {
+		ww.buf = make([]byte, size)
+	}
+	ww.buf = ww.buf[:size]
+}
+
+func (ww *WavWriter) writeData(data []byte) error {
+	written, err := ww.writer.Write(data)
+	ww.dataSize += uint32(written)
+	return err
+}
+
+func (ww *WavWriter) writeHeader() error {
+	blockAlign := ww.channels * 4
+	header := make([]byte, wavHeaderSize)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 36+ww.dataSize)
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 3) // IEEE float
+	binary.LittleEndian.PutUint16(header[22:], ww.channels)
+	binary.LittleEndian.PutUint32(header[24:], ww.sampleRate)
+	binary.LittleEndian.PutUint32(header[28:], ww.sampleRate*uint32(blockAlign))
+	binary.LittleEndian.PutUint16(header[32:], blockAlign)
+	binary.LittleEndian.PutUint16(header[34:], 32)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], ww.dataSize)
+	_, err := ww.writer.Write(header)
+	return err
+}
@@ -0,0 +1,59 @@
+package fcio
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWavWriter_WriteIQ(t *testing.T) {
+	f, err := ioutil.TempFile("", "wavwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	ww, err := NewWavWriter(f, 48000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, ww.WriteIQ([]complex64{complex(0.5, -0.5), complex(1, 0)}))
+	assert.NoError(t, ww.WriteIQ([]complex64{complex(0, 0.25)}))
+	assert.NoError(t, ww.Close())
+	assert.Equal(t, 24, ww.DataSize())
+
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, wavHeaderSize+24, len(data))
+	assert.Equal(t, "RIFF", string(data[0:4]))
+	assert.Equal(t, uint32(36+24), binary.LittleEndian.Uint32(data[4:]))
+	assert.Equal(t, uint16(3), binary.LittleEndian.Uint16(data[20:]))
+	assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(data[22:]))
+	assert.Equal(t, uint32(48000), binary.LittleEndian.Uint32(data[24:]))
+	assert.Equal(t, "data", string(data[36:40]))
+	assert.Equal(t, uint32(24), binary.LittleEndian.Uint32(data[40:]))
+	assert.Equal(t, float32(-0.5), math.Float32frombits(binary.LittleEndian.Uint32(data[wavHeaderSize+4:])))
+	assert.Equal(t, float32(0.25), math.Float32frombits(binary.LittleEndian.Uint32(data[wavHeaderSize+20:])))
+}
+
+func TestWavWriter_WriteIQNeedsTwoChannels(t *testing.T) {
+	f, err := ioutil.TempFile("", "wavwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	ww, err := NewWavWriter(f, 48000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, ww.WriteIQ([]complex64{1}))
+}
@@ -0,0 +1,125 @@
+// Package tlstest writes certificates for testing TLS links between services: a CA, a server
+// certificate for localhost and 127.0.0.1, also usable by a service to dial the next, and client
+// certificates signed by it or by a stranger
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Files the PEM files written
+type Files struct {
+	CA         string
+	ServerCert string
+	ServerKey  string
+	ClientCert string
+	ClientKey  string
+
+	// signed by a CA nobody trusts
+	StrangerCert string
+	StrangerKey  string
+}
+
+// Write writes the certificates to dir, failing the test if they can't be
+func Write(t testing.TB, dir string) Files {
+	t.Helper()
+	files := Files{
+		CA:           filepath.Join(dir, "ca.pem"),
+		ServerCert:   filepath.Join(dir, "server.pem"),
+		ServerKey:    filepath.Join(dir, "server-key.pem"),
+		ClientCert:   filepath.Join(dir, "client.pem"),
+		ClientKey:    filepath.Join(dir, "client-key.pem"),
+		StrangerCert: filepath.Join(dir, "stranger.pem"),
+		StrangerKey:  filepath.Join(dir, "stranger-key.pem"),
+	}
+
+	ca, caKey := newCertificate(t, "funcube test ca", nil, nil, func(tmpl *x509.Certificate) {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	})
+	writePEM(t, files.CA, "CERTIFICATE", ca.Raw)
+
+	server, serverKey := newCertificate(t, "funcube test server", ca, caKey, func(tmpl *x509.Certificate) {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		tmpl.DNSNames = []string{"localhost"}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+	})
+	writePair(t, files.ServerCert, files.ServerKey, server, serverKey)
+
+	client, clientKey := newCertificate(t, "funcube test client", ca, caKey, clientUsage)
+	writePair(t, files.ClientCert, files.ClientKey, client, clientKey)
+
+	strangerCA, strangerCAKey := newCertificate(t, "stranger ca", nil, nil, func(tmpl *x509.Certificate) {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	})
+	stranger, strangerKey := newCertificate(t, "stranger", strangerCA, strangerCAKey, clientUsage)
+	writePair(t, files.StrangerCert, files.StrangerKey, stranger, strangerKey)
+	return files
+}
+
+func clientUsage(tmpl *x509.Certificate) {
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+}
+
+// newCertificate signed by parent, self signed if parent is nil
+func newCertificate(t testing.TB, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+	customise func(*x509.Certificate)) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	customise(tmpl)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writePair(t testing.TB, certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	writePEM(t, certFile, "CERTIFICATE", cert.Raw)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, keyFile, "EC PRIVATE KEY", der)
+}
+
+func writePEM(t testing.TB, file, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,32 +1,131 @@
-/* File : callbackshim.h */
-
-#include <cstdio>
-#include <iostream>
-#include <functional>
-
-class CallbackShim {
-public:
-	virtual ~CallbackShim() {}
-	virtual void run() {};    
-};
-CallbackShim* g_shimInstance=0;
-
-void OnDataReadyShim() {
-    if (g_shimInstance) g_shimInstance->run(); 
-}
-
-void CleanupCallbackShim() { 
-    CallbackShim* tmp=g_shimInstance;
-    g_shimInstance = 0;
-    delete tmp;
-}
-
-void InitialiseCallbackShim(CallbackShim *cb) {
-        CleanupCallbackShim(); 
-        g_shimInstance = cb;         
-        Decode_SetOnDataReadyCallback(&OnDataReadyShim);
-}
-
-void RunCallback() { 
-    OnDataReadyShim(); 
-}
+/* File : callbackshim.h */
+
+#include <cstdio>
+#include <iostream>
+#include <functional>
+#include <mutex>
+#include <cstring>
+
+class CallbackShim {
+public:
+	virtual ~CallbackShim() {}
+	virtual void run() {};    
+};
+CallbackShim* g_shimInstance=0;
+
+void OnDataReadyShim() {
+    if (g_shimInstance) g_shimInstance->run(); 
+}
+
+void CleanupCallbackShim() { 
+    CallbackShim* tmp=g_shimInstance;
+    g_shimInstance = 0;
+    delete tmp;
+}
+
+void InitialiseCallbackShim(CallbackShim *cb) {
+        CleanupCallbackShim(); 
+        g_shimInstance = cb;         
+        Decode_SetOnDataReadyCallback(&OnDataReadyShim);
+}
+
+void RunCallback() { 
+    OnDataReadyShim(); 
+}
+
+class DeviceEnumShim {
+public:
+	virtual ~DeviceEnumShim() {}
+	virtual void device(int index, int isInput, int isOutput) {};
+};
+DeviceEnumShim* g_deviceEnumInstance=0;
+const char* g_deviceEnumName=0;
+
+void DeviceEnumCallbackShim(int index, const char* name, BOOL isInput, BOOL isOutput) {
+    if (!g_deviceEnumInstance) return;
+    g_deviceEnumName = name;
+    g_deviceEnumInstance->device(index, isInput, isOutput);
+    g_deviceEnumName = 0;
+}
+
+const char* EnumDeviceName() {
+    return g_deviceEnumName ? g_deviceEnumName : "";
+}
+
+BOOL EnumDevicesShim(DeviceEnumShim *cb) {
+    g_deviceEnumInstance = cb;
+    BOOL result = Decode_EnumDevices(&DeviceEnumCallbackShim);
+    g_deviceEnumInstance = 0;
+    return result;
+}
+
+class LogShim {
+public:
+	virtual ~LogShim() {}
+	virtual void log(int level) {};
+};
+LogShim* g_logInstance=0;
+const char* g_logMessage=0;
+std::mutex g_logMutex;
+
+void LogCallbackShim(int level, const char* message) {
+    std::lock_guard<std::mutex> lock(g_logMutex);
+    if (!g_logInstance) return;
+    g_logMessage = message;
+    g_logInstance->log(level);
+    g_logMessage = 0;
+}
+
+const char* LogShimMessage() {
+    return g_logMessage ? g_logMessage : "";
+}
+
+BOOL InitialiseLogShim(LogShim *cb) {
+    {
+        std::lock_guard<std::mutex> lock(g_logMutex);
+        LogShim* tmp = g_logInstance;
+        g_logInstance = cb;
+        if (tmp != cb) delete tmp;
+    }
+    return Library_SetOnLogMessageCallback(cb ? &LogCallbackShim : 0);
+}
+
+class SampleShim {
+public:
+	virtual ~SampleShim() {}
+	virtual void samples(int length) {};
+};
+SampleShim* g_sampleInstance=0;
+const FLOAT* g_sampleData=0;
+ULONG g_sampleLength=0;
+std::mutex g_sampleMutex;
+
+void SampleCallbackShim(const FLOAT* data, ULONG length) {
+    std::lock_guard<std::mutex> lock(g_sampleMutex);
+    if (!g_sampleInstance) return;
+    g_sampleData = data;
+    g_sampleLength = length;
+    g_sampleInstance->samples(length);
+    g_sampleData = 0;
+    g_sampleLength = 0;
+}
+
+BOOL SampleShimCollect(FLOAT* samples, ULONG* length) {
+    if (!g_sampleData) {
+        *length = 0;
+        return 0;
+    }
+    if (*length > g_sampleLength) *length = g_sampleLength;
+    memcpy(samples, g_sampleData, *length * sizeof(FLOAT));
+    return 1;
+}
+
+BOOL InitialiseSampleShim(SampleShim *cb) {
+    {
+        std::lock_guard<std::mutex> lock(g_sampleMutex);
+        SampleShim* tmp = g_sampleInstance;
+        g_sampleInstance = cb;
+        if (tmp != cb) delete tmp;
+    }
+    return Decode_SetOnDataCallback(cb ? &SampleCallbackShim : 0);
+}
@@ -1,33 +1,57 @@
+//go:build !fcsim
+// +build !fcsim
+
 package fclib
 
 import (
 	"sync"
 )
 
-//OnDecodeReadyFunc Signature of method required to receive notifications data ready
-type OnDecodeReadyFunc func()
-
 type goCallback struct {
 	onDecodeReadyCallback OnDecodeReadyFunc
 }
 
 var callbackInstance *goCallback
 
-//var callerInstance Caller
-var once sync.Once
+var sampleInstance *goSampleShim
 
-// Callback_SetOnDecodeReady sets the function to be called when data is decoded and ready to collect
+// callbackMutex guards callbackInstance, the library calls back on its own threads
+var callbackMutex sync.Mutex
+
+// Callback_SetOnDecodeReady sets the function to be called when data is decoded and ready to collect,
+// replacing the one Decode_Subscribe sets, the library calls it on its own threads
 func Callback_SetOnDecodeReady(callbackFunc OnDecodeReadyFunc) {
-	once.Do(func() {
+	callbackMutex.Lock()
+	defer callbackMutex.Unlock()
+	if callbackInstance == nil {
 		callbackInstance = &goCallback{}
 		InitialiseCallbackShim(NewDirectorCallbackShim(callbackInstance))
-	})
+	}
 	callbackInstance.onDecodeReadyCallback = callbackFunc
 }
 
-// Callback_ClearOnDecodeReady clears the function to be called when data is decoded and ready to collect
+// Callback_Reattach registers the decode ready and samples callbacks with the library again, call after
+// Decode_Shutdown and Decode_Initialize restart the decoder
+func Callback_Reattach() {
+	callbackMutex.Lock()
+	defer callbackMutex.Unlock()
+	if sampleInstance != nil {
+		InitialiseSampleShim(NewDirectorSampleShim(sampleInstance))
+	}
+	if callbackInstance == nil {
+		return
+	}
+	InitialiseCallbackShim(NewDirectorCallbackShim(callbackInstance))
+}
+
+// Callback_ClearOnDecodeReady clears the function to be called when data is decoded and ready to collect,
+// whichever was set, the argument is ignored
 func Callback_ClearOnDecodeReady(callbackFunc OnDecodeReadyFunc) {
-	callbackInstance.onDecodeReadyCallback = nil
+	callbackMutex.Lock()
+	defer callbackMutex.Unlock()
+	if callbackInstance != nil {
+		callbackInstance.onDecodeReadyCallback = nil
+	}
 }
 
 func Callback_Test() {
@@ -36,7 +60,69 @@ func Callback_Test() {
 
 //Run does stuff too
 func (p *goCallback) Run() {
-	if nil != p.onDecodeReadyCallback {
-		p.onDecodeReadyCallback()
+	callbackMutex.Lock()
+	callback := p.onDecodeReadyCallback
+	callbackMutex.Unlock()
+	if nil != callback {
+		callback()
+	}
+}
+
+type goLogShim struct {
+	onLogMessage OnLogMessageFunc
+}
+
+// Callback_SetOnLogMessage sets the function to be called with each message the library logs,
+// in place of the library writing them to stderr, returns 1 once registered with the library
+func Callback_SetOnLogMessage(callbackFunc OnLogMessageFunc) int {
+	return InitialiseLogShim(NewDirectorLogShim(&goLogShim{onLogMessage: callbackFunc}))
+}
+
+// Callback_ClearOnLogMessage the library writes the messages it logs to stderr again
+func Callback_ClearOnLogMessage() {
+	InitialiseLogShim(SwigcptrLogShim(0))
+}
+
+// Log is called by the LogShim with each message, the message text is only valid during the call
+func (p *goLogShim) Log(level int) {
+	if nil != p.onLogMessage {
+		p.onLogMessage(level, LogShimMessage())
+	}
+}
+
+type goSampleShim struct {
+	onSamples OnSamplesFunc
+	samples   []float32
+}
+
+// Callback_SetOnSamples sets the function to be called with each block of raw samples the decoder
+// sees, the library calls it on its own threads, returns 1 once registered with the library
+func Callback_SetOnSamples(callbackFunc OnSamplesFunc) int {
+	callbackMutex.Lock()
+	defer callbackMutex.Unlock()
+	sampleInstance = &goSampleShim{onSamples: callbackFunc}
+	return InitialiseSampleShim(NewDirectorSampleShim(sampleInstance))
+}
+
+// Callback_ClearOnSamples stops the raw samples being collected
+func Callback_ClearOnSamples() {
+	callbackMutex.Lock()
+	defer callbackMutex.Unlock()
+	sampleInstance = nil
+	InitialiseSampleShim(SwigcptrSampleShim(0))
+}
+
+// Samples is called by the SampleShim with each block, the samples are only collectable during the call
+func (p *goSampleShim) Samples(length int) {
+	if length <= 0 || nil == p.onSamples {
+		return
+	}
+	if cap(p.samples) < length {
+		p.samples = make([]float32, length)
+	}
+	size := uint32(length)
+	if SampleShimCollect(&p.samples[0], &size) != 1 {
+		return
 	}
+	p.onSamples(p.samples[:size])
 }
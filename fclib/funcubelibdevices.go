@@ -0,0 +1,46 @@
+//go:build !fcsim
+// +build !fcsim
+
+package fclib
+
+import (
+	"errors"
+	"sync"
+)
+
+type goDeviceEnum struct {
+	devices []AudioDevice
+}
+
+// Device is called by the DeviceEnumShim once per device, the name is only valid during the call
+func (p *goDeviceEnum) Device(index int, isInput int, isOutput int) {
+	p.devices = append(p.devices, AudioDevice{
+		Index:    index,
+		Name:     EnumDeviceName(),
+		IsInput:  isInput != 0,
+		IsOutput: isOutput != 0,
+	})
+}
+
+// the library reports devices through a single global callback, so one enumeration at a time
+var enumMutex sync.Mutex
+
+// Decode_ListDevices enumerates the audio devices the decoder can be started with,
+// the Index of a device is what Decode_StartByIndex expects
+func Decode_ListDevices() ([]AudioDevice, error) {
+	enumMutex.Lock()
+	defer enumMutex.Unlock()
+
+	enum := &goDeviceEnum{}
+	shim := NewDirectorDeviceEnumShim(enum)
+	defer DeleteDirectorDeviceEnumShim(shim)
+
+	if EnumDevicesShim(shim) != 1 {
+		msg := Decode_LastError()
+		if msg == "" {
+			msg = "failed to enumerate audio devices"
+		}
+		return nil, errors.New(msg)
+	}
+	return enum.devices, nil
+}
@@ -0,0 +1,142 @@
+package fclib
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DecodeEvent a frame decoded by the library, as delivered to each DecodeSubscription
+type DecodeEvent struct {
+	Time      time.Time `json:"time"`
+	Worker    int       `json:"worker"` // decoder whose peak is nearest the frequency, -1 if unknown
+	Frequency float32   `json:"frequency"`
+	Errors    int       `json:"errors"`
+	Data      []byte    `json:"data"`
+}
+
+// decodeBufferSize large enough for any frame the library collects, the data is trimmed to its size
+const decodeBufferSize = 1024
+
+// maxWorkers the most decoders the library runs
+const maxWorkers = 16
+
+// DecodeSubscription receives the decode events from Decode_Subscribe until unsubscribed, events
+// arriving while its buffer is full are dropped and counted
+type DecodeSubscription struct {
+	events  chan DecodeEvent
+	dropped uint64
+	closed  bool // guarded by decodeHub.mu
+}
+
+// Events the decode events, closed by Unsubscribe once those buffered are read
+func (s *DecodeSubscription) Events() <-chan DecodeEvent {
+	return s.events
+}
+
+// Dropped the events dropped because the buffer was full
+func (s *DecodeSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe stops delivery and closes the events channel, safe to call more than once and
+// while events are being delivered
+func (s *DecodeSubscription) Unsubscribe() {
+	decodeHub.mu.Lock()
+	defer decodeHub.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(decodeHub.subscribers, s)
+	close(s.events)
+}
+
+// decodeHub the subscriptions the decode events are delivered to, the library calls back on its
+// own threads so delivery never blocks
+var decodeHub = struct {
+	mu          sync.Mutex
+	subscribers map[*DecodeSubscription]struct{}
+}{
+	subscribers: make(map[*DecodeSubscription]struct{}),
+}
+
+// Decode_Subscribe the decode events, each subscription buffers up to buffer events for its reader.
+// Subscribing sets the library's decode ready callback, replacing any set by Callback_SetOnDecodeReady,
+// after Decode_Shutdown and Decode_Initialize restart the decoder call Callback_Reattach
+func Decode_Subscribe(buffer int) *DecodeSubscription {
+	if buffer < 0 {
+		buffer = 0
+	}
+	s := &DecodeSubscription{events: make(chan DecodeEvent, buffer)}
+	decodeHub.mu.Lock()
+	decodeHub.subscribers[s] = struct{}{}
+	decodeHub.mu.Unlock()
+	Callback_SetOnDecodeReady(publishDecode)
+	return s
+}
+
+// publishDecode collects the frame decoded and delivers it to every subscription
+func publishDecode() {
+	decodeHub.mu.Lock()
+	defer decodeHub.mu.Unlock()
+	if len(decodeHub.subscribers) == 0 {
+		return
+	}
+	if event, ok := collectDecode(); ok {
+		deliverDecode(event)
+	}
+}
+
+// deliverDecode offers the event to every subscription, the hub lock held
+func deliverDecode(event DecodeEvent) {
+	for s := range decodeHub.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// collectDecode the frame last decoded, with the worker decoding it
+func collectDecode() (DecodeEvent, bool) {
+	data := make([]byte, decodeBufferSize)
+	size := uint32(len(data))
+	var freq float32
+	var errors int
+	if Decode_CollectLastData(&data[0], &size, &freq, &errors) != 1 {
+		return DecodeEvent{}, false
+	}
+	if int(size) < len(data) {
+		data = data[:size]
+	}
+	return DecodeEvent{
+		Time:      time.Now(),
+		Worker:    nearestWorker(freq),
+		Frequency: freq,
+		Errors:    errors,
+		Data:      data,
+	}, true
+}
+
+// nearestWorker the decoder whose peak is nearest freq, -1 if the peaks are unavailable
+func nearestWorker(freq float32) int {
+	peaks := make([]float32, maxWorkers)
+	count := uint32(len(peaks))
+	if Decode_GetWorkerPeaks(&peaks[0], &count) != 1 {
+		return -1
+	}
+	if int(count) > len(peaks) {
+		count = uint32(len(peaks))
+	}
+	worker := -1
+	nearest := math.Inf(1)
+	for i, peak := range peaks[:count] {
+		if d := math.Abs(float64(peak - freq)); d < nearest {
+			worker, nearest = i, d
+		}
+	}
+	return worker
+}
@@ -0,0 +1,71 @@
+package fclib
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// publish delivers the event as the library's callback does once it has collected it
+func publish(event DecodeEvent) {
+	decodeHub.mu.Lock()
+	defer decodeHub.mu.Unlock()
+	deliverDecode(event)
+}
+
+func TestDecodeSubscribe(t *testing.T) {
+	archive := Decode_Subscribe(4)
+	defer archive.Unsubscribe()
+	stats := Decode_Subscribe(1)
+	defer stats.Unsubscribe()
+
+	for i := 0; i < 3; i++ {
+		publish(DecodeEvent{Worker: i, Frequency: 1200, Errors: i, Data: []byte{byte(i)}})
+	}
+	for i := 0; i < 3; i++ {
+		event := <-archive.Events()
+		assert.Equal(t, i, event.Worker)
+		assert.Equal(t, []byte{byte(i)}, event.Data)
+	}
+	assert.Zero(t, archive.Dropped())
+
+	// a subscriber falling behind only loses its own events
+	assert.Equal(t, 0, (<-stats.Events()).Worker)
+	assert.Equal(t, uint64(2), stats.Dropped())
+}
+
+func TestDecodeSubscribe_Unsubscribe(t *testing.T) {
+	s := Decode_Subscribe(2)
+	publish(DecodeEvent{Worker: 1})
+	s.Unsubscribe()
+	s.Unsubscribe()
+	publish(DecodeEvent{Worker: 2})
+
+	// those buffered are still read, then the channel is closed
+	event, ok := <-s.Events()
+	assert.True(t, ok)
+	assert.Equal(t, 1, event.Worker)
+	_, ok = <-s.Events()
+	assert.False(t, ok)
+}
+
+func TestDecodeSubscribe_Concurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s := Decode_Subscribe(1)
+			publish(DecodeEvent{})
+			s.Unsubscribe()
+			for range s.Events() {
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			publish(DecodeEvent{})
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,248 @@
+package fclib
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel of a message logged by the library, as passed to LogMessage, lower is more severe
+type LogLevel int
+
+// Levels the library logs at
+const (
+	LogError LogLevel = iota
+	LogWarning
+	LogInfo
+	LogDebug
+)
+
+var logLevelNames = []string{"error", "warning", "info", "debug"}
+
+func (l LogLevel) String() string {
+	if l >= 0 && int(l) < len(logLevelNames) {
+		return logLevelNames[l]
+	}
+	return fmt.Sprintf("level %d", int(l))
+}
+
+// ParseLogLevel the level named error, warning, info or debug
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(name, n) {
+			return LogLevel(i), nil
+		}
+	}
+	return LogError, fmt.Errorf("unknown native log level %q, expected error, warning, info or debug", name)
+}
+
+// LogConfig how the messages the library logs are handled, the koanf tags match the nativelog
+// table of the service configuration files
+type LogConfig struct {
+	Level   string  `koanf:"level"`   // error, warning, info or debug, less severe messages are dropped
+	Burst   int     `koanf:"burst"`   // times a message is logged each window before its repeats are only counted
+	Window  float64 `koanf:"window"`  // seconds over which a message's repeats are counted
+	History int     `koanf:"history"` // recent messages kept for the status api
+}
+
+// DefaultLogConfig the native log settings the services use when none are given
+func DefaultLogConfig() LogConfig {
+	return LogConfig{
+		Level:   "info",
+		Burst:   3,
+		Window:  60,
+		History: 100,
+	}
+}
+
+// LogEntry a message logged by the library
+type LogEntry struct {
+	Time     time.Time `json:"time"`
+	Level    string    `json:"level"`
+	Message  string    `json:"message"`
+	Repeated int       `json:"repeated,omitempty"` // further times it was logged but suppressed
+}
+
+// LogStats counts of the messages logged by the library
+type LogStats struct {
+	Logged     uint `json:"logged"`
+	Filtered   uint `json:"filtered"`   // below the level
+	Suppressed uint `json:"suppressed"` // repeats beyond the burst
+}
+
+// LogStatus served on the status api
+type LogStatus struct {
+	Level  string     `json:"level"`
+	Stats  LogStats   `json:"stats"`
+	Recent []LogEntry `json:"recent"`
+}
+
+// logRepeat the times a message was seen in the current window
+type logRepeat struct {
+	start      time.Time
+	count      int
+	suppressed int
+	seq        uint64 // of the message's last entry in the history
+}
+
+// maxRepeats messages tracked for repeats before those from past windows are forgotten
+const maxRepeats = 256
+
+// NativeLog routes the messages the library logs to a Go logger, dropping those below the level
+// and counting, rather than logging, a message repeated more than the burst in a window. The
+// most recent messages are kept for the status api, a suppressed repeat counted against its entry
+type NativeLog struct {
+	mu      sync.Mutex
+	output  func(message string)
+	level   LogLevel
+	burst   int
+	window  time.Duration
+	history []LogEntry
+	seqs    []uint64
+	seq     uint64
+	repeats map[string]*logRepeat
+	stats   LogStats
+	now     func() time.Time
+}
+
+// NewNativeLog the NativeLog for the config, logging to logger, the standard logger if nil, an
+// empty level is info
+func NewNativeLog(config LogConfig, logger *log.Logger) (*NativeLog, error) {
+	level := LogInfo
+	if config.Level != "" {
+		var err error
+		if level, err = ParseLogLevel(config.Level); err != nil {
+			return nil, err
+		}
+	}
+	output := func(message string) { log.Println(message) }
+	if logger != nil {
+		output = func(message string) { logger.Println(message) }
+	}
+	history := config.History
+	if history < 0 {
+		history = 0
+	}
+	return &NativeLog{
+		output:  output,
+		level:   level,
+		burst:   config.Burst,
+		window:  time.Duration(config.Window * float64(time.Second)),
+		history: make([]LogEntry, 0, history),
+		seqs:    make([]uint64, 0, history),
+		repeats: make(map[string]*logRepeat),
+		now:     time.Now,
+	}, nil
+}
+
+// Handle the library logged message at level, an OnLogMessageFunc
+func (l *NativeLog) Handle(level int, message string) {
+	message = strings.TrimRight(message, "\r\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if LogLevel(level) > l.level {
+		l.stats.Filtered++
+		return
+	}
+	now := l.now()
+	key := fmt.Sprintf("%d:%s", level, message)
+	repeat := l.repeats[key]
+	if repeat != nil && l.window > 0 && now.Sub(repeat.start) < l.window {
+		repeat.count++
+		if l.burst > 0 && repeat.count > l.burst {
+			repeat.suppressed++
+			l.stats.Suppressed++
+			l.countRepeat(repeat.seq)
+			return
+		}
+	} else {
+		if repeat != nil {
+			l.reportSuppressed(key, repeat)
+		} else if len(l.repeats) >= maxRepeats {
+			l.forget(now)
+		}
+		repeat = &logRepeat{start: now, count: 1}
+		l.repeats[key] = repeat
+	}
+
+	l.stats.Logged++
+	l.output(fmt.Sprintf("fclib %s: %s", LogLevel(level), message))
+	repeat.seq = l.record(LogEntry{Time: now, Level: LogLevel(level).String(), Message: message})
+}
+
+// record adds the entry to the history, replacing the oldest once full, returning its sequence number
+func (l *NativeLog) record(entry LogEntry) uint64 {
+	l.seq++
+	if cap(l.history) == 0 {
+		return l.seq
+	}
+	if len(l.history) < cap(l.history) {
+		l.history = append(l.history, entry)
+		l.seqs = append(l.seqs, l.seq)
+	} else {
+		i := int(l.seq-1) % cap(l.history)
+		l.history[i] = entry
+		l.seqs[i] = l.seq
+	}
+	return l.seq
+}
+
+// countRepeat counts a suppressed repeat against the entry with the sequence number, if still held
+func (l *NativeLog) countRepeat(seq uint64) {
+	if cap(l.history) == 0 {
+		return
+	}
+	i := int(seq-1) % cap(l.history)
+	if i < len(l.seqs) && l.seqs[i] == seq {
+		l.history[i].Repeated++
+	}
+}
+
+func (l *NativeLog) reportSuppressed(key string, repeat *logRepeat) {
+	if repeat.suppressed > 0 {
+		l.output(fmt.Sprintf("fclib: suppressed %d repeats of %s", repeat.suppressed, key[strings.Index(key, ":")+1:]))
+	}
+}
+
+// forget the messages whose window has passed, or all of them if none has, reporting any
+// repeats suppressed
+func (l *NativeLog) forget(now time.Time) {
+	for key, repeat := range l.repeats {
+		if now.Sub(repeat.start) >= l.window {
+			l.reportSuppressed(key, repeat)
+			delete(l.repeats, key)
+		}
+	}
+	if len(l.repeats) < maxRepeats {
+		return
+	}
+	for key, repeat := range l.repeats {
+		l.reportSuppressed(key, repeat)
+		delete(l.repeats, key)
+	}
+}
+
+// Recent the messages kept, oldest first
+func (l *NativeLog) Recent() []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(l.history)
+	recent := make([]LogEntry, 0, n)
+	if n < cap(l.history) || n == 0 {
+		return append(recent, l.history...)
+	}
+	oldest := int(l.seq) % n
+	recent = append(recent, l.history[oldest:]...)
+	return append(recent, l.history[:oldest]...)
+}
+
+// Status the level, counts and recent messages, as served on the status api
+func (l *NativeLog) Status() LogStatus {
+	recent := l.Recent()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LogStatus{Level: l.level.String(), Stats: l.stats, Recent: recent}
+}
@@ -0,0 +1,105 @@
+package fclib
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testNativeLog a NativeLog logging to the returned buffer, its clock moved on by advance
+func testNativeLog(t *testing.T, config LogConfig) (*NativeLog, *bytes.Buffer, func(time.Duration)) {
+	var buf bytes.Buffer
+	l, err := NewNativeLog(config, log.New(&buf, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &buf, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("Warning")
+	assert.NoError(t, err)
+	assert.Equal(t, LogWarning, level)
+	_, err = ParseLogLevel("loud")
+	assert.Error(t, err)
+	assert.Equal(t, "level 7", LogLevel(7).String())
+
+	_, err = NewNativeLog(LogConfig{Level: "loud"}, nil)
+	assert.Error(t, err)
+}
+
+func TestNativeLog_Level(t *testing.T) {
+	l, buf, _ := testNativeLog(t, LogConfig{Level: "warning", History: 10})
+	l.Handle(int(LogError), "ALSA lib pcm.c:2495: Unknown PCM\n")
+	l.Handle(int(LogInfo), "Decoder started")
+	l.Handle(int(LogDebug), "Worker 3 peak 1200Hz")
+	assert.Equal(t, "fclib error: ALSA lib pcm.c:2495: Unknown PCM\n", buf.String())
+	assert.Equal(t, LogStats{Logged: 1, Filtered: 2}, l.Status().Stats)
+	assert.Len(t, l.Recent(), 1)
+}
+
+func TestNativeLog_RateLimit(t *testing.T) {
+	l, buf, advance := testNativeLog(t, LogConfig{Level: "info", Burst: 2, Window: 60, History: 10})
+	for i := 0; i < 5; i++ {
+		l.Handle(int(LogWarning), "Audio underrun")
+		l.Handle(int(LogInfo), fmt.Sprintf("Frame %d", i))
+	}
+	assert.Equal(t, 2, strings.Count(buf.String(), "Audio underrun"))
+	assert.Equal(t, uint(3), l.Status().Stats.Suppressed)
+
+	// the suppressed repeats are counted against the last entry logged
+	recent := l.Recent()
+	assert.Len(t, recent, 7)
+	assert.Equal(t, "Audio underrun", recent[2].Message)
+	assert.Equal(t, 3, recent[2].Repeated)
+
+	// and reported once the window has passed
+	buf.Reset()
+	advance(time.Minute)
+	l.Handle(int(LogWarning), "Audio underrun")
+	assert.Equal(t, "fclib: suppressed 3 repeats of Audio underrun\nfclib warning: Audio underrun\n", buf.String())
+}
+
+func TestNativeLog_History(t *testing.T) {
+	l, _, advance := testNativeLog(t, LogConfig{Level: "debug", History: 3})
+	for i := 0; i < 5; i++ {
+		l.Handle(int(LogDebug), fmt.Sprintf("message %d", i))
+		advance(time.Second)
+	}
+	status := l.Status()
+	assert.Equal(t, "debug", status.Level)
+	if assert.Len(t, status.Recent, 3, "oldest replaced") {
+		assert.Equal(t, "message 2", status.Recent[0].Message)
+		assert.Equal(t, "message 4", status.Recent[2].Message)
+		assert.Equal(t, "debug", status.Recent[2].Level)
+		assert.True(t, status.Recent[1].Time.Before(status.Recent[2].Time))
+	}
+
+	// a suppressed repeat of a message no longer held is only counted
+	l, _, _ = testNativeLog(t, LogConfig{Level: "info", Burst: 1, Window: 60, History: 2})
+	l.Handle(int(LogInfo), "repeated")
+	l.Handle(int(LogInfo), "one")
+	l.Handle(int(LogInfo), "two")
+	l.Handle(int(LogInfo), "repeated")
+	assert.Equal(t, []string{"one", "two"}, []string{l.Recent()[0].Message, l.Recent()[1].Message})
+	assert.Zero(t, l.Recent()[0].Repeated+l.Recent()[1].Repeated)
+	assert.Equal(t, uint(1), l.Status().Stats.Suppressed)
+}
+
+func TestNativeLog_Forget(t *testing.T) {
+	l, buf, _ := testNativeLog(t, LogConfig{Level: "info", Burst: 1, Window: 60})
+	l.Handle(int(LogInfo), "repeated")
+	l.Handle(int(LogInfo), "repeated")
+	for i := 0; i < maxRepeats; i++ {
+		l.Handle(int(LogInfo), fmt.Sprintf("message %d", i))
+	}
+	assert.Contains(t, buf.String(), "fclib: suppressed 1 repeats of repeated\n", "reported when forgotten")
+	assert.True(t, len(l.repeats) <= maxRepeats)
+}
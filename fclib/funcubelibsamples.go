@@ -0,0 +1,171 @@
+package fclib
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// SampleStream the raw samples the decoder sees, interleaved I and Q, held in a bounded ring for its
+// reader. When the reader falls behind the oldest samples are overwritten and counted as dropped,
+// so an unread stream always holds the most recent samples
+type SampleStream struct {
+	mu      sync.Mutex
+	ring    []float32
+	start   int
+	count   int
+	dropped uint64
+	closed  bool
+	ready   chan struct{}
+}
+
+// NewSampleStream a stream holding up to capacity samples, written to by Write
+func NewSampleStream(capacity int) *SampleStream {
+	if capacity < 2 {
+		capacity = 2
+	}
+	return &SampleStream{
+		ring:  make([]float32, capacity),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// Write adds the samples, overwriting the oldest if the ring is full, ignored once closed
+func (s *SampleStream) Write(samples []float32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || len(samples) == 0 {
+		return
+	}
+	size := len(s.ring)
+	if len(samples) > size {
+		s.dropped += uint64(len(samples) - size)
+		samples = samples[len(samples)-size:]
+	}
+	if overflow := s.count + len(samples) - size; overflow > 0 {
+		s.discard(overflow)
+		s.dropped += uint64(overflow)
+	}
+	end := (s.start + s.count) % size
+	n := copy(s.ring[end:], samples)
+	copy(s.ring, samples[n:])
+	s.count += len(samples)
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Read copies the oldest samples held into p, waiting for some until ctx is done, io.EOF once
+// closed and empty
+func (s *SampleStream) Read(ctx context.Context, p []float32) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.count > 0 {
+			n := len(p)
+			if n > s.count {
+				n = s.count
+			}
+			first := copy(p[:n], s.ring[s.start:])
+			copy(p[first:n], s.ring)
+			s.discard(n)
+			s.mu.Unlock()
+			return n, nil
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Keep discards all but the newest n samples held, these are not counted as dropped
+func (s *SampleStream) Keep(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count > n {
+		s.discard(s.count - n)
+	}
+}
+
+func (s *SampleStream) discard(n int) {
+	s.start = (s.start + n) % len(s.ring)
+	s.count -= n
+}
+
+// Buffered the samples held for the reader
+func (s *SampleStream) Buffered() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Dropped the samples overwritten before they were read
+func (s *SampleStream) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Close stops the stream, the samples held can still be read, safe to call more than once
+func (s *SampleStream) Close() {
+	sampleHub.register.Lock()
+	defer sampleHub.register.Unlock()
+	sampleHub.mu.Lock()
+	_, hubbed := sampleHub.streams[s]
+	delete(sampleHub.streams, s)
+	remaining := len(sampleHub.streams)
+	sampleHub.mu.Unlock()
+	if hubbed && remaining == 0 {
+		Callback_ClearOnSamples()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ready)
+	}
+}
+
+// sampleHub the streams the raw samples are written to. register serialises setting and clearing
+// the library's callback, which waits for a callback in progress, so is never held by one
+var sampleHub = struct {
+	register sync.Mutex
+	mu       sync.Mutex
+	streams  map[*SampleStream]struct{}
+}{
+	streams: make(map[*SampleStream]struct{}),
+}
+
+// Decode_StreamSamples a stream of the raw samples the decoder sees, holding up to capacity.
+// The library's samples callback is set while there are streams, replacing any set by
+// Callback_SetOnSamples, after Decode_Shutdown and Decode_Initialize restart the decoder call
+// Callback_Reattach
+func Decode_StreamSamples(capacity int) *SampleStream {
+	s := NewSampleStream(capacity)
+	sampleHub.register.Lock()
+	defer sampleHub.register.Unlock()
+	sampleHub.mu.Lock()
+	sampleHub.streams[s] = struct{}{}
+	sampleHub.mu.Unlock()
+	Callback_SetOnSamples(publishSamples)
+	return s
+}
+
+// publishSamples writes the samples to every stream
+func publishSamples(samples []float32) {
+	sampleHub.mu.Lock()
+	defer sampleHub.mu.Unlock()
+	for s := range sampleHub.streams {
+		s.Write(samples)
+	}
+}
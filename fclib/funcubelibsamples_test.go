@@ -0,0 +1,90 @@
+package fclib
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readAll reads everything held without waiting
+func readAll(s *SampleStream) []float32 {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var all []float32
+	buf := make([]float32, 3)
+	for {
+		n, err := s.Read(ctx, buf)
+		if err != nil {
+			return all
+		}
+		all = append(all, buf[:n]...)
+	}
+}
+
+func TestSampleStream_Ring(t *testing.T) {
+	s := NewSampleStream(6)
+	s.Write([]float32{1, 2, 3, 4})
+	assert.Equal(t, []float32{1, 2, 3, 4}, readAll(s))
+
+	// the oldest are overwritten, the newest always held
+	s.Write([]float32{5, 6, 7, 8})
+	s.Write([]float32{9, 10})
+	assert.Equal(t, 6, s.Buffered())
+	assert.Equal(t, uint64(0), s.Dropped())
+	s.Write([]float32{11, 12, 13})
+	assert.Equal(t, uint64(3), s.Dropped())
+	assert.Equal(t, []float32{8, 9, 10, 11, 12, 13}, readAll(s))
+
+	s.Write([]float32{1, 2, 3, 4, 5, 6, 7, 8})
+	assert.Equal(t, uint64(5), s.Dropped())
+	s.Keep(2)
+	assert.Equal(t, uint64(5), s.Dropped(), "kept not dropped")
+	assert.Equal(t, []float32{7, 8}, readAll(s))
+}
+
+func TestSampleStream_ReadWaits(t *testing.T) {
+	s := NewSampleStream(8)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		s.Write([]float32{1, 2})
+	}()
+	buf := make([]float32, 8)
+	n, err := s.Read(context.Background(), buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{1, 2}, buf[:n])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = s.Read(ctx, buf)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// those held are read after closing, then EOF
+	s.Write([]float32{3})
+	s.Close()
+	s.Close()
+	s.Write([]float32{4})
+	n, err = s.Read(context.Background(), buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{3}, buf[:n])
+	_, err = s.Read(context.Background(), buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecodeStreamSamples(t *testing.T) {
+	recorder := Decode_StreamSamples(4)
+	demod := Decode_StreamSamples(16)
+	publishSamples([]float32{1, 2, 3, 4, 5, 6})
+	assert.Equal(t, []float32{3, 4, 5, 6}, readAll(recorder))
+	assert.Equal(t, uint64(2), recorder.Dropped())
+	assert.Equal(t, []float32{1, 2, 3, 4, 5, 6}, readAll(demod))
+
+	recorder.Close()
+	publishSamples([]float32{7, 8})
+	assert.Empty(t, readAll(recorder))
+	assert.Equal(t, []float32{7, 8}, readAll(demod))
+	demod.Close()
+	assert.Empty(t, sampleHub.streams)
+}
@@ -0,0 +1,570 @@
+//go:build fcsim
+// +build fcsim
+
+package fclib
+
+// The simulated FUNcubeLib, built with -tags fcsim in place of the cgo wrapper so the apps build
+// and test without libfuncube.a, libusb, fftw3f and portaudio. The dongle is always there unless
+// configured missing, the decoder replays the frames of a funcubebin file, calling back for each,
+// with a 10kHz tone as the raw samples it sees, and the encoder turns each bit of a frame, most significant first, into a float32 sample of +1 or -1
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// SimConfig how the simulated library behaves, read from the FCSIM_FILE, FCSIM_INTERVAL (seconds),
+// FCSIM_DECODEDFREQ and FCSIM_DECODEDERRORS environment variables at startup
+type SimConfig struct {
+	File          string        // funcubebin file replayed by the decoder, looped, numbered frames if empty
+	Interval      time.Duration // between decoded frames, 5 seconds (as the satellite sends them) if 0
+	DecodedFreq   float32       // reported with each frame by Decode_CollectLastData, plus 1000Hz a worker
+	DecodedErrors int           // reported with each frame by Decode_CollectLastData
+	DongleMissing bool          // Dongle_Initialize and Dongle_Exists fail, as if the dongle was unplugged
+	SampleRate    int           // IQ pairs a second of the 10kHz tone sent to the samples callback, 192000 if 0
+}
+
+// SimState the settings made through the simulated library
+type SimState struct {
+	DongleOpen bool
+	Frequency  uint32
+	BiasT      bool
+	Workers    uint32
+	Exclude    []float32
+	Started    bool
+	Decoded    int // frames decoded since the library started
+}
+
+const (
+	simVersion   = 0
+	simFrameSize = 256
+	simFftSize   = 4096
+
+	simSampleInterval = 10 * time.Millisecond
+	simToneFreq       = 10000
+)
+
+var sim = struct {
+	sync.Mutex
+	config   SimConfig
+	state    SimState
+	callback OnDecodeReadyFunc
+	logFunc  OnLogMessageFunc
+	samples  OnSamplesFunc
+	frames   [][]byte
+	next     int
+	last     []byte
+	worker   int
+	stop     chan struct{}
+	stopped  chan struct{}
+	encoded  []byte
+	lastErr  string
+}{
+	config: simConfigFromEnv(),
+	state:  SimState{Frequency: 145935000},
+}
+
+func simConfigFromEnv() SimConfig {
+	config := SimConfig{File: os.Getenv("FCSIM_FILE")}
+	if seconds, err := strconv.ParseFloat(os.Getenv("FCSIM_INTERVAL"), 64); err == nil {
+		config.Interval = time.Duration(seconds * float64(time.Second))
+	}
+	if freq, err := strconv.ParseFloat(os.Getenv("FCSIM_DECODEDFREQ"), 32); err == nil {
+		config.DecodedFreq = float32(freq)
+	}
+	if errors, err := strconv.Atoi(os.Getenv("FCSIM_DECODEDERRORS")); err == nil {
+		config.DecodedErrors = errors
+	}
+	return config
+}
+
+// Sim_Configure changes how the simulated library behaves, a new file is replayed from the next Decode_Start
+func Sim_Configure(config SimConfig) {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.config = config
+}
+
+// Sim_State the settings made through the simulated library
+func Sim_State() SimState {
+	sim.Lock()
+	defer sim.Unlock()
+	state := sim.state
+	state.Exclude = append([]float32(nil), sim.state.Exclude...)
+	return state
+}
+
+// simBytes the n bytes at p, as passed to the library by pointer and length
+func simBytes(p *byte, n uint32) []byte {
+	return (*[1 << 30]byte)(unsafe.Pointer(p))[:n:n]
+}
+
+func simFloats(p *float32, n uint32) []float32 {
+	return (*[1 << 28]float32)(unsafe.Pointer(p))[:n:n]
+}
+
+func simUints(p *uint, n uint32) []uint {
+	return (*[1 << 27]uint)(unsafe.Pointer(p))[:n:n]
+}
+
+func Library_GetVersion() uint {
+	return simVersion
+}
+
+func Library_CheckFftwExists() int {
+	return 1
+}
+
+func Dongle_Initialize() int {
+	sim.Lock()
+	missing := sim.config.DongleMissing
+	sim.state.DongleOpen = !missing
+	sim.Unlock()
+	if missing {
+		simLogf(LogError, "No FUNcube Dongle found")
+		return 0
+	}
+	return 1
+}
+
+func Dongle_Shutdown() int {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.state.DongleOpen = false
+	return 1
+}
+
+func Dongle_Exists() int {
+	sim.Lock()
+	defer sim.Unlock()
+	if sim.config.DongleMissing {
+		return 0
+	}
+	return 1
+}
+
+func Dongle_GetFrequency() uint32 {
+	sim.Lock()
+	defer sim.Unlock()
+	return sim.state.Frequency
+}
+
+func Dongle_SetFrequency(frequency uint32) int {
+	sim.Lock()
+	defer sim.Unlock()
+	if sim.config.DongleMissing {
+		return 0
+	}
+	sim.state.Frequency = frequency
+	return 1
+}
+
+func Dongle_BiasTEnable(enable int) int {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.state.BiasT = enable != 0
+	return 1
+}
+
+func Decode_Initialize() int {
+	return 1
+}
+
+// Decode_Shutdown stops the decoder if started
+func Decode_Shutdown() int {
+	Decode_Stop()
+	return 1
+}
+
+func Decode_RefreshAudioDevices() int {
+	return 1
+}
+
+// Decode_ListDevices the simulated dongle's audio input and an output
+func Decode_ListDevices() ([]AudioDevice, error) {
+	return []AudioDevice{
+		{Index: 0, Name: "Simulated FUNcube Dongle V2.0", IsInput: true},
+		{Index: 1, Name: "Simulated Speakers", IsOutput: true},
+	}, nil
+}
+
+func Decode_Start(audioIn string, audioOut string, removeDC int, monitor int) int {
+	return Decode_StartByIndex(-1, -1, removeDC, monitor)
+}
+
+// Decode_StartByIndex starts replaying the frames, each calls back once it can be collected
+func Decode_StartByIndex(audioIn int, audioOut int, removeDC int, monitor int) int {
+	sim.Lock()
+	if sim.state.Started {
+		sim.Unlock()
+		return 1
+	}
+	frames, err := simLoadFrames(sim.config.File)
+	if err != nil {
+		sim.lastErr = err.Error()
+		sim.Unlock()
+		simLogf(LogError, "Failed to start decoder: %v", err)
+		return 0
+	}
+	sim.frames = frames
+	sim.next = 0
+	interval := sim.config.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	sim.stop = make(chan struct{})
+	sim.stopped = make(chan struct{})
+	sim.state.Started = true
+	rate := sim.config.SampleRate
+	if rate <= 0 {
+		rate = 192000
+	}
+	go simDecode(sim.stop, sim.stopped, interval, rate)
+	sim.Unlock()
+	simLogf(LogInfo, "Decoder started, replaying %d frames every %v", len(frames), interval)
+	return 1
+}
+
+// simLoadFrames the whole frames of the file, ten numbered frames if there is no file
+func simLoadFrames(fileName string) ([][]byte, error) {
+	var frames [][]byte
+	if fileName == "" {
+		for i := 0; i < 10; i++ {
+			frame := make([]byte, simFrameSize)
+			for j := range frame {
+				frame[j] = byte(i + j)
+			}
+			frames = append(frames, frame)
+		}
+		return frames, nil
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	for len(data) >= simFrameSize {
+		frames = append(frames, data[:simFrameSize])
+		data = data[simFrameSize:]
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no whole frames in %s", fileName)
+	}
+	return frames, nil
+}
+
+// simDecode decodes the next frame each interval and sends the samples callback a block of the
+// tone each simSampleInterval until stopped
+func simDecode(stop, stopped chan struct{}, interval time.Duration, rate int) {
+	defer close(stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	sampleTicker := time.NewTicker(simSampleInterval)
+	defer sampleTicker.Stop()
+	block := make([]float32, 2*rate*int(simSampleInterval)/int(time.Second))
+	var phase float64
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sampleTicker.C:
+			sim.Lock()
+			samples := sim.samples
+			sim.Unlock()
+			if samples == nil {
+				continue
+			}
+			step := 2 * math.Pi * simToneFreq / float64(rate)
+			for i := 0; i < len(block); i += 2 {
+				block[i] = float32(0.5 * math.Cos(phase))
+				block[i+1] = float32(0.5 * math.Sin(phase))
+				phase = math.Mod(phase+step, 2*math.Pi)
+			}
+			samples(block)
+			continue
+		case <-ticker.C:
+		}
+
+		sim.Lock()
+		sim.last = sim.frames[sim.next]
+		sim.worker = sim.state.Decoded % simWorkers()
+		sim.next = (sim.next + 1) % len(sim.frames)
+		sim.state.Decoded++
+		callback := sim.callback
+		sim.Unlock()
+		if callback != nil {
+			callback()
+		}
+	}
+}
+
+// Decode_Stop stops replaying frames, waiting for a frame being called back
+func Decode_Stop() int {
+	sim.Lock()
+	if !sim.state.Started {
+		sim.Unlock()
+		return 1
+	}
+	sim.state.Started = false
+	close(sim.stop)
+	stopped := sim.stopped
+	sim.Unlock()
+	<-stopped
+	return 1
+}
+
+func Decode_IsStarted() int {
+	sim.Lock()
+	defer sim.Unlock()
+	if sim.state.Started {
+		return 1
+	}
+	return 0
+}
+
+// Decode_CollectFftOutput a noise floor with a peak at the decoded frequency's bin, zeros once stopped
+func Decode_CollectFftOutput(fft *float32, size *uint32) int {
+	sim.Lock()
+	defer sim.Unlock()
+	if *size > simFftSize {
+		*size = simFftSize
+	}
+	out := simFloats(fft, *size)
+	for i := range out {
+		out[i] = 0
+		if sim.state.Started {
+			out[i] = 1
+		}
+	}
+	if sim.state.Started && len(out) > 0 {
+		out[len(out)/2] = 10
+	}
+	return 1
+}
+
+func Decode_SetWorkerCount(workers uint32) int {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.state.Workers = workers
+	return 1
+}
+
+// Decode_GetWorkerPeaks each worker's peak, 1000Hz apart from the configured decoded frequency,
+// the frames are decoded by each worker in turn
+func Decode_GetWorkerPeaks(peaks *float32, count *uint32) int {
+	sim.Lock()
+	defer sim.Unlock()
+	workers := simWorkers()
+	if int(*count) < workers {
+		workers = int(*count)
+	}
+	out := simFloats(peaks, *count)
+	for i := 0; i < workers; i++ {
+		out[i] = simWorkerFreq(i)
+	}
+	*count = uint32(workers)
+	return 1
+}
+
+// simWorkers the workers set, at least one, the sim lock held
+func simWorkers() int {
+	if sim.state.Workers == 0 {
+		return 1
+	}
+	return int(sim.state.Workers)
+}
+
+func simWorkerFreq(worker int) float32 {
+	return sim.config.DecodedFreq + float32(1000*worker)
+}
+
+// Decode_GetWorkerAvailability 1 for each worker free, 0 for one on its peak, the workers are all
+// free until the decoder is started and then busy unless their peak is excluded
+func Decode_GetWorkerAvailability(available *uint, count *uint32) int {
+	sim.Lock()
+	defer sim.Unlock()
+	workers := simWorkers()
+	if int(*count) < workers {
+		workers = int(*count)
+	}
+	out := simUints(available, *count)
+	for i := 0; i < workers; i++ {
+		out[i] = 0
+		if !sim.state.Started || simExcluded(simWorkerFreq(i)) {
+			out[i] = 1
+		}
+	}
+	*count = uint32(workers)
+	return 1
+}
+
+// simExcluded whether freq is within 500Hz of an excluded peak, the sim lock held
+func simExcluded(freq float32) bool {
+	for _, f := range sim.state.Exclude {
+		if f-500 < freq && freq < f+500 {
+			return true
+		}
+	}
+	return false
+}
+
+func Decode_ExcludePeaks(frequencies *float32, count *uint32) int {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.state.Exclude = append([]float32(nil), simFloats(frequencies, *count)...)
+	return 1
+}
+
+// Decode_CollectLastData copies the frame last decoded, with the configured frequency and error count
+func Decode_CollectLastData(data *byte, size *uint32, decodedFreq *float32, decodedErrors *int) int {
+	sim.Lock()
+	defer sim.Unlock()
+	if sim.last == nil {
+		*size = 0
+		return 0
+	}
+	*size = uint32(copy(simBytes(data, *size), sim.last))
+	*decodedFreq = simWorkerFreq(sim.worker)
+	*decodedErrors = sim.config.DecodedErrors
+	return 1
+}
+
+func Decode_LastError() string {
+	sim.Lock()
+	defer sim.Unlock()
+	return sim.lastErr
+}
+
+func Encode_Initialize() int {
+	return 1
+}
+
+func Encode_Shutdown() int {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.encoded = nil
+	return 1
+}
+
+func Encode_CanCollect() int {
+	sim.Lock()
+	defer sim.Unlock()
+	if len(sim.encoded) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func Encode_AllDataCollected() int {
+	sim.Lock()
+	defer sim.Unlock()
+	if len(sim.encoded) == 0 {
+		return 1
+	}
+	return 0
+}
+
+// Encode_CollectSamples copies as many whole float32 samples as fit, size is set to the bytes copied
+func Encode_CollectSamples(samples *byte, size *uint32) int {
+	sim.Lock()
+	defer sim.Unlock()
+	n := copy(simBytes(samples, *size-*size%4), sim.encoded)
+	sim.encoded = sim.encoded[n:]
+	*size = uint32(n)
+	return 1
+}
+
+// Encode_PushData encodes each bit of the data as a float32 sample, +1 for a one and -1 for a zero
+func Encode_PushData(data *byte, size uint32) int {
+	sim.Lock()
+	defer sim.Unlock()
+	sample := make([]byte, 4)
+	for _, b := range simBytes(data, size) {
+		for bit := 7; bit >= 0; bit-- {
+			value := float32(-1)
+			if b&(1<<uint(bit)) != 0 {
+				value = 1
+			}
+			binary.LittleEndian.PutUint32(sample, math.Float32bits(value))
+			sim.encoded = append(sim.encoded, sample...)
+		}
+	}
+	return 1
+}
+
+// Callback_SetOnDecodeReady sets the function to be called when data is decoded and ready to collect
+func Callback_SetOnDecodeReady(callbackFunc OnDecodeReadyFunc) {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.callback = callbackFunc
+}
+
+// Callback_Reattach nothing to do for the simulation, the callback survives a restart
+func Callback_Reattach() {
+}
+
+// Callback_ClearOnDecodeReady clears the function to be called when data is decoded and ready to collect
+func Callback_ClearOnDecodeReady(callbackFunc OnDecodeReadyFunc) {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.callback = nil
+}
+
+// Callback_SetOnSamples sets the function to be called with each block of the simulated tone
+func Callback_SetOnSamples(callbackFunc OnSamplesFunc) int {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.samples = callbackFunc
+	return 1
+}
+
+// Callback_ClearOnSamples stops the tone being sent
+func Callback_ClearOnSamples() {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.samples = nil
+}
+
+// Callback_SetOnLogMessage sets the function to be called with each message the simulation logs
+func Callback_SetOnLogMessage(callbackFunc OnLogMessageFunc) int {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.logFunc = callbackFunc
+	return 1
+}
+
+// Callback_ClearOnLogMessage the simulation writes the messages it logs to stderr again
+func Callback_ClearOnLogMessage() {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.logFunc = nil
+}
+
+// simLogf logs the message through the log callback, to stderr if none, as the library does
+func simLogf(level LogLevel, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	sim.Lock()
+	logFunc := sim.logFunc
+	sim.Unlock()
+	if logFunc == nil {
+		fmt.Fprintln(os.Stderr, message)
+		return
+	}
+	logFunc(int(level), message)
+}
+
+func Callback_Test() {
+	sim.Lock()
+	callback := sim.callback
+	sim.Unlock()
+	if callback != nil {
+		callback()
+	}
+}
@@ -0,0 +1,180 @@
+//go:build fcsim
+// +build fcsim
+
+package fclib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSim_Dongle(t *testing.T) {
+	Sim_Configure(SimConfig{})
+	assert.Equal(t, 1, Dongle_Initialize())
+	assert.Equal(t, 1, Dongle_SetFrequency(145860000))
+	assert.Equal(t, uint32(145860000), Dongle_GetFrequency())
+	Dongle_BiasTEnable(1)
+	assert.True(t, Sim_State().BiasT)
+	Dongle_BiasTEnable(0)
+	assert.False(t, Sim_State().BiasT)
+	Dongle_Shutdown()
+
+	Sim_Configure(SimConfig{DongleMissing: true})
+	defer Sim_Configure(SimConfig{})
+	assert.Equal(t, 0, Dongle_Initialize())
+	assert.Equal(t, 0, Dongle_Exists())
+}
+
+func TestSim_DecodeReplaysFile(t *testing.T) {
+	frames := append(bytes.Repeat([]byte{1}, 256), bytes.Repeat([]byte{2}, 256)...)
+	file := filepath.Join(t.TempDir(), "frames.bin")
+	if err := ioutil.WriteFile(file, frames, 0644); err != nil {
+		t.Fatal(err)
+	}
+	Sim_Configure(SimConfig{File: file, Interval: 10 * time.Millisecond, DecodedFreq: 1234.5, DecodedErrors: 3})
+	defer Sim_Configure(SimConfig{})
+
+	ready := make(chan []byte, 8)
+	Callback_SetOnDecodeReady(func() {
+		decoded := make([]byte, 256)
+		size := uint32(len(decoded))
+		var freq float32
+		var errors int
+		Decode_CollectLastData(&decoded[0], &size, &freq, &errors)
+		assert.Equal(t, float32(1234.5), freq)
+		assert.Equal(t, 3, errors)
+		ready <- decoded[:size]
+	})
+	defer Callback_ClearOnDecodeReady(nil)
+
+	assert.Equal(t, 1, Decode_Initialize())
+	assert.Equal(t, 1, Decode_StartByIndex(0, -1, 1, 1))
+	assert.Equal(t, 1, Decode_IsStarted())
+	for _, want := range []byte{1, 2, 1} {
+		assert.Equal(t, bytes.Repeat([]byte{want}, 256), <-ready, "replayed in order and looped")
+	}
+
+	fft := make([]float32, 8192)
+	size := uint32(len(fft))
+	assert.Equal(t, 1, Decode_CollectFftOutput(&fft[0], &size))
+	assert.Equal(t, uint32(4096), size)
+	assert.NotZero(t, fft[0], "not stalled while started")
+
+	Decode_Shutdown()
+	assert.Equal(t, 0, Decode_IsStarted())
+	size = uint32(len(fft))
+	Decode_CollectFftOutput(&fft[0], &size)
+	assert.Zero(t, fft[0])
+}
+
+func TestSim_DecodeMissingFile(t *testing.T) {
+	var logged []string
+	assert.Equal(t, 1, Callback_SetOnLogMessage(func(level int, message string) {
+		assert.Equal(t, int(LogError), level)
+		logged = append(logged, message)
+	}))
+	defer Callback_ClearOnLogMessage()
+
+	Sim_Configure(SimConfig{File: filepath.Join(t.TempDir(), "missing.bin")})
+	defer Sim_Configure(SimConfig{})
+	assert.Equal(t, 0, Decode_StartByIndex(0, -1, 1, 1))
+	assert.NotEmpty(t, Decode_LastError())
+	if assert.Len(t, logged, 1) {
+		assert.Contains(t, logged[0], "Failed to start decoder")
+	}
+}
+
+func TestSim_Encode(t *testing.T) {
+	Encode_Initialize()
+	defer Encode_Shutdown()
+	frame := []byte{0xA0}
+	Encode_PushData(&frame[0], uint32(len(frame)))
+	assert.Equal(t, 1, Encode_CanCollect())
+
+	// 8 samples, collected 6 bytes (one whole sample) at a time then the rest
+	var samples []float32
+	for Encode_AllDataCollected() == 0 {
+		buf := make([]byte, 6)
+		size := uint32(len(buf))
+		Encode_CollectSamples(&buf[0], &size)
+		assert.Equal(t, uint32(4), size)
+		samples = append(samples, math.Float32frombits(binary.LittleEndian.Uint32(buf)))
+	}
+	assert.Equal(t, []float32{1, -1, 1, -1, -1, -1, -1, -1}, samples)
+}
+
+func TestSim_DecodeSubscribe(t *testing.T) {
+	Sim_Configure(SimConfig{Interval: 10 * time.Millisecond, DecodedFreq: 1200, DecodedErrors: 2})
+	defer Sim_Configure(SimConfig{})
+	Decode_SetWorkerCount(3)
+	defer Decode_SetWorkerCount(0)
+
+	forward := Decode_Subscribe(8)
+	archive := Decode_Subscribe(8)
+	assert.Equal(t, 1, Decode_StartByIndex(0, -1, 1, 1))
+	for want := 0; want < 4; want++ {
+		event := <-forward.Events()
+		assert.Equal(t, want%3, event.Worker, "each worker in turn, found by its peak")
+		assert.Equal(t, float32(1200+1000*(want%3)), event.Frequency)
+		assert.Equal(t, 2, event.Errors)
+		assert.Len(t, event.Data, 256)
+		assert.False(t, event.Time.IsZero())
+		assert.Equal(t, event, <-archive.Events())
+	}
+	Decode_Stop()
+	forward.Unsubscribe()
+	archive.Unsubscribe()
+}
+
+func TestSim_WorkerAvailability(t *testing.T) {
+	Sim_Configure(SimConfig{DecodedFreq: 1200})
+	defer Sim_Configure(SimConfig{})
+	Decode_SetWorkerCount(3)
+	defer Decode_SetWorkerCount(0)
+
+	available := make([]uint, 4)
+	count := uint32(len(available))
+	assert.Equal(t, 1, Decode_GetWorkerAvailability(&available[0], &count))
+	assert.Equal(t, []uint{1, 1, 1}, available[:count], "free until started")
+
+	assert.Equal(t, 1, Decode_StartByIndex(0, -1, 1, 1))
+	exclude := []float32{2200}
+	excluded := uint32(len(exclude))
+	Decode_ExcludePeaks(&exclude[0], &excluded)
+	defer func() {
+		excluded = 0
+		Decode_ExcludePeaks(&exclude[0], &excluded)
+	}()
+	count = uint32(len(available))
+	assert.Equal(t, 1, Decode_GetWorkerAvailability(&available[0], &count))
+	assert.Equal(t, []uint{0, 1, 0}, available[:count], "free once its peak is excluded")
+	Decode_Stop()
+}
+
+func TestSim_StreamSamples(t *testing.T) {
+	Sim_Configure(SimConfig{SampleRate: 48000})
+	defer Sim_Configure(SimConfig{})
+	stream := Decode_StreamSamples(48000)
+	defer stream.Close()
+	assert.Equal(t, 1, Decode_StartByIndex(0, -1, 1, 1))
+	defer Decode_Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	buf := make([]float32, 960)
+	n, err := stream.Read(ctx, buf)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 960, n, "10ms of IQ pairs")
+		assert.Equal(t, float32(0.5), buf[0])
+		assert.Equal(t, float32(0), buf[1])
+		assert.InDelta(t, 0.5, math.Hypot(float64(buf[10]), float64(buf[11])), 1e-6, "constant amplitude tone")
+	}
+}
@@ -0,0 +1,21 @@
+package fclib
+
+// the types shared by the FUNcubeLib wrapper and the simulated library (-tags fcsim)
+
+// OnDecodeReadyFunc Signature of method required to receive notifications data ready
+type OnDecodeReadyFunc func()
+
+// OnSamplesFunc Signature of method required to receive the raw samples the decoder sees,
+// interleaved I and Q, the slice is only valid during the call
+type OnSamplesFunc func(samples []float32)
+
+// OnLogMessageFunc Signature of method required to receive the messages the library logs
+type OnLogMessageFunc func(level int, message string)
+
+// AudioDevice an audio device as reported by Decode_EnumDevices
+type AudioDevice struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name"`
+	IsInput  bool   `json:"isInput"`
+	IsOutput bool   `json:"isOutput"`
+}
@@ -1,3 +1,6 @@
+//go:build !fcsim
+// +build !fcsim
+
 /* ----------------------------------------------------------------------------
  * This file was automatically generated by SWIG (http://www.swig.org).
  * Version 3.0.12
@@ -34,6 +37,8 @@ typedef struct { void* array; intgo len; intgo cap; } _goslice_;
 typedef _gostring_ swig_type_1;
 typedef _gostring_ swig_type_2;
 typedef _gostring_ swig_type_3;
+typedef _gostring_ swig_type_4;
+typedef _gostring_ swig_type_5;
 extern void _wrap_Swig_free_fclib_a388184c668b9ee2(uintptr_t arg1);
 extern uintptr_t _wrap_Swig_malloc_fclib_a388184c668b9ee2(swig_intgo arg1);
 extern int _wrap_timeGetTime_fclib_a388184c668b9ee2(void);
@@ -104,6 +109,30 @@ extern void _wrap_OnDataReadyShim_fclib_a388184c668b9ee2(void);
 extern void _wrap_CleanupCallbackShim_fclib_a388184c668b9ee2(void);
 extern void _wrap_InitialiseCallbackShim_fclib_a388184c668b9ee2(uintptr_t arg1);
 extern void _wrap_RunCallback_fclib_a388184c668b9ee2(void);
+extern uintptr_t _wrap__swig_NewDirectorDeviceEnumShimDeviceEnumShim_fclib_a388184c668b9ee2(int);
+extern void _wrap_DeleteDirectorDeviceEnumShim_fclib_a388184c668b9ee2(uintptr_t arg1);
+extern void _wrap__swig_DirectorDeviceEnumShim_upcall_Device_fclib_a388184c668b9ee2(uintptr_t, swig_intgo arg2, swig_intgo arg3, swig_intgo arg4);
+extern void _wrap_delete_DeviceEnumShim_fclib_a388184c668b9ee2(uintptr_t arg1);
+extern void _wrap_DeviceEnumShim_device_fclib_a388184c668b9ee2(uintptr_t arg1, swig_intgo arg2, swig_intgo arg3, swig_intgo arg4);
+extern uintptr_t _wrap_new_DeviceEnumShim_fclib_a388184c668b9ee2(void);
+extern swig_type_4 _wrap_EnumDeviceName_fclib_a388184c668b9ee2(void);
+extern swig_intgo _wrap_EnumDevicesShim_fclib_a388184c668b9ee2(uintptr_t arg1);
+extern uintptr_t _wrap__swig_NewDirectorLogShimLogShim_fclib_a388184c668b9ee2(int);
+extern void _wrap_DeleteDirectorLogShim_fclib_a388184c668b9ee2(uintptr_t arg1);
+extern void _wrap__swig_DirectorLogShim_upcall_Log_fclib_a388184c668b9ee2(uintptr_t, swig_intgo arg2);
+extern void _wrap_delete_LogShim_fclib_a388184c668b9ee2(uintptr_t arg1);
+extern void _wrap_LogShim_log_fclib_a388184c668b9ee2(uintptr_t arg1, swig_intgo arg2);
+extern uintptr_t _wrap_new_LogShim_fclib_a388184c668b9ee2(void);
+extern swig_type_5 _wrap_LogShimMessage_fclib_a388184c668b9ee2(void);
+extern swig_intgo _wrap_InitialiseLogShim_fclib_a388184c668b9ee2(uintptr_t arg1);
+extern uintptr_t _wrap__swig_NewDirectorSampleShimSampleShim_fclib_a388184c668b9ee2(int);
+extern void _wrap_DeleteDirectorSampleShim_fclib_a388184c668b9ee2(uintptr_t arg1);
+extern void _wrap__swig_DirectorSampleShim_upcall_Samples_fclib_a388184c668b9ee2(uintptr_t, swig_intgo arg2);
+extern void _wrap_delete_SampleShim_fclib_a388184c668b9ee2(uintptr_t arg1);
+extern void _wrap_SampleShim_samples_fclib_a388184c668b9ee2(uintptr_t arg1, swig_intgo arg2);
+extern uintptr_t _wrap_new_SampleShim_fclib_a388184c668b9ee2(void);
+extern swig_intgo _wrap_SampleShimCollect_fclib_a388184c668b9ee2(swig_voidp arg1, swig_voidp arg2);
+extern swig_intgo _wrap_InitialiseSampleShim_fclib_a388184c668b9ee2(uintptr_t arg1);
 #undef intgo
 */
 import "C"
@@ -671,6 +700,341 @@ func RunCallback() {
 	C._wrap_RunCallback_fclib_a388184c668b9ee2()
 }
 
+type _swig_DirectorDeviceEnumShim struct {
+	SwigcptrDeviceEnumShim
+	v interface{}
+}
+
+func (p *_swig_DirectorDeviceEnumShim) Swigcptr() uintptr {
+	return p.SwigcptrDeviceEnumShim.Swigcptr()
+}
+
+func (p *_swig_DirectorDeviceEnumShim) SwigIsDeviceEnumShim() {
+}
+
+func (p *_swig_DirectorDeviceEnumShim) DirectorInterface() interface{} {
+	return p.v
+}
+
+func NewDirectorDeviceEnumShim(v interface{}) DeviceEnumShim {
+	p := &_swig_DirectorDeviceEnumShim{0, v}
+	p.SwigcptrDeviceEnumShim = SwigcptrDeviceEnumShim(C._wrap__swig_NewDirectorDeviceEnumShimDeviceEnumShim_fclib_a388184c668b9ee2(C.int(swigDirectorAdd(p))))
+	return p
+}
+
+func DeleteDirectorDeviceEnumShim(arg1 DeviceEnumShim) {
+	_swig_i_0 := arg1.Swigcptr()
+	C._wrap_DeleteDirectorDeviceEnumShim_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0))
+}
+
+//export Swiggo_DeleteDirector_DeviceEnumShim_fclib_a388184c668b9ee2
+func Swiggo_DeleteDirector_DeviceEnumShim_fclib_a388184c668b9ee2(c int) {
+	swigDirectorLookup(c).(*_swig_DirectorDeviceEnumShim).SwigcptrDeviceEnumShim = 0
+	swigDirectorDelete(c)
+}
+
+type _swig_DirectorInterfaceDeviceEnumShimDevice interface {
+	Device(int, int, int)
+}
+
+func (swig_p *_swig_DirectorDeviceEnumShim) Device(arg2 int, arg3 int, arg4 int) {
+	if swig_g, swig_ok := swig_p.v.(_swig_DirectorInterfaceDeviceEnumShimDevice); swig_ok {
+		swig_g.Device(arg2, arg3, arg4)
+		return
+	}
+	_swig_i_0 := arg2
+	_swig_i_1 := arg3
+	_swig_i_2 := arg4
+	C._wrap__swig_DirectorDeviceEnumShim_upcall_Device_fclib_a388184c668b9ee2(C.uintptr_t(swig_p.SwigcptrDeviceEnumShim), C.swig_intgo(_swig_i_0), C.swig_intgo(_swig_i_1), C.swig_intgo(_swig_i_2))
+}
+
+func DirectorDeviceEnumShimDevice(p DeviceEnumShim, arg2 int, arg3 int, arg4 int) {
+	_swig_i_0 := arg2
+	_swig_i_1 := arg3
+	_swig_i_2 := arg4
+	C._wrap__swig_DirectorDeviceEnumShim_upcall_Device_fclib_a388184c668b9ee2(C.uintptr_t(p.(*_swig_DirectorDeviceEnumShim).SwigcptrDeviceEnumShim), C.swig_intgo(_swig_i_0), C.swig_intgo(_swig_i_1), C.swig_intgo(_swig_i_2))
+}
+
+//export Swig_DirectorDeviceEnumShim_callback_device_fclib_a388184c668b9ee2
+func Swig_DirectorDeviceEnumShim_callback_device_fclib_a388184c668b9ee2(swig_c int, arg2 int, arg3 int, arg4 int) {
+	swig_p := swigDirectorLookup(swig_c).(*_swig_DirectorDeviceEnumShim)
+	swig_p.Device(arg2, arg3, arg4)
+}
+
+type SwigcptrDeviceEnumShim uintptr
+
+func (p SwigcptrDeviceEnumShim) Swigcptr() uintptr {
+	return (uintptr)(p)
+}
+
+func (p SwigcptrDeviceEnumShim) SwigIsDeviceEnumShim() {
+}
+
+func (p SwigcptrDeviceEnumShim) DirectorInterface() interface{} {
+	return nil
+}
+
+func DeleteDeviceEnumShim(arg1 DeviceEnumShim) {
+	_swig_i_0 := arg1.Swigcptr()
+	C._wrap_delete_DeviceEnumShim_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0))
+}
+
+func (arg1 SwigcptrDeviceEnumShim) Device(arg2 int, arg3 int, arg4 int) {
+	_swig_i_0 := arg1
+	_swig_i_1 := arg2
+	_swig_i_2 := arg3
+	_swig_i_3 := arg4
+	C._wrap_DeviceEnumShim_device_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0), C.swig_intgo(_swig_i_1), C.swig_intgo(_swig_i_2), C.swig_intgo(_swig_i_3))
+}
+
+func NewDeviceEnumShim() (_swig_ret DeviceEnumShim) {
+	var swig_r DeviceEnumShim
+	swig_r = (DeviceEnumShim)(SwigcptrDeviceEnumShim(C._wrap_new_DeviceEnumShim_fclib_a388184c668b9ee2()))
+	return swig_r
+}
+
+type DeviceEnumShim interface {
+	Swigcptr() uintptr
+	SwigIsDeviceEnumShim()
+	DirectorInterface() interface{}
+	Device(arg2 int, arg3 int, arg4 int)
+}
+
+func EnumDeviceName() (_swig_ret string) {
+	var swig_r string
+	swig_r_p := C._wrap_EnumDeviceName_fclib_a388184c668b9ee2()
+	swig_r = *(*string)(unsafe.Pointer(&swig_r_p))
+	var swig_r_1 string
+ swig_r_1 = swigCopyString(swig_r) 
+	return swig_r_1
+}
+
+func EnumDevicesShim(arg1 DeviceEnumShim) (_swig_ret int) {
+	var swig_r int
+	_swig_i_0 := arg1.Swigcptr()
+	swig_r = (int)(C._wrap_EnumDevicesShim_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0)))
+	return swig_r
+}
+
+
+type _swig_DirectorLogShim struct {
+	SwigcptrLogShim
+	v interface{}
+}
+
+func (p *_swig_DirectorLogShim) Swigcptr() uintptr {
+	return p.SwigcptrLogShim.Swigcptr()
+}
+
+func (p *_swig_DirectorLogShim) SwigIsLogShim() {
+}
+
+func (p *_swig_DirectorLogShim) DirectorInterface() interface{} {
+	return p.v
+}
+
+func NewDirectorLogShim(v interface{}) LogShim {
+	p := &_swig_DirectorLogShim{0, v}
+	p.SwigcptrLogShim = SwigcptrLogShim(C._wrap__swig_NewDirectorLogShimLogShim_fclib_a388184c668b9ee2(C.int(swigDirectorAdd(p))))
+	return p
+}
+
+func DeleteDirectorLogShim(arg1 LogShim) {
+	_swig_i_0 := arg1.Swigcptr()
+	C._wrap_DeleteDirectorLogShim_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0))
+}
+
+//export Swiggo_DeleteDirector_LogShim_fclib_a388184c668b9ee2
+func Swiggo_DeleteDirector_LogShim_fclib_a388184c668b9ee2(c int) {
+	swigDirectorLookup(c).(*_swig_DirectorLogShim).SwigcptrLogShim = 0
+	swigDirectorDelete(c)
+}
+
+type _swig_DirectorInterfaceLogShimLog interface {
+	Log(int)
+}
+
+func (swig_p *_swig_DirectorLogShim) Log(arg2 int) {
+	if swig_g, swig_ok := swig_p.v.(_swig_DirectorInterfaceLogShimLog); swig_ok {
+		swig_g.Log(arg2)
+		return
+	}
+	_swig_i_0 := arg2
+	C._wrap__swig_DirectorLogShim_upcall_Log_fclib_a388184c668b9ee2(C.uintptr_t(swig_p.SwigcptrLogShim), C.swig_intgo(_swig_i_0))
+}
+
+func DirectorLogShimLog(p LogShim, arg2 int) {
+	_swig_i_0 := arg2
+	C._wrap__swig_DirectorLogShim_upcall_Log_fclib_a388184c668b9ee2(C.uintptr_t(p.(*_swig_DirectorLogShim).SwigcptrLogShim), C.swig_intgo(_swig_i_0))
+}
+
+//export Swig_DirectorLogShim_callback_log_fclib_a388184c668b9ee2
+func Swig_DirectorLogShim_callback_log_fclib_a388184c668b9ee2(swig_c int, arg2 int) {
+	swig_p := swigDirectorLookup(swig_c).(*_swig_DirectorLogShim)
+	swig_p.Log(arg2)
+}
+
+type SwigcptrLogShim uintptr
+
+func (p SwigcptrLogShim) Swigcptr() uintptr {
+	return (uintptr)(p)
+}
+
+func (p SwigcptrLogShim) SwigIsLogShim() {
+}
+
+func (p SwigcptrLogShim) DirectorInterface() interface{} {
+	return nil
+}
+
+func DeleteLogShim(arg1 LogShim) {
+	_swig_i_0 := arg1.Swigcptr()
+	C._wrap_delete_LogShim_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0))
+}
+
+func (arg1 SwigcptrLogShim) Log(arg2 int) {
+	_swig_i_0 := arg1
+	_swig_i_1 := arg2
+	C._wrap_LogShim_log_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0), C.swig_intgo(_swig_i_1))
+}
+
+func NewLogShim() (_swig_ret LogShim) {
+	var swig_r LogShim
+	swig_r = (LogShim)(SwigcptrLogShim(C._wrap_new_LogShim_fclib_a388184c668b9ee2()))
+	return swig_r
+}
+
+type LogShim interface {
+	Swigcptr() uintptr
+	SwigIsLogShim()
+	DirectorInterface() interface{}
+	Log(arg2 int)
+}
+
+func LogShimMessage() (_swig_ret string) {
+	var swig_r string
+	swig_r_p := C._wrap_LogShimMessage_fclib_a388184c668b9ee2()
+	swig_r = *(*string)(unsafe.Pointer(&swig_r_p))
+	var swig_r_1 string
+ swig_r_1 = swigCopyString(swig_r) 
+	return swig_r_1
+}
+
+func InitialiseLogShim(arg1 LogShim) (_swig_ret int) {
+	var swig_r int
+	_swig_i_0 := arg1.Swigcptr()
+	swig_r = (int)(C._wrap_InitialiseLogShim_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0)))
+	return swig_r
+}
+
+type _swig_DirectorSampleShim struct {
+	SwigcptrSampleShim
+	v interface{}
+}
+
+func (p *_swig_DirectorSampleShim) Swigcptr() uintptr {
+	return p.SwigcptrSampleShim.Swigcptr()
+}
+
+func (p *_swig_DirectorSampleShim) SwigIsSampleShim() {
+}
+
+func (p *_swig_DirectorSampleShim) DirectorInterface() interface{} {
+	return p.v
+}
+
+func NewDirectorSampleShim(v interface{}) SampleShim {
+	p := &_swig_DirectorSampleShim{0, v}
+	p.SwigcptrSampleShim = SwigcptrSampleShim(C._wrap__swig_NewDirectorSampleShimSampleShim_fclib_a388184c668b9ee2(C.int(swigDirectorAdd(p))))
+	return p
+}
+
+func DeleteDirectorSampleShim(arg1 SampleShim) {
+	_swig_i_0 := arg1.Swigcptr()
+	C._wrap_DeleteDirectorSampleShim_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0))
+}
+
+//export Swiggo_DeleteDirector_SampleShim_fclib_a388184c668b9ee2
+func Swiggo_DeleteDirector_SampleShim_fclib_a388184c668b9ee2(c int) {
+	swigDirectorLookup(c).(*_swig_DirectorSampleShim).SwigcptrSampleShim = 0
+	swigDirectorDelete(c)
+}
+
+type _swig_DirectorInterfaceSampleShimSamples interface {
+	Samples(int)
+}
+
+func (swig_p *_swig_DirectorSampleShim) Samples(arg2 int) {
+	if swig_g, swig_ok := swig_p.v.(_swig_DirectorInterfaceSampleShimSamples); swig_ok {
+		swig_g.Samples(arg2)
+		return
+	}
+	_swig_i_0 := arg2
+	C._wrap__swig_DirectorSampleShim_upcall_Samples_fclib_a388184c668b9ee2(C.uintptr_t(swig_p.SwigcptrSampleShim), C.swig_intgo(_swig_i_0))
+}
+
+func DirectorSampleShimSamples(p SampleShim, arg2 int) {
+	_swig_i_0 := arg2
+	C._wrap__swig_DirectorSampleShim_upcall_Samples_fclib_a388184c668b9ee2(C.uintptr_t(p.(*_swig_DirectorSampleShim).SwigcptrSampleShim), C.swig_intgo(_swig_i_0))
+}
+
+//export Swig_DirectorSampleShim_callback_samples_fclib_a388184c668b9ee2
+func Swig_DirectorSampleShim_callback_samples_fclib_a388184c668b9ee2(swig_c int, arg2 int) {
+	swig_p := swigDirectorLookup(swig_c).(*_swig_DirectorSampleShim)
+	swig_p.Samples(arg2)
+}
+
+type SwigcptrSampleShim uintptr
+
+func (p SwigcptrSampleShim) Swigcptr() uintptr {
+	return (uintptr)(p)
+}
+
+func (p SwigcptrSampleShim) SwigIsSampleShim() {
+}
+
+func (p SwigcptrSampleShim) DirectorInterface() interface{} {
+	return nil
+}
+
+func DeleteSampleShim(arg1 SampleShim) {
+	_swig_i_0 := arg1.Swigcptr()
+	C._wrap_delete_SampleShim_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0))
+}
+
+func (arg1 SwigcptrSampleShim) Samples(arg2 int) {
+	_swig_i_0 := arg1
+	_swig_i_1 := arg2
+	C._wrap_SampleShim_samples_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0), C.swig_intgo(_swig_i_1))
+}
+
+func NewSampleShim() (_swig_ret SampleShim) {
+	var swig_r SampleShim
+	swig_r = (SampleShim)(SwigcptrSampleShim(C._wrap_new_SampleShim_fclib_a388184c668b9ee2()))
+	return swig_r
+}
+
+type SampleShim interface {
+	Swigcptr() uintptr
+	SwigIsSampleShim()
+	DirectorInterface() interface{}
+	Samples(arg2 int)
+}
+
+func SampleShimCollect(arg1 *float32, arg2 *uint32) (_swig_ret int) {
+	var swig_r int
+	_swig_i_0 := arg1
+	_swig_i_1 := arg2
+	swig_r = (int)(C._wrap_SampleShimCollect_fclib_a388184c668b9ee2(C.swig_voidp(_swig_i_0), C.swig_voidp(_swig_i_1)))
+	return swig_r
+}
+
+func InitialiseSampleShim(arg1 SampleShim) (_swig_ret int) {
+	var swig_r int
+	_swig_i_0 := arg1.Swigcptr()
+	swig_r = (int)(C._wrap_InitialiseSampleShim_fclib_a388184c668b9ee2(C.uintptr_t(_swig_i_0)))
+	return swig_r
+}
 
 type SwigcptrSwigDirector_CallbackShim uintptr
 type SwigDirector_CallbackShim interface {
@@ -680,6 +1044,30 @@ func (p SwigcptrSwigDirector_CallbackShim) Swigcptr() uintptr {
 	return uintptr(p)
 }
 
+type SwigcptrSwigDirector_DeviceEnumShim uintptr
+type SwigDirector_DeviceEnumShim interface {
+	Swigcptr() uintptr;
+}
+func (p SwigcptrSwigDirector_DeviceEnumShim) Swigcptr() uintptr {
+	return uintptr(p)
+}
+
+type SwigcptrSwigDirector_LogShim uintptr
+type SwigDirector_LogShim interface {
+	Swigcptr() uintptr;
+}
+func (p SwigcptrSwigDirector_LogShim) Swigcptr() uintptr {
+	return uintptr(p)
+}
+
+type SwigcptrSwigDirector_SampleShim uintptr
+type SwigDirector_SampleShim interface {
+	Swigcptr() uintptr;
+}
+func (p SwigcptrSwigDirector_SampleShim) Swigcptr() uintptr {
+	return uintptr(p)
+}
+
 type SwigcptrStd_string uintptr
 type Std_string interface {
 	Swigcptr() uintptr;
@@ -2,6 +2,12 @@
 %feature("autodoc", "0");
 /* turn on director wrapping for CallbackShim class */
 %feature("director") CallbackShim;
+/* and for DeviceEnumShim, called once per device during EnumDevicesShim */
+%feature("director") DeviceEnumShim;
+/* and for LogShim, called with each message the library logs */
+%feature("director") LogShim;
+/* and for SampleShim, called with each block of samples the decoder sees */
+%feature("director") SampleShim;
 
 %module(directors="1") funcubelibwrap
 %{
@@ -36,6 +42,18 @@
 %ignore CEncodeThread;
 %ignore COMPLEXSTRUCT;
 %ignore Caller::call;
+%ignore g_deviceEnumInstance;
+%ignore g_deviceEnumName;
+%ignore DeviceEnumCallbackShim;
+%ignore g_logInstance;
+%ignore g_logMessage;
+%ignore g_logMutex;
+%ignore LogCallbackShim;
+%ignore g_sampleInstance;
+%ignore g_sampleData;
+%ignore g_sampleLength;
+%ignore g_sampleMutex;
+%ignore SampleCallbackShim;
 
 %include "wintypes.h"
 %include "funcubeLib.h"
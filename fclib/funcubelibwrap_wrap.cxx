@@ -1,3 +1,6 @@
+//go:build !fcsim
+// +build !fcsim
+
 /* ----------------------------------------------------------------------------
  * This file was automatically generated by SWIG (http://www.swig.org).
  * Version 3.0.12
@@ -341,6 +344,70 @@ void SwigDirector_CallbackShim::run() {
   Swig_DirectorCallbackShim_callback_run_fclib_a388184c668b9ee2(go_val);
 }
 
+SwigDirector_DeviceEnumShim::SwigDirector_DeviceEnumShim(int swig_p)
+    : DeviceEnumShim(),
+      go_val(swig_p), swig_mem(0)
+{ }
+
+extern "C" void Swiggo_DeleteDirector_DeviceEnumShim_fclib_a388184c668b9ee2(intgo);
+SwigDirector_DeviceEnumShim::~SwigDirector_DeviceEnumShim()
+{
+  Swiggo_DeleteDirector_DeviceEnumShim_fclib_a388184c668b9ee2(go_val);
+  delete swig_mem;
+}
+
+extern "C" void Swig_DirectorDeviceEnumShim_callback_device_fclib_a388184c668b9ee2(int, intgo arg2, intgo arg3, intgo arg4);
+void SwigDirector_DeviceEnumShim::device(int index, int isInput, int isOutput) {
+  intgo swig_index;
+  intgo swig_isInput;
+  intgo swig_isOutput;
+  
+  swig_index = (int)index; 
+  swig_isInput = (int)isInput; 
+  swig_isOutput = (int)isOutput; 
+  Swig_DirectorDeviceEnumShim_callback_device_fclib_a388184c668b9ee2(go_val, swig_index, swig_isInput, swig_isOutput);
+}
+
+SwigDirector_LogShim::SwigDirector_LogShim(int swig_p)
+    : LogShim(),
+      go_val(swig_p), swig_mem(0)
+{ }
+
+extern "C" void Swiggo_DeleteDirector_LogShim_fclib_a388184c668b9ee2(intgo);
+SwigDirector_LogShim::~SwigDirector_LogShim()
+{
+  Swiggo_DeleteDirector_LogShim_fclib_a388184c668b9ee2(go_val);
+  delete swig_mem;
+}
+
+extern "C" void Swig_DirectorLogShim_callback_log_fclib_a388184c668b9ee2(int, intgo arg2);
+void SwigDirector_LogShim::log(int level) {
+  intgo swig_level;
+  
+  swig_level = (int)level; 
+  Swig_DirectorLogShim_callback_log_fclib_a388184c668b9ee2(go_val, swig_level);
+}
+
+SwigDirector_SampleShim::SwigDirector_SampleShim(int swig_p)
+    : SampleShim(),
+      go_val(swig_p), swig_mem(0)
+{ }
+
+extern "C" void Swiggo_DeleteDirector_SampleShim_fclib_a388184c668b9ee2(intgo);
+SwigDirector_SampleShim::~SwigDirector_SampleShim()
+{
+  Swiggo_DeleteDirector_SampleShim_fclib_a388184c668b9ee2(go_val);
+  delete swig_mem;
+}
+
+extern "C" void Swig_DirectorSampleShim_callback_samples_fclib_a388184c668b9ee2(int, intgo arg2);
+void SwigDirector_SampleShim::samples(int length) {
+  intgo swig_length;
+  
+  swig_length = (int)length; 
+  Swig_DirectorSampleShim_callback_samples_fclib_a388184c668b9ee2(go_val, swig_length);
+}
+
 #ifdef __cplusplus
 extern "C" {
 #endif
@@ -1190,6 +1257,294 @@ void _wrap_RunCallback_fclib_a388184c668b9ee2() {
 }
 
 
+DeviceEnumShim *_wrap__swig_NewDirectorDeviceEnumShimDeviceEnumShim_fclib_a388184c668b9ee2(intgo _swig_go_0) {
+  int arg1 ;
+  DeviceEnumShim *result = 0 ;
+  DeviceEnumShim *_swig_go_result;
+  
+  arg1 = (int)_swig_go_0; 
+  
+  result = new SwigDirector_DeviceEnumShim(arg1);
+  *(DeviceEnumShim **)&_swig_go_result = (DeviceEnumShim *)result; 
+  return _swig_go_result;
+}
+
+
+void _wrap_DeleteDirectorDeviceEnumShim_fclib_a388184c668b9ee2(DeviceEnumShim *_swig_go_0) {
+  DeviceEnumShim *arg1 = (DeviceEnumShim *) 0 ;
+  
+  arg1 = *(DeviceEnumShim **)&_swig_go_0; 
+  
+  delete arg1;
+  
+}
+
+
+void _wrap__swig_DirectorDeviceEnumShim_upcall_Device_fclib_a388184c668b9ee2(SwigDirector_DeviceEnumShim *_swig_go_0, intgo _swig_go_1, intgo _swig_go_2, intgo _swig_go_3) {
+  SwigDirector_DeviceEnumShim *arg1 = (SwigDirector_DeviceEnumShim *) 0 ;
+  int arg2 ;
+  int arg3 ;
+  int arg4 ;
+  
+  arg1 = *(SwigDirector_DeviceEnumShim **)&_swig_go_0; 
+  arg2 = (int)_swig_go_1; 
+  arg3 = (int)_swig_go_2; 
+  arg4 = (int)_swig_go_3; 
+  
+  arg1->_swig_upcall_device(arg2,arg3,arg4);
+  
+}
+
+
+void _wrap_delete_DeviceEnumShim_fclib_a388184c668b9ee2(DeviceEnumShim *_swig_go_0) {
+  DeviceEnumShim *arg1 = (DeviceEnumShim *) 0 ;
+  
+  arg1 = *(DeviceEnumShim **)&_swig_go_0; 
+  
+  delete arg1;
+  
+}
+
+
+void _wrap_DeviceEnumShim_device_fclib_a388184c668b9ee2(DeviceEnumShim *_swig_go_0, intgo _swig_go_1, intgo _swig_go_2, intgo _swig_go_3) {
+  DeviceEnumShim *arg1 = (DeviceEnumShim *) 0 ;
+  int arg2 ;
+  int arg3 ;
+  int arg4 ;
+  
+  arg1 = *(DeviceEnumShim **)&_swig_go_0; 
+  arg2 = (int)_swig_go_1; 
+  arg3 = (int)_swig_go_2; 
+  arg4 = (int)_swig_go_3; 
+  
+  (arg1)->device(arg2,arg3,arg4);
+  
+}
+
+
+DeviceEnumShim *_wrap_new_DeviceEnumShim_fclib_a388184c668b9ee2() {
+  DeviceEnumShim *result = 0 ;
+  DeviceEnumShim *_swig_go_result;
+  
+  
+  result = (DeviceEnumShim *)new DeviceEnumShim();
+  *(DeviceEnumShim **)&_swig_go_result = (DeviceEnumShim *)result; 
+  return _swig_go_result;
+}
+
+
+_gostring_ _wrap_EnumDeviceName_fclib_a388184c668b9ee2() {
+  char *result = 0 ;
+  _gostring_ _swig_go_result;
+  
+  
+  result = (char *)EnumDeviceName();
+  _swig_go_result = Swig_AllocateString((char*)result, result ? strlen((char*)result) : 0); 
+  return _swig_go_result;
+}
+
+
+intgo _wrap_EnumDevicesShim_fclib_a388184c668b9ee2(DeviceEnumShim *_swig_go_0) {
+  DeviceEnumShim *arg1 = (DeviceEnumShim *) 0 ;
+  BOOL result;
+  intgo _swig_go_result;
+  
+  arg1 = *(DeviceEnumShim **)&_swig_go_0; 
+  
+  result = (BOOL)EnumDevicesShim(arg1);
+  _swig_go_result = result; 
+  return _swig_go_result;
+}
+
+
+LogShim *_wrap__swig_NewDirectorLogShimLogShim_fclib_a388184c668b9ee2(intgo _swig_go_0) {
+  int arg1 ;
+  LogShim *result = 0 ;
+  LogShim *_swig_go_result;
+  
+  arg1 = (int)_swig_go_0; 
+  
+  result = new SwigDirector_LogShim(arg1);
+  *(LogShim **)&_swig_go_result = (LogShim *)result; 
+  return _swig_go_result;
+}
+
+
+void _wrap_DeleteDirectorLogShim_fclib_a388184c668b9ee2(LogShim *_swig_go_0) {
+  LogShim *arg1 = (LogShim *) 0 ;
+  
+  arg1 = *(LogShim **)&_swig_go_0; 
+  
+  delete arg1;
+  
+}
+
+
+void _wrap__swig_DirectorLogShim_upcall_Log_fclib_a388184c668b9ee2(SwigDirector_LogShim *_swig_go_0, intgo _swig_go_1) {
+  SwigDirector_LogShim *arg1 = (SwigDirector_LogShim *) 0 ;
+  int arg2 ;
+  
+  arg1 = *(SwigDirector_LogShim **)&_swig_go_0; 
+  arg2 = (int)_swig_go_1; 
+  
+  arg1->_swig_upcall_log(arg2);
+  
+}
+
+
+void _wrap_delete_LogShim_fclib_a388184c668b9ee2(LogShim *_swig_go_0) {
+  LogShim *arg1 = (LogShim *) 0 ;
+  
+  arg1 = *(LogShim **)&_swig_go_0; 
+  
+  delete arg1;
+  
+}
+
+
+void _wrap_LogShim_log_fclib_a388184c668b9ee2(LogShim *_swig_go_0, intgo _swig_go_1) {
+  LogShim *arg1 = (LogShim *) 0 ;
+  int arg2 ;
+  
+  arg1 = *(LogShim **)&_swig_go_0; 
+  arg2 = (int)_swig_go_1; 
+  
+  (arg1)->log(arg2);
+  
+}
+
+
+LogShim *_wrap_new_LogShim_fclib_a388184c668b9ee2() {
+  LogShim *result = 0 ;
+  LogShim *_swig_go_result;
+  
+  
+  result = (LogShim *)new LogShim();
+  *(LogShim **)&_swig_go_result = (LogShim *)result; 
+  return _swig_go_result;
+}
+
+
+_gostring_ _wrap_LogShimMessage_fclib_a388184c668b9ee2() {
+  char *result = 0 ;
+  _gostring_ _swig_go_result;
+  
+  
+  result = (char *)LogShimMessage();
+  _swig_go_result = Swig_AllocateString((char*)result, result ? strlen((char*)result) : 0); 
+  return _swig_go_result;
+}
+
+
+intgo _wrap_InitialiseLogShim_fclib_a388184c668b9ee2(LogShim *_swig_go_0) {
+  LogShim *arg1 = (LogShim *) 0 ;
+  BOOL result;
+  intgo _swig_go_result;
+  
+  arg1 = *(LogShim **)&_swig_go_0; 
+  
+  result = (BOOL)InitialiseLogShim(arg1);
+  _swig_go_result = result; 
+  return _swig_go_result;
+}
+
+
+SampleShim *_wrap__swig_NewDirectorSampleShimSampleShim_fclib_a388184c668b9ee2(intgo _swig_go_0) {
+  int arg1 ;
+  SampleShim *result = 0 ;
+  SampleShim *_swig_go_result;
+  
+  arg1 = (int)_swig_go_0; 
+  
+  result = new SwigDirector_SampleShim(arg1);
+  *(SampleShim **)&_swig_go_result = (SampleShim *)result; 
+  return _swig_go_result;
+}
+
+
+void _wrap_DeleteDirectorSampleShim_fclib_a388184c668b9ee2(SampleShim *_swig_go_0) {
+  SampleShim *arg1 = (SampleShim *) 0 ;
+  
+  arg1 = *(SampleShim **)&_swig_go_0; 
+  
+  delete arg1;
+  
+}
+
+
+void _wrap__swig_DirectorSampleShim_upcall_Samples_fclib_a388184c668b9ee2(SwigDirector_SampleShim *_swig_go_0, intgo _swig_go_1) {
+  SwigDirector_SampleShim *arg1 = (SwigDirector_SampleShim *) 0 ;
+  int arg2 ;
+  
+  arg1 = *(SwigDirector_SampleShim **)&_swig_go_0; 
+  arg2 = (int)_swig_go_1; 
+  
+  arg1->_swig_upcall_samples(arg2);
+  
+}
+
+
+void _wrap_delete_SampleShim_fclib_a388184c668b9ee2(SampleShim *_swig_go_0) {
+  SampleShim *arg1 = (SampleShim *) 0 ;
+  
+  arg1 = *(SampleShim **)&_swig_go_0; 
+  
+  delete arg1;
+  
+}
+
+
+void _wrap_SampleShim_samples_fclib_a388184c668b9ee2(SampleShim *_swig_go_0, intgo _swig_go_1) {
+  SampleShim *arg1 = (SampleShim *) 0 ;
+  int arg2 ;
+  
+  arg1 = *(SampleShim **)&_swig_go_0; 
+  arg2 = (int)_swig_go_1; 
+  
+  (arg1)->samples(arg2);
+  
+}
+
+
+SampleShim *_wrap_new_SampleShim_fclib_a388184c668b9ee2() {
+  SampleShim *result = 0 ;
+  SampleShim *_swig_go_result;
+  
+  
+  result = (SampleShim *)new SampleShim();
+  *(SampleShim **)&_swig_go_result = (SampleShim *)result; 
+  return _swig_go_result;
+}
+
+
+intgo _wrap_SampleShimCollect_fclib_a388184c668b9ee2(float *_swig_go_0, int *_swig_go_1) {
+  FLOAT *arg1 = (FLOAT *) 0 ;
+  ULONG *arg2 = (ULONG *) 0 ;
+  BOOL result;
+  intgo _swig_go_result;
+  
+  arg1 = *(FLOAT **)&_swig_go_0; 
+  arg2 = *(ULONG **)&_swig_go_1; 
+  
+  result = (BOOL)SampleShimCollect(arg1,arg2);
+  _swig_go_result = result; 
+  return _swig_go_result;
+}
+
+
+intgo _wrap_InitialiseSampleShim_fclib_a388184c668b9ee2(SampleShim *_swig_go_0) {
+  SampleShim *arg1 = (SampleShim *) 0 ;
+  BOOL result;
+  intgo _swig_go_result;
+  
+  arg1 = *(SampleShim **)&_swig_go_0; 
+  
+  result = (BOOL)InitialiseSampleShim(arg1);
+  _swig_go_result = result; 
+  return _swig_go_result;
+}
+
+
 #ifdef __cplusplus
 }
 #endif
@@ -29,4 +29,46 @@ class SwigDirector_CallbackShim : public CallbackShim
   Swig_memory *swig_mem;
 };
 
+class SwigDirector_DeviceEnumShim : public DeviceEnumShim
+{
+ public:
+  SwigDirector_DeviceEnumShim(int swig_p);
+  virtual ~SwigDirector_DeviceEnumShim();
+  void _swig_upcall_device(int index, int isInput, int isOutput) {
+    DeviceEnumShim::device(index,isInput,isOutput);
+  }
+  virtual void device(int index, int isInput, int isOutput);
+ private:
+  intgo go_val;
+  Swig_memory *swig_mem;
+};
+
+class SwigDirector_LogShim : public LogShim
+{
+ public:
+  SwigDirector_LogShim(int swig_p);
+  virtual ~SwigDirector_LogShim();
+  void _swig_upcall_log(int level) {
+    LogShim::log(level);
+  }
+  virtual void log(int level);
+ private:
+  intgo go_val;
+  Swig_memory *swig_mem;
+};
+
+class SwigDirector_SampleShim : public SampleShim
+{
+ public:
+  SwigDirector_SampleShim(int swig_p);
+  virtual ~SwigDirector_SampleShim();
+  void _swig_upcall_samples(int length) {
+    SampleShim::samples(length);
+  }
+  virtual void samples(int length);
+ private:
+  intgo go_val;
+  Swig_memory *swig_mem;
+};
+
 #endif
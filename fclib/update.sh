@@ -6,3 +6,8 @@ rm funcubelibwrap.go
 rm funcubelibwrap_wrap.cxx
 rm funcubelibwrap_wrap.h
 swig -cgo -go -c++ -intgosize 64 -package fclib -I/usr/local/include/funcubelib funcubelibwrap.i
+
+# the simulated library (funcubelibsim.go) replaces these with -tags fcsim
+for f in funcubelibwrap.go funcubelibwrap_wrap.cxx; do
+	printf '//go:build !fcsim\n// +build !fcsim\n\n' | cat - $f > $f.tmp && mv $f.tmp $f
+done
@@ -0,0 +1,146 @@
+package fcorbit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/funcube-dev/go/fcstore"
+)
+
+// Config what to rebuild and the frames it is rebuilt from, the koanf tags match the fcorbit
+// configuration files
+type Config struct {
+	Store     string   `koanf:"store"`     // fcstore file the frames are read from
+	From      string   `koanf:"from"`      // RFC3339, stored frames received from
+	To        string   `koanf:"to"`        // RFC3339, stored frames received before
+	Satellite int      `koanf:"satellite"` // from the frame header, -1 the only one in the frames
+	Files     []string `koanf:"files"`     // funcubebin files the frames are read from
+	FileStart string   `koanf:"filestart"` // RFC3339, the files' first frame was received, the rest a frame interval apart
+	Station   string   `koanf:"station"`   // received the files' frames
+	Dataset   string   `koanf:"dataset"`   // wholeorbit or highres
+	Format    string   `koanf:"format"`    // csv or json
+	Output    string   `koanf:"output"`    // file written, stdout if empty
+	Layout    Layout   `koanf:"layout"`
+}
+
+// DefaultConfig returns the default configuration
+func DefaultConfig() Config {
+	return Config{
+		Satellite: -1,
+		Files:     []string{},
+		Dataset:   "wholeorbit",
+		Format:    "csv",
+		Layout:    DefaultLayout(),
+	}
+}
+
+// Frames read the frames of the store and files
+func Frames(config Config) ([]Frame, error) {
+	var frames []Frame
+	if config.Store != "" {
+		q := fcstore.Query{}
+		var err error
+		if q.From, err = parseTime("from", config.From); err != nil {
+			return nil, err
+		}
+		if q.To, err = parseTime("to", config.To); err != nil {
+			return nil, err
+		}
+		if config.Satellite >= 0 {
+			q.Satellite = &config.Satellite
+		}
+		records, err := fcstore.Read(config.Store, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			frames = append(frames, Frame{Received: r.Received, Station: r.Station, Data: r.Frame})
+		}
+	}
+
+	if len(config.Files) == 0 {
+		return frames, nil
+	}
+	received, err := parseTime("filestart", config.FileStart)
+	if err != nil {
+		return nil, err
+	}
+	if received.IsZero() {
+		return nil, errors.New("files need the time their first frame was received, filestart")
+	}
+	frameInterval := time.Duration(config.Layout.FrameInterval * float64(time.Second))
+	for _, name := range config.Files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(data)%256 != 0 {
+			return nil, fmt.Errorf("%s is not a multiple of 256 bytes", name)
+		}
+		for i := 0; i < len(data); i += 256 {
+			if satellite, _ := fcstore.Header(data[i:]); config.Satellite < 0 || satellite == config.Satellite {
+				frames = append(frames, Frame{Received: received, Station: config.Station, Data: data[i : i+256]})
+			}
+			received = received.Add(frameInterval)
+		}
+	}
+	return frames, nil
+}
+
+func parseTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s %q not an RFC3339 time", name, value)
+	}
+	return t, nil
+}
+
+// Export rebuild the dataset from the frames and write it to w
+func Export(config Config, w io.Writer) (Dataset, Stats, error) {
+	r, err := NewReassembler(config.Layout)
+	if err != nil {
+		return Dataset{}, Stats{}, err
+	}
+	var write func(Dataset, io.Writer) error
+	switch config.Format {
+	case "csv":
+		write = Dataset.WriteCSV
+	case "json":
+		write = Dataset.WriteJSON
+	default:
+		return Dataset{}, Stats{}, fmt.Errorf("format %q not csv or json", config.Format)
+	}
+	var dataset func(int) Dataset
+	switch config.Dataset {
+	case "wholeorbit":
+		dataset = r.WholeOrbit
+	case "highres":
+		dataset = r.HighRes
+	default:
+		return Dataset{}, Stats{}, fmt.Errorf("dataset %q not wholeorbit or highres", config.Dataset)
+	}
+
+	frames, err := Frames(config)
+	if err != nil {
+		return Dataset{}, Stats{}, err
+	}
+	r.Add(frames...)
+	satellite := config.Satellite
+	if satellite < 0 {
+		satellites := r.Satellites()
+		if len(satellites) > 1 {
+			return Dataset{}, r.Stats(), fmt.Errorf("frames of satellites %v, choose one with satellite", satellites)
+		}
+		if len(satellites) == 1 {
+			satellite = satellites[0]
+		}
+	}
+	d := dataset(satellite)
+	return d, r.Stats(), write(d, w)
+}
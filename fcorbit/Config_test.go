@@ -0,0 +1,88 @@
+package fcorbit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	layout := DefaultLayout()
+
+	// a pass stored, with a frame of another satellite, and the next cycle in a file
+	config := DefaultConfig()
+	config.Store = filepath.Join(dir, "frames.log")
+	store, err := fcstore.Open(fcstore.Config{Path: config.Store, Station: "G0ABC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := make([]byte, 256)
+	other[0] = 1 << 6
+	for _, f := range append(cycleFrames(layout, orbitEpoch, ""), Frame{Received: orbitEpoch, Data: other}) {
+		_, err := store.Add(fcstore.Record{Received: f.Received, Frame: f.Data})
+		assert.NoError(t, err)
+	}
+	defer store.Close()
+	var file []byte
+	for _, f := range cycleFrames(layout, orbitEpoch.Add(2*time.Minute), "") {
+		file = append(file, f.Data...)
+	}
+	config.Files = []string{filepath.Join(dir, "pass.funcubebin")}
+	assert.NoError(t, ioutil.WriteFile(config.Files[0], file, 0644))
+	config.Station = "M0XYZ"
+	config.Satellite = 2
+
+	_, _, err = Export(config, ioutil.Discard)
+	assert.Error(t, err, "no filestart")
+	config.FileStart = orbitEpoch.Add(2 * time.Minute).Format(time.RFC3339)
+
+	// the store holds frames of satellites 1 and 2
+	several := config
+	several.Satellite = -1
+	_, _, err = Export(several, ioutil.Discard)
+	assert.EqualError(t, err, "frames of satellites [1 2], choose one with satellite")
+
+	var out bytes.Buffer
+	d, stats, err := Export(config, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{Frames: 48, Cycles: 2}, stats)
+	assert.Equal(t, 106, d.Records)
+	assert.Equal(t, []string{"G0ABC"}, d.Points[0].Stations)
+	assert.Equal(t, []string{"G0ABC", "M0XYZ"}, d.Points[50].Stations)
+	assert.Equal(t, []string{"M0XYZ"}, d.Points[105].Stations)
+	assert.Equal(t, 107, strings.Count(out.String(), "\n"))
+
+	// the stored frames from a time, as JSON
+	config.Files = nil
+	config.From = orbitEpoch.Add(time.Minute).Format(time.RFC3339)
+	config.Dataset = "highres"
+	config.Format = "json"
+	out.Reset()
+	d, stats, err = Export(config, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, stats.Frames)
+	var got Dataset
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.Equal(t, d, got)
+	assert.Equal(t, 60, got.Records)
+
+	for _, broken := range []func(*Config){
+		func(c *Config) { c.Format = "xml" },
+		func(c *Config) { c.Dataset = "fitter" },
+		func(c *Config) { c.From = "yesterday" },
+		func(c *Config) { c.Layout.PayloadSize = 0 },
+	} {
+		c := config
+		broken(&c)
+		_, _, err := Export(c, ioutil.Discard)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,53 @@
+package fcorbit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteJSON write the dataset as JSON
+func (d Dataset) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+// WriteCSV write the dataset as CSV, a row for each point with its fields' values or its record,
+// a gap's row has the records missing from its time and nothing else
+func (d Dataset) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"time", "gap", "stations"}
+	if len(d.Fields) > 0 {
+		header = append(header, d.Fields...)
+	} else {
+		header = append(header, "record")
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, p := range d.Points {
+		row := make([]string, len(header))
+		row[0] = p.Time.Format(time.RFC3339)
+		switch {
+		case p.Gap > 0:
+			row[1] = strconv.Itoa(p.Gap)
+		case len(d.Fields) > 0:
+			row[2] = strings.Join(p.Stations, ";")
+			for i, v := range p.Values {
+				row[3+i] = strconv.FormatUint(v, 10)
+			}
+		default:
+			row[2] = strings.Join(p.Stations, ";")
+			row[3] = p.Record
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
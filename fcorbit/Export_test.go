@@ -0,0 +1,49 @@
+package fcorbit
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDataset(fields ...string) Dataset {
+	d := Dataset{Name: "wholeorbit", Interval: 60, Fields: fields, Records: 2, Missing: 3, Points: []Point{
+		{Time: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC), Record: "0102", Stations: []string{"G0ABC", "M0XYZ"}},
+		{Time: time.Date(2020, 6, 1, 12, 1, 0, 0, time.UTC), Gap: 3},
+		{Time: time.Date(2020, 6, 1, 12, 4, 0, 0, time.UTC), Record: "0304"},
+	}}
+	if len(fields) > 0 {
+		d.Points[0].Values = []uint64{1, 2}
+		d.Points[2].Values = []uint64{3, 4}
+	}
+	return d
+}
+
+func TestDataset_WriteCSV(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, testDataset().WriteCSV(&out))
+	assert.Equal(t, "time,gap,stations,record\n"+
+		"2020-06-01T12:00:00Z,,G0ABC;M0XYZ,0102\n"+
+		"2020-06-01T12:01:00Z,3,,\n"+
+		"2020-06-01T12:04:00Z,,,0304\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, testDataset("a", "b").WriteCSV(&out))
+	assert.Equal(t, "time,gap,stations,a,b\n"+
+		"2020-06-01T12:00:00Z,,G0ABC;M0XYZ,1,2\n"+
+		"2020-06-01T12:01:00Z,3,,,\n"+
+		"2020-06-01T12:04:00Z,,,3,4\n", out.String())
+}
+
+func TestDataset_WriteJSON(t *testing.T) {
+	var out bytes.Buffer
+	d := testDataset("a", "b")
+	assert.NoError(t, d.WriteJSON(&out))
+	var got Dataset
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.Equal(t, d, got)
+	assert.Contains(t, out.String(), `"gap": 3`)
+}
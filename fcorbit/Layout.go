@@ -0,0 +1,115 @@
+// Package fcorbit reassembles the whole orbit data and high resolution samples a FUNcube
+// satellite spreads across consecutive frames into time series, merging what was received on
+// every pass by every station and marking the gaps
+package fcorbit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Series where a time series is carried in the frames: the payloads of its frame types, in order,
+// hold Records records of RecordSize bytes, the newest last, one each Interval
+type Series struct {
+	FrameTypes []int   `koanf:"frametypes"`
+	RecordSize int     `koanf:"recordsize"`
+	Records    int     `koanf:"records"`
+	Interval   float64 `koanf:"interval"` // seconds between records
+	// Fields the values packed in each record, "name:bits" most significant bit first, the
+	// record's bytes are exported as they are if there are none
+	Fields []string `koanf:"fields"`
+}
+
+// Layout how a satellite's frames carry its time series, the koanf tags match the layout table
+// of the fcorbit configuration
+type Layout struct {
+	CycleFrames   int     `koanf:"cycleframes"`   // frame types sent in turn, a cycle
+	FrameInterval float64 `koanf:"frameinterval"` // seconds between frames
+	PayloadOffset int     `koanf:"payloadoffset"` // the payload follows the header and real time telemetry
+	PayloadSize   int     `koanf:"payloadsize"`
+	WholeOrbit    Series  `koanf:"wholeorbit"`
+	HighRes       Series  `koanf:"highres"`
+}
+
+// DefaultLayout the layout of FUNcube-1: 24 frame types a 2 minute cycle, each frame's last 200
+// bytes are payload, frame types 0 to 11 carry the last 104 minutes of whole orbit data and 21
+// to 23 the last minute sampled every second
+func DefaultLayout() Layout {
+	return Layout{
+		CycleFrames:   24,
+		FrameInterval: 5,
+		PayloadOffset: 56,
+		PayloadSize:   200,
+		WholeOrbit: Series{
+			FrameTypes: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+			RecordSize: 23,
+			Records:    104,
+			Interval:   60,
+		},
+		HighRes: Series{
+			FrameTypes: []int{21, 22, 23},
+			RecordSize: 10,
+			Records:    60,
+			Interval:   1,
+		},
+	}
+}
+
+// field a value packed in a record
+type field struct {
+	name string
+	bits int
+}
+
+// check the series fits its frames, returning its fields
+func (s Series) check(layout Layout) ([]field, error) {
+	if len(s.FrameTypes) == 0 || s.RecordSize <= 0 || s.Records <= 0 || s.Interval <= 0 {
+		return nil, errors.New("needs frame types, a record size, records and an interval")
+	}
+	for _, frameType := range s.FrameTypes {
+		if frameType < 0 || frameType >= layout.CycleFrames {
+			return nil, fmt.Errorf("frame type %d not in the cycle of %d", frameType, layout.CycleFrames)
+		}
+	}
+	if s.RecordSize*s.Records > len(s.FrameTypes)*layout.PayloadSize {
+		return nil, fmt.Errorf("%d records of %d bytes do not fit %d payloads", s.Records, s.RecordSize, len(s.FrameTypes))
+	}
+	var fields []field
+	bits := 0
+	for _, f := range s.Fields {
+		parts := strings.Split(f, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("field %q not name:bits", f)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 || n > 64 {
+			return nil, fmt.Errorf("field %q not 1 to 64 bits", f)
+		}
+		fields = append(fields, field{name: parts[0], bits: n})
+		bits += n
+	}
+	if bits > s.RecordSize*8 {
+		return nil, fmt.Errorf("fields of %d bits do not fit a record of %d bytes", bits, s.RecordSize)
+	}
+	return fields, nil
+}
+
+func (s Series) interval() time.Duration {
+	return time.Duration(s.Interval * float64(time.Second))
+}
+
+// unpack the fields' values from a record, most significant bit first
+func unpack(fields []field, record []byte) []uint64 {
+	values := make([]uint64, len(fields))
+	bit := 0
+	for i, f := range fields {
+		for n := 0; n < f.bits; n++ {
+			values[i] = values[i]<<1 | uint64(record[bit/8]>>(7-uint(bit%8))&1)
+			bit++
+		}
+	}
+	return values
+}
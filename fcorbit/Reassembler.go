@@ -0,0 +1,309 @@
+package fcorbit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/funcube-dev/go/fcstore"
+)
+
+// Frame a frame as received, when and by which station
+type Frame struct {
+	Received time.Time
+	Station  string
+	Data     []byte
+}
+
+// Point a record of a time series, or where records are missing
+type Point struct {
+	Time     time.Time `json:"time"`
+	Gap      int       `json:"gap,omitempty"`      // records missing from here, no record
+	Values   []uint64  `json:"values,omitempty"`   // of the fields
+	Record   string    `json:"record,omitempty"`   // hex
+	Stations []string  `json:"stations,omitempty"` // that received it
+}
+
+// Dataset a time series rebuilt, the oldest point first
+type Dataset struct {
+	Name      string   `json:"name"`
+	Satellite int      `json:"satellite"` // from the frame header
+	Interval  float64  `json:"interval"`  // seconds
+	Fields    []string `json:"fields,omitempty"`
+	Records   int      `json:"records"`
+	Missing   int      `json:"missing"`   // records in the gaps
+	Conflicts int      `json:"conflicts"` // records received differently, the first kept
+	Points    []Point  `json:"points"`
+}
+
+// Stats the frames reassembled
+type Stats struct {
+	Frames     int `json:"frames"`
+	Cycles     int `json:"cycles"`
+	Duplicates int `json:"duplicates"` // a frame type received again in a cycle
+	Ignored    int `json:"ignored"`    // too short or not a frame type of the cycle
+}
+
+// record a record of a series, by its slot, the intervals since the epoch
+type record struct {
+	data     []byte
+	stations map[string]bool
+}
+
+type series struct {
+	name      string
+	config    Series
+	fields    []field
+	interval  time.Duration
+	records   map[int64]*record
+	conflicts int
+}
+
+// cycle the frames received of a cycle of a satellite's frame types
+type cycle struct {
+	satellite int
+	start     time.Time // frame type 0 was sent, estimated
+	frames    map[int][]byte
+	stations  map[int]map[string]bool // that received each frame type
+}
+
+// satellite the series rebuilt of a satellite
+type satellite struct {
+	wholeOrbit *series
+	highRes    *series
+}
+
+// Reassembler rebuilds the time series of each satellite from frames, merging every pass and
+// station's frames added
+type Reassembler struct {
+	layout     Layout
+	fields     [2][]field // of the whole orbit and high resolution series
+	satellites map[int]*satellite
+	stats      Stats
+}
+
+// NewReassembler create a reassembler for frames of the layout
+func NewReassembler(layout Layout) (*Reassembler, error) {
+	if layout.CycleFrames <= 0 || layout.FrameInterval <= 0 || layout.PayloadSize <= 0 || layout.PayloadOffset < 0 {
+		return nil, errors.New("layout needs a cycle, a frame interval and a payload")
+	}
+	r := &Reassembler{layout: layout, satellites: make(map[int]*satellite)}
+	var err error
+	if r.fields[0], err = layout.WholeOrbit.check(layout); err != nil {
+		return nil, fmt.Errorf("wholeorbit %v", err)
+	}
+	if r.fields[1], err = layout.HighRes.check(layout); err != nil {
+		return nil, fmt.Errorf("highres %v", err)
+	}
+	return r, nil
+}
+
+func newSeries(name string, config Series, fields []field) *series {
+	return &series{name: name, config: config, fields: fields, interval: config.interval(), records: make(map[int64]*record)}
+}
+
+// satellite the series of the satellite, empty if none of its frames have been added
+func (r *Reassembler) satellite(id int) *satellite {
+	if sat, ok := r.satellites[id]; ok {
+		return sat
+	}
+	return &satellite{
+		wholeOrbit: newSeries("wholeorbit", r.layout.WholeOrbit, r.fields[0]),
+		highRes:    newSeries("highres", r.layout.HighRes, r.fields[1]),
+	}
+}
+
+// Add the frames of a pass, or several, of any satellites in any order
+func (r *Reassembler) Add(frames ...Frame) {
+	frameInterval := time.Duration(r.layout.FrameInterval * float64(time.Second))
+	type received struct {
+		Frame
+		satellite int
+		frameType int
+		start     time.Time
+	}
+	var sorted []received
+	for _, f := range frames {
+		satellite, frameType := fcstore.Header(f.Data)
+		if len(f.Data) < r.layout.PayloadOffset+r.layout.PayloadSize || frameType >= r.layout.CycleFrames {
+			r.stats.Ignored++
+			continue
+		}
+		r.stats.Frames++
+		sorted = append(sorted, received{Frame: f, satellite: satellite, frameType: frameType, start: f.Received.Add(-time.Duration(frameType) * frameInterval)})
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].start.Before(sorted[j].start) })
+
+	// a satellite's frames whose cycle started within half a cycle of each other are of the same
+	// cycle
+	half := time.Duration(r.layout.CycleFrames) * frameInterval / 2
+	var cycles []*cycle
+	currents := make(map[int]*cycle)
+	for _, f := range sorted {
+		current := currents[f.satellite]
+		if current == nil || f.start.Sub(current.start) > half {
+			current = &cycle{satellite: f.satellite, start: f.start, frames: make(map[int][]byte), stations: make(map[int]map[string]bool)}
+			currents[f.satellite] = current
+			cycles = append(cycles, current)
+		}
+		if current.stations[f.frameType] == nil {
+			current.stations[f.frameType] = make(map[string]bool)
+		}
+		current.stations[f.frameType][f.Station] = true
+		if _, ok := current.frames[f.frameType]; ok {
+			r.stats.Duplicates++
+			continue
+		}
+		current.frames[f.frameType] = f.Data
+	}
+
+	r.stats.Cycles += len(cycles)
+	for _, c := range cycles {
+		sat := r.satellite(c.satellite)
+		r.satellites[c.satellite] = sat
+		sat.wholeOrbit.add(r.layout, c)
+		sat.highRes.add(r.layout, c)
+	}
+}
+
+// add the series' records carried in the cycle
+func (s *series) add(layout Layout, c *cycle) {
+	payloads := make([]byte, len(s.config.FrameTypes)*layout.PayloadSize)
+	known := make([]bool, len(s.config.FrameTypes))
+	for i, frameType := range s.config.FrameTypes {
+		if frame, ok := c.frames[frameType]; ok {
+			copy(payloads[i*layout.PayloadSize:], frame[layout.PayloadOffset:layout.PayloadOffset+layout.PayloadSize])
+			known[i] = true
+		}
+	}
+
+	// the newest record was the last taken before the series' first frame was sent
+	sent := c.start.Add(time.Duration(float64(s.config.FrameTypes[0]) * layout.FrameInterval * float64(time.Second)))
+	newest := s.slot(sent) - 1
+	received := make(map[int64][]byte)
+	receivedBy := make(map[int64][]string)
+	for k := 0; k < s.config.Records; k++ {
+		from, to := k*s.config.RecordSize, (k+1)*s.config.RecordSize
+		complete := true
+		for i := from / layout.PayloadSize; i <= (to-1)/layout.PayloadSize; i++ {
+			complete = complete && known[i]
+		}
+		if !complete {
+			continue
+		}
+		slot := newest - int64(s.config.Records-1-k)
+		received[slot] = payloads[from:to]
+		// the stations that received every frame the record is in
+		for station := range c.stations[s.config.FrameTypes[from/layout.PayloadSize]] {
+			every := true
+			for i := from / layout.PayloadSize; i <= (to-1)/layout.PayloadSize; i++ {
+				every = every && c.stations[s.config.FrameTypes[i]][station]
+			}
+			if every {
+				receivedBy[slot] = append(receivedBy[slot], station)
+			}
+		}
+	}
+
+	shift := s.align(received)
+	for slot, data := range received {
+		held, ok := s.records[slot+shift]
+		if !ok {
+			held = &record{data: data, stations: make(map[string]bool)}
+			s.records[slot+shift] = held
+		} else if !bytes.Equal(held.data, data) {
+			s.conflicts++
+			continue
+		}
+		for _, station := range receivedBy[slot] {
+			held.stations[station] = true
+		}
+	}
+}
+
+// align the shift, in slots, that matches the most records received to those held, the times are
+// estimated so the same record can be up to 2 slots either side when received again
+func (s *series) align(received map[int64][]byte) int64 {
+	best, bestMatches := int64(0), 0
+	for _, shift := range []int64{0, -1, 1, -2, 2} {
+		matches := 0
+		for slot, data := range received {
+			if held, ok := s.records[slot+shift]; ok && bytes.Equal(held.data, data) {
+				matches++
+			}
+		}
+		if matches > bestMatches {
+			best, bestMatches = shift, matches
+		}
+	}
+	return best
+}
+
+func (s *series) slot(t time.Time) int64 {
+	return (t.UnixNano() + int64(s.interval)/2) / int64(s.interval)
+}
+
+func (s *series) dataset(satellite int) Dataset {
+	d := Dataset{Name: s.name, Satellite: satellite, Interval: s.config.Interval, Conflicts: s.conflicts, Points: []Point{}}
+	for _, f := range s.fields {
+		d.Fields = append(d.Fields, f.name)
+	}
+	slots := make([]int64, 0, len(s.records))
+	for slot := range s.records {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	for i, slot := range slots {
+		if i > 0 && slot-slots[i-1] > 1 {
+			missing := int(slot - slots[i-1] - 1)
+			d.Points = append(d.Points, Point{Time: s.time(slots[i-1] + 1), Gap: missing})
+			d.Missing += missing
+		}
+		held := s.records[slot]
+		point := Point{Time: s.time(slot), Record: hex.EncodeToString(held.data)}
+		if len(s.fields) > 0 {
+			point.Values = unpack(s.fields, held.data)
+		}
+		for station := range held.stations {
+			if station != "" {
+				point.Stations = append(point.Stations, station)
+			}
+		}
+		sort.Strings(point.Stations)
+		d.Points = append(d.Points, point)
+		d.Records++
+	}
+	return d
+}
+
+func (s *series) time(slot int64) time.Time {
+	return time.Unix(0, slot*int64(s.interval)).UTC()
+}
+
+// Satellites the satellites frames have been added of, in order
+func (r *Reassembler) Satellites() []int {
+	satellites := make([]int, 0, len(r.satellites))
+	for id := range r.satellites {
+		satellites = append(satellites, id)
+	}
+	sort.Ints(satellites)
+	return satellites
+}
+
+// WholeOrbit the satellite's whole orbit data rebuilt from the frames added
+func (r *Reassembler) WholeOrbit(satellite int) Dataset {
+	return r.satellite(satellite).wholeOrbit.dataset(satellite)
+}
+
+// HighRes the satellite's high resolution samples rebuilt from the frames added
+func (r *Reassembler) HighRes(satellite int) Dataset {
+	return r.satellite(satellite).highRes.dataset(satellite)
+}
+
+// Stats the frames added
+func (r *Reassembler) Stats() Stats {
+	return r.stats
+}
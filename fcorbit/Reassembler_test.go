@@ -0,0 +1,236 @@
+package fcorbit
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var orbitEpoch = time.Date(2020, 6, 1, 12, 0, 10, 0, time.UTC)
+
+// sample what the satellite recorded for the series at t, distinct for every time
+func sample(size int, kind byte, t time.Time) []byte {
+	record := make([]byte, size)
+	record[0] = kind
+	binary.BigEndian.PutUint64(record[1:], uint64(t.Unix()))
+	return record
+}
+
+// cycleFrames the frames of the cycle the satellite started sending at start, without the frame
+// types skipped
+func cycleFrames(layout Layout, start time.Time, station string, skip ...int) []Frame {
+	payloads := func(s Series, kind byte, sent time.Time) []byte {
+		interval := s.interval()
+		newest := sent.Truncate(interval)
+		if newest.Equal(sent) {
+			newest = newest.Add(-interval)
+		}
+		data := make([]byte, len(s.FrameTypes)*layout.PayloadSize)
+		for k := 0; k < s.Records; k++ {
+			copy(data[k*s.RecordSize:], sample(s.RecordSize, kind, newest.Add(-time.Duration(s.Records-1-k)*interval)))
+		}
+		return data
+	}
+	frameInterval := time.Duration(layout.FrameInterval) * time.Second
+	wholeOrbit := payloads(layout.WholeOrbit, 'W', start)
+	highRes := payloads(layout.HighRes, 'H', start.Add(time.Duration(layout.HighRes.FrameTypes[0])*frameInterval))
+	var frames []Frame
+	for frameType := 0; frameType < layout.CycleFrames; frameType++ {
+		skipped := false
+		for _, s := range skip {
+			skipped = skipped || s == frameType
+		}
+		if skipped {
+			continue
+		}
+		data := make([]byte, 256)
+		data[0] = 2<<6 | byte(frameType)
+		for i, t := range layout.WholeOrbit.FrameTypes {
+			if t == frameType {
+				copy(data[layout.PayloadOffset:], wholeOrbit[i*layout.PayloadSize:(i+1)*layout.PayloadSize])
+			}
+		}
+		for i, t := range layout.HighRes.FrameTypes {
+			if t == frameType {
+				copy(data[layout.PayloadOffset:], highRes[i*layout.PayloadSize:(i+1)*layout.PayloadSize])
+			}
+		}
+		frames = append(frames, Frame{Received: start.Add(time.Duration(frameType) * frameInterval), Station: station, Data: data})
+	}
+	return frames
+}
+
+func reassembler(t *testing.T, layout Layout) *Reassembler {
+	r, err := NewReassembler(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestReassembler_Cycle(t *testing.T) {
+	layout := DefaultLayout()
+	r := reassembler(t, layout)
+	frames := cycleFrames(layout, orbitEpoch, "G0ABC")
+	// in any order, a frame received twice and one too short
+	frames[0], frames[5] = frames[5], frames[0]
+	frames = append(frames, frames[3], Frame{Received: orbitEpoch, Data: []byte{1}})
+	r.Add(frames...)
+	assert.Equal(t, Stats{Frames: 25, Cycles: 1, Duplicates: 1, Ignored: 1}, r.Stats())
+
+	wholeOrbit := r.WholeOrbit(2)
+	assert.Equal(t, 104, wholeOrbit.Records)
+	assert.Equal(t, 0, wholeOrbit.Missing)
+	assert.Len(t, wholeOrbit.Points, 104)
+	// the times are estimated to the interval from when the frames were received
+	newest := wholeOrbit.Points[103]
+	assert.Equal(t, time.Date(2020, 6, 1, 11, 59, 0, 0, time.UTC), newest.Time)
+	assert.Equal(t, hex.EncodeToString(sample(23, 'W', time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))), newest.Record)
+	assert.Equal(t, []string{"G0ABC"}, newest.Stations)
+
+	highRes := r.HighRes(2)
+	assert.Equal(t, 60, highRes.Records)
+	sent := orbitEpoch.Add(21 * 5 * time.Second)
+	assert.Equal(t, sent.Add(-60*time.Second), highRes.Points[0].Time)
+	assert.Equal(t, hex.EncodeToString(sample(10, 'H', sent.Add(-60*time.Second))), highRes.Points[0].Record)
+}
+
+func TestReassembler_Gaps(t *testing.T) {
+	layout := DefaultLayout()
+	r := reassembler(t, layout)
+	r.Add(cycleFrames(layout, orbitEpoch, "G0ABC", 1, 22)...)
+
+	// the records partly or wholly in the frames missing
+	wholeOrbit := r.WholeOrbit(2)
+	assert.Equal(t, 94, wholeOrbit.Records)
+	assert.Equal(t, 10, wholeOrbit.Missing)
+	gap := wholeOrbit.Points[8]
+	assert.Equal(t, 10, gap.Gap)
+	assert.Equal(t, wholeOrbit.Points[7].Time.Add(time.Minute), gap.Time)
+	assert.Empty(t, gap.Record)
+	assert.Equal(t, gap.Time.Add(10*time.Minute), wholeOrbit.Points[9].Time)
+
+	highRes := r.HighRes(2)
+	assert.Equal(t, 40, highRes.Records)
+	assert.Equal(t, 20, highRes.Missing)
+
+	// filled in by the next cycle
+	r.Add(cycleFrames(layout, orbitEpoch.Add(2*time.Minute), "G0ABC")...)
+	wholeOrbit = r.WholeOrbit(2)
+	assert.Equal(t, 106, wholeOrbit.Records)
+	assert.Equal(t, 0, wholeOrbit.Missing)
+	assert.Equal(t, 0, wholeOrbit.Conflicts)
+}
+
+func TestReassembler_Passes(t *testing.T) {
+	layout := DefaultLayout()
+	r := reassembler(t, layout)
+	var pass []Frame
+	for k := 0; k < 3; k++ {
+		pass = append(pass, cycleFrames(layout, orbitEpoch.Add(time.Duration(k)*2*time.Minute), "G0ABC")...)
+	}
+	// a second station heard the first cycle too
+	pass = append(pass, cycleFrames(layout, orbitEpoch, "M0XYZ", 0, 1, 2)...)
+	r.Add(pass...)
+
+	// an orbit later, received times estimate the records a minute later than the first pass
+	next := orbitEpoch.Add(100*time.Minute + 30*time.Second)
+	r.Add(append(cycleFrames(layout, next, "M0XYZ"), cycleFrames(layout, next.Add(2*time.Minute), "M0XYZ")...)...)
+	assert.Equal(t, Stats{Frames: 5*24 + 21, Cycles: 5, Duplicates: 21}, r.Stats())
+
+	// the passes overlap, so are aligned and merged into one series
+	wholeOrbit := r.WholeOrbit(2)
+	assert.Equal(t, 0, wholeOrbit.Conflicts)
+	assert.Equal(t, 0, wholeOrbit.Missing)
+	assert.Equal(t, 206, wholeOrbit.Records)
+	for _, p := range wholeOrbit.Points {
+		assert.Equal(t, hex.EncodeToString(sample(23, 'W', p.Time.Add(time.Minute))), p.Record)
+	}
+	assert.Equal(t, []string{"G0ABC"}, wholeOrbit.Points[0].Stations)
+	assert.Equal(t, []string{"G0ABC", "M0XYZ"}, wholeOrbit.Points[50].Stations)
+	assert.Equal(t, []string{"G0ABC", "M0XYZ"}, wholeOrbit.Points[104].Stations)
+	assert.Equal(t, []string{"M0XYZ"}, wholeOrbit.Points[205].Stations)
+
+	// high resolution samples are a minute every 2
+	highRes := r.HighRes(2)
+	assert.Equal(t, 5*60, highRes.Records)
+	assert.Equal(t, 0, highRes.Conflicts)
+	assert.Equal(t, 60, highRes.Points[60].Gap)
+	for _, p := range highRes.Points {
+		if p.Gap == 0 {
+			assert.Equal(t, hex.EncodeToString(sample(10, 'H', p.Time)), p.Record)
+		}
+	}
+}
+
+func TestReassembler_Satellites(t *testing.T) {
+	layout := DefaultLayout()
+	r := reassembler(t, layout)
+	// satellite 1 sending its own records over the same cycles, its frames between satellite 2's
+	var frames []Frame
+	for k := 0; k < 2; k++ {
+		start := orbitEpoch.Add(time.Duration(k) * 2 * time.Minute)
+		other := cycleFrames(layout, start.Add(2500*time.Millisecond), "G0ABC")
+		for i, f := range cycleFrames(layout, start, "G0ABC") {
+			data := append([]byte{}, other[i].Data...)
+			for j := range data {
+				data[j] ^= 0xff
+			}
+			data[0] = 1<<6 | byte(i)
+			frames = append(frames, f, Frame{Received: other[i].Received, Station: other[i].Station, Data: data})
+		}
+	}
+	r.Add(frames...)
+	assert.Equal(t, Stats{Frames: 96, Cycles: 4}, r.Stats())
+	assert.Equal(t, []int{1, 2}, r.Satellites())
+
+	for _, satellite := range []int{1, 2} {
+		wholeOrbit := r.WholeOrbit(satellite)
+		assert.Equal(t, satellite, wholeOrbit.Satellite)
+		assert.Equal(t, 106, wholeOrbit.Records)
+		assert.Equal(t, 0, wholeOrbit.Conflicts)
+		assert.Equal(t, 0, wholeOrbit.Missing)
+		assert.Equal(t, 120, r.HighRes(satellite).Records)
+		assert.Equal(t, 0, r.HighRes(satellite).Conflicts)
+	}
+	assert.Equal(t, hex.EncodeToString(sample(23, 'W', orbitEpoch.Truncate(time.Minute))), r.WholeOrbit(2).Points[103].Record)
+	assert.NotEqual(t, r.WholeOrbit(2).Points[103].Record, r.WholeOrbit(1).Points[103].Record)
+	assert.Empty(t, r.WholeOrbit(3).Points)
+}
+
+func TestReassembler_Fields(t *testing.T) {
+	layout := DefaultLayout()
+	layout.HighRes.Fields = []string{"kind:8", "high:32", "seconds:32"}
+	r := reassembler(t, layout)
+	r.Add(cycleFrames(layout, orbitEpoch, "")...)
+
+	highRes := r.HighRes(2)
+	assert.Equal(t, []string{"kind", "high", "seconds"}, highRes.Fields)
+	p := highRes.Points[0]
+	assert.Equal(t, []uint64{'H', 0, uint64(p.Time.Unix())}, p.Values)
+	assert.Empty(t, p.Stations)
+	assert.Empty(t, r.WholeOrbit(2).Points[0].Values)
+}
+
+func TestNewReassembler_Layout(t *testing.T) {
+	broken := []func(*Layout){
+		func(l *Layout) { l.CycleFrames = 0 },
+		func(l *Layout) { l.WholeOrbit.FrameTypes = nil },
+		func(l *Layout) { l.WholeOrbit.FrameTypes = []int{24} },
+		func(l *Layout) { l.HighRes.Records = 61 },
+		func(l *Layout) { l.HighRes.Fields = []string{"bad"} },
+		func(l *Layout) { l.HighRes.Fields = []string{"a:0"} },
+		func(l *Layout) { l.HighRes.Fields = []string{"a:64", "b:17"} },
+	}
+	for i, b := range broken {
+		layout := DefaultLayout()
+		b(&layout)
+		_, err := NewReassembler(layout)
+		assert.Error(t, err, i)
+	}
+	_, err := NewReassembler(DefaultLayout())
+	assert.NoError(t, err)
+}
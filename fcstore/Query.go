@@ -0,0 +1,103 @@
+package fcstore
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// maxLimit the most frames a query served returns
+const maxLimit = 1000
+
+// Query which frames to return, the zero value matches every frame
+type Query struct {
+	From      time.Time // received at or after, unbounded if zero
+	To        time.Time // received before, unbounded if zero
+	Station   string
+	Satellite *int
+	FrameType *int
+	// Destination and Status match frames whose upload to the destination has the status, any
+	// destination if empty, Status "none" matches frames never queued for the destination
+	Destination string
+	Status      UploadStatus
+	Limit       int // 0 unlimited
+}
+
+func (q Query) matches(r *Record) bool {
+	switch {
+	case !q.From.IsZero() && r.Received.Before(q.From):
+		return false
+	case !q.To.IsZero() && !r.Received.Before(q.To):
+		return false
+	case q.Station != "" && r.Station != q.Station:
+		return false
+	case q.Satellite != nil && r.Satellite != *q.Satellite:
+		return false
+	case q.FrameType != nil && r.FrameType != *q.FrameType:
+		return false
+	}
+	if q.Status == "" {
+		return q.Destination == "" || r.Uploads[q.Destination] != ""
+	}
+	if q.Destination != "" {
+		status, ok := r.Uploads[q.Destination]
+		return status == q.Status || !ok && q.Status == "none"
+	}
+	for _, status := range r.Uploads {
+		if status == q.Status {
+			return true
+		}
+	}
+	return q.Status == "none" && len(r.Uploads) == 0
+}
+
+// ParseQuery the query of the api's url parameters: from and to (RFC3339), station, satellite,
+// frametype, destination, status and limit (100 by default, at most 1000)
+func ParseQuery(values url.Values) (Query, error) {
+	q := Query{
+		Station:     values.Get("station"),
+		Destination: values.Get("destination"),
+		Status:      UploadStatus(values.Get("status")),
+		Limit:       100,
+	}
+	var err error
+	parseTime := func(name string, t *time.Time) {
+		if v := values.Get(name); v != "" && err == nil {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				err = fmt.Errorf("invalid %s %q, not RFC3339", name, v)
+			}
+		}
+	}
+	parseInt := func(name string) *int {
+		v := values.Get(name)
+		if v == "" || err != nil {
+			return nil
+		}
+		n, parseErr := strconv.Atoi(v)
+		if parseErr != nil {
+			err = fmt.Errorf("invalid %s %q", name, v)
+			return nil
+		}
+		return &n
+	}
+	parseTime("from", &q.From)
+	parseTime("to", &q.To)
+	q.Satellite = parseInt("satellite")
+	q.FrameType = parseInt("frametype")
+	if limit := parseInt("limit"); limit != nil {
+		q.Limit = *limit
+	}
+	if err != nil {
+		return Query{}, err
+	}
+	switch q.Status {
+	case "", Pending, Uploaded, Failed, "none":
+	default:
+		return Query{}, fmt.Errorf("invalid status %q", q.Status)
+	}
+	if q.Limit <= 0 || q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+	return q, nil
+}
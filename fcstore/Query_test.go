@@ -0,0 +1,43 @@
+package fcstore
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, Query{Limit: 100}, q)
+
+	q, err = ParseQuery(url.Values{
+		"from":        {"2020-06-01T12:00:00Z"},
+		"to":          {"2020-06-02T12:00:00Z"},
+		"station":     {"G0ABC"},
+		"satellite":   {"2"},
+		"frametype":   {"0"},
+		"destination": {"warehouse"},
+		"status":      {"failed"},
+		"limit":       {"5000"},
+	})
+	assert.NoError(t, err)
+	two, zero := 2, 0
+	assert.Equal(t, Query{
+		From:        time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC),
+		To:          time.Date(2020, 6, 2, 12, 0, 0, 0, time.UTC),
+		Station:     "G0ABC",
+		Satellite:   &two,
+		FrameType:   &zero,
+		Destination: "warehouse",
+		Status:      Failed,
+		Limit:       1000,
+	}, q)
+
+	for _, bad := range []url.Values{{"from": {"yesterday"}}, {"satellite": {"x"}}, {"status": {"lost"}}} {
+		_, err = ParseQuery(bad)
+		assert.Error(t, err)
+	}
+}
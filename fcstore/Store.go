@@ -0,0 +1,450 @@
+// Package fcstore an embedded store of the frames a service handles, what is known of each and
+// where it has been uploaded, kept in a file as a log replayed at startup and compacted as frames
+// pass out of retention
+package fcstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config settings for a store, the koanf tags match the store table of the service configuration
+// files
+type Config struct {
+	Path      string  `koanf:"path"`      // the store's file, no store if empty
+	Station   string  `koanf:"station"`   // recorded with the frames stored
+	MaxAge    float64 `koanf:"maxage"`    // days frames are kept, 0 forever
+	MaxFrames int     `koanf:"maxframes"` // frames kept, the oldest go a tenth at a time, 0 unlimited
+}
+
+// UploadStatus how the upload of a frame to a destination went
+type UploadStatus string
+
+// the statuses of an upload, queued, taken by the destination or given up on
+const (
+	Pending  UploadStatus = "pending"
+	Uploaded UploadStatus = "uploaded"
+	Failed   UploadStatus = "failed"
+)
+
+// Record a frame stored
+type Record struct {
+	ID        uint64                  `json:"id"`
+	Received  time.Time               `json:"received"`
+	Station   string                  `json:"station,omitempty"`
+	Satellite int                     `json:"satellite"` // from the frame header
+	FrameType int                     `json:"frameType"` // from the frame header
+	Frequency float64                 `json:"frequency,omitempty"`
+	Errors    int                     `json:"errors"` // corrected decoding it, -1 if not known
+	Uploads   map[string]UploadStatus `json:"uploads,omitempty"`
+	Frame     []byte                  `json:"frame"`
+}
+
+// Header the satellite id and frame type of a FUNcube frame, the top 2 bits and the rest of its
+// first byte
+func Header(frame []byte) (satellite, frameType int) {
+	if len(frame) == 0 {
+		return 0, 0
+	}
+	return int(frame[0] >> 6), int(frame[0] & 0x3f)
+}
+
+// entry a line of the store's file, a record added or an upload status changed
+type entry struct {
+	Record *Record       `json:"record,omitempty"`
+	Upload *uploadChange `json:"upload,omitempty"`
+}
+
+type uploadChange struct {
+	ID          uint64       `json:"id"`
+	Destination string       `json:"destination"`
+	Status      UploadStatus `json:"status"`
+}
+
+// Stats the frames in a store
+type Stats struct {
+	Frames int       `json:"frames"`
+	Oldest time.Time `json:"oldest,omitempty"`
+	Newest time.Time `json:"newest,omitempty"`
+	Pruned uint64    `json:"pruned"` // passed out of retention since opened
+}
+
+// Store the frames, in memory and logged to the file
+type Store struct {
+	mu      sync.Mutex
+	config  Config
+	file    *os.File
+	records []*Record // oldest first, the ids ascending
+	byFrame map[string]*Record
+	nextID  uint64
+	entries int   // lines in the file
+	length  int64 // of the file up to the end of its last whole line when loaded
+	pruned  uint64
+	now     func() time.Time
+}
+
+// Open the store at the configured path, created if missing
+func Open(config Config) (*Store, error) {
+	if config.Path == "" {
+		return nil, errors.New("store needs a path")
+	}
+	s := &Store{config: config, byFrame: make(map[string]*Record), nextID: 1, now: time.Now}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s error:%v", config.Path, err)
+	}
+	s.file = file
+	// cut a partly written last line so what is added next starts a line of its own
+	if info, err := file.Stat(); err == nil && info.Size() > s.length {
+		if err := file.Truncate(s.length); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to truncate store %s error:%v", config.Path, err)
+		}
+	}
+	if _, err := s.Prune(); err != nil {
+		s.file.Close()
+		return nil, err
+	}
+	log.Printf("Opened store %s with %d frames", config.Path, len(s.records))
+	return s, nil
+}
+
+// Read the frames in a store's file matching the query without opening it for writing, so it can
+// be read while a service has it open
+func Read(path string, q Query) ([]Record, error) {
+	s := &Store{config: Config{Path: path}, byFrame: make(map[string]*Record), nextID: 1, now: time.Now}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s.Query(q), nil
+}
+
+// load replays the file, a partly written last line is dropped
+func (s *Store) load() error {
+	f, err := os.Open(s.config.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read store %s error:%v", s.config.Path, err)
+	}
+	defer f.Close()
+	reader := bufio.NewReaderSize(f, 64*1024)
+	index := make(map[uint64]*Record)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Skipped partly written store entry")
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read store %s error:%v", s.config.Path, err)
+		}
+		s.length += int64(len(line))
+
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			log.Printf("Skipped damaged store entry: %v", err)
+			continue
+		}
+		s.entries++
+		switch {
+		case e.Record != nil:
+			s.insert(e.Record)
+			index[e.Record.ID] = e.Record
+		case e.Upload != nil:
+			if r, ok := index[e.Upload.ID]; ok {
+				setUpload(r, e.Upload.Destination, e.Upload.Status)
+			}
+		}
+	}
+}
+
+func (s *Store) insert(r *Record) {
+	s.records = append(s.records, r)
+	s.byFrame[string(r.Frame)] = r
+	if r.ID >= s.nextID {
+		s.nextID = r.ID + 1
+	}
+}
+
+func setUpload(r *Record, destination string, status UploadStatus) {
+	if r.Uploads == nil {
+		r.Uploads = make(map[string]UploadStatus)
+	}
+	r.Uploads[destination] = status
+}
+
+func (s *Store) append(e entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write store %s error:%v", s.config.Path, err)
+	}
+	s.entries++
+	return nil
+}
+
+// Add a frame, its id, header, station (if not set) and received time (if zero) are filled in
+func (s *Store) Add(r Record) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return Record{}, errors.New("store closed")
+	}
+	r.ID = s.nextID
+	r.Satellite, r.FrameType = Header(r.Frame)
+	if r.Station == "" {
+		r.Station = s.config.Station
+	}
+	if r.Received.IsZero() {
+		r.Received = s.now()
+	}
+	r.Received = r.Received.UTC()
+	r.Frame = append([]byte(nil), r.Frame...)
+	uploads := r.Uploads
+	r.Uploads = nil
+	for destination, status := range uploads {
+		setUpload(&r, destination, status)
+	}
+	if err := s.append(entry{Record: &r}); err != nil {
+		return Record{}, err
+	}
+	stored := r
+	s.insert(&stored)
+	if s.config.MaxFrames > 0 && len(s.records) > s.config.MaxFrames {
+		if _, err := s.prune(); err != nil {
+			return Record{}, err
+		}
+	}
+	return copyRecord(&stored), nil
+}
+
+// SetUpload records how the upload of a frame to a destination went
+func (s *Store) SetUpload(id uint64, destination string, status UploadStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.find(id)
+	if r == nil {
+		return fmt.Errorf("no frame %d", id)
+	}
+	if r.Uploads[destination] == status {
+		return nil
+	}
+	if s.file == nil {
+		return errors.New("store closed")
+	}
+	if err := s.append(entry{Upload: &uploadChange{ID: id, Destination: destination, Status: status}}); err != nil {
+		return err
+	}
+	setUpload(r, destination, status)
+	return nil
+}
+
+// SetFrameUpload records how the upload of a frame to a destination went, by the frame's data,
+// the newest record of it is updated, nothing if it is not stored
+func (s *Store) SetFrameUpload(frame []byte, destination string, status UploadStatus) error {
+	r, ok := s.Find(frame)
+	if !ok {
+		return nil
+	}
+	return s.SetUpload(r.ID, destination, status)
+}
+
+func (s *Store) find(id uint64) *Record {
+	i := sort.Search(len(s.records), func(i int) bool { return s.records[i].ID >= id })
+	if i < len(s.records) && s.records[i].ID == id {
+		return s.records[i]
+	}
+	return nil
+}
+
+// Get the frame with the id
+func (s *Store) Get(id uint64) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.find(id)
+	if r == nil {
+		return Record{}, false
+	}
+	return copyRecord(r), true
+}
+
+// Find the newest record of the frame
+func (s *Store) Find(frame []byte) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.byFrame[string(frame)]
+	if !ok {
+		return Record{}, false
+	}
+	return copyRecord(r), true
+}
+
+func copyRecord(r *Record) Record {
+	c := *r
+	if r.Uploads != nil {
+		c.Uploads = make(map[string]UploadStatus, len(r.Uploads))
+		for destination, status := range r.Uploads {
+			c.Uploads[destination] = status
+		}
+	}
+	return c
+}
+
+// Query the frames matching, oldest first
+func (s *Store) Query(q Query) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := []Record{}
+	for _, r := range s.records {
+		if !q.matches(r) {
+			continue
+		}
+		if q.Limit > 0 && len(records) == q.Limit {
+			break
+		}
+		records = append(records, copyRecord(r))
+	}
+	return records
+}
+
+// Stats the frames stored
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := Stats{Frames: len(s.records), Pruned: s.pruned}
+	if len(s.records) > 0 {
+		stats.Oldest = s.records[0].Received
+		stats.Newest = s.records[len(s.records)-1].Received
+	}
+	return stats
+}
+
+// Prune the frames out of retention, compacting the file when it is mostly entries no longer
+// needed, returns the frames pruned
+func (s *Store) Prune() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prune()
+}
+
+func (s *Store) prune() (int, error) {
+	keep := 0
+	if s.config.MaxAge > 0 {
+		oldest := s.now().Add(-time.Duration(s.config.MaxAge * float64(24*time.Hour)))
+		for keep < len(s.records) && s.records[keep].Received.Before(oldest) {
+			keep++
+		}
+	}
+	// over MaxFrames a tenth more go, so adding frames does not prune each time
+	if s.config.MaxFrames > 0 && len(s.records)-keep > s.config.MaxFrames {
+		keep = len(s.records) - (s.config.MaxFrames - s.config.MaxFrames/10)
+	}
+	if keep > 0 {
+		for i, r := range s.records[:keep] {
+			if s.byFrame[string(r.Frame)] == r {
+				delete(s.byFrame, string(r.Frame))
+			}
+			s.records[i] = nil
+		}
+		s.records = s.records[keep:]
+		s.pruned += uint64(keep)
+	}
+
+	// the file is rewritten once it has twice the lines it needs, and a few
+	if s.file != nil && s.entries > 2*len(s.records)+100 {
+		if err := s.compact(); err != nil {
+			return keep, err
+		}
+	}
+	return keep, nil
+}
+
+// compact rewrites the file with only the frames kept, replacing it once written
+func (s *Store) compact() error {
+	temp := s.config.Path + ".tmp"
+	f, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to compact store %s error:%v", s.config.Path, err)
+	}
+	w := bufio.NewWriter(f)
+	for _, r := range s.records {
+		line, err := json.Marshal(entry{Record: r})
+		if err == nil {
+			_, err = w.Write(append(line, '\n'))
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(temp)
+			return fmt.Errorf("failed to compact store %s error:%v", s.config.Path, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(temp)
+		return fmt.Errorf("failed to compact store %s error:%v", s.config.Path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to compact store %s error:%v", s.config.Path, err)
+	}
+	if err := os.Rename(temp, s.config.Path); err != nil {
+		return fmt.Errorf("failed to compact store %s error:%v", s.config.Path, err)
+	}
+	file, err := os.OpenFile(s.config.Path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		s.file.Close()
+		s.file = nil
+		return fmt.Errorf("failed to reopen store %s error:%v", s.config.Path, err)
+	}
+	s.file.Close()
+	s.file = file
+	s.entries = len(s.records)
+	return nil
+}
+
+// Run prunes the frames out of retention every interval until ctx is done
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if pruned, err := s.Prune(); err != nil {
+				log.Printf("Failed to prune store: %v", err)
+			} else if pruned > 0 {
+				log.Printf("Pruned %d frames from the store", pruned)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close the file, the frames stay readable
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
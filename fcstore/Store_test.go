@@ -0,0 +1,152 @@
+package fcstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var storeEpoch = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// testFrame a frame from satellite 2 of the frame type
+func testFrame(frameType, value byte) []byte {
+	frame := bytes.Repeat([]byte{value}, 256)
+	frame[0] = 2<<6 | frameType
+	return frame
+}
+
+func open(t *testing.T, config Config) *Store {
+	s, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	config := Config{Path: filepath.Join(t.TempDir(), "frames.log"), Station: "G0ABC"}
+	s := open(t, config)
+
+	added, err := s.Add(Record{Received: storeEpoch, Frequency: 145935000, Errors: 3, Frame: testFrame(5, 1)})
+	assert.NoError(t, err)
+	assert.Equal(t, Record{ID: 1, Received: storeEpoch, Station: "G0ABC", Satellite: 2, FrameType: 5, Frequency: 145935000, Errors: 3, Frame: testFrame(5, 1)}, added)
+	_, err = s.Add(Record{Received: storeEpoch.Add(5 * time.Second), Station: "M0XYZ", Errors: -1, Frame: testFrame(6, 2), Uploads: map[string]UploadStatus{"warehouse": Pending}})
+	assert.NoError(t, err)
+	assert.NoError(t, s.SetUpload(1, "warehouse", Uploaded))
+	assert.NoError(t, s.SetFrameUpload(testFrame(6, 2), "warehouse", Failed))
+	assert.NoError(t, s.SetFrameUpload(testFrame(7, 3), "warehouse", Failed), "not stored")
+	assert.Error(t, s.SetUpload(9, "warehouse", Failed))
+
+	found, ok := s.Find(testFrame(6, 2))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), found.ID)
+	assert.Equal(t, map[string]UploadStatus{"warehouse": Failed}, found.Uploads)
+
+	// the frames are replayed from the file
+	assert.NoError(t, s.Close())
+	s = open(t, config)
+	defer s.Close()
+	got, ok := s.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, Uploaded, got.Uploads["warehouse"])
+	assert.Equal(t, storeEpoch, got.Received)
+	added, err = s.Add(Record{Received: storeEpoch.Add(10 * time.Second), Frame: testFrame(5, 3)})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), added.ID)
+	assert.Equal(t, Stats{Frames: 3, Oldest: storeEpoch, Newest: storeEpoch.Add(10 * time.Second)}, s.Stats())
+
+	ids := func(q Query) []uint64 {
+		var ids []uint64
+		for _, r := range s.Query(q) {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	five := 5
+	assert.Equal(t, []uint64{1, 2, 3}, ids(Query{}))
+	assert.Equal(t, []uint64{2, 3}, ids(Query{From: storeEpoch.Add(time.Second)}))
+	assert.Equal(t, []uint64{1}, ids(Query{To: storeEpoch.Add(5 * time.Second)}))
+	assert.Equal(t, []uint64{1, 3}, ids(Query{FrameType: &five}))
+	assert.Equal(t, []uint64{2}, ids(Query{Station: "M0XYZ"}))
+	assert.Equal(t, []uint64{2}, ids(Query{Status: Failed}))
+	assert.Equal(t, []uint64{1}, ids(Query{Destination: "warehouse", Status: Uploaded}))
+	assert.Equal(t, []uint64{3}, ids(Query{Destination: "warehouse", Status: "none"}))
+	assert.Equal(t, []uint64{1, 2}, ids(Query{Destination: "warehouse"}))
+	assert.Equal(t, []uint64{1}, ids(Query{Limit: 1}))
+
+	// read while open
+	read, err := Read(config.Path, Query{FrameType: &five})
+	assert.NoError(t, err)
+	assert.Equal(t, s.Query(Query{FrameType: &five}), read)
+}
+
+func TestStore_Retention(t *testing.T) {
+	config := Config{Path: filepath.Join(t.TempDir(), "frames.log"), MaxAge: 1, MaxFrames: 100}
+	s := open(t, config)
+	clock := storeEpoch
+	s.now = func() time.Time { return clock }
+	for i := 0; i < 300; i++ {
+		frame := testFrame(1, byte(i))
+		frame[1] = byte(i >> 8)
+		_, err := s.Add(Record{Received: storeEpoch.Add(time.Duration(i) * time.Minute), Frame: frame})
+		assert.NoError(t, err)
+	}
+	// pruned to 90 each time there are more than 100
+	stats := s.Stats()
+	assert.Equal(t, 91, stats.Frames, "the oldest go first")
+	assert.Equal(t, uint64(209), stats.Pruned)
+	assert.Equal(t, storeEpoch.Add(209*time.Minute), stats.Oldest)
+	_, ok := s.Find(testFrame(1, 0))
+	assert.False(t, ok)
+	pruned, err := s.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pruned)
+
+	// a day later the frames older than a day go
+	clock = storeEpoch.Add(24*time.Hour + 250*time.Minute)
+	pruned, err = s.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 41, pruned)
+	assert.NoError(t, s.Close())
+
+	// the file was last compacted pruning at the 288th frame, it holds the 90 frames kept then and
+	// the 12 added since, opened without retention they are all kept
+	data, err := ioutil.ReadFile(config.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, 102, strings.Count(string(data), "\n"))
+	s = open(t, Config{Path: config.Path})
+	defer s.Close()
+	assert.Equal(t, 102, s.Stats().Frames)
+	assert.Equal(t, storeEpoch.Add(198*time.Minute), s.Stats().Oldest)
+}
+
+func TestOpen_Damaged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frames.log")
+	s := open(t, Config{Path: path})
+	_, err := s.Add(Record{Frame: testFrame(1, 1)})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+	data, _ := ioutil.ReadFile(path)
+	assert.NoError(t, ioutil.WriteFile(path, append(data, `{"record":{"id":2,`...), 0644))
+
+	s = open(t, Config{Path: path})
+	assert.Equal(t, 1, s.Stats().Frames, "the partly written entry is dropped")
+
+	// and cut from the file, so the frame added next is on a line of its own
+	_, err = s.Add(Record{Frame: testFrame(2, 2)})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+	s = open(t, Config{Path: path})
+	defer s.Close()
+	assert.Equal(t, 2, s.Stats().Frames)
+	_, ok := s.Find(testFrame(2, 2))
+	assert.True(t, ok)
+
+	_, err = Open(Config{})
+	assert.Error(t, err)
+}
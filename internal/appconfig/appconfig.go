@@ -0,0 +1,40 @@
+// Package appconfig the configuration printing shared by the apps
+package appconfig
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+
+	"github.com/knadh/koanf"
+)
+
+// secrets patterns of the keys masked when printed, the warehouse credentials, the hub key of a
+// station and the hub's table of station keys and credentials
+var secrets = []string{"authcode", "hubkey", "stations.*.authcode", "stations.*.key"}
+
+// SprintSafe prints each key -> value a line as koanf's Sprint does, masking secrets but for their last two characters
+func SprintSafe(config *koanf.Koanf) string {
+	b := bytes.Buffer{}
+	for _, k := range config.Keys() {
+		v := config.Get(k)
+		if secret(k) {
+			s, ok := v.(string)
+			if !ok || len(s) < 2 {
+				s = "**"
+			}
+			v = "********" + s[len(s)-2:]
+		}
+		b.WriteString(fmt.Sprintf("%s -> %v\n", k, v))
+	}
+	return b.String()
+}
+
+func secret(key string) bool {
+	for _, pattern := range secrets {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
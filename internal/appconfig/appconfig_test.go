@@ -0,0 +1,28 @@
+package appconfig
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSprintSafe(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "test.conf")
+	settings := "siteid = \"G0ABC\"\nauthcode = \"warehousesecret\"\nhubkey = \"x\"\n" +
+		"[stations.M0XYZ]\nkey = \"xyz-key\"\nsiteid = \"M0XYZ\"\n[tls]\nkey = \"/certs/station.key\"\n"
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(settings), 0600))
+	config := koanf.New(".")
+	assert.NoError(t, config.Load(file.Provider(conf), toml.Parser()))
+
+	assert.Equal(t, "authcode -> ********et\n"+
+		"hubkey -> **********\n"+
+		"siteid -> G0ABC\n"+
+		"stations.M0XYZ.key -> ********ey\n"+
+		"stations.M0XYZ.siteid -> M0XYZ\n"+
+		"tls.key -> /certs/station.key\n", SprintSafe(config), "a file path named key is no secret")
+}
@@ -0,0 +1,58 @@
+// Package testutil the helpers shared by the service tests
+package testutil
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// AssertNoLeaks checks the goroutine count gets back to where it was before the test started
+func AssertNoLeaks(t *testing.T, before int) {
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !assert.LessOrEqual(t, runtime.NumGoroutine(), before, "goroutines leaked") {
+		buf := make([]byte, 1<<16)
+		t.Logf("%s", buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// WaitFor polls cond until it holds, failing the test once timeout passes
+func WaitFor(t *testing.T, what string, timeout time.Duration, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Run runs the service until the returned stop is called, which returns the Run result
+func Run(service interface{ Run(context.Context) error }) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- service.Run(ctx) }()
+	return func() error {
+		cancel()
+		return <-result
+	}
+}
+
+// TempFile writes data to a file of the name in a directory removed after the test, returning its path
+func TempFile(t *testing.T, name string, data []byte) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
@@ -0,0 +1,252 @@
+package combine
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/funcube-dev/go/fcfec"
+)
+
+// Stats served on /api/v1/stats
+type Stats struct {
+	Blocks    uint64 `json:"blocks"`    // soft blocks received from the receivers
+	Unsynced  uint64 `json:"unsynced"`  // blocks without a sync vector, not used
+	Frames    uint64 `json:"frames"`    // blocks once the receivers' copies were gathered
+	Decoded   uint64 `json:"decoded"`   // frames decoded, alone or combined
+	Single    uint64 `json:"single"`    // frames a receiver's copy decoded alone
+	Recovered uint64 `json:"recovered"` // frames decoded only by combining the copies
+	Failed    uint64 `json:"failed"`    // frames not decoded even combined
+}
+
+// ReceiverStats what a receiver has sent since the combiner started
+type ReceiverStats struct {
+	Receiver string  `json:"receiver"`
+	Blocks   int     `json:"blocks"`
+	Unsynced int     `json:"unsynced"`
+	Decoded  int     `json:"decoded"`  // frames its copy decoded alone
+	Combined int     `json:"combined"` // frames recovered combining its copy with others
+	SNR      float64 `json:"snr"`      // mean signal to noise ratio of its synced blocks, dB
+	snrSum   float64
+}
+
+// aligned a receiver's block starting at its first sync symbol, not inverted
+type aligned struct {
+	receiver    string
+	start       time.Time
+	correlation float64
+	weight      float64 // signal over noise variance, for maximal ratio combining
+	symbols     []float32
+}
+
+// group the receivers' copies of a block
+type group struct {
+	received time.Time // the first copy arrived
+	start    time.Time
+	blocks   []*aligned
+}
+
+// maxSNR caps the weight of a block so clean as to have no noise measurable
+const maxSNR = 1000
+
+// combiner gathers the receivers' soft blocks of each frame for the window, aligned on their
+// sync vectors and grouped by time, then decodes each copy alone and, when none decodes, the copies
+// combined weighted by their signal to noise ratio
+type combiner struct {
+	mu        sync.Mutex
+	window    time.Duration
+	alignment time.Duration
+	minSync   float64
+	forward   func(frame []byte, errors int)
+	pending   []*group
+	stats     Stats
+	receivers map[string]*ReceiverStats
+	now       func() time.Time
+}
+
+func newCombiner(window, alignment time.Duration, minSync float64, forward func(frame []byte, errors int)) *combiner {
+	return &combiner{
+		window:    window,
+		alignment: alignment,
+		minSync:   minSync,
+		forward:   forward,
+		receivers: make(map[string]*ReceiverStats),
+		now:       time.Now,
+	}
+}
+
+// add a receiver's block, a receiver's further copies of a block only count if their sync
+// correlates better
+func (c *combiner) add(block fcfec.SoftBlock) {
+	soft := make([]float32, len(block.Symbols))
+	for i, s := range block.Symbols {
+		soft[i] = float32(s)
+	}
+	offset, correlation := fcfec.FindSync(soft)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Blocks++
+	r := c.receiver(block.Receiver)
+	r.Blocks++
+	if math.Abs(correlation) < c.minSync {
+		c.stats.Unsynced++
+		r.Unsynced++
+		return
+	}
+
+	b := &aligned{
+		receiver:    block.Receiver,
+		start:       block.Time.Add(time.Duration(offset) * time.Second / fcfec.SymbolRate),
+		correlation: math.Abs(correlation),
+		symbols:     soft[offset : offset+fcfec.BlockSymbols],
+	}
+	if correlation < 0 {
+		for i := range b.symbols {
+			b.symbols[i] = -b.symbols[i]
+		}
+	}
+	mean, variance := fcfec.SyncStats(b.symbols)
+	if variance < mean*mean/maxSNR {
+		variance = mean * mean / maxSNR
+	}
+	b.weight = mean / variance
+	r.snrSum += 10 * math.Log10(mean*mean/variance)
+
+	for _, g := range c.pending {
+		if d := b.start.Sub(g.start); d < -c.alignment || d > c.alignment {
+			continue
+		}
+		for i, other := range g.blocks {
+			if other.receiver == b.receiver {
+				if b.correlation > other.correlation {
+					g.blocks[i] = b
+				}
+				return
+			}
+		}
+		g.blocks = append(g.blocks, b)
+		return
+	}
+	c.pending = append(c.pending, &group{received: c.now(), start: b.start, blocks: []*aligned{b}})
+}
+
+func (c *combiner) receiver(receiver string) *ReceiverStats {
+	r, ok := c.receivers[receiver]
+	if !ok {
+		r = &ReceiverStats{Receiver: receiver}
+		c.receivers[receiver] = r
+	}
+	return r
+}
+
+// run decodes the frames as their window passes until ctx is done, call flush after
+func (c *combiner) run(ctx context.Context) {
+	interval := c.window / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			c.decodeDue(c.now())
+			c.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flush decodes every frame still gathering copies
+func (c *combiner) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.decodeDue(time.Time{})
+}
+
+// decodeDue decodes the frames gathered for the window before now, all if now is zero, the lock held
+func (c *combiner) decodeDue(now time.Time) {
+	for len(c.pending) > 0 {
+		g := c.pending[0]
+		if !now.IsZero() && now.Before(g.received.Add(c.window)) {
+			return
+		}
+		c.pending = c.pending[1:]
+		c.decode(g)
+	}
+}
+
+// decode the frame from the copy decoding with the fewest errors, or all the copies combined
+func (c *combiner) decode(g *group) {
+	c.stats.Frames++
+	var best []byte
+	bestErrors := 0
+	for _, b := range g.blocks {
+		frame, errors, err := fcfec.Decode(b.symbols)
+		if err != nil {
+			continue
+		}
+		c.receiver(b.receiver).Decoded++
+		if best == nil || errors < bestErrors {
+			best, bestErrors = frame, errors
+		}
+	}
+	if best != nil {
+		c.stats.Single++
+		c.stats.Decoded++
+		c.forward(best, bestErrors)
+		return
+	}
+	if len(g.blocks) < 2 {
+		c.stats.Failed++
+		return
+	}
+
+	combined := make([]float32, fcfec.BlockSymbols)
+	for _, b := range g.blocks {
+		weight := float32(b.weight)
+		for i, s := range b.symbols {
+			combined[i] += weight * s
+		}
+	}
+	frame, errors, err := fcfec.Decode(combined)
+	if err != nil {
+		c.stats.Failed++
+		return
+	}
+	c.stats.Recovered++
+	c.stats.Decoded++
+	for _, b := range g.blocks {
+		c.receiver(b.receiver).Combined++
+	}
+	c.forward(frame, errors)
+}
+
+// Stats the counts since the combiner started
+func (c *combiner) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Receivers what each receiver has sent, by name
+func (c *combiner) Receivers() []ReceiverStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	receivers := []ReceiverStats{}
+	for _, r := range c.receivers {
+		status := *r
+		status.snrSum = 0
+		if synced := r.Blocks - r.Unsynced; synced > 0 {
+			status.SNR = r.snrSum / float64(synced)
+		}
+		receivers = append(receivers, status)
+	}
+	sort.Slice(receivers, func(i, j int) bool { return receivers[i].Receiver < receivers[j].Receiver })
+	return receivers
+}
@@ -0,0 +1,85 @@
+package combine
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcfec"
+	"github.com/stretchr/testify/assert"
+)
+
+var combineEpoch = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func testFrame(seed int64) []byte {
+	frame := make([]byte, fcfec.FrameSize)
+	rand.New(rand.NewSource(seed)).Read(frame)
+	return frame
+}
+
+// softBlock the frame as a receiver demodulates it, starting offset symbols into the window, with
+// gaussian noise of sigma, scaled by the receiver's gain and inverted if the gain is negative
+func softBlock(rng *rand.Rand, receiver string, frame []byte, offset int, sigma, gain float64) fcfec.SoftBlock {
+	block, err := fcfec.Encode(frame)
+	if err != nil {
+		panic(err)
+	}
+	window := make([]float32, fcfec.WindowSymbols)
+	copy(window[offset:], fcfec.Symbols(block))
+	symbols := make([]int8, len(window))
+	for i, s := range window {
+		v := math.Round((float64(s) + rng.NormFloat64()*sigma) * gain)
+		symbols[i] = int8(math.Max(-127, math.Min(127, v)))
+	}
+	start := combineEpoch.Add(-time.Duration(offset) * time.Second / fcfec.SymbolRate)
+	return fcfec.SoftBlock{Receiver: receiver, Time: start, Symbols: symbols}
+}
+
+func TestCombiner(t *testing.T) {
+	type decoded struct {
+		frame  []byte
+		errors int
+	}
+	var forwarded []decoded
+	c := newCombiner(2*time.Second, time.Second, 0.4, func(frame []byte, errors int) {
+		forwarded = append(forwarded, decoded{frame, errors})
+	})
+	clock := combineEpoch
+	c.now = func() time.Time { return clock }
+	rng := rand.New(rand.NewSource(1))
+
+	// two copies too noisy to decode alone, at different offsets and gains, one inverted
+	c.add(softBlock(rng, "G0ABC", testFrame(1), 40, 1, 30))
+	c.add(softBlock(rng, "M0XYZ", testFrame(1), 120, 1, -50))
+	// a block of noise
+	c.add(softBlock(rng, "2E0QRP", testFrame(1), 0, 100, 1))
+	clock = combineEpoch.Add(2 * time.Second)
+	c.decodeDue(clock)
+	if assert.Len(t, forwarded, 1) {
+		assert.Equal(t, testFrame(1), forwarded[0].frame)
+	}
+
+	// a clean copy decodes alone, a copy alone fails, a frame later
+	c.add(softBlock(rng, "G0ABC", testFrame(2), 0, 0.3, 40))
+	c.add(softBlock(rng, "M0XYZ", testFrame(2), 10, 1, 40))
+	next := softBlock(rng, "M0XYZ", testFrame(3), 0, 1, 40)
+	next.Time = next.Time.Add(5 * time.Second)
+	c.add(next)
+	c.flush()
+	if assert.Len(t, forwarded, 2) {
+		assert.Equal(t, testFrame(2), forwarded[1].frame)
+	}
+
+	assert.Equal(t, Stats{Blocks: 6, Unsynced: 1, Frames: 3, Decoded: 2, Single: 1, Recovered: 1, Failed: 1}, c.Stats())
+	receivers := c.Receivers()
+	if assert.Len(t, receivers, 3) {
+		assert.Equal(t, "2E0QRP", receivers[0].Receiver)
+		assert.Equal(t, 1, receivers[0].Unsynced)
+		assert.Equal(t, 1, receivers[1].Decoded)
+		assert.Equal(t, 1, receivers[1].Combined)
+		assert.Equal(t, 3, receivers[2].Blocks)
+		assert.Equal(t, 0, receivers[2].Decoded)
+		assert.InDelta(t, 0, receivers[2].SNR, 3, "the noise as strong as the signal, estimated from the sync vector")
+	}
+}
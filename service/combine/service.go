@@ -0,0 +1,312 @@
+// Package combine decodes frames from the soft symbols several receivers demodulate. Each
+// receiver sends the soft symbols around every block it hears, the combiner aligns the receivers'
+// copies of a block on the sync vector and their timing and, when no copy decodes alone, decodes
+// the copies combined weighted by their signal to noise ratio, recovering frames every receiver lost
+package combine
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/funcube-dev/go/fcfec"
+	"github.com/funcube-dev/go/fcio"
+	"github.com/gin-gonic/gin"
+)
+
+// Config settings for the combine service, the koanf tags match the fccombine flags
+type Config struct {
+	BindAddress      string   `koanf:"bindaddress"`
+	DataPort         int      `koanf:"dataport"`
+	CommandPort      int      `koanf:"commandport"`
+	ConnectLocations []string `koanf:"connectlocations"`
+	Window           float64  `koanf:"window"`
+	Alignment        float64  `koanf:"alignment"`
+	MinSync          float64  `koanf:"minsync"`
+	ShutdownTimeout  float64  `koanf:"shutdowntimeout"`
+
+	// TLS for the listeners and the connect locations
+	TLS fcio.TLSConfig `koanf:"tls"`
+}
+
+// DefaultConfig the settings fccombine uses when none are given
+func DefaultConfig() Config {
+	return Config{
+		BindAddress:      "0.0.0.0",
+		DataPort:         0xFC0A,
+		CommandPort:      0xFC0B,
+		ConnectLocations: []string{},
+		Window:           2,
+		Alignment:        1,
+		MinSync:          0.4,
+		ShutdownTimeout:  5,
+	}
+}
+
+// Service receives the receivers' soft blocks and sends the frames decoded to the connect locations
+type Service struct {
+	config     Config
+	tlsServer  *tls.Config
+	tlsClient  *tls.Config
+	sources    *fcio.SourceQueue
+	combiner   *combiner
+	dataChans  []chan []byte
+	dataClosed bool
+	dataMutex  sync.Mutex
+}
+
+// New creates the service, nothing is received until Run
+func New(config Config) (*Service, error) {
+	tlsServer, err := config.TLS.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsClient, err := config.TLS.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(config.ConnectLocations) == 0 {
+		log.Println("Empty connectlocations, frames decoded are only counted")
+	}
+	s := &Service{
+		config:    config,
+		tlsServer: tlsServer,
+		tlsClient: tlsClient,
+		sources:   fcio.NewSourceQueue(fcfec.SoftBlockSize, fcfec.SoftBlockSize, fcio.Fair),
+	}
+	for _, loc := range config.ConnectLocations {
+		if err := fcio.CheckAddress(loc); err != nil {
+			return nil, fmt.Errorf("invalid connect location: %v", err)
+		}
+		s.dataChans = append(s.dataChans, make(chan []byte, 64))
+	}
+	s.combiner = newCombiner(seconds(config.Window), seconds(config.Alignment), config.MinSync, s.queueFrame)
+	return s, nil
+}
+
+// Run receives and decodes blocks until ctx is done, then stops receiving, decodes the blocks
+// gathered and carries on sending until the shutdown timeout
+func (s *Service) Run(ctx context.Context) error {
+	drainCtx, abandon := context.WithCancel(context.Background())
+	defer abandon()
+
+	var inputs, outputs fcio.Group
+	for i, loc := range s.config.ConnectLocations {
+		ch, loc := s.dataChans[i], loc
+		outputs.Go(func() { s.sendData(drainCtx, ch, loc) })
+	}
+	inputs.Go(func() { s.readData(ctx) })
+	inputs.Go(func() { s.combiner.run(ctx) })
+	inputs.Go(func() { s.listen(ctx) })
+	inputs.Go(func() { s.serveAPI(ctx) })
+
+	<-ctx.Done()
+	if !fcio.Drain(seconds(s.config.ShutdownTimeout), time.Second, abandon, &inputs, &outputs) {
+		return errors.New("shutdown timed out")
+	}
+	return nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// readData takes the receivers' blocks from the connections until the context is done, then
+// decodes the blocks still gathering copies and closes the data channels
+func (s *Service) readData(ctx context.Context) {
+	defer s.closeDataChannels()
+	defer s.combiner.flush()
+	defer s.sources.Close()
+	for {
+		raw, ok := s.sources.Next(ctx)
+		if !ok {
+			return
+		}
+		block, err := fcfec.DecodeSoftBlock(raw)
+		if err != nil {
+			log.Printf("Rejected block: %v", err)
+			continue
+		}
+		fmt.Printf("<")
+		s.combiner.add(block)
+	}
+}
+
+// queueFrame queues a frame decoded for each connect location, followed by an inter frame marker
+func (s *Service) queueFrame(frame []byte, errors int) {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	if s.dataClosed {
+		log.Printf("Discarded result, shutting down")
+		return
+	}
+	for _, ch := range s.dataChans {
+		select {
+		case ch <- frame:
+		default:
+			log.Printf("Discarded result, channel full")
+			continue
+		}
+		// send zero length buffer to drop connection
+		select {
+		case ch <- make([]byte, 0):
+		default:
+			log.Printf("Failed to send inter frame marker")
+		}
+	}
+}
+
+func (s *Service) closeDataChannels() {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	if !s.dataClosed {
+		s.dataClosed = true
+		for _, ch := range s.dataChans {
+			close(ch)
+		}
+	}
+}
+
+// sendData sends the frames to destLoc until srcChan is closed and empty or ctx is done
+func (s *Service) sendData(ctx context.Context, srcChan chan []byte, destLoc string) {
+	log.Println("Starting send worker for:", destLoc)
+
+	dst, err := fcio.NewReconnectingWriter(ctx, destLoc, fcio.WriterOptions{
+		WriteTimeout: 10 * time.Second,
+		MinBackoff:   5 * time.Second,
+		MaxBackoff:   120 * time.Second,
+		TLS:          s.tlsClient,
+		OnState: func(event fcio.ConnEvent) {
+			if event.Err != nil {
+				log.Println(event)
+			}
+		},
+	})
+	if err != nil {
+		log.Println("Failed to create writer:", err)
+		return
+	}
+	defer dst.Close()
+
+	for {
+		var data []byte
+		var ok bool
+		select {
+		case data, ok = <-srcChan:
+			if !ok {
+				log.Println("All frames sent to:", destLoc)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		// a zero byte buffer on the channel is an inter frame marker so drop the connection
+		if len(data) == 0 {
+			dst.EndFrame()
+			continue
+		}
+		// only fails once ctx is done, failed writes are retried over a new connection
+		if _, err := dst.Write(data); err != nil {
+			return
+		}
+		fmt.Printf(">")
+	}
+}
+
+func (s *Service) listen(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.DataPort))
+	log.Println("Opening listen socket...")
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer lsock.Close()
+	defer fcio.CloseWhenDone(ctx, lsock)()
+	log.Printf("Listening on socket: %s", hostport)
+
+	for {
+		c, err := lsock.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		s.handleConnection(c)
+	}
+}
+
+// handleConnection reads the blocks of a connection alongside the others, resynchronising on
+// the block header, it is dropped after a second without data
+func (s *Service) handleConnection(c net.Conn) {
+	err := s.sources.Add(c, fcio.SourceOptions{
+		Name:        c.RemoteAddr().String(),
+		ReadTimeout: time.Second,
+		Validate:    fcfec.IsSoftBlock,
+	})
+	if err != nil {
+		log.Printf("Failed to read connection, ignoring error:%v", err)
+	}
+}
+
+// Response wraps the data served by the api
+type Response struct {
+	Data interface{} `json:"data,omitempty"`
+}
+
+// serveAPI serves the api until ctx is done
+func (s *Service) serveAPI(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.CommandPort))
+	log.Println("Opening command listen socket...")
+
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		log.Printf("Command listen socket failed: %v", err)
+		return
+	}
+	server := &http.Server{Handler: s.router()}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(lsock) }()
+
+	select {
+	case err := <-served:
+		log.Printf("Command listen socket failed: %v", err)
+		return
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to stop command listen socket: %v", err)
+	}
+	<-served
+}
+
+func (s *Service) router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Logger())
+	r.Use(gin.Recovery())
+
+	apiv1 := r.Group("/api/v1")
+	{
+		apiv1.GET("/stats", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.combiner.Stats(),
+			})
+		})
+		apiv1.GET("/receivers", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.combiner.Receivers(),
+			})
+		})
+	}
+	return r
+}
@@ -0,0 +1,95 @@
+package combine
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcfec"
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/internal/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_RecoversCombined(t *testing.T) {
+	before := runtime.NumGoroutine()
+	sink, err := fcio.Listen("tcp", "pipe://combined", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := make(chan []byte, 1)
+	go func() {
+		conn, err := sink.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		frame := make([]byte, fcfec.FrameSize)
+		if _, err := io.ReadFull(conn, frame); err == nil {
+			frames <- frame
+		}
+	}()
+
+	config := DefaultConfig()
+	config.BindAddress = "127.0.0.1"
+	config.DataPort = 0
+	config.CommandPort = 0
+	config.Window = 0.2
+	config.ConnectLocations = []string{"pipe://combined"}
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+
+	rng := rand.New(rand.NewSource(2))
+	for _, receiver := range []string{"G0ABC", "M0XYZ"} {
+		data, err := softBlock(rng, receiver, testFrame(1), rng.Intn(160), 1, 30).Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, server := net.Pipe()
+		s.handleConnection(server)
+		if _, err := client.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+	}
+
+	select {
+	case frame := <-frames:
+		assert.Equal(t, testFrame(1), frame)
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for the frame")
+	}
+	cancel()
+	assert.NoError(t, <-result)
+	sink.Close()
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	var stats struct {
+		Data Stats `json:"data"`
+	}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats)) {
+		assert.Equal(t, Stats{Blocks: 2, Frames: 1, Decoded: 1, Recovered: 1}, stats.Data)
+	}
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestNew_ConnectLocations(t *testing.T) {
+	config := DefaultConfig()
+	config.ConnectLocations = []string{"nohost"}
+	_, err := New(config)
+	assert.Error(t, err)
+}
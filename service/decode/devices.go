@@ -0,0 +1,102 @@
+package decode
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/funcube-dev/go/fclib"
+)
+
+// regexPrefix marks a device name query as a regular expression
+const regexPrefix = "re:"
+
+// AudioDeviceList the devices found when the decoder was last started and the ones it uses,
+// served on /api/v1/devices
+type AudioDeviceList struct {
+	Devices []fclib.AudioDevice `json:"devices"`
+	Input   int                 `json:"input"`
+	Output  int                 `json:"output"`
+}
+
+// listAudioDevices refreshes and enumerates the audio devices, logging each one
+func listAudioDevices() []fclib.AudioDevice {
+	if result := fclib.Decode_RefreshAudioDevices(); result != 1 {
+		log.Printf("Failed to refresh audio devices, result:%d", result)
+	}
+	devices, err := fclib.Decode_ListDevices()
+	if err != nil {
+		log.Printf("Failed to list audio devices: %v", err)
+	}
+	for _, d := range devices {
+		log.Printf("Audio device %d: %q input:%t output:%t", d.Index, d.Name, d.IsInput, d.IsOutput)
+	}
+	return devices
+}
+
+// resolveAudioDevice turns an audiodevicein/audiodeviceout setting into a device index,
+// a number is used as is (-1 the default device), anything else is looked up by name
+func resolveAudioDevice(devices []fclib.AudioDevice, setting string, input bool) (int, error) {
+	if id, err := strconv.Atoi(strings.TrimSpace(setting)); err == nil {
+		return id, nil
+	}
+	d, err := findDevice(devices, setting, input)
+	if err != nil {
+		return -1, err
+	}
+	return d.Index, nil
+}
+
+// findDevice picks the device matching query, an exact name first, otherwise the only device
+// whose name contains query (ignoring case), or with re: in front the only device whose name
+// matches the regular expression, devices that can't record are skipped when input is set
+func findDevice(devices []fclib.AudioDevice, query string, input bool) (fclib.AudioDevice, error) {
+	match := func(name string) bool {
+		return strings.Contains(strings.ToLower(name), strings.ToLower(query))
+	}
+	if strings.HasPrefix(query, regexPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(query, regexPrefix))
+		if err != nil {
+			return fclib.AudioDevice{}, fmt.Errorf("bad audio device pattern %q: %v", query, err)
+		}
+		match = re.MatchString
+	}
+
+	var candidates []fclib.AudioDevice
+	for _, d := range devices {
+		if (input && !d.IsInput) || (!input && !d.IsOutput) {
+			continue
+		}
+		if d.Name == query {
+			return d, nil
+		}
+		if match(d.Name) {
+			candidates = append(candidates, d)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return fclib.AudioDevice{}, fmt.Errorf("no audio device matching %q", query)
+	case 1:
+		return candidates[0], nil
+	}
+	names := make([]string, len(candidates))
+	for i, d := range candidates {
+		names[i] = fmt.Sprintf("%d:%q", d.Index, d.Name)
+	}
+	return fclib.AudioDevice{}, fmt.Errorf("%q matches more than one audio device: %s", query, strings.Join(names, ", "))
+}
+
+// countDongles number of recording devices that look like a FUNcube Dongle
+func countDongles(devices []fclib.AudioDevice) int {
+	count := 0
+	for _, d := range devices {
+		if d.IsInput && strings.Contains(strings.ToLower(d.Name), "funcube") {
+			count++
+		}
+	}
+	return count
+}
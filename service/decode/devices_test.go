@@ -0,0 +1,107 @@
+package decode
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fclib"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var testDevices = []fclib.AudioDevice{
+	{Index: 0, Name: "default", IsInput: true, IsOutput: true},
+	{Index: 1, Name: "FUNcube Dongle V2.0: USB Audio (hw:1,0)", IsInput: true},
+	{Index: 2, Name: "FUNcube Dongle V2.0: USB Audio (hw:2,0)", IsInput: true},
+	{Index: 3, Name: "bcm2835 Headphones: - (hw:0,0)", IsOutput: true},
+}
+
+func TestFindDevice(t *testing.T) {
+	d, err := findDevice(testDevices, "default", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, d.Index)
+
+	d, err = findDevice(testDevices, "hw:2,0", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d.Index)
+
+	d, err = findDevice(testDevices, "headphones", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, d.Index)
+}
+
+func TestFindDevice_NoMatch(t *testing.T) {
+	_, err := findDevice(testDevices, "funcube", true)
+	assert.Error(t, err, "ambiguous match")
+
+	_, err = findDevice(testDevices, "headphones", true)
+	assert.Error(t, err, "output only device used for input")
+
+	_, err = findDevice(testDevices, "pluto", true)
+	assert.Error(t, err)
+}
+
+func TestCountDongles(t *testing.T) {
+	assert.Equal(t, 2, countDongles(testDevices))
+}
+
+func TestFindDevice_Regex(t *testing.T) {
+	d, err := findDevice(testDevices, `re:FUNcube.*\(hw:1,`, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, d.Index)
+
+	_, err = findDevice(testDevices, `re:hw:\d,0`, true)
+	assert.Error(t, err, "ambiguous pattern")
+
+	_, err = findDevice(testDevices, `re:hw:(`, true)
+	assert.Error(t, err, "bad pattern")
+}
+
+func TestResolveAudioDevice(t *testing.T) {
+	id, err := resolveAudioDevice(testDevices, "-1", true)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, id)
+
+	id, err = resolveAudioDevice(testDevices, "7", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, id)
+
+	id, err = resolveAudioDevice(testDevices, "FUNcube Dongle V2.0: USB Audio (hw:2,0)", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+
+	_, err = resolveAudioDevice(testDevices, "funcube", true)
+	assert.Error(t, err)
+}
+
+func TestDevicesEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := DefaultConfig()
+	config.AudioDeviceIn = "hw:2,0"
+	config.Library = &fakeDongle{present: true, devices: testDevices}
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.dongle.stepDelay = 0
+	s.dongle.step(time.Now())
+
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/devices", nil))
+	assert.Equal(t, 200, w.Code)
+
+	var response struct {
+		Data struct {
+			Devices []fclib.AudioDevice `json:"devices"`
+			Input   int                 `json:"input"`
+			Output  int                 `json:"output"`
+		} `json:"data"`
+	}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response)) {
+		assert.Equal(t, testDevices, response.Data.Devices)
+		assert.Equal(t, 2, response.Data.Input)
+		assert.Equal(t, -1, response.Data.Output)
+	}
+}
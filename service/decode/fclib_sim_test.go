@@ -0,0 +1,65 @@
+//go:build fcsim
+// +build fcsim
+
+package decode
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fclib"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRun_SimulatedLibrary the service on the FUNcubeLib dongle, simulated with -tags fcsim,
+// sends the frames replayed from a funcubebin file
+func TestRun_SimulatedLibrary(t *testing.T) {
+	frame := bytes.Repeat([]byte{0x5a}, 256)
+	file := filepath.Join(t.TempDir(), "frames.bin")
+	if err := ioutil.WriteFile(file, frame, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fclib.Sim_Configure(fclib.SimConfig{File: file, Interval: 50 * time.Millisecond})
+	defer fclib.Sim_Configure(fclib.SimConfig{})
+
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+	config := testConfig(lsock.Addr().String())
+	config.Library = nil
+	config.BiasT = true
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+
+	c, err := lsock.Accept()
+	if assert.NoError(t, err) {
+		received, err := ioutil.ReadAll(c)
+		assert.NoError(t, err)
+		assert.Equal(t, frame, received)
+		c.Close()
+	}
+	state := fclib.Sim_State()
+	assert.True(t, state.Started)
+	assert.True(t, state.BiasT)
+	assert.Equal(t, uint32(config.Frequency), state.Frequency)
+	recent := s.nativeLog.Recent()
+	if assert.NotEmpty(t, recent, "library log messages kept") {
+		assert.Contains(t, recent[0].Message, "Decoder started")
+	}
+
+	cancel()
+	assert.NoError(t, <-result)
+	assert.False(t, fclib.Sim_State().Started, "decoder stopped on shutdown")
+}
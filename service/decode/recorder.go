@@ -0,0 +1,174 @@
+package decode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/fclib"
+)
+
+// iqRecorder writes the raw IQ samples the decoder sees around a pass to WAV files. A recording
+// starts preTrigger before the first frame decoded, rolls to a new file every fileLength and ends
+// once no frame has been decoded for hold. Until triggered the stream holds the pre-trigger samples
+type iqRecorder struct {
+	dir        string
+	rate       int // IQ pairs a second
+	preTrigger time.Duration
+	hold       time.Duration
+	fileLength time.Duration // 0 never rolls
+	triggered  chan struct{}
+	now        func() time.Time
+}
+
+func newIQRecorder(dir string, rate int, preTrigger, hold, fileLength time.Duration) *iqRecorder {
+	return &iqRecorder{
+		dir:        dir,
+		rate:       rate,
+		preTrigger: preTrigger,
+		hold:       hold,
+		fileLength: fileLength,
+		triggered:  make(chan struct{}, 1),
+		now:        time.Now,
+	}
+}
+
+// capacity the samples the stream needs to hold, the pre-trigger and a few seconds for the writer
+func (r *iqRecorder) capacity() int {
+	return r.samples(r.preTrigger + 5*time.Second)
+}
+
+// samples the interleaved I and Q samples in d
+func (r *iqRecorder) samples(d time.Duration) int {
+	return 2 * int(d.Seconds()*float64(r.rate))
+}
+
+// trigger starts or extends a recording, called with each frame decoded
+func (r *iqRecorder) trigger() {
+	select {
+	case r.triggered <- struct{}{}:
+	default:
+	}
+}
+
+// run records from the stream until ctx is done, then closes the stream and any recording
+func (r *iqRecorder) run(ctx context.Context, stream *fclib.SampleStream) {
+	defer stream.Close()
+	buf := make([]float32, 8192)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.triggered:
+		}
+		// until now the oldest samples were overwritten as intended
+		stream.Keep(r.samples(r.preTrigger))
+		dropped := stream.Dropped()
+		if err := r.record(ctx, stream, buf); err != nil {
+			log.Printf("IQ recording failed: %v", err)
+		}
+		if dropped = stream.Dropped() - dropped; dropped > 0 {
+			log.Printf("IQ recording fell behind, %d samples dropped", dropped)
+		}
+	}
+}
+
+// record writes the samples to files until hold has passed since the last frame decoded
+func (r *iqRecorder) record(ctx context.Context, stream *fclib.SampleStream, buf []float32) error {
+	fileSamples := r.samples(r.fileLength)
+	var file *iqFile
+	defer func() {
+		if file != nil {
+			file.close()
+		}
+	}()
+
+	last := r.now()
+	for {
+		select {
+		case <-r.triggered:
+			last = r.now()
+		default:
+		}
+		if r.now().Sub(last) >= r.hold {
+			return nil
+		}
+
+		// wait a second at most, so the recording ends even if the samples stop
+		readCtx, cancel := context.WithTimeout(ctx, time.Second)
+		n, err := stream.Read(readCtx, buf)
+		cancel()
+		if ctx.Err() != nil || err == io.EOF {
+			return nil
+		}
+		samples := buf[:n]
+		for err == nil && len(samples) > 0 {
+			if file == nil || (fileSamples > 0 && file.written >= fileSamples) {
+				if file != nil {
+					file.close()
+				}
+				if file, err = r.create(); err != nil {
+					return err
+				}
+			}
+			n := len(samples)
+			if fileSamples > 0 && n > fileSamples-file.written {
+				n = fileSamples - file.written
+			}
+			if err := file.write(samples[:n]); err != nil {
+				return err
+			}
+			samples = samples[n:]
+		}
+	}
+}
+
+// iqFile a recording being written
+type iqFile struct {
+	name    string
+	file    *os.File
+	wav     *fcio.WavWriter
+	written int
+}
+
+// create the next recording, named for when it was started
+func (r *iqRecorder) create() (*iqFile, error) {
+	started := r.now().UTC().Format("20060102T150405.000Z")
+	name := filepath.Join(r.dir, fmt.Sprintf("iq-%s.wav", started))
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	// a file rolled within the millisecond
+	for i := 1; os.IsExist(err); i++ {
+		name = filepath.Join(r.dir, fmt.Sprintf("iq-%s-%d.wav", started, i))
+		file, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	wav, err := fcio.NewWavWriter(file, r.rate, 2)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	log.Println("IQ recording to:", name)
+	return &iqFile{name: name, file: file, wav: wav}, nil
+}
+
+func (f *iqFile) write(samples []float32) error {
+	if err := f.wav.WriteSamples(samples); err != nil {
+		return fmt.Errorf("failed to write %s: %v", f.name, err)
+	}
+	f.written += len(samples)
+	return nil
+}
+
+func (f *iqFile) close() {
+	if err := f.wav.Close(); err != nil {
+		log.Printf("Failed to finish %s: %v", f.name, err)
+	}
+	f.file.Close()
+}
@@ -0,0 +1,106 @@
+package decode
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/fclib"
+	"github.com/funcube-dev/go/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// ramp n IQ samples counting up from first
+func ramp(first, n int) []float32 {
+	samples := make([]float32, n)
+	for i := range samples {
+		samples[i] = float32(first + i)
+	}
+	return samples
+}
+
+// readRecordings the samples in each WAV file in dir finished, in the order of the ramps recorded
+func readRecordings(t *testing.T, dir string) [][]float32 {
+	names, _ := filepath.Glob(filepath.Join(dir, "iq-*.wav"))
+	var recordings [][]float32
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(data[22:]), "IQ as two channels")
+		assert.Equal(t, uint32(100), binary.LittleEndian.Uint32(data[24:]), "sample rate")
+		size := binary.LittleEndian.Uint32(data[40:])
+		samples := make([]float32, size/4)
+		for i := range samples {
+			samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[44+4*i:]))
+		}
+		// the size is written as the file is finished
+		if len(samples) > 0 {
+			recordings = append(recordings, samples)
+		}
+	}
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i][0] < recordings[j][0] })
+	return recordings
+}
+
+func TestIQRecorder(t *testing.T) {
+	dir := t.TempDir()
+	// 100 IQ pairs a second, 1 second before the first decode, 4 second files
+	r := newIQRecorder(dir, 100, time.Second, 200*time.Millisecond, 4*time.Second)
+	stream := fclib.NewSampleStream(r.capacity())
+	ctx, cancel := context.WithCancel(context.Background())
+	var group fcio.Group
+	group.Go(func() { r.run(ctx, stream) })
+
+	// a while before the pass, only the last second is recorded
+	stream.Write(ramp(0, 1000))
+	r.trigger()
+	testutil.WaitFor(t, "pre-trigger samples read", 5*time.Second, func() bool { return stream.Buffered() == 0 })
+	stream.Write(ramp(1000, 1000))
+	testutil.WaitFor(t, "recording ended", 5*time.Second, func() bool { return len(readRecordings(t, dir)) == 2 })
+	cancel()
+	assert.True(t, group.Wait(5*time.Second))
+
+	recordings := readRecordings(t, dir)
+	assert.Equal(t, ramp(800, 800), recordings[0], "rolled after 4 seconds")
+	assert.Equal(t, ramp(1600, 400), recordings[1])
+}
+
+func TestRun_IQRecording(t *testing.T) {
+	config := testConfig("")
+	config.ConnectLocations = nil
+	config.IQDir = t.TempDir()
+	config.IQRate = 100
+	config.IQPreTrigger = 1
+	config.IQFileSeconds = 0
+	fd := config.Library.(*fakeDongle)
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+	testutil.WaitFor(t, "dongle running", 5*time.Second, func() bool { return s.dongle.Status().State == DongleRunning })
+	fd.readyMutex.Lock()
+	stream := fd.samples
+	fd.readyMutex.Unlock()
+	stream.Write(ramp(0, 400))
+	fd.decode(make([]byte, 256))
+	testutil.WaitFor(t, "samples recorded", 5*time.Second, func() bool { return stream.Buffered() == 0 })
+
+	cancel()
+	assert.NoError(t, <-result)
+	recordings := readRecordings(t, config.IQDir)
+	if assert.Len(t, recordings, 1, "closed on shutdown") {
+		assert.Equal(t, ramp(200, 200), recordings[0])
+	}
+}
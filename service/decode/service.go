@@ -0,0 +1,629 @@
+// Package decode decodes FUNcube frames from the FUNcube Dongle and sends each 256 byte frame to
+// every configured location, the dongle is supervised and restarted if it goes away
+package decode
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/fclib"
+	"github.com/funcube-dev/go/fcstore"
+	"github.com/gin-gonic/gin"
+)
+
+// Config settings for the decode service, the koanf tags match the fcdecode flags
+type Config struct {
+	Frequency        float64   `koanf:"frequency"`
+	Exclude          []float64 `koanf:"exclude"`
+	NumDecoders      int       `koanf:"numdecoders"`
+	BiasT            bool      `koanf:"biast"`
+	AudioDeviceIn    string    `koanf:"audiodevicein"`
+	AudioDeviceOut   string    `koanf:"audiodeviceout"`
+	Dongle           string    `koanf:"dongle"`
+	ConnectAddress   string    `koanf:"connectaddress"`
+	ConnectPort      int       `koanf:"connectport"`
+	BindAddress      string    `koanf:"bindaddress"`
+	ConnectLocations []string  `koanf:"connectlocations"`
+	CommandPort      int       `koanf:"commandport"`
+	OutDir           string    `koanf:"outdir"`
+	CheckInterval    float64   `koanf:"checkinterval"`
+	StallTimeout     float64   `koanf:"stalltimeout"`
+	ShutdownTimeout  float64   `koanf:"shutdowntimeout"`
+	IQDir            string    `koanf:"iqdir"`
+	IQRate           int       `koanf:"iqrate"`
+	IQPreTrigger     float64   `koanf:"iqpretrigger"`
+	IQHold           float64   `koanf:"iqhold"`
+	IQFileSeconds    float64   `koanf:"iqfileseconds"`
+	WorkerTimeout    float64   `koanf:"workertimeout"`
+	WorkerExclude    float64   `koanf:"workerexclude"`
+	DedupeWindow     float64   `koanf:"dedupewindow"`
+	HubAddress       string    `koanf:"hubaddress"`
+	HubStation       string    `koanf:"hubstation"`
+	HubKey           string    `koanf:"hubkey"`
+
+	// Store records every frame decoded and how sending it to each connect location went, none
+	// without a path
+	Store fcstore.Config `koanf:"store"`
+
+	// TLS for the connections to the connect locations and the command api
+	TLS fcio.TLSConfig `koanf:"tls"`
+
+	// NativeLog how the messages the FUNcubeLib logs are filtered and kept for /api/v1/nativelog
+	NativeLog fclib.LogConfig `koanf:"nativelog"`
+
+	// Library nil for the FUNcubeLib dongle and decoder
+	Library DongleLibrary `koanf:"-"`
+}
+
+// DefaultConfig the settings fcdecode uses when none are given
+func DefaultConfig() Config {
+	return Config{
+		Frequency:        145860000,
+		Exclude:          []float64{},
+		NumDecoders:      5,
+		AudioDeviceIn:    "-1",
+		AudioDeviceOut:   "-1",
+		ConnectAddress:   "encodeserver",
+		ConnectPort:      0xFC02,
+		BindAddress:      "0.0.0.0",
+		ConnectLocations: []string{},
+		CommandPort:      0xFC01,
+		CheckInterval:    5,
+		StallTimeout:     30,
+		ShutdownTimeout:  5,
+		IQRate:           192000,
+		IQPreTrigger:     10,
+		IQHold:           60,
+		IQFileSeconds:    300,
+		WorkerExclude:    600,
+		NativeLog:        fclib.DefaultLogConfig(),
+		Store:            fcstore.Config{MaxAge: 30},
+	}
+}
+
+// Stats served on /api/v1/stats
+type Stats struct {
+	Decoded uint
+}
+
+// Service decodes frames from the dongle and sends them to the connect locations
+type Service struct {
+	config       Config
+	tlsServer    *tls.Config
+	tlsClient    *tls.Config
+	nativeLog    *fclib.NativeLog
+	dongle       *supervisor
+	recorder     *iqRecorder
+	dedupe       *fcio.Dedupe
+	store        *fcstore.Store
+	locations    []string
+	sendDisabled bool
+	dataChan     chan []byte
+	hubChan      chan []byte
+	dataClosed   bool
+	dataMutex    sync.Mutex
+	stats        Stats
+}
+
+// New creates the service, the dongle is not started until Run
+func New(config Config) (*Service, error) {
+	tlsServer, err := config.TLS.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsClient, err := config.TLS.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	nativeLog, err := fclib.NewNativeLog(config.NativeLog, nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		config:    config,
+		tlsServer: tlsServer,
+		tlsClient: tlsClient,
+		nativeLog: nativeLog,
+		dataChan:  make(chan []byte, 64),
+		hubChan:   make(chan []byte, 64),
+	}
+
+	if config.ConnectAddress == "" && len(config.ConnectLocations) == 0 && config.HubAddress == "" {
+		log.Println("Empty connectaddress, connectlocations and hubaddress, disabled sending")
+		s.sendDisabled = true
+	}
+
+	s.locations = append(s.locations, config.ConnectLocations...)
+	// append original connect address/port to location for backward compatibility
+	if config.ConnectAddress != "" {
+		s.locations = append(s.locations, fcio.JoinAddress(config.ConnectAddress, strconv.Itoa(config.ConnectPort)))
+	}
+	for _, loc := range s.locations {
+		if err := fcio.CheckAddress(loc); err != nil {
+			return nil, fmt.Errorf("invalid connect location: %v", err)
+		}
+	}
+
+	if config.HubAddress != "" {
+		if err := fcio.CheckAddress(config.HubAddress); err != nil {
+			return nil, fmt.Errorf("invalid hub address: %v", err)
+		}
+		if config.HubKey == "" {
+			return nil, errors.New("hubkey needed to send to the hub")
+		}
+		// check the station name fits a record
+		record := fcio.StationRecord{Station: config.HubStation, Frame: make([]byte, 256)}
+		if _, err := record.Encode([]byte(config.HubKey)); err != nil {
+			return nil, fmt.Errorf("invalid hubstation: %v", err)
+		}
+	}
+
+	var exclude []float32
+	for _, f := range config.Exclude {
+		exclude = append(exclude, float32(f))
+	}
+
+	lib := config.Library
+	if lib == nil {
+		lib = &fclibDongle{}
+		if fclib.Callback_SetOnLogMessage(nativeLog.Handle) != 1 {
+			log.Println("Failed to route FUNcubeLib log messages, they go to stderr")
+		}
+	}
+	// the supervisor starts the dongle and decoder, and restarts them if the dongle goes away
+	s.dongle = newSupervisor(lib, dongleSettings{
+		Frequency:      uint32(config.Frequency),
+		BiasT:          config.BiasT,
+		Workers:        uint32(config.NumDecoders),
+		Exclude:        exclude,
+		AudioDeviceIn:  config.AudioDeviceIn,
+		AudioDeviceOut: config.AudioDeviceOut,
+		Dongle:         config.Dongle,
+	}, s.onFrame)
+	s.dongle.stallTimeout = seconds(config.StallTimeout)
+	if config.WorkerTimeout > 0 {
+		s.dongle.workers = newWorkerController(seconds(config.WorkerTimeout), seconds(config.WorkerExclude))
+	}
+
+	if config.DedupeWindow > 0 {
+		s.dedupe = fcio.NewDedupe(seconds(config.DedupeWindow), nil, s.forwardFrame)
+	}
+
+	if config.IQDir != "" {
+		if config.IQRate <= 0 {
+			return nil, fmt.Errorf("invalid iqrate %d", config.IQRate)
+		}
+		s.recorder = newIQRecorder(config.IQDir, config.IQRate, seconds(config.IQPreTrigger), seconds(config.IQHold), seconds(config.IQFileSeconds))
+	}
+
+	if config.Store.Path != "" {
+		storeConfig := config.Store
+		if storeConfig.Station == "" {
+			storeConfig.Station = config.HubStation
+		}
+		if s.store, err = fcstore.Open(storeConfig); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Run decodes and sends frames until ctx is done, then stops the decoder and carries on sending
+// the frames already decoded until the shutdown timeout
+func (s *Service) Run(ctx context.Context) error {
+	drainCtx, abandon := context.WithCancel(context.Background())
+	defer abandon()
+
+	var inputs, outputs fcio.Group
+	inputs.Go(func() {
+		s.dongle.run(ctx, seconds(s.config.CheckInterval))
+		if s.dedupe != nil {
+			s.dedupe.Flush()
+			log.Printf("Suppressed %d duplicate frames", s.dedupe.Stats().Suppressed)
+		}
+		s.closeDataChannel()
+	})
+	if s.dedupe != nil {
+		inputs.Go(func() { s.dedupe.Run(ctx) })
+	}
+	inputs.Go(func() { s.serveStats(ctx) })
+	if s.recorder != nil {
+		stream := s.dongle.lib.DecodeStreamSamples(s.recorder.capacity())
+		inputs.Go(func() { s.recorder.run(ctx, stream) })
+	}
+	if s.store != nil {
+		defer s.store.Close()
+		inputs.Go(func() { s.store.Run(ctx, time.Hour) })
+	}
+
+	var dataChans []chan []byte
+
+	// start one sendData routine per destination host
+	for _, loc := range s.locations {
+		ch := make(chan []byte, 64)
+		dataChans = append(dataChans, ch)
+		loc := loc
+		outputs.Go(func() { s.sendData(drainCtx, ch, loc) })
+	}
+
+	outputs.Go(func() { s.cloneDataChannel(s.dataChan, dataChans) })
+	if s.config.HubAddress != "" {
+		outputs.Go(func() { s.sendData(drainCtx, s.hubChan, s.config.HubAddress) })
+	}
+
+	<-ctx.Done()
+	drained := fcio.Drain(seconds(s.config.ShutdownTimeout), time.Second, abandon, &inputs, &outputs)
+	for i, ch := range dataChans {
+		s.markUnsent(ch, s.locations[i])
+	}
+	if !drained {
+		return errors.New("shutdown timed out")
+	}
+	return nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// onFrame starts or extends an IQ recording, stores the frame decoded and queues it for sending,
+// once the copies decoded by the other workers have been weighed up when deduplicating
+func (s *Service) onFrame(event fclib.DecodeEvent) {
+	if s.recorder != nil {
+		s.recorder.trigger()
+	}
+	if s.dedupe != nil {
+		s.dedupe.Add(event.Data, event.Errors, event)
+		return
+	}
+	s.storeFrame(event)
+	s.queueFrame(event.Data, event.Errors)
+}
+
+// forwardFrame stores and queues the copy of a frame the dedupe keeps, the duplicates are neither
+// stored nor sent
+func (s *Service) forwardFrame(decoded []byte, errorCount int, event interface{}) {
+	s.storeFrame(event.(fclib.DecodeEvent))
+	s.queueFrame(decoded, errorCount)
+}
+
+// storeFrame adds the frame decoded to the store
+func (s *Service) storeFrame(event fclib.DecodeEvent) {
+	if s.store == nil {
+		return
+	}
+	_, err := s.store.Add(fcstore.Record{
+		Received:  event.Time,
+		Frequency: float64(event.Frequency),
+		Errors:    event.Errors,
+		Frame:     event.Data,
+	})
+	if err != nil {
+		log.Printf("Failed to store frame: %v", err)
+	}
+}
+
+// queueFrame queues a frame for sending, followed by an inter frame marker, and for the hub as a
+// record signed for this station
+func (s *Service) queueFrame(decoded []byte, errorCount int) {
+	// bail if not sending
+	if s.sendDisabled {
+		fmt.Println("Discarded result, send disabled.")
+		return
+	}
+
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	if s.dataClosed {
+		log.Printf("Discarded result, shutting down")
+		s.markSent(decoded, fcstore.Failed)
+		return
+	}
+
+	select {
+	case s.dataChan <- decoded:
+		s.markSent(decoded, fcstore.Pending)
+	default:
+		fmt.Println("Discarded result channel full.")
+		s.markSent(decoded, fcstore.Failed)
+	}
+
+	// send zero length buffer to drop connection
+	select {
+	case s.dataChan <- make([]byte, 0):
+	default:
+		fmt.Println("Failed to send inter frame marker.")
+	}
+
+	if s.config.HubAddress != "" {
+		s.queueRecord(decoded, errorCount)
+	}
+}
+
+// markSent records the status of the frame's sending to every connect location in the store
+func (s *Service) markSent(decoded []byte, status fcstore.UploadStatus) {
+	if s.store == nil {
+		return
+	}
+	for _, loc := range s.locations {
+		s.markUpload(decoded, loc, status)
+	}
+}
+
+// markUnsent records the frames left on a connect location's channel when its sender gave up as
+// failed
+func (s *Service) markUnsent(ch chan []byte, destLoc string) {
+	for {
+		select {
+		case data, ok := <-ch:
+			if !ok {
+				return
+			}
+			if len(data) > 0 {
+				s.markUpload(data, destLoc, fcstore.Failed)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *Service) markUpload(decoded []byte, destLoc string, status fcstore.UploadStatus) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.SetFrameUpload(decoded, destLoc, status); err != nil {
+		log.Printf("Failed to store upload status: %v", err)
+	}
+}
+
+// queueRecord queues the frame for the hub, followed by an inter frame marker, the data lock held
+func (s *Service) queueRecord(decoded []byte, errorCount int) {
+	record, err := fcio.StationRecord{
+		Station: s.config.HubStation,
+		Time:    time.Now(),
+		Errors:  errorCount,
+		Frame:   decoded,
+	}.Encode([]byte(s.config.HubKey))
+	if err != nil {
+		log.Printf("Discarded result for the hub: %v", err)
+		return
+	}
+	select {
+	case s.hubChan <- record:
+	default:
+		log.Printf("Discarded result for the hub, channel full")
+		return
+	}
+	select {
+	case s.hubChan <- make([]byte, 0):
+	default:
+		log.Printf("Failed to send inter frame marker to the hub")
+	}
+}
+
+// closeDataChannel called once the decoder has stopped, anything decoded after is discarded
+func (s *Service) closeDataChannel() {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	if !s.dataClosed {
+		s.dataClosed = true
+		close(s.dataChan)
+		close(s.hubChan)
+	}
+}
+
+// cloneDataChannel copies everything from srcChan to the destChans, one for each connect location,
+// closing them once srcChan is closed
+func (s *Service) cloneDataChannel(srcChan chan []byte, destChans []chan []byte) {
+	defer func() {
+		for _, dest := range destChans {
+			close(dest)
+		}
+	}()
+	for {
+		// take data off the source channel, block until there's something to read
+		fmt.Print("v")
+		data, ok := <-srcChan
+		if !ok {
+			return
+		}
+		fmt.Print("^")
+		//send it to all the dest channels (dont block if channel full)
+		for i, dest := range destChans {
+			select {
+			case dest <- data:
+			default:
+				fmt.Print("x")
+				if len(data) > 0 {
+					s.markUpload(data, s.locations[i], fcstore.Failed)
+				}
+				continue
+			}
+			fmt.Print("+")
+		}
+	}
+}
+
+// sendData sends the frames to destLoc until srcChan is closed and empty or ctx is done
+func (s *Service) sendData(ctx context.Context, srcChan chan []byte, destLoc string) {
+	log.Println("Starting send worker for:", destLoc)
+
+	dst, err := fcio.NewReconnectingWriter(ctx, destLoc, fcio.WriterOptions{
+		WriteTimeout: fcio.DefaultWriteTimeout,
+		MinBackoff:   5 * time.Second,
+		MaxBackoff:   120 * time.Second,
+		TLS:          s.tlsClient,
+		OnState:      fcio.LogConnEvent,
+	})
+	if err != nil {
+		log.Println("Failed to create writer:", err)
+		return
+	}
+	defer dst.Close()
+
+	for {
+		var data []byte
+		var ok bool
+		select {
+		case data, ok = <-srcChan:
+			if !ok {
+				log.Println("All frames sent to:", destLoc)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		fmt.Printf("-")
+
+		// a zero byte buffer on the channel is an inter frame marker
+		// so drop the connection
+		if len(data) == 0 {
+			dst.EndFrame()
+			fmt.Printf("|")
+			continue
+		}
+
+		// only fails once ctx is done, failed writes are retried over a new connection
+		if _, err := dst.Write(data); err != nil {
+			if destLoc != s.config.HubAddress {
+				s.markUpload(data, destLoc, fcstore.Failed)
+			}
+			return
+		}
+		fmt.Printf(">")
+		if destLoc != s.config.HubAddress {
+			s.markUpload(data, destLoc, fcstore.Uploaded)
+		}
+	}
+}
+
+// Response wraps the data served by the api
+type Response struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// serveStats serves the api until ctx is done
+func (s *Service) serveStats(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.CommandPort))
+	log.Println("Opening command listen socket...")
+
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		log.Printf("Command listen socket failed: %v", err)
+		return
+	}
+	server := &http.Server{Handler: s.router()}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(lsock) }()
+
+	select {
+	case err := <-served:
+		log.Printf("Command listen socket failed: %v", err)
+		return
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to stop command listen socket: %v", err)
+	}
+	<-served
+}
+
+func (s *Service) router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Logger())
+	r.Use(gin.Recovery())
+
+	apiv1 := r.Group("/api/v1")
+	apiv1.Use()
+	{
+		apiv1.GET("/stats", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.stats,
+			})
+		})
+		apiv1.GET("/devices", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.dongle.AudioDevices(),
+			})
+		})
+		apiv1.GET("/dongle", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.dongle.Status(),
+			})
+		})
+		apiv1.GET("/workers", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.dongle.Workers(),
+			})
+		})
+		apiv1.GET("/dedupe", func(c *gin.Context) {
+			var stats fcio.DedupeStats
+			if s.dedupe != nil {
+				stats = s.dedupe.Stats()
+			}
+			c.JSON(200, Response{
+				Data: stats,
+			})
+		})
+		apiv1.GET("/nativelog", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.nativeLog.Status(),
+			})
+		})
+		apiv1.GET("/store", func(c *gin.Context) {
+			if s.store == nil {
+				c.JSON(404, Response{Error: "no store configured"})
+				return
+			}
+			c.JSON(200, Response{
+				Data: s.store.Stats(),
+			})
+		})
+		apiv1.GET("/frames", func(c *gin.Context) {
+			if s.store == nil {
+				c.JSON(404, Response{Error: "no store configured"})
+				return
+			}
+			query, err := fcstore.ParseQuery(c.Request.URL.Query())
+			if err != nil {
+				c.JSON(400, Response{Error: err.Error()})
+				return
+			}
+			c.JSON(200, Response{
+				Data: s.store.Query(query),
+			})
+		})
+		apiv1.GET("/frames/:id", func(c *gin.Context) {
+			if s.store == nil {
+				c.JSON(404, Response{Error: "no store configured"})
+				return
+			}
+			id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+			if err != nil {
+				c.JSON(400, Response{Error: "invalid id"})
+				return
+			}
+			record, ok := s.store.Get(id)
+			if !ok {
+				c.JSON(404, Response{Error: "no such frame"})
+				return
+			}
+			c.JSON(200, Response{
+				Data: record,
+			})
+		})
+	}
+	return r
+}
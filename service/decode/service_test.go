@@ -0,0 +1,360 @@
+package decode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/fclib"
+	"github.com/funcube-dev/go/fcstore"
+	"github.com/funcube-dev/go/internal/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfig sends to location from a fake dongle, listening on a loopback ephemeral port
+func testConfig(location string) Config {
+	config := DefaultConfig()
+	config.ConnectAddress = ""
+	config.ConnectLocations = []string{location}
+	config.BindAddress = "127.0.0.1"
+	config.CommandPort = 0
+	config.Library = &fakeDongle{present: true, devices: testDevices, fft: []float32{1, 2, 3}}
+	return config
+}
+
+func TestRun_SendsDecodedFrames(t *testing.T) {
+	before := runtime.NumGoroutine()
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+
+	config := testConfig(lsock.Addr().String())
+	fd := config.Library.(*fakeDongle)
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+
+	testutil.WaitFor(t, "dongle running", 5*time.Second, func() bool { return s.dongle.Status().State == DongleRunning })
+	frame := bytes.Repeat([]byte{0xfc}, 256)
+	fd.decode(frame)
+	c, err := lsock.Accept()
+	if !assert.NoError(t, err) {
+		cancel()
+		<-result
+		return
+	}
+	received, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, frame, received, "connection dropped at the inter frame marker")
+	c.Close()
+
+	cancel()
+	assert.NoError(t, <-result)
+	assert.Equal(t, DongleStopped, s.dongle.Status().State)
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestRun_StoresFrames(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	before := runtime.NumGoroutine()
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+
+	location := lsock.Addr().String()
+	config := testConfig(location)
+	config.Store = fcstore.Config{Path: filepath.Join(t.TempDir(), "frames.log"), Station: "G0ABC"}
+	fd := config.Library.(*fakeDongle)
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+
+	testutil.WaitFor(t, "dongle running", 5*time.Second, func() bool { return s.dongle.Status().State == DongleRunning })
+	frame := bytes.Repeat([]byte{0x85}, 256)
+	fd.decodeEvent(fclib.DecodeEvent{Time: supervisorEpoch, Worker: 1, Frequency: 145936000, Errors: 2, Data: frame})
+	c, err := lsock.Accept()
+	if assert.NoError(t, err) {
+		_, _ = ioutil.ReadAll(c)
+		c.Close()
+	}
+	testutil.WaitFor(t, "frame sent", 5*time.Second, func() bool {
+		record, _ := s.store.Find(frame)
+		return record.Uploads[location] == fcstore.Uploaded
+	})
+
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/frames?satellite=2&frametype=5&status=uploaded", nil))
+	var response struct {
+		Data []fcstore.Record `json:"data"`
+	}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response)) && assert.Len(t, response.Data, 1) {
+		assert.Equal(t, fcstore.Record{
+			ID:        1,
+			Received:  supervisorEpoch,
+			Station:   "G0ABC",
+			Satellite: 2,
+			FrameType: 5,
+			Frequency: 145936000,
+			Errors:    2,
+			Uploads:   map[string]fcstore.UploadStatus{location: fcstore.Uploaded},
+			Frame:     frame,
+		}, response.Data[0])
+	}
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/frames?from=today", nil))
+	assert.Equal(t, 400, w.Code)
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/frames/2", nil))
+	assert.Equal(t, 404, w.Code)
+
+	cancel()
+	assert.NoError(t, <-result)
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestRun_MarksUnsentFailed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// nothing listening, the frames are never sent
+	lsock, _ := net.Listen("tcp4", "127.0.0.1:0")
+	location := lsock.Addr().String()
+	lsock.Close()
+
+	config := testConfig(location)
+	config.ShutdownTimeout = 0.5
+	config.Store = fcstore.Config{Path: filepath.Join(t.TempDir(), "frames.log"), Station: "G0ABC"}
+	fd := config.Library.(*fakeDongle)
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+
+	testutil.WaitFor(t, "dongle running", 5*time.Second, func() bool { return s.dongle.Status().State == DongleRunning })
+	frames := [][]byte{bytes.Repeat([]byte{0x85}, 256), bytes.Repeat([]byte{0x86}, 256)}
+	for _, frame := range frames {
+		fd.decodeEvent(fclib.DecodeEvent{Time: supervisorEpoch, Data: frame})
+	}
+	testutil.WaitFor(t, "frames queued", 5*time.Second, func() bool { return s.store.Stats().Frames == 2 })
+	cancel()
+	<-result
+
+	// the frame being sent and the one still queued
+	records, err := fcstore.Read(config.Store.Path, fcstore.Query{})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		for _, r := range records {
+			assert.Equal(t, map[string]fcstore.UploadStatus{location: fcstore.Failed}, r.Uploads)
+		}
+	}
+}
+
+func TestCloneDataChannel_MarksDiscardedFailed(t *testing.T) {
+	config := testConfig("127.0.0.1:1")
+	config.Store = fcstore.Config{Path: filepath.Join(t.TempDir(), "frames.log")}
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.store.Close()
+	frame := bytes.Repeat([]byte{0x85}, 256)
+	s.onFrame(fclib.DecodeEvent{Data: frame})
+	record, _ := s.store.Find(frame)
+	assert.Equal(t, fcstore.Pending, record.Uploads["127.0.0.1:1"])
+
+	// nobody reading the location's channel
+	close(s.dataChan)
+	s.cloneDataChannel(s.dataChan, []chan []byte{make(chan []byte)})
+	record, _ = s.store.Find(frame)
+	assert.Equal(t, fcstore.Failed, record.Uploads["127.0.0.1:1"])
+}
+
+func TestShutdown_DrainsFrames(t *testing.T) {
+	before := runtime.NumGoroutine()
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+
+	s, err := New(testConfig(lsock.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := bytes.Repeat([]byte{0xfc}, 256)
+	s.queueFrame(frame, 0)
+
+	drainCtx, abandon := context.WithCancel(context.Background())
+	defer abandon()
+	var outputs fcio.Group
+	destChan := make(chan []byte, 64)
+	outputs.Go(func() { s.sendData(drainCtx, destChan, lsock.Addr().String()) })
+	outputs.Go(func() { s.cloneDataChannel(s.dataChan, []chan []byte{destChan}) })
+
+	// the decoder has stopped, what it decoded is still sent and anything later discarded
+	s.closeDataChannel()
+	s.closeDataChannel()
+	s.queueFrame(frame, 0)
+	c, err := lsock.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	received, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, frame, received)
+	c.Close()
+
+	assert.True(t, fcio.Drain(5*time.Second, time.Second, abandon, &outputs))
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestOnFrame_Dedupe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := testConfig("127.0.0.1:1")
+	config.DedupeWindow = 60
+	config.Store = fcstore.Config{Path: filepath.Join(t.TempDir(), "frames.log")}
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.store.Close()
+	frame := bytes.Repeat([]byte{0xfc}, 256)
+	s.onFrame(fclib.DecodeEvent{Worker: 0, Frequency: 145935000, Errors: 4, Data: frame})
+	s.onFrame(fclib.DecodeEvent{Worker: 1, Frequency: 145936000, Errors: 1, Data: append([]byte(nil), frame...)})
+	assert.Empty(t, s.dataChan, "held for copies from the other workers")
+	assert.Equal(t, 0, s.store.Stats().Frames)
+
+	// the decoder has stopped, what is held is sent and only that copy stored
+	s.dedupe.Flush()
+	assert.Equal(t, frame, <-s.dataChan)
+	assert.Empty(t, <-s.dataChan, "inter frame marker")
+	assert.Empty(t, s.dataChan)
+	records := s.store.Query(fcstore.Query{})
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, float64(145936000), records[0].Frequency)
+		assert.Equal(t, 1, records[0].Errors)
+		assert.Equal(t, map[string]fcstore.UploadStatus{"127.0.0.1:1": fcstore.Pending}, records[0].Uploads)
+	}
+
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/dedupe", nil))
+	var response struct {
+		Data fcio.DedupeStats `json:"data"`
+	}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response)) {
+		assert.Equal(t, fcio.DedupeStats{Frames: 2, Forwarded: 1, Suppressed: 1, Replaced: 1}, response.Data)
+	}
+}
+
+func TestSendData_Abandoned(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// nothing listening, sendData is backing off when the deadline passes
+	lsock, _ := net.Listen("tcp4", "127.0.0.1:0")
+	addr := lsock.Addr().String()
+	lsock.Close()
+
+	s, _ := New(testConfig(addr))
+	srcChan := make(chan []byte, 1)
+	srcChan <- bytes.Repeat([]byte{1}, 256)
+	drainCtx, abandon := context.WithCancel(context.Background())
+	var outputs fcio.Group
+	outputs.Go(func() { s.sendData(drainCtx, srcChan, addr) })
+	time.Sleep(500 * time.Millisecond)
+
+	abandon()
+	assert.True(t, outputs.Wait(time.Second))
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestServeStats_Shutdown(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s, _ := New(testConfig("127.0.0.1:1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var inputs fcio.Group
+	inputs.Go(func() { s.serveStats(ctx) })
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	assert.True(t, inputs.Wait(5*time.Second))
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestNew_Locations(t *testing.T) {
+	config := DefaultConfig()
+	config.ConnectLocations = []string{"warehouse:64518"}
+	s, err := New(config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"warehouse:64518", "encodeserver:64514"}, s.locations)
+	assert.False(t, s.sendDisabled)
+
+	// IPv6 locations are bracketed, a connectaddress may be or not
+	config.ConnectAddress = "::1"
+	config.ConnectLocations = []string{"[2001:db8::1]:64518"}
+	s, err = New(config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[2001:db8::1]:64518", "[::1]:64514"}, s.locations)
+	config.ConnectLocations = []string{"2001:db8::1:64518"}
+	_, err = New(config)
+	assert.Error(t, err, "ambiguous without brackets")
+
+	config.ConnectAddress = ""
+	config.ConnectLocations = nil
+	s, err = New(config)
+	assert.NoError(t, err)
+	assert.Empty(t, s.locations)
+	assert.True(t, s.sendDisabled)
+}
+
+func TestNativeLogEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := testConfig("127.0.0.1:1")
+	config.NativeLog.Level = "warning"
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.nativeLog.Handle(int(fclib.LogWarning), "ALSA lib confmisc.c:767: Unable to open card\n")
+	s.nativeLog.Handle(int(fclib.LogInfo), "Decoder started")
+
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/nativelog", nil))
+	assert.Equal(t, 200, w.Code)
+
+	var response struct {
+		Data fclib.LogStatus `json:"data"`
+	}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response)) {
+		assert.Equal(t, "warning", response.Data.Level)
+		assert.Equal(t, fclib.LogStats{Logged: 1, Filtered: 1}, response.Data.Stats)
+		if assert.Len(t, response.Data.Recent, 1) {
+			assert.Equal(t, "ALSA lib confmisc.c:767: Unable to open card", response.Data.Recent[0].Message)
+		}
+	}
+
+	config.NativeLog.Level = "verbose"
+	_, err = New(config)
+	assert.Error(t, err)
+}
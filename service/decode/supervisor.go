@@ -0,0 +1,538 @@
+package decode
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/fclib"
+)
+
+// Dongle states reported by the supervisor
+const (
+	DongleStarting   = "starting"
+	DongleRunning    = "running"
+	DongleFailed     = "failed"
+	DongleRecovering = "recovering"
+	DongleStopped    = "stopped"
+)
+
+// DongleLibrary the library calls the supervisor makes, the FUNcubeLib unless the config has another
+type DongleLibrary interface {
+	DongleInitialize() bool
+	DongleExists() bool
+	DongleGetFrequency() uint32
+	DongleSetFrequency(frequency uint32) bool
+	DongleBiasT(enable bool)
+	DongleShutdown()
+	DecodeInitialize() bool
+	// DecodeSetCallback sets the function called with each frame decoded
+	DecodeSetCallback(ready func(event fclib.DecodeEvent))
+	DecodeListDevices() []fclib.AudioDevice
+	DecodeSetWorkerCount(workers uint32) bool
+	DecodeExcludePeaks(frequencies []float32) bool
+	// DecodeWorkerPeaks the peak each decode worker is on
+	DecodeWorkerPeaks() []float32
+	// DecodeWorkerAvailability 0 for each decode worker on its peak, otherwise it is free
+	DecodeWorkerAvailability() []uint
+	DecodeStart(audioIn, audioOut int) bool
+	DecodeIsStarted() bool
+	DecodeFftOutput() []float32
+	// DecodeStreamSamples a stream of the raw IQ samples the decoder sees, holding up to capacity
+	DecodeStreamSamples(capacity int) *fclib.SampleStream
+	DecodeStop()
+	DecodeShutdown()
+}
+
+// dongleSettings applied each time the dongle and decoder are started
+type dongleSettings struct {
+	Frequency      uint32    `json:"frequency"`
+	BiasT          bool      `json:"biasT"`
+	Workers        uint32    `json:"workers"`
+	Exclude        []float32 `json:"exclude"`
+	AudioDeviceIn  string    `json:"audioDeviceIn"`
+	AudioDeviceOut string    `json:"audioDeviceOut"`
+	Dongle         string    `json:"dongle"`
+}
+
+// DongleStatus snapshot of the supervisor state
+type DongleStatus struct {
+	State       string         `json:"state"`
+	LastError   string         `json:"lastError,omitempty"`
+	Restarts    int            `json:"restarts"`
+	Failures    int            `json:"failures"`
+	Since       time.Time      `json:"since"`
+	LastCheck   time.Time      `json:"lastCheck"`
+	NextAttempt time.Time      `json:"nextAttempt,omitempty"`
+	Settings    dongleSettings `json:"settings"`
+}
+
+// supervisor starts the dongle and decoder, checks they keep running and producing fft output,
+// and on failure shuts them down and starts them again with the last known settings, backing off
+// between attempts
+type supervisor struct {
+	mu           sync.Mutex
+	lib          DongleLibrary
+	onFrame      func(event fclib.DecodeEvent)
+	settings     dongleSettings
+	workers      *workerController // nil leaves the workers on the peaks they find
+	status       DongleStatus
+	devices      AudioDeviceList
+	stepDelay    time.Duration // pause between library calls, the dongle needs a moment
+	stallTimeout time.Duration // fft output unchanged this long is a failure, 0 never
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	backoff      time.Duration
+	started      bool
+	fftSum       uint32
+	fftChanged   time.Time
+}
+
+func newSupervisor(lib DongleLibrary, settings dongleSettings, onFrame func(event fclib.DecodeEvent)) *supervisor {
+	return &supervisor{
+		lib:          lib,
+		onFrame:      onFrame,
+		settings:     settings,
+		status:       DongleStatus{State: DongleStarting, Since: time.Now()},
+		devices:      AudioDeviceList{Input: -1, Output: -1},
+		stepDelay:    time.Millisecond * 50,
+		stallTimeout: time.Second * 30,
+		minBackoff:   time.Second * 5,
+		maxBackoff:   time.Second * 120,
+	}
+}
+
+// Status returns a snapshot of the supervisor state
+func (s *supervisor) Status() DongleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Settings = s.settings
+	status.Settings.Exclude = append([]float32(nil), s.settings.Exclude...)
+	return status
+}
+
+// Workers a snapshot of the decode workers, empty unless they are managed
+func (s *supervisor) Workers() WorkersStatus {
+	if s.workers == nil {
+		return WorkersStatus{Excluded: []float32{}, Workers: []WorkerStatus{}}
+	}
+	return s.workers.Status()
+}
+
+// AudioDevices the audio devices found when the decoder was last started and the ones it uses
+func (s *supervisor) AudioDevices() AudioDeviceList {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.devices
+}
+
+// run starts the dongle and decoder then checks them every interval, restarting them on failure,
+// once ctx is done they are shut down and run returns
+func (s *supervisor) run(ctx context.Context, interval time.Duration) {
+	for ctx.Err() == nil {
+		s.step(time.Now())
+		fcio.Sleep(ctx, interval)
+	}
+	s.shutdown(time.Now())
+}
+
+// shutdown stops the decoder and dongle for good, nothing more is decoded once it returns
+func (s *supervisor) shutdown(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		s.stop()
+	}
+	s.status.NextAttempt = time.Time{}
+	s.setState(now, DongleStopped)
+}
+
+// step makes one check or start attempt, retries wait for the backoff
+func (s *supervisor) step(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastCheck = now
+
+	if s.started {
+		err := s.check(now)
+		if err == nil {
+			s.balanceWorkers(now)
+			return
+		}
+		s.fail(now, err)
+		s.stop()
+		s.setState(now, DongleRecovering)
+		return
+	}
+
+	if now.Before(s.status.NextAttempt) {
+		return
+	}
+	if err := s.start(); err != nil {
+		s.stop()
+		s.fail(now, err)
+		return
+	}
+	if s.status.State != DongleStarting {
+		s.status.Restarts++
+		log.Printf("*** Recovered FUNcube Dongle after: %s ***", s.status.LastError)
+	}
+	s.started = true
+	s.backoff = 0
+	s.status.NextAttempt = time.Time{}
+	s.fftChanged = now
+	s.setState(now, DongleRunning)
+}
+
+func (s *supervisor) fail(now time.Time, err error) {
+	// double the wait after each failure in a row
+	if s.backoff *= 2; s.backoff < s.minBackoff {
+		s.backoff = s.minBackoff
+	} else if s.backoff > s.maxBackoff {
+		s.backoff = s.maxBackoff
+	}
+	s.status.Failures++
+	s.status.LastError = err.Error()
+	s.status.NextAttempt = now.Add(s.backoff)
+	if s.status.State != DongleRecovering {
+		s.setState(now, DongleFailed)
+	}
+	log.Printf("*** FUNcube Dongle failure: %v, retry in %v ***", err, s.backoff)
+}
+
+func (s *supervisor) setState(now time.Time, state string) {
+	if s.status.State != state {
+		s.status.State = state
+		s.status.Since = now
+	}
+}
+
+// check tests the dongle is present, the decoder running and the fft output still changing
+func (s *supervisor) check(now time.Time) error {
+	if !s.lib.DongleExists() {
+		return errors.New("FUNcube Dongle not found")
+	}
+	if !s.lib.DecodeIsStarted() {
+		return errors.New("decoder stopped")
+	}
+	// remember the frequency so a restart retunes to it
+	if frequency := s.lib.DongleGetFrequency(); frequency != 0 {
+		s.settings.Frequency = frequency
+	}
+
+	if s.stallTimeout <= 0 {
+		return nil
+	}
+	fft := s.lib.DecodeFftOutput()
+	if sum := fftChecksum(fft); sum != s.fftSum && !allZero(fft) {
+		s.fftSum = sum
+		s.fftChanged = now
+	} else if now.Sub(s.fftChanged) >= s.stallTimeout {
+		return fmt.Errorf("fft output stalled for %v", now.Sub(s.fftChanged).Round(time.Second))
+	}
+	return nil
+}
+
+// start initialises the dongle and decoder in the order the library needs
+func (s *supervisor) start() error {
+	if !s.lib.DongleInitialize() || !s.lib.DongleExists() {
+		return errors.New("failed to initialise FUNcube Dongle, check it is plugged in (maybe try a powered usb hub) " +
+			"and the docker container is started with --privileged for device access")
+	}
+	s.pause()
+	log.Println("Found and Initialised FUNcube Dongle.")
+
+	if !s.lib.DecodeInitialize() {
+		return errors.New("failed to initialise decode workers")
+	}
+	s.pause()
+	log.Println("Initialised Decode workers.")
+
+	s.lib.DecodeSetCallback(s.decoded)
+	s.pause()
+	log.Println("Set decode callback function.")
+
+	if !s.lib.DongleSetFrequency(s.settings.Frequency) {
+		return fmt.Errorf("failed to set FUNcube Dongle frequency:%dHz", s.settings.Frequency)
+	}
+	s.pause()
+	log.Printf("Set FUNcube Dongle frequency:%dHz\n", s.settings.Frequency)
+
+	s.lib.DongleBiasT(s.settings.BiasT)
+	if s.settings.BiasT {
+		log.Println("Set FUNcube Dongle 5V Bias-T ON")
+	} else {
+		log.Println("Set FUNcube Dongle 5V Bias-T OFF")
+	}
+	s.pause()
+
+	// audio device indices can change when the dongle is plugged back in, so match the names again
+	devices := s.lib.DecodeListDevices()
+	idAudioIn, err := resolveAudioDevice(devices, s.settings.AudioDeviceIn, true)
+	if err != nil {
+		return fmt.Errorf("failed to select audio in device: %v", err)
+	}
+	idAudioOut, err := resolveAudioDevice(devices, s.settings.AudioDeviceOut, false)
+	if err != nil {
+		return fmt.Errorf("failed to select audio out device: %v", err)
+	}
+	if s.settings.Dongle != "" {
+		d, err := findDevice(devices, s.settings.Dongle, true)
+		if err != nil {
+			return fmt.Errorf("failed to select FUNcube Dongle: %v", err)
+		}
+		log.Printf("Selected FUNcube Dongle audio device %d: %q", d.Index, d.Name)
+		idAudioIn = d.Index
+		if countDongles(devices) > 1 {
+			log.Println("Several FUNcube Dongles found, frequency and Bias-T settings apply to the first one the library opened")
+		}
+	}
+	s.devices = AudioDeviceList{Devices: devices, Input: idAudioIn, Output: idAudioOut}
+	log.Printf("Using audio in device:%d out device:%d", idAudioIn, idAudioOut)
+
+	if !s.lib.DecodeSetWorkerCount(s.settings.Workers) {
+		return fmt.Errorf("failed to set number of decode workers, requested: %d workers", s.settings.Workers)
+	}
+	if exclude := s.exclusions(); len(exclude) > 0 {
+		if !s.lib.DecodeExcludePeaks(exclude) {
+			return fmt.Errorf("failed to exclude frequencies: %v", exclude)
+		}
+		log.Printf("Excluded frequencies: %v", exclude)
+	}
+	if s.workers != nil {
+		s.workers.reset()
+	}
+
+	log.Println("*** Starting decode workers (may produce a few ALSA errors, just ignore!) ***")
+	if !s.lib.DecodeStart(idAudioIn, idAudioOut) {
+		return errors.New("failed to start decode workers")
+	}
+	log.Println("*** Started decode workers, waiting for packet decodes ***")
+	return nil
+}
+
+// stop shuts the decoder and dongle down in the reverse order to start
+func (s *supervisor) stop() {
+	if s.started {
+		log.Println("Stopping decode workers and FUNcube Dongle")
+	}
+	s.started = false
+	s.lib.DecodeStop()
+	s.lib.DecodeShutdown()
+	s.lib.DongleShutdown()
+	s.pause()
+}
+
+// decoded counts the decode against its worker and hands on the frame, called by the library so
+// never takes the lock, DecodeShutdown waits for it
+func (s *supervisor) decoded(event fclib.DecodeEvent) {
+	if s.workers != nil {
+		s.workers.decoded(event)
+	}
+	s.onFrame(event)
+}
+
+// balanceWorkers releases the workers on peaks producing no decodes, by excluding those peaks
+// alongside the ones configured
+func (s *supervisor) balanceWorkers(now time.Time) {
+	if s.workers == nil {
+		return
+	}
+	peaks := s.lib.DecodeWorkerPeaks()
+	available := s.lib.DecodeWorkerAvailability()
+	if !s.workers.poll(now, peaks, available) {
+		return
+	}
+	if exclude := s.exclusions(); !s.lib.DecodeExcludePeaks(exclude) {
+		log.Printf("Failed to exclude frequencies: %v", exclude)
+	}
+}
+
+// exclusions the frequencies configured and the peaks the workers were released from
+func (s *supervisor) exclusions() []float32 {
+	exclude := append([]float32(nil), s.settings.Exclude...)
+	if s.workers != nil {
+		exclude = append(exclude, s.workers.exclusions()...)
+	}
+	return exclude
+}
+
+func (s *supervisor) pause() {
+	time.Sleep(s.stepDelay)
+}
+
+func fftChecksum(fft []float32) uint32 {
+	h := fnv.New32a()
+	var b [4]byte
+	for _, v := range fft {
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+		h.Write(b[:])
+	}
+	return h.Sum32()
+}
+
+func allZero(fft []float32) bool {
+	for _, v := range fft {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// fclibDongle DongleLibrary backed by the FUNcubeLib
+type fclibDongle struct {
+	callbackSet  bool
+	subscription *fclib.DecodeSubscription
+	delivered    chan struct{}
+}
+
+func (fd *fclibDongle) DongleInitialize() bool {
+	return fclib.Dongle_Initialize() == 1
+}
+
+func (fd *fclibDongle) DongleExists() bool {
+	return fclib.Dongle_Exists() == 1
+}
+
+func (fd *fclibDongle) DongleGetFrequency() uint32 {
+	return fclib.Dongle_GetFrequency()
+}
+
+func (fd *fclibDongle) DongleSetFrequency(frequency uint32) bool {
+	return fclib.Dongle_SetFrequency(frequency) == 1
+}
+
+func (fd *fclibDongle) DongleBiasT(enable bool) {
+	if enable {
+		fclib.Dongle_BiasTEnable(1)
+	} else {
+		fclib.Dongle_BiasTEnable(0)
+	}
+}
+
+func (fd *fclibDongle) DongleShutdown() {
+	fclib.Dongle_Shutdown()
+}
+
+func (fd *fclibDongle) DecodeInitialize() bool {
+	return fclib.Decode_Initialize() == 1
+}
+
+// DecodeSetCallback subscribes to the decode events, ready is called with each one until
+// DecodeShutdown, after a restart the library needs the callback again
+func (fd *fclibDongle) DecodeSetCallback(ready func(event fclib.DecodeEvent)) {
+	if fd.subscription == nil {
+		fd.subscription = fclib.Decode_Subscribe(decodeBuffer)
+		fd.delivered = make(chan struct{})
+		go deliverDecodes(fd.subscription, fd.delivered, ready)
+	}
+	if !fd.callbackSet {
+		fd.callbackSet = true
+		return
+	}
+	fclib.Callback_Reattach()
+}
+
+// decodeBuffer decode events held for the sender, those decoded while it is full are dropped
+const decodeBuffer = 64
+
+// deliverDecodes calls ready with each frame decoded until the subscription ends
+func deliverDecodes(subscription *fclib.DecodeSubscription, delivered chan struct{}, ready func(event fclib.DecodeEvent)) {
+	defer close(delivered)
+	for event := range subscription.Events() {
+		fmt.Printf("Decoded Frequency: %.2fHz  Worker: %d  Error Count: %d  data: % x\n", event.Frequency, event.Worker, event.Errors, event.Data)
+		ready(event)
+	}
+	if dropped := subscription.Dropped(); dropped > 0 {
+		log.Printf("Dropped %d decoded frames, the sender fell behind", dropped)
+	}
+}
+
+func (fd *fclibDongle) DecodeListDevices() []fclib.AudioDevice {
+	return listAudioDevices()
+}
+
+func (fd *fclibDongle) DecodeSetWorkerCount(workers uint32) bool {
+	return fclib.Decode_SetWorkerCount(workers) == 1
+}
+
+// DecodeExcludePeaks replaces the frequencies excluded, none clears them
+func (fd *fclibDongle) DecodeExcludePeaks(frequencies []float32) bool {
+	count := uint32(len(frequencies))
+	exclude := append(make([]float32, 0, 1), frequencies...)
+	return fclib.Decode_ExcludePeaks(&exclude[:1][0], &count) == 1
+}
+
+func (fd *fclibDongle) DecodeWorkerPeaks() []float32 {
+	peaks := make([]float32, maxWorkers)
+	count := uint32(len(peaks))
+	if fclib.Decode_GetWorkerPeaks(&peaks[0], &count) != 1 {
+		return nil
+	}
+	if int(count) < len(peaks) {
+		peaks = peaks[:count]
+	}
+	return peaks
+}
+
+func (fd *fclibDongle) DecodeWorkerAvailability() []uint {
+	available := make([]uint, maxWorkers)
+	count := uint32(len(available))
+	if fclib.Decode_GetWorkerAvailability(&available[0], &count) != 1 {
+		return nil
+	}
+	if int(count) < len(available) {
+		available = available[:count]
+	}
+	return available
+}
+
+// maxWorkers the most decode workers the library reports on
+const maxWorkers = 16
+
+func (fd *fclibDongle) DecodeStart(audioIn, audioOut int) bool {
+	return fclib.Decode_StartByIndex(audioIn, audioOut, 1, 1) == 1
+}
+
+func (fd *fclibDongle) DecodeIsStarted() bool {
+	return fclib.Decode_IsStarted() == 1
+}
+
+func (fd *fclibDongle) DecodeFftOutput() []float32 {
+	fft := make([]float32, 4096)
+	size := uint32(len(fft))
+	if fclib.Decode_CollectFftOutput(&fft[0], &size) != 1 {
+		return nil
+	}
+	if int(size) < len(fft) {
+		fft = fft[:size]
+	}
+	return fft
+}
+
+func (fd *fclibDongle) DecodeStreamSamples(capacity int) *fclib.SampleStream {
+	return fclib.Decode_StreamSamples(capacity)
+}
+
+func (fd *fclibDongle) DecodeStop() {
+	fclib.Decode_Stop()
+}
+
+// DecodeShutdown shuts the decoder down and ends the subscription once its frames are delivered
+func (fd *fclibDongle) DecodeShutdown() {
+	fclib.Decode_Shutdown()
+	if fd.subscription != nil {
+		fd.subscription.Unsubscribe()
+		<-fd.delivered
+		fd.subscription = nil
+	}
+}
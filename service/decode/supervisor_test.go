@@ -0,0 +1,236 @@
+package decode
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/fclib"
+	"github.com/funcube-dev/go/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDongle DongleLibrary that records what the supervisor asks of it
+type fakeDongle struct {
+	present     bool
+	started     bool
+	frequency   uint32
+	biasT       bool
+	workers     uint32
+	exclude     []float32
+	audioIn     int
+	audioOut    int
+	fft         []float32
+	starts      int
+	shutdowns   int
+	devices     []fclib.AudioDevice
+	callbackSet int
+	stops       []string
+	peaks       []float32
+	available   []uint
+
+	readyMutex sync.Mutex
+	ready      func(event fclib.DecodeEvent)
+	samples    *fclib.SampleStream
+}
+
+func (fd *fakeDongle) DongleInitialize() bool     { return fd.present }
+func (fd *fakeDongle) DongleExists() bool         { return fd.present }
+func (fd *fakeDongle) DongleGetFrequency() uint32 { return fd.frequency }
+func (fd *fakeDongle) DongleSetFrequency(frequency uint32) bool {
+	fd.frequency = frequency
+	return true
+}
+func (fd *fakeDongle) DongleBiasT(enable bool) { fd.biasT = enable }
+func (fd *fakeDongle) DongleShutdown() {
+	fd.shutdowns++
+	fd.stops = append(fd.stops, "DongleShutdown")
+}
+func (fd *fakeDongle) DecodeInitialize() bool { return true }
+func (fd *fakeDongle) DecodeSetCallback(ready func(event fclib.DecodeEvent)) {
+	fd.readyMutex.Lock()
+	defer fd.readyMutex.Unlock()
+	fd.ready = ready
+	fd.callbackSet++
+}
+func (fd *fakeDongle) DecodeListDevices() []fclib.AudioDevice { return fd.devices }
+func (fd *fakeDongle) DecodeSetWorkerCount(workers uint32) bool {
+	fd.workers = workers
+	return true
+}
+func (fd *fakeDongle) DecodeExcludePeaks(frequencies []float32) bool {
+	fd.exclude = frequencies
+	return true
+}
+func (fd *fakeDongle) DecodeWorkerPeaks() []float32     { return fd.peaks }
+func (fd *fakeDongle) DecodeWorkerAvailability() []uint { return fd.available }
+func (fd *fakeDongle) DecodeStart(audioIn, audioOut int) bool {
+	fd.audioIn, fd.audioOut = audioIn, audioOut
+	fd.started = true
+	fd.starts++
+	return true
+}
+func (fd *fakeDongle) DecodeIsStarted() bool      { return fd.started }
+func (fd *fakeDongle) DecodeFftOutput() []float32 { return fd.fft }
+func (fd *fakeDongle) DecodeStreamSamples(capacity int) *fclib.SampleStream {
+	fd.readyMutex.Lock()
+	defer fd.readyMutex.Unlock()
+	fd.samples = fclib.NewSampleStream(capacity)
+	return fd.samples
+}
+func (fd *fakeDongle) DecodeStop() {
+	fd.started = false
+	fd.stops = append(fd.stops, "DecodeStop")
+}
+func (fd *fakeDongle) DecodeShutdown() { fd.stops = append(fd.stops, "DecodeShutdown") }
+
+// decode hands the frame to the callback as the library does once a frame is decoded
+func (fd *fakeDongle) decode(frame []byte) {
+	fd.decodeEvent(fclib.DecodeEvent{Time: time.Now(), Worker: -1, Data: frame})
+}
+
+func (fd *fakeDongle) decodeEvent(event fclib.DecodeEvent) {
+	fd.readyMutex.Lock()
+	defer fd.readyMutex.Unlock()
+	if fd.ready != nil {
+		fd.ready(event)
+	}
+}
+
+var supervisorEpoch = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func after(seconds int) time.Time {
+	return supervisorEpoch.Add(time.Duration(seconds) * time.Second)
+}
+
+func newTestSupervisor(fd *fakeDongle) *supervisor {
+	s := newSupervisor(fd, dongleSettings{
+		Frequency:      145935000,
+		BiasT:          true,
+		Workers:        5,
+		Exclude:        []float32{145936000},
+		AudioDeviceIn:  "hw:2,0",
+		AudioDeviceOut: "-1",
+	}, func(event fclib.DecodeEvent) {})
+	s.stepDelay = 0
+	s.stallTimeout = 30 * time.Second
+	return s
+}
+
+func TestSupervisor_Start(t *testing.T) {
+	fd := &fakeDongle{present: true, devices: testDevices, fft: []float32{1, 2, 3}}
+	s := newTestSupervisor(fd)
+
+	s.step(after(0))
+	assert.Equal(t, DongleRunning, s.Status().State)
+	assert.Equal(t, uint32(145935000), fd.frequency)
+	assert.True(t, fd.biasT)
+	assert.Equal(t, uint32(5), fd.workers)
+	assert.Equal(t, []float32{145936000}, fd.exclude)
+	assert.Equal(t, 2, fd.audioIn, "audio in matched by name")
+	assert.Equal(t, -1, fd.audioOut)
+	assert.Equal(t, 0, s.Status().Restarts)
+}
+
+func TestSupervisor_UnplugAndRecover(t *testing.T) {
+	fd := &fakeDongle{present: true, devices: testDevices, fft: []float32{1, 2, 3}}
+	s := newTestSupervisor(fd)
+	s.step(after(0))
+
+	// retuned while running, the restart should keep the new frequency
+	fd.frequency = 435100000
+	fd.fft = []float32{4, 5, 6}
+	s.step(after(5))
+	assert.Equal(t, DongleRunning, s.Status().State)
+
+	fd.present = false
+	s.step(after(10))
+	status := s.Status()
+	assert.Equal(t, DongleRecovering, status.State)
+	assert.Equal(t, "FUNcube Dongle not found", status.LastError)
+	assert.Equal(t, 1, fd.shutdowns)
+	assert.False(t, fd.started)
+
+	// still unplugged, backs off further
+	s.step(after(15))
+	assert.Equal(t, after(25), s.Status().NextAttempt)
+	assert.Equal(t, DongleRecovering, s.Status().State)
+
+	// plugged back in, on a different audio device index
+	fd.present = true
+	fd.devices = []fclib.AudioDevice{{Index: 5, Name: "FUNcube Dongle V2.0: USB Audio (hw:2,0)", IsInput: true}}
+	fd.frequency = 0
+	s.step(after(20))
+	assert.Equal(t, DongleRecovering, s.Status().State, "waits for the backoff")
+	s.step(after(25))
+	status = s.Status()
+	assert.Equal(t, DongleRunning, status.State)
+	assert.Equal(t, 1, status.Restarts)
+	assert.Equal(t, uint32(435100000), fd.frequency)
+	assert.Equal(t, 5, fd.audioIn)
+	assert.Equal(t, 2, fd.callbackSet)
+}
+
+func TestSupervisor_StartFailureBacksOff(t *testing.T) {
+	fd := &fakeDongle{devices: testDevices}
+	s := newTestSupervisor(fd)
+	s.maxBackoff = 20 * time.Second
+
+	s.step(after(0))
+	assert.Equal(t, DongleFailed, s.Status().State)
+	assert.Equal(t, after(5), s.Status().NextAttempt)
+	s.step(after(5))
+	assert.Equal(t, after(15), s.Status().NextAttempt)
+	s.step(after(15))
+	assert.Equal(t, after(35), s.Status().NextAttempt)
+	s.step(after(35))
+	assert.Equal(t, after(55), s.Status().NextAttempt, "capped at the max backoff")
+	assert.Equal(t, 4, s.Status().Failures)
+
+	fd.present = true
+	s.step(after(55))
+	assert.Equal(t, DongleRunning, s.Status().State)
+	assert.Equal(t, 1, s.Status().Restarts)
+}
+
+func TestSupervisor_FftStall(t *testing.T) {
+	fd := &fakeDongle{present: true, devices: testDevices, fft: []float32{1, 2, 3}}
+	s := newTestSupervisor(fd)
+	s.step(after(0))
+
+	s.step(after(10))
+	assert.Equal(t, DongleRunning, s.Status().State)
+	s.step(after(29))
+	assert.Equal(t, DongleRunning, s.Status().State)
+	s.step(after(40))
+	assert.Equal(t, DongleRecovering, s.Status().State)
+	assert.Contains(t, s.Status().LastError, "stalled")
+
+	// silence is a stall too
+	fd.fft = make([]float32, 3)
+	s.step(after(45))
+	assert.Equal(t, DongleRunning, s.Status().State)
+	s.step(after(80))
+	assert.Equal(t, DongleRecovering, s.Status().State)
+}
+
+func TestSupervisor_RunShutdown(t *testing.T) {
+	before := runtime.NumGoroutine()
+	fd := &fakeDongle{present: true, devices: testDevices, fft: []float32{1, 2, 3}}
+	s := newTestSupervisor(fd)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var g fcio.Group
+	g.Go(func() { s.run(ctx, 10*time.Millisecond) })
+	testutil.WaitFor(t, "dongle running", 5*time.Second, func() bool { return s.Status().State == DongleRunning })
+	cancel()
+	assert.True(t, g.Wait(5*time.Second))
+
+	assert.Equal(t, DongleStopped, s.Status().State)
+	assert.False(t, fd.started)
+	assert.Equal(t, []string{"DecodeStop", "DecodeShutdown", "DongleShutdown"}, fd.stops, "native libraries shut down in order")
+	testutil.AssertNoLeaks(t, before)
+}
@@ -0,0 +1,184 @@
+package decode
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/funcube-dev/go/fclib"
+)
+
+// peakTolerance a worker's peak moving further than this between polls is a new peak, closer is
+// the same signal drifting with doppler
+const peakTolerance = 500
+
+// WorkerStatus a decode worker's peak and how often the peaks it took produced decodes
+type WorkerStatus struct {
+	Worker      int       `json:"worker"`
+	Busy        bool      `json:"busy"`
+	Peak        float32   `json:"peak,omitempty"`
+	Since       time.Time `json:"since"`
+	LastDecode  time.Time `json:"lastDecode"`
+	Decodes     int       `json:"decodes"`
+	Assignments int       `json:"assignments"` // peaks taken
+	Productive  int       `json:"productive"`  // peaks taken that produced a decode
+	Released    int       `json:"released"`    // peaks released for producing none
+	Success     float64   `json:"success"`     // productive out of the peaks taken
+}
+
+// WorkersStatus served on /api/v1/workers
+type WorkersStatus struct {
+	Timeout  float64        `json:"timeout"` // seconds without a decode before a worker is released, 0 never
+	Excluded []float32      `json:"excluded"`
+	Workers  []WorkerStatus `json:"workers"`
+}
+
+type workerState struct {
+	WorkerStatus
+	decoded bool // the peak held has produced a decode
+}
+
+type excludedPeak struct {
+	frequency float32
+	until     time.Time
+}
+
+// workerController watches the peaks the decode workers sit on and which of them produce decodes.
+// A worker whose peak has produced nothing for timeout is released by excluding the peak for
+// excludeFor, so it moves on to another instead of sitting on a birdie through a pass
+type workerController struct {
+	mu         sync.Mutex
+	timeout    time.Duration
+	excludeFor time.Duration
+	workers    []workerState
+	excluded   []excludedPeak
+}
+
+func newWorkerController(timeout, excludeFor time.Duration) *workerController {
+	return &workerController{timeout: timeout, excludeFor: excludeFor}
+}
+
+// reset forgets the peaks held, the decoder has been restarted, the exclusions and counts are kept
+func (c *workerController) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.workers {
+		c.workers[i].Busy = false
+		c.workers[i].Peak = 0
+	}
+}
+
+// poll updates the workers from their peaks and availability (0 busy on its peak), releasing
+// those without a decode for timeout, returns whether the exclusions changed
+func (c *workerController) poll(now time.Time, peaks []float32, available []uint) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := c.expire(now)
+	for len(c.workers) < len(peaks) {
+		c.workers = append(c.workers, workerState{WorkerStatus: WorkerStatus{Worker: len(c.workers)}})
+	}
+
+	for i := range c.workers {
+		w := &c.workers[i]
+		// a worker still on a peak just excluded is leaving it
+		if i >= len(peaks) || i >= len(available) || available[i] != 0 || c.isExcluded(peaks[i]) {
+			w.Busy, w.Peak = false, 0
+			continue
+		}
+		if !w.Busy || abs32(peaks[i]-w.Peak) > peakTolerance {
+			w.Busy = true
+			w.Since = now
+			w.decoded = false
+			w.Assignments++
+		}
+		w.Peak = peaks[i]
+
+		last := w.Since
+		if w.LastDecode.After(last) {
+			last = w.LastDecode
+		}
+		if now.Sub(last) < c.timeout {
+			continue
+		}
+		log.Printf("Released decode worker %d from %.0fHz, no decode for %v", w.Worker, w.Peak, now.Sub(last).Round(time.Second))
+		c.excluded = append(c.excluded, excludedPeak{frequency: w.Peak, until: now.Add(c.excludeFor)})
+		w.Released++
+		w.Busy, w.Peak = false, 0
+		changed = true
+	}
+	return changed
+}
+
+// expire drops the exclusions that have run out, the lock held
+func (c *workerController) expire(now time.Time) bool {
+	kept := c.excluded[:0]
+	for _, e := range c.excluded {
+		if now.Before(e.until) {
+			kept = append(kept, e)
+		}
+	}
+	changed := len(kept) != len(c.excluded)
+	c.excluded = kept
+	return changed
+}
+
+// isExcluded whether freq is a peak released, the lock held
+func (c *workerController) isExcluded(freq float32) bool {
+	for _, e := range c.excluded {
+		if abs32(freq-e.frequency) <= peakTolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// decoded counts a decode against the worker that made it
+func (c *workerController) decoded(event fclib.DecodeEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if event.Worker < 0 || event.Worker >= len(c.workers) {
+		return
+	}
+	w := &c.workers[event.Worker]
+	w.Decodes++
+	w.LastDecode = event.Time
+	if w.Busy && !w.decoded {
+		w.decoded = true
+		w.Productive++
+	}
+}
+
+// exclusions the peaks released and still excluded
+func (c *workerController) exclusions() []float32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var frequencies []float32
+	for _, e := range c.excluded {
+		frequencies = append(frequencies, e.frequency)
+	}
+	return frequencies
+}
+
+// Status a snapshot of the workers
+func (c *workerController) Status() WorkersStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := WorkersStatus{Timeout: c.timeout.Seconds(), Excluded: []float32{}, Workers: []WorkerStatus{}}
+	for _, e := range c.excluded {
+		status.Excluded = append(status.Excluded, e.frequency)
+	}
+	for _, w := range c.workers {
+		if w.Assignments > 0 {
+			w.Success = float64(w.Productive) / float64(w.Assignments)
+		}
+		status.Workers = append(status.Workers, w.WorkerStatus)
+	}
+	return status
+}
+
+func abs32(f float32) float32 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
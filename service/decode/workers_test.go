@@ -0,0 +1,107 @@
+package decode
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fclib"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerController(t *testing.T) {
+	c := newWorkerController(60*time.Second, 600*time.Second)
+	peaks := []float32{1200, 5000, 9000}
+	available := []uint{0, 0, 1}
+
+	assert.False(t, c.poll(after(0), peaks, available))
+	c.decoded(fclib.DecodeEvent{Time: after(20), Worker: 0})
+	c.decoded(fclib.DecodeEvent{Time: after(25), Worker: 0})
+	// the signal drifting with doppler is the same peak
+	peaks[0] = 1400
+	assert.False(t, c.poll(after(30), peaks, available))
+
+	// worker 1 has sat on its birdie for a minute, worker 0 decoded 35 seconds ago
+	assert.True(t, c.poll(after(60), peaks, available))
+	assert.Equal(t, []float32{5000}, c.exclusions())
+	status := c.Status()
+	assert.Equal(t, float64(60), status.Timeout)
+	if assert.Len(t, status.Workers, 3) {
+		assert.Equal(t, WorkerStatus{Worker: 0, Busy: true, Peak: 1400, Since: after(0), LastDecode: after(25),
+			Decodes: 2, Assignments: 1, Productive: 1, Success: 1}, status.Workers[0])
+		assert.Equal(t, WorkerStatus{Worker: 1, Assignments: 1, Released: 1, Since: after(0)}, status.Workers[1])
+		assert.False(t, status.Workers[2].Busy)
+	}
+
+	// still reported on the excluded peak until it moves on, then on a new one
+	assert.False(t, c.poll(after(65), peaks, available))
+	assert.False(t, c.Status().Workers[1].Busy)
+	peaks[1] = 7000
+	assert.False(t, c.poll(after(70), peaks, available))
+	status = c.Status()
+	assert.True(t, status.Workers[1].Busy)
+	assert.Equal(t, 2, status.Workers[1].Assignments)
+	assert.Equal(t, float64(0), status.Workers[1].Success)
+
+	// the exclusion runs out
+	assert.True(t, c.poll(after(660), peaks, []uint{1, 1, 1}))
+	assert.Empty(t, c.exclusions())
+}
+
+func TestSupervisor_ReleasesWorkers(t *testing.T) {
+	fd := &fakeDongle{present: true, devices: testDevices, fft: []float32{1, 2, 3},
+		peaks: []float32{145935500, 145937000}, available: []uint{0, 0}}
+	s := newTestSupervisor(fd)
+	s.workers = newWorkerController(30*time.Second, 300*time.Second)
+
+	s.step(after(0))
+	fd.fft = []float32{3, 2, 1}
+	s.step(after(5))
+	fd.decodeEvent(fclib.DecodeEvent{Time: after(20), Worker: 0, Frequency: 145935600, Data: []byte{1}})
+	fd.fft = []float32{1, 2, 3}
+	s.step(after(40))
+	assert.Equal(t, []float32{145936000, 145937000}, fd.exclude, "configured and released")
+	assert.Equal(t, []float32{145937000}, s.Workers().Excluded)
+
+	// a restart keeps them excluded
+	fd.present = false
+	s.step(after(45))
+	fd.present = true
+	fd.exclude = nil
+	s.step(after(50))
+	assert.Equal(t, DongleRunning, s.Status().State)
+	assert.Equal(t, []float32{145936000, 145937000}, fd.exclude)
+	assert.Equal(t, []float32{145936000}, s.Status().Settings.Exclude, "settings unchanged")
+}
+
+func TestWorkersEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := testConfig("127.0.0.1:1")
+	config.WorkerTimeout = 90
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.dongle.workers.poll(time.Now(), []float32{1200}, []uint{0})
+	s.dongle.workers.decoded(fclib.DecodeEvent{Time: time.Now(), Worker: 0})
+
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/workers", nil))
+	assert.Equal(t, 200, w.Code)
+	var response struct {
+		Data WorkersStatus `json:"data"`
+	}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response)) {
+		assert.Equal(t, float64(90), response.Data.Timeout)
+		if assert.Len(t, response.Data.Workers, 1) {
+			assert.Equal(t, 1, response.Data.Workers[0].Decodes)
+			assert.Equal(t, float64(1), response.Data.Workers[0].Success)
+		}
+	}
+
+	config.WorkerTimeout = 0
+	s, _ = New(config)
+	assert.Equal(t, WorkersStatus{Excluded: []float32{}, Workers: []WorkerStatus{}}, s.dongle.Workers())
+}
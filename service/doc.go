@@ -0,0 +1,3 @@
+// Package service holds nothing itself, each app runs one of the packages below it: decode, encode,
+// limetx and warehouse, each has a Config, New and Run so they can be embedded and run together
+package service
@@ -0,0 +1,43 @@
+package encode
+
+import "github.com/funcube-dev/go/fclib"
+
+// Encoder the encoding library calls the service makes, FUNcubeLib unless the config has another
+type Encoder interface {
+	Initialize() int
+	Shutdown()
+	PushData(frame []byte)
+	CanCollect() bool
+	AllDataCollected() bool
+	// CollectSamples fills buf with the next encoded samples, returning how many bytes it filled
+	CollectSamples(buf []byte) int
+}
+
+// fclibEncoder Encoder backed by the FUNcubeLib
+type fclibEncoder struct{}
+
+func (fclibEncoder) Initialize() int {
+	return fclib.Encode_Initialize()
+}
+
+func (fclibEncoder) Shutdown() {
+	fclib.Encode_Shutdown()
+}
+
+func (fclibEncoder) PushData(frame []byte) {
+	fclib.Encode_PushData(&frame[0], uint32(len(frame)))
+}
+
+func (fclibEncoder) CanCollect() bool {
+	return fclib.Encode_CanCollect() > 0
+}
+
+func (fclibEncoder) AllDataCollected() bool {
+	return fclib.Encode_AllDataCollected() != 0
+}
+
+func (fclibEncoder) CollectSamples(buf []byte) int {
+	size := uint32(len(buf))
+	fclib.Encode_CollectSamples(&buf[0], &size)
+	return int(size)
+}
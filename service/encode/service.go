@@ -0,0 +1,323 @@
+// Package encode encodes 256 byte FUNcube frames into dbpsk samples (with forward error correction)
+// and sends them to limetx for transmission, frames are read from a file or from connections on
+// the data port
+package encode
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/fclib"
+)
+
+// Config settings for the encode service, the koanf tags match the fcencode flags
+type Config struct {
+	Rate            float64 `koanf:"rate"`
+	IdleTimeout     int     `koanf:"idletimeout"`
+	LimeTxServer    string  `koanf:"limetxserver"`
+	LimeTxPort      int     `koanf:"limetxport"`
+	BindAddress     string  `koanf:"bindaddress"`
+	DataPort        int     `koanf:"dataport"`
+	CommandPort     int     `koanf:"commandport"`
+	File            string  `koanf:"file"`
+	LoopFile        bool    `koanf:"loopfile"`
+	ShutdownTimeout float64 `koanf:"shutdowntimeout"`
+
+	// TLS for the data and command listeners and the connection to limetx
+	TLS fcio.TLSConfig `koanf:"tls"`
+
+	// NativeLog how the messages the FUNcubeLib logs are filtered
+	NativeLog fclib.LogConfig `koanf:"nativelog"`
+
+	// Encoder nil for the FUNcubeLib encoder
+	Encoder Encoder `koanf:"-"`
+}
+
+// DefaultConfig the settings fcencode uses when none are given
+func DefaultConfig() Config {
+	return Config{
+		Rate:            48000,
+		IdleTimeout:     3,
+		LimeTxServer:    "limeserver",
+		LimeTxPort:      0xFC04,
+		BindAddress:     "0.0.0.0",
+		DataPort:        0xFC02,
+		CommandPort:     0xFC03,
+		ShutdownTimeout: 5,
+		NativeLog:       fclib.DefaultLogConfig(),
+	}
+}
+
+// Service reads frames from its sources, encodes them and sends the samples to limetx
+type Service struct {
+	config    Config
+	tlsServer *tls.Config
+	tlsClient *tls.Config
+	encoder   Encoder
+	sources   *fcio.SourceQueue
+	dataChan  chan []byte
+	bpskChan  chan []byte
+}
+
+// New creates the service, queuing the configured file
+func New(config Config) (*Service, error) {
+	tlsServer, err := config.TLS.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsClient, err := config.TLS.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		config:    config,
+		tlsServer: tlsServer,
+		tlsClient: tlsClient,
+		encoder:   config.Encoder,
+		sources:   fcio.NewSourceQueue(256, 256, fcio.Fair),
+		dataChan:  make(chan []byte, 64),
+		bpskChan:  make(chan []byte, 64),
+	}
+	if s.encoder == nil {
+		nativeLog, err := fclib.NewNativeLog(config.NativeLog, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.encoder = fclibEncoder{}
+		if fclib.Callback_SetOnLogMessage(nativeLog.Handle) != 1 {
+			log.Println("Failed to route FUNcubeLib log messages, they go to stderr")
+		}
+	}
+
+	fileName := config.File
+	if len(fileName) > 0 {
+		fcbinfile, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file %s error:%v", fileName, err)
+		}
+		s.sources.Add(fcbinfile, fcio.SourceOptions{Name: fileName, Loop: config.LoopFile})
+	}
+	return s, nil
+}
+
+// Run encodes and sends frames until ctx is done, then stops reading and carries on encoding
+// and sending the frames already read until the shutdown timeout
+func (s *Service) Run(ctx context.Context) error {
+	drainCtx, abandon := context.WithCancel(context.Background())
+	defer abandon()
+
+	var inputs, outputs fcio.Group
+	inputs.Go(func() { s.readData(ctx, drainCtx) })
+	inputs.Go(func() { s.listen(ctx) })
+	inputs.Go(func() { s.commandListen(ctx) })
+	outputs.Go(func() { s.encodeData(drainCtx) })
+	outputs.Go(func() { s.sendData(drainCtx) })
+
+	<-ctx.Done()
+	if !fcio.Drain(seconds(s.config.ShutdownTimeout), time.Second, abandon, &inputs, &outputs) {
+		return errors.New("shutdown timed out")
+	}
+	return nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// readData takes frames from the sources until ctx is done, a frame already taken is still
+// handed on unless drainCtx is done, dataChan is closed when it returns
+func (s *Service) readData(ctx, drainCtx context.Context) {
+	defer close(s.dataChan)
+	defer s.sources.Close()
+	for {
+		raw, ok := s.sources.Next(ctx)
+		if !ok {
+			return
+		}
+
+		fmt.Printf("<")
+		select {
+		case s.dataChan <- raw:
+		case <-drainCtx.Done():
+			return
+		}
+	}
+}
+
+// encodeData encodes frames until dataChan is closed or ctx is done, then closes bpskChan
+func (s *Service) encodeData(ctx context.Context) {
+	defer close(s.bpskChan)
+	init := s.encoder.Initialize()
+	log.Printf("Initialised %d\n", init)
+	defer s.encoder.Shutdown()
+
+	for {
+		var raw []byte
+		var ok bool
+		select {
+		case raw, ok = <-s.dataChan:
+			if !ok {
+				return
+			}
+			fmt.Printf("!")
+		case <-ctx.Done():
+			return
+		}
+
+		if byteCount := len(raw); byteCount != 256 {
+			log.Printf("Warning: Got %d bytes, need 256... skipping\n", byteCount)
+			continue
+		}
+
+		s.encoder.PushData(raw)
+
+		for !s.encoder.AllDataCollected() && ctx.Err() == nil {
+			bpskSize := 0
+			if s.encoder.CanCollect() {
+				bpskBuffer := make([]byte, 1280) // (40*8*4)
+				bpskSize = s.encoder.CollectSamples(bpskBuffer)
+				// dont send zero length buffers (shouldn't ever happen)
+				if bpskSize == 0 {
+					fmt.Printf("0")
+					continue
+				}
+
+				fmt.Printf("~")
+				select {
+				case s.bpskChan <- bpskBuffer[:bpskSize]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			// pause a bit if there was nothing to collect or we collected nothing!
+			if bpskSize == 0 {
+				time.Sleep(15 * time.Millisecond)
+			}
+		}
+
+		// send zero length buffer to drop connection
+		select {
+		case s.bpskChan <- make([]byte, 0):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendData sends the encoded samples until bpskChan is closed and empty or ctx is done
+func (s *Service) sendData(ctx context.Context) {
+	log.Println("Ready to Send...")
+
+	hostport := fcio.JoinAddress(s.config.LimeTxServer, strconv.Itoa(s.config.LimeTxPort))
+	dst, err := fcio.NewReconnectingWriter(ctx, hostport, fcio.WriterOptions{
+		WriteTimeout: fcio.DefaultWriteTimeout,
+		MinBackoff:   5 * time.Second,
+		MaxBackoff:   60 * time.Second,
+		TLS:          s.tlsClient,
+		OnState:      fcio.LogConnEvent,
+	})
+	if err != nil {
+		log.Println("Failed to create writer:", err)
+		return
+	}
+	defer dst.Close()
+
+	for {
+		var bpsk []byte
+		var ok bool
+		select {
+		case bpsk, ok = <-s.bpskChan:
+			if !ok {
+				log.Println("All samples sent")
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		fmt.Printf("-")
+
+		// a zero byte buffer on the channel is an inter frame marker
+		// so drop the connection
+		if len(bpsk) == 0 {
+			dst.EndFrame()
+			fmt.Printf("|")
+			continue
+		}
+
+		// only fails once ctx is done, failed writes are retried over a new connection
+		if _, err := dst.Write(bpsk); err != nil {
+			return
+		}
+		fmt.Printf(">")
+	}
+}
+
+func (s *Service) listen(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.DataPort))
+	log.Println("Opening listen socket...")
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer lsock.Close()
+	defer fcio.CloseWhenDone(ctx, lsock)()
+	log.Printf("Listening on socket: %s", hostport)
+
+	for {
+		c, err := lsock.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		s.handleConnection(c)
+	}
+}
+
+func (s *Service) commandListen(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.CommandPort))
+	log.Println("Opening command listen socket...")
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer lsock.Close()
+	defer fcio.CloseWhenDone(ctx, lsock)()
+	log.Printf("Listening for commands on socket: %s", hostport)
+
+	for {
+		c, err := lsock.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		handleCommandConnection(c)
+	}
+}
+
+// handleConnection reads the frames of a connection alongside the other sources, it is dropped
+// after a second without data
+func (s *Service) handleConnection(c net.Conn) {
+	log.Printf("Connection from: %v", c.RemoteAddr())
+	if err := s.sources.Add(c, fcio.SourceOptions{Name: c.RemoteAddr().String(), ReadTimeout: time.Second}); err != nil {
+		log.Printf("Failed to read connection, ignoring error:%v", err)
+	}
+}
+
+func handleCommandConnection(c net.Conn) {
+	log.Printf("Connection from: %v", c)
+	c.Close()
+}
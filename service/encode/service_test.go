@@ -0,0 +1,230 @@
+package encode
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEncoder hands back each frame pushed as its samples, 100 bytes at a time
+type fakeEncoder struct {
+	mu       sync.Mutex
+	pending  []byte
+	shutdown bool
+}
+
+func (fe *fakeEncoder) Initialize() int { return 1 }
+func (fe *fakeEncoder) Shutdown() {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.shutdown = true
+}
+func (fe *fakeEncoder) PushData(frame []byte) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.pending = append(fe.pending, frame...)
+}
+func (fe *fakeEncoder) CanCollect() bool { return !fe.AllDataCollected() }
+func (fe *fakeEncoder) AllDataCollected() bool {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	return len(fe.pending) == 0
+}
+func (fe *fakeEncoder) CollectSamples(buf []byte) int {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	n := copy(buf[:100], fe.pending)
+	fe.pending = fe.pending[n:]
+	return n
+}
+func (fe *fakeEncoder) isShutdown() bool {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	return fe.shutdown
+}
+
+// limetxListener stands in for limetx, accepted connections are sent on the channel
+func limetxListener(t *testing.T, config *Config) (net.Listener, chan net.Conn) {
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 4)
+	go func() {
+		for {
+			c, err := lsock.Accept()
+			if err != nil {
+				close(conns)
+				return
+			}
+			conns <- c
+		}
+	}()
+	_, port, _ := net.SplitHostPort(lsock.Addr().String())
+	config.LimeTxServer = "127.0.0.1"
+	config.LimeTxPort, _ = strconv.Atoi(port)
+	return lsock, conns
+}
+
+// testConfig encodes a file of the frames with a fake encoder, listening on loopback ephemeral ports
+func testConfig(t *testing.T, frames []byte) Config {
+	file := testutil.TempFile(t, "frames.bin", frames)
+	config := DefaultConfig()
+	config.BindAddress = "127.0.0.1"
+	config.DataPort = 0
+	config.CommandPort = 0
+	config.File = file
+	config.Encoder = &fakeEncoder{}
+	return config
+}
+
+// runService runs the service until the returned stop is called, which returns the Run result
+func runService(t *testing.T, config Config) (*Service, func() error) {
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, testutil.Run(s)
+}
+
+func TestRun_SendsEachFrame(t *testing.T) {
+	before := runtime.NumGoroutine()
+	frames := append(bytes.Repeat([]byte{1}, 256), bytes.Repeat([]byte{2}, 256)...)
+	config := testConfig(t, frames)
+	lsock, conns := limetxListener(t, &config)
+	defer lsock.Close()
+
+	_, stop := runService(t, config)
+	// a connection per frame, dropped at the inter frame marker
+	for i := 0; i < 2; i++ {
+		c := <-conns
+		received, err := ioutil.ReadAll(c)
+		assert.NoError(t, err)
+		assert.Equal(t, frames[i*256:(i+1)*256], received)
+		c.Close()
+	}
+	assert.NoError(t, stop())
+	assert.True(t, config.Encoder.(*fakeEncoder).isShutdown())
+
+	lsock.Close()
+	for range conns {
+	}
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestShutdown_NoLeaks(t *testing.T) {
+	before := runtime.NumGoroutine()
+	config := testConfig(t, make([]byte, 3*256))
+	config.LoopFile = true
+	config.Encoder = nil
+
+	s, stop := runService(t, config)
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, stop())
+	// samples left unsent (the simulated library encodes some) are drained to find it closed
+	closed := make(chan struct{})
+	go func() {
+		for range s.bpskChan {
+		}
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("encoder closes bpskChan once drained")
+	}
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestSendData_Drains(t *testing.T) {
+	before := runtime.NumGoroutine()
+	config := DefaultConfig()
+	lsock, conns := limetxListener(t, &config)
+	defer lsock.Close()
+
+	s, _ := New(config)
+	s.bpskChan <- []byte{1, 2, 3}
+	s.bpskChan <- []byte{4, 5}
+	s.bpskChan <- []byte{}
+	close(s.bpskChan)
+
+	var outputs fcio.Group
+	outputs.Go(func() { s.sendData(context.Background()) })
+	assert.True(t, outputs.Wait(5*time.Second), "returns once bpskChan is closed and empty")
+
+	c := <-conns
+	received, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5}, received)
+	c.Close()
+	lsock.Close()
+	for range conns {
+	}
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestSendData_AbandonsStalledWrite(t *testing.T) {
+	before := runtime.NumGoroutine()
+	config := DefaultConfig()
+	lsock, conns := limetxListener(t, &config)
+	defer lsock.Close()
+
+	// far more than the socket buffers hold, limetx never reads it
+	s, _ := New(config)
+	s.bpskChan <- make([]byte, 64<<20)
+
+	ctx, abandon := context.WithCancel(context.Background())
+	var outputs fcio.Group
+	outputs.Go(func() { s.sendData(ctx) })
+	c := <-conns
+	time.Sleep(100 * time.Millisecond)
+	abandon()
+	assert.True(t, outputs.Wait(5*time.Second), "blocked write is abandoned")
+
+	c.Close()
+	lsock.Close()
+	for range conns {
+	}
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestReadData_SlowConnection(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s, _ := New(DefaultConfig())
+
+	// a client stalled part way through a frame doesn't hold up the next one
+	slow, slowClient := net.Pipe()
+	defer slowClient.Close()
+	fast, fastClient := net.Pipe()
+	defer fastClient.Close()
+	s.handleConnection(slow)
+	s.handleConnection(fast)
+	go func() {
+		slowClient.Write(make([]byte, 100))
+		fastClient.Write(bytes.Repeat([]byte{7}, 256))
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var inputs fcio.Group
+	inputs.Go(func() { s.readData(ctx, context.Background()) })
+	select {
+	case frame := <-s.dataChan:
+		assert.Equal(t, bytes.Repeat([]byte{7}, 256), frame)
+	case <-time.After(5 * time.Second):
+		t.Error("frame held up by the slow client")
+	}
+
+	cancel()
+	assert.True(t, inputs.Wait(5*time.Second))
+	testutil.AssertNoLeaks(t, before)
+}
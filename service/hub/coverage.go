@@ -0,0 +1,347 @@
+package hub
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+)
+
+// Copy one station's copy of a frame
+type Copy struct {
+	Station string    `json:"station"`
+	Errors  int       `json:"errors"` // -1 if not known
+	Time    time.Time `json:"time"`   // decoded, by the station's clock
+	Late    bool      `json:"late,omitempty"`
+}
+
+// HeardFrame a frame and every station that heard it
+type HeardFrame struct {
+	Received time.Time `json:"received"` // the first copy
+	Pass     int       `json:"pass"`
+	Best     string    `json:"best"` // the station with the fewest errors known, the frame is submitted as it
+	Copies   []Copy    `json:"copies"`
+	Data     []byte    `json:"-"`
+
+	bestErrors int
+}
+
+// StationCoverage how much of a pass a station heard
+type StationCoverage struct {
+	Heard      int     `json:"heard"`
+	Best       int     `json:"best"`       // frames it heard with the fewest errors
+	MeanErrors float64 `json:"meanErrors"` // of the copies with an error count known
+	Coverage   float64 `json:"coverage"`   // heard out of the frames any station heard
+	errors     int
+	counted    int // copies with an error count known
+}
+
+// PassCoverage the frames heard in a pass, a pass ends once no station hears a frame for the pass gap
+type PassCoverage struct {
+	Pass     int                         `json:"pass"`
+	Start    time.Time                   `json:"start"`
+	End      time.Time                   `json:"end"`
+	Frames   int                         `json:"frames"`
+	Stations map[string]*StationCoverage `json:"stations"`
+}
+
+// StationStatus what a station has sent since the hub started
+type StationStatus struct {
+	Station    string    `json:"station"`
+	Heard      int       `json:"heard"`
+	Best       int       `json:"best"`
+	Late       int       `json:"late"`       // copies arriving after the frame was submitted
+	MeanErrors float64   `json:"meanErrors"` // of the copies with an error count known
+	LastHeard  time.Time `json:"lastHeard,omitempty"`
+	errors     int
+	counted    int // copies with an error count known
+}
+
+// maxPasses the passes kept for the api
+const maxPasses = 50
+
+// aggregator gathers the copies of each frame from every station for the window, then forwards
+// the frame once, attributed to the station that heard it with the fewest errors. Copies arriving
+// for another window after are counted as heard but late
+type aggregator struct {
+	mu       sync.Mutex
+	window   time.Duration
+	passGap  time.Duration
+	history  int
+	forward  func(frame *HeardFrame)
+	pending  map[string]*HeardFrame
+	order    []*HeardFrame // pending by first arrival
+	recent   map[string]time.Time
+	frames   []*HeardFrame // forwarded, the newest history
+	passes   []*PassCoverage
+	last     time.Time // a frame was last heard
+	stations map[string]*StationStatus
+	now      func() time.Time
+}
+
+func newAggregator(window, passGap time.Duration, history int, stations []string, forward func(frame *HeardFrame)) *aggregator {
+	a := &aggregator{
+		window:   window,
+		passGap:  passGap,
+		history:  history,
+		forward:  forward,
+		pending:  make(map[string]*HeardFrame),
+		recent:   make(map[string]time.Time),
+		stations: make(map[string]*StationStatus),
+		now:      time.Now,
+	}
+	for _, station := range stations {
+		a.stations[station] = &StationStatus{Station: station}
+	}
+	return a
+}
+
+// add a station's copy of a frame, a station's further copies of a frame only count if they have
+// fewer errors
+func (a *aggregator) add(record fcio.StationRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	key := string(record.Frame)
+	heard := Copy{Station: record.Station, Errors: record.Errors, Time: record.Time}
+
+	h, gathering := a.pending[key]
+	switch {
+	case gathering:
+	case now.Before(a.recent[key]):
+		// already submitted, the station still heard it
+		h = a.findRecent(key)
+		heard.Late = true
+	default:
+		if len(a.passes) == 0 || now.Sub(a.last) >= a.passGap {
+			a.passes = append(a.passes, &PassCoverage{
+				Pass:     a.passCount() + 1,
+				Start:    now,
+				Stations: make(map[string]*StationCoverage),
+			})
+			if len(a.passes) > maxPasses {
+				a.passes = a.passes[1:]
+			}
+		}
+		pass := a.passes[len(a.passes)-1]
+		pass.Frames++
+		h = &HeardFrame{Received: now, Pass: pass.Pass, Best: record.Station, bestErrors: record.Errors, Data: record.Frame}
+		a.pending[key] = h
+		a.order = append(a.order, h)
+	}
+	a.last = now
+	if h == nil {
+		return
+	}
+	if gathering && fewerErrors(record.Errors, h.bestErrors) {
+		h.Best, h.bestErrors = record.Station, record.Errors
+	}
+
+	s := a.station(record.Station)
+	pass := a.pass(h.Pass)
+	for i, c := range h.Copies {
+		if c.Station != record.Station {
+			continue
+		}
+		if fewerErrors(record.Errors, c.Errors) {
+			h.Copies[i].Errors = record.Errors
+			tally(&s.errors, &s.counted, c.Errors, record.Errors)
+			if pass != nil {
+				coverage := pass.coverage(record.Station)
+				tally(&coverage.errors, &coverage.counted, c.Errors, record.Errors)
+			}
+		}
+		return
+	}
+	h.Copies = append(h.Copies, heard)
+	s.Heard++
+	tally(&s.errors, &s.counted, -1, record.Errors)
+	s.LastHeard = now
+	if heard.Late {
+		s.Late++
+	}
+	if pass != nil {
+		pass.End = now
+		coverage := pass.coverage(record.Station)
+		coverage.Heard++
+		tally(&coverage.errors, &coverage.counted, -1, record.Errors)
+	}
+}
+
+// fewerErrors whether an error count is known and fewer than another, which may not be known
+func fewerErrors(errors, than int) bool {
+	return errors >= 0 && (than < 0 || errors < than)
+}
+
+// tally replaces a copy's error count, was, in a sum of the counts known with is
+func tally(sum, counted *int, was, is int) {
+	if was >= 0 {
+		*sum -= was
+		*counted--
+	}
+	if is >= 0 {
+		*sum += is
+		*counted++
+	}
+}
+
+// passCount the passes since the hub started
+func (a *aggregator) passCount() int {
+	if len(a.passes) == 0 {
+		return 0
+	}
+	return a.passes[len(a.passes)-1].Pass
+}
+
+// findRecent the frame forwarded for the key, nil once out of the history
+func (a *aggregator) findRecent(key string) *HeardFrame {
+	for i := len(a.frames) - 1; i >= 0; i-- {
+		if string(a.frames[i].Data) == key {
+			return a.frames[i]
+		}
+	}
+	return nil
+}
+
+func (a *aggregator) pass(pass int) *PassCoverage {
+	for i := len(a.passes) - 1; i >= 0; i-- {
+		if a.passes[i].Pass == pass {
+			return a.passes[i]
+		}
+	}
+	return nil
+}
+
+func (p *PassCoverage) coverage(station string) *StationCoverage {
+	c, ok := p.Stations[station]
+	if !ok {
+		c = &StationCoverage{}
+		p.Stations[station] = c
+	}
+	return c
+}
+
+func (a *aggregator) station(station string) *StationStatus {
+	s, ok := a.stations[station]
+	if !ok {
+		s = &StationStatus{Station: station}
+		a.stations[station] = s
+	}
+	return s
+}
+
+// run forwards the frames as their window passes until ctx is done, call flush after
+func (a *aggregator) run(ctx context.Context) {
+	interval := a.window / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			a.forwardDue(a.now())
+			a.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flush forwards every frame still gathering copies
+func (a *aggregator) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.forwardDue(time.Time{})
+}
+
+// forwardDue forwards the frames gathered for the window before now, all if now is zero, the lock held
+func (a *aggregator) forwardDue(now time.Time) {
+	for len(a.order) > 0 {
+		h := a.order[0]
+		due := h.Received.Add(a.window)
+		if !now.IsZero() && now.Before(due) {
+			break
+		}
+		a.order = a.order[1:]
+		key := string(h.Data)
+		delete(a.pending, key)
+		a.recent[key] = due.Add(a.window)
+
+		a.station(h.Best).Best++
+		if pass := a.pass(h.Pass); pass != nil {
+			pass.coverage(h.Best).Best++
+		}
+		a.frames = append(a.frames, h)
+		if len(a.frames) > a.history {
+			a.frames = a.frames[1:]
+		}
+		a.forward(h)
+	}
+	if now.IsZero() {
+		return
+	}
+	for key, until := range a.recent {
+		if !now.Before(until) {
+			delete(a.recent, key)
+		}
+	}
+}
+
+// Stations what each station has sent, by name
+func (a *aggregator) Stations() []StationStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stations := []StationStatus{}
+	for _, s := range a.stations {
+		status := *s
+		status.errors, status.counted = 0, 0
+		if s.counted > 0 {
+			status.MeanErrors = float64(s.errors) / float64(s.counted)
+		}
+		stations = append(stations, status)
+	}
+	sort.Slice(stations, func(i, j int) bool { return stations[i].Station < stations[j].Station })
+	return stations
+}
+
+// Passes the coverage of the recent passes, oldest first
+func (a *aggregator) Passes() []PassCoverage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	passes := []PassCoverage{}
+	for _, p := range a.passes {
+		pass := *p
+		pass.Stations = make(map[string]*StationCoverage)
+		for station, c := range p.Stations {
+			coverage := *c
+			coverage.errors, coverage.counted = 0, 0
+			if c.counted > 0 {
+				coverage.MeanErrors = float64(c.errors) / float64(c.counted)
+			}
+			if p.Frames > 0 {
+				coverage.Coverage = float64(c.Heard) / float64(p.Frames)
+			}
+			pass.Stations[station] = &coverage
+		}
+		passes = append(passes, pass)
+	}
+	return passes
+}
+
+// Frames the frames recently forwarded and the stations that heard them, oldest first
+func (a *aggregator) Frames() []HeardFrame {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	frames := []HeardFrame{}
+	for _, h := range a.frames {
+		frame := *h
+		frame.Copies = append([]Copy(nil), h.Copies...)
+		frames = append(frames, frame)
+	}
+	return frames
+}
@@ -0,0 +1,124 @@
+package hub
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/stretchr/testify/assert"
+)
+
+var hubEpoch = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func after(seconds int) time.Time {
+	return hubEpoch.Add(time.Duration(seconds) * time.Second)
+}
+
+func testFrame(value byte) []byte {
+	return bytes.Repeat([]byte{value}, 256)
+}
+
+func record(station string, errors int, frame []byte) fcio.StationRecord {
+	return fcio.StationRecord{Station: station, Time: hubEpoch, Errors: errors, Frame: frame}
+}
+
+func TestAggregator(t *testing.T) {
+	var forwarded []HeardFrame
+	a := newAggregator(2*time.Second, 600*time.Second, 10, []string{"G0ABC", "M0XYZ", "2E0QRP"}, func(frame *HeardFrame) {
+		forwarded = append(forwarded, *frame)
+	})
+	clock := after(0)
+	a.now = func() time.Time { return clock }
+
+	// the first frame heard by two stations, the second by one, sending it twice
+	a.add(record("G0ABC", 5, testFrame(1)))
+	a.add(record("M0XYZ", 2, testFrame(1)))
+	a.add(record("M0XYZ", 3, testFrame(2)))
+	a.add(record("M0XYZ", 1, testFrame(2)))
+	clock = after(2)
+	a.forwardDue(clock)
+	if assert.Len(t, forwarded, 2) {
+		assert.Equal(t, "M0XYZ", forwarded[0].Best, "fewest errors")
+		assert.Equal(t, testFrame(1), forwarded[0].Data)
+		assert.Equal(t, []Copy{{Station: "G0ABC", Errors: 5, Time: hubEpoch}, {Station: "M0XYZ", Errors: 2, Time: hubEpoch}}, forwarded[0].Copies)
+		assert.Equal(t, []Copy{{Station: "M0XYZ", Errors: 1, Time: hubEpoch}}, forwarded[1].Copies, "a station counted once a frame")
+	}
+
+	// a late copy is recorded but not submitted again
+	clock = after(3)
+	a.add(record("G0ABC", 0, testFrame(2)))
+	a.forwardDue(clock)
+	assert.Len(t, forwarded, 2)
+
+	// the next pass starts after the gap
+	clock = after(700)
+	a.add(record("G0ABC", 4, testFrame(3)))
+	a.flush()
+	assert.Len(t, forwarded, 3)
+
+	passes := a.Passes()
+	if assert.Len(t, passes, 2) {
+		assert.Equal(t, 1, passes[0].Pass)
+		assert.Equal(t, 2, passes[0].Frames)
+		assert.Equal(t, after(0), passes[0].Start)
+		assert.Equal(t, after(3), passes[0].End)
+		assert.Equal(t, &StationCoverage{Heard: 2, Best: 2, MeanErrors: 1.5, Coverage: 1}, passes[0].Stations["M0XYZ"])
+		assert.Equal(t, &StationCoverage{Heard: 2, MeanErrors: 2.5, Coverage: 1}, passes[0].Stations["G0ABC"])
+		assert.Equal(t, 1, passes[1].Frames)
+		assert.Equal(t, &StationCoverage{Heard: 1, Best: 1, MeanErrors: 4, Coverage: 1}, passes[1].Stations["G0ABC"])
+	}
+
+	assert.Equal(t, []StationStatus{
+		{Station: "2E0QRP"},
+		{Station: "G0ABC", Heard: 3, Best: 1, Late: 1, MeanErrors: 3, LastHeard: after(700)},
+		{Station: "M0XYZ", Heard: 2, Best: 2, MeanErrors: 1.5, LastHeard: after(0)},
+	}, a.Stations())
+
+	frames := a.Frames()
+	if assert.Len(t, frames, 3) {
+		assert.Len(t, frames[1].Copies, 2)
+		assert.True(t, frames[1].Copies[1].Late)
+		assert.Equal(t, 2, frames[2].Pass)
+	}
+}
+
+func TestAggregator_UnknownErrors(t *testing.T) {
+	var forwarded []HeardFrame
+	a := newAggregator(time.Second, time.Hour, 10, nil, func(frame *HeardFrame) {
+		forwarded = append(forwarded, *frame)
+	})
+	a.now = func() time.Time { return hubEpoch }
+
+	// a copy with errors not known is neither the best nor counted in the mean, until it is known
+	a.add(record("2E0QRP", -1, testFrame(1)))
+	a.add(record("G0ABC", 3, testFrame(1)))
+	a.add(record("2E0QRP", -1, testFrame(2)))
+	a.add(record("2E0QRP", 2, testFrame(2)))
+	a.flush()
+	if assert.Len(t, forwarded, 2) {
+		assert.Equal(t, "G0ABC", forwarded[0].Best)
+		assert.Equal(t, []Copy{{Station: "2E0QRP", Errors: -1, Time: hubEpoch}, {Station: "G0ABC", Errors: 3, Time: hubEpoch}}, forwarded[0].Copies)
+		assert.Equal(t, []Copy{{Station: "2E0QRP", Errors: 2, Time: hubEpoch}}, forwarded[1].Copies)
+	}
+	assert.Equal(t, []StationStatus{
+		{Station: "2E0QRP", Heard: 2, Best: 1, MeanErrors: 2, LastHeard: hubEpoch},
+		{Station: "G0ABC", Heard: 1, Best: 1, MeanErrors: 3, LastHeard: hubEpoch},
+	}, a.Stations())
+	assert.Equal(t, &StationCoverage{Heard: 2, Best: 1, MeanErrors: 2, Coverage: 1}, a.Passes()[0].Stations["2E0QRP"])
+}
+
+func TestAggregator_History(t *testing.T) {
+	a := newAggregator(0, time.Hour, 2, nil, func(frame *HeardFrame) {})
+	for i := 0; i < 4; i++ {
+		a.add(record("G0ABC", 0, testFrame(byte(i))))
+		a.flush()
+	}
+	frames := a.Frames()
+	if assert.Len(t, frames, 2) {
+		assert.Equal(t, testFrame(3), frames[1].Data)
+	}
+	// a copy of a frame no longer kept still counts for the station
+	a.add(record("M0XYZ", 0, testFrame(0)))
+	assert.Equal(t, 1, a.Stations()[1].Heard)
+}
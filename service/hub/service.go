@@ -0,0 +1,375 @@
+// Package hub aggregates the frames several receive stations decode. Each station's fcdecode sends
+// its frames signed with the station's key, the hub gathers every station's copy of a frame and
+// submits it to the data warehouse once, as the station that heard it with the fewest errors
+package hub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/service/warehouse"
+	"github.com/gin-gonic/gin"
+)
+
+// StationConfig a station allowed to send to the hub
+type StationConfig struct {
+	// Key the station's frames are signed with, as its fcdecode hubkey
+	Key string `koanf:"key"`
+	// SiteID and AuthCode the station's warehouse credentials, the frames it hears best are
+	// submitted as it, the hub's own when empty
+	SiteID   string `koanf:"siteid"`
+	AuthCode string `koanf:"authcode"`
+}
+
+// Config settings for the hub service, the koanf tags match the fchub flags, the stations are a
+// [stations.<name>] table each in fchub.conf
+type Config struct {
+	SiteID           string                   `koanf:"siteid"`
+	AuthCode         string                   `koanf:"authcode"`
+	URL              string                   `koanf:"url"`
+	RetryAttempts    int                      `koanf:"retryattempts"`
+	RetryWaitSeconds int                      `koanf:"retrywaitseconds"`
+	BindAddress      string                   `koanf:"bindaddress"`
+	DataPort         int                      `koanf:"dataport"`
+	CommandPort      int                      `koanf:"commandport"`
+	Window           float64                  `koanf:"window"`
+	ReplayWindow     float64                  `koanf:"replaywindow"`
+	PassGap          float64                  `koanf:"passgap"`
+	History          int                      `koanf:"history"`
+	ShutdownTimeout  float64                  `koanf:"shutdowntimeout"`
+	Stations         map[string]StationConfig `koanf:"stations"`
+
+	// TLS for the data and command listeners
+	TLS fcio.TLSConfig `koanf:"tls"`
+}
+
+// DefaultConfig the settings fchub uses when none are given
+func DefaultConfig() Config {
+	return Config{
+		URL:              "http://data.amsat-uk.org/",
+		RetryAttempts:    3,
+		RetryWaitSeconds: 60,
+		BindAddress:      "0.0.0.0",
+		DataPort:         0xFC08,
+		CommandPort:      0xFC09,
+		Window:           5,
+		ReplayWindow:     600,
+		PassGap:          600,
+		History:          100,
+		ShutdownTimeout:  5,
+		Stations:         map[string]StationConfig{},
+	}
+}
+
+// Stats served on /api/v1/stats
+type Stats struct {
+	Records   uint64 `json:"records"`   // copies received from the stations
+	Rejected  uint64 `json:"rejected"`  // records from unknown stations, not signed with their key or replayed
+	Frames    uint64 `json:"frames"`    // frames once the copies were gathered
+	Submitted uint64 `json:"submitted"` // frames the warehouse accepted
+	Failed    uint64 `json:"failed"`    // frames the warehouse did not accept after the retries
+	Dropped   uint64 `json:"dropped"`   // frames not submitted, the sender had fallen behind or had no credentials
+}
+
+// submission a frame to submit and the credentials it is submitted with
+type submission struct {
+	data     []byte
+	station  string
+	siteID   string
+	authCode string
+}
+
+// Service receives the stations' frames and submits each to the warehouse once
+type Service struct {
+	config     Config
+	tlsServer  *tls.Config
+	sources    *fcio.SourceQueue
+	aggregator *aggregator
+	replays    *fcio.ReplayGuard
+	sendChan   chan submission
+	stats      Stats
+}
+
+// New creates the service, nothing is received until Run
+func New(config Config) (*Service, error) {
+	tlsServer, err := config.TLS.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	var stations []string
+	for name, station := range config.Stations {
+		if station.Key == "" {
+			return nil, fmt.Errorf("station %s has no key", name)
+		}
+		if _, err := (fcio.StationRecord{Station: name, Frame: make([]byte, 256)}).Encode([]byte(station.Key)); err != nil {
+			return nil, fmt.Errorf("invalid station: %v", err)
+		}
+		stations = append(stations, name)
+	}
+	if len(stations) == 0 {
+		log.Println("No stations configured, every frame will be rejected")
+	}
+	if config.ReplayWindow <= 0 {
+		return nil, errors.New("replaywindow must be more than 0 seconds")
+	}
+	sort.Strings(stations)
+	log.Printf("Stations: %v", stations)
+
+	s := &Service{
+		config:    config,
+		tlsServer: tlsServer,
+		sources:   fcio.NewSourceQueue(fcio.StationRecordSize, fcio.StationRecordSize, fcio.Fair),
+		replays:   fcio.NewReplayGuard(seconds(config.ReplayWindow)),
+		sendChan:  make(chan submission, 64),
+	}
+	s.aggregator = newAggregator(seconds(config.Window), seconds(config.PassGap), config.History, stations, s.queueFrame)
+	return s, nil
+}
+
+// Run receives and submits frames until ctx is done, then stops receiving, submits the frames
+// gathered and carries on submitting until the shutdown timeout, any left are dropped
+func (s *Service) Run(ctx context.Context) error {
+	drainCtx, abandon := context.WithCancel(context.Background())
+	defer abandon()
+
+	var inputs, senders fcio.Group
+	senders.Go(func() { s.sendData(drainCtx) })
+	inputs.Go(func() { s.readData(ctx) })
+	inputs.Go(func() { s.aggregator.run(ctx) })
+	inputs.Go(func() { s.listen(ctx) })
+	inputs.Go(func() { s.serveAPI(ctx) })
+
+	<-ctx.Done()
+	if !fcio.Drain(seconds(s.config.ShutdownTimeout), time.Second, abandon, &inputs, &senders) {
+		return errors.New("shutdown timed out")
+	}
+	return nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// stationKey the key of a station configured, nil for any other
+func (s *Service) stationKey(station string) []byte {
+	if config, ok := s.config.Stations[station]; ok {
+		return []byte(config.Key)
+	}
+	return nil
+}
+
+// readData takes the stations' records from the connections until the context is done, then
+// submits the frames still gathering copies and closes sendChan so the sender knows it has everything
+func (s *Service) readData(ctx context.Context) {
+	defer close(s.sendChan)
+	defer s.aggregator.flush()
+	defer s.sources.Close()
+	for {
+		raw, ok := s.sources.Next(ctx)
+		if !ok {
+			return
+		}
+		atomic.AddUint64(&s.stats.Records, 1)
+		record, err := fcio.DecodeStationRecord(raw, s.stationKey)
+		if err == nil {
+			err = s.replays.Check(raw, record)
+		}
+		if err != nil {
+			atomic.AddUint64(&s.stats.Rejected, 1)
+			log.Printf("Rejected frame: %v", err)
+			continue
+		}
+		fmt.Printf("<")
+		s.aggregator.add(record)
+	}
+}
+
+// queueFrame queues a frame gathered for submitting as the station that heard it best
+func (s *Service) queueFrame(frame *HeardFrame) {
+	atomic.AddUint64(&s.stats.Frames, 1)
+	sub := submission{data: frame.Data, station: frame.Best, siteID: s.config.SiteID, authCode: s.config.AuthCode}
+	if station := s.config.Stations[frame.Best]; station.SiteID != "" {
+		sub.siteID, sub.authCode = station.SiteID, station.AuthCode
+	}
+	if sub.siteID == "" {
+		atomic.AddUint64(&s.stats.Dropped, 1)
+		log.Printf("No warehouse credentials for station %s or the hub, frame not submitted", frame.Best)
+		return
+	}
+	select {
+	case s.sendChan <- sub:
+	default:
+		atomic.AddUint64(&s.stats.Dropped, 1)
+		log.Printf("Discarded result, send channel full")
+	}
+}
+
+// sendData submits the frames until sendChan is closed and empty or the context is done
+func (s *Service) sendData(ctx context.Context) {
+	log.Println("Ready to Send...")
+	for {
+		var sub submission
+		var ok bool
+		select {
+		case sub, ok = <-s.sendChan:
+			if !ok {
+				log.Println("All frames sent")
+				return
+			}
+		case <-ctx.Done():
+			if left := len(s.sendChan); left > 0 {
+				log.Printf("Dropping %d unsent frames", left)
+			}
+			return
+		}
+		if s.submit(ctx, sub) {
+			atomic.AddUint64(&s.stats.Submitted, 1)
+			fmt.Printf(">")
+		} else {
+			atomic.AddUint64(&s.stats.Failed, 1)
+			fmt.Printf("x")
+		}
+	}
+}
+
+// submit uploads the frame, retrying as configured, false if it was never accepted
+func (s *Service) submit(ctx context.Context, sub submission) bool {
+	frame, err := warehouse.NewFrame(sub.data, s.config.RetryAttempts)
+	if err != nil {
+		log.Printf("Failed to create frame: (%+v)\n", err)
+		return false
+	}
+	for {
+		err := warehouse.Upload(ctx, s.config.URL, sub.siteID, sub.authCode, frame)
+		if err == nil {
+			return true
+		}
+		log.Printf("%v, heard best by %s", err, sub.station)
+		frame.DecrementRetry()
+		if !frame.CanRetry() {
+			return false
+		}
+		log.Printf("Retry waiting: %d  attempts remaining: %d of %d, backlog: %d\n", s.config.RetryWaitSeconds, frame.RemainingRetry(), s.config.RetryAttempts, len(s.sendChan))
+		if !fcio.Sleep(ctx, time.Duration(s.config.RetryWaitSeconds)*time.Second) {
+			return false
+		}
+	}
+}
+
+func (s *Service) listen(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.DataPort))
+	log.Println("Opening listen socket...")
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer lsock.Close()
+	defer fcio.CloseWhenDone(ctx, lsock)()
+	log.Printf("Listening on socket: %s", hostport)
+
+	for {
+		c, err := lsock.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		s.handleConnection(c)
+	}
+}
+
+// handleConnection reads the records of a connection alongside the others, resynchronising on
+// the record header, it is dropped after a second without data
+func (s *Service) handleConnection(c net.Conn) {
+	err := s.sources.Add(c, fcio.SourceOptions{
+		Name:        c.RemoteAddr().String(),
+		ReadTimeout: time.Second,
+		Validate:    fcio.IsStationRecord,
+	})
+	if err != nil {
+		log.Printf("Failed to read connection, ignoring error:%v", err)
+	}
+}
+
+// Response wraps the data served by the api
+type Response struct {
+	Data interface{} `json:"data,omitempty"`
+}
+
+// serveAPI serves the api until ctx is done
+func (s *Service) serveAPI(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.CommandPort))
+	log.Println("Opening command listen socket...")
+
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		log.Printf("Command listen socket failed: %v", err)
+		return
+	}
+	server := &http.Server{Handler: s.router()}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(lsock) }()
+
+	select {
+	case err := <-served:
+		log.Printf("Command listen socket failed: %v", err)
+		return
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to stop command listen socket: %v", err)
+	}
+	<-served
+}
+
+func (s *Service) router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Logger())
+	r.Use(gin.Recovery())
+
+	apiv1 := r.Group("/api/v1")
+	{
+		apiv1.GET("/stats", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: Stats{
+					Records:   atomic.LoadUint64(&s.stats.Records),
+					Rejected:  atomic.LoadUint64(&s.stats.Rejected),
+					Frames:    atomic.LoadUint64(&s.stats.Frames),
+					Submitted: atomic.LoadUint64(&s.stats.Submitted),
+					Failed:    atomic.LoadUint64(&s.stats.Failed),
+					Dropped:   atomic.LoadUint64(&s.stats.Dropped),
+				},
+			})
+		})
+		apiv1.GET("/stations", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.aggregator.Stations(),
+			})
+		})
+		apiv1.GET("/passes", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.aggregator.Passes(),
+			})
+		})
+		apiv1.GET("/frames", func(c *gin.Context) {
+			c.JSON(200, Response{
+				Data: s.aggregator.Frames(),
+			})
+		})
+	}
+	return r
+}
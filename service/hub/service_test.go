@@ -0,0 +1,139 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/internal/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfig submits to url, listening on loopback ephemeral ports, G0ABC has its own warehouse
+// credentials and M0XYZ is submitted as the hub
+func testConfig(url string) Config {
+	config := DefaultConfig()
+	config.URL = url + "/"
+	config.SiteID = "hub"
+	config.AuthCode = "hubsecret"
+	config.BindAddress = "127.0.0.1"
+	config.DataPort = 0
+	config.CommandPort = 0
+	config.Window = 0.2
+	config.Stations = map[string]StationConfig{
+		"G0ABC": {Key: "abc-key", SiteID: "G0ABC", AuthCode: "abcsecret"},
+		"M0XYZ": {Key: "xyz-key"},
+	}
+	return config
+}
+
+// send writes the records over a connection as fcdecode does, one connection each
+func send(t *testing.T, s *Service, records ...[]byte) {
+	for _, data := range records {
+		client, server := net.Pipe()
+		s.handleConnection(server)
+		if _, err := client.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+	}
+}
+
+// encode a record decoded now, by the hub's clock
+func encode(t *testing.T, station, key string, errors int, frame []byte) []byte {
+	r := record(station, errors, frame)
+	r.Time = time.Now()
+	data, err := r.Encode([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRun_SubmitsBestCopy(t *testing.T) {
+	before := runtime.NumGoroutine()
+	var mu sync.Mutex
+	var sites []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		sites = append(sites, strings.Split(r.URL.Path, "/")[4])
+	}))
+	defer server.Close()
+
+	s, err := New(testConfig(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+
+	best := encode(t, "M0XYZ", "xyz-key", 1, testFrame(1))
+	old, err := record("G0ABC", 0, testFrame(5)).Encode([]byte("abc-key"))
+	assert.NoError(t, err)
+	send(t, s,
+		encode(t, "G0ABC", "abc-key", 4, testFrame(1)),
+		best,
+		encode(t, "G0ABC", "abc-key", 0, testFrame(2)),
+		encode(t, "M0XYZ", "guess", 0, testFrame(3)),
+		encode(t, "2E0QRP", "qrp-key", 0, testFrame(4)),
+		// replayed, sent again and from long ago
+		best,
+		old,
+	)
+	testutil.WaitFor(t, "frames submitted", 5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sites) == 2
+	})
+	cancel()
+	assert.NoError(t, <-result)
+	assert.ElementsMatch(t, []string{"hub", "G0ABC"}, sites, "as the station heard best by, the hub without its own credentials")
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	var stats struct {
+		Data Stats `json:"data"`
+	}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats)) {
+		assert.Equal(t, Stats{Records: 7, Rejected: 4, Frames: 2, Submitted: 2}, stats.Data)
+	}
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/passes", nil))
+	var passes struct {
+		Data []PassCoverage `json:"data"`
+	}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &passes)) && assert.Len(t, passes.Data, 1) {
+		assert.Equal(t, 2, passes.Data[0].Frames)
+		assert.Equal(t, 1.0, passes.Data[0].Stations["G0ABC"].Coverage)
+		assert.Equal(t, 0.5, passes.Data[0].Stations["M0XYZ"].Coverage)
+	}
+	server.Close()
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestNew_Stations(t *testing.T) {
+	config := DefaultConfig()
+	config.Stations = map[string]StationConfig{"G0ABC": {}}
+	_, err := New(config)
+	assert.EqualError(t, err, "station G0ABC has no key")
+
+	config.Stations = map[string]StationConfig{"a-station-name-too-long": {Key: "key"}}
+	_, err = New(config)
+	assert.Error(t, err)
+
+	config.Stations = map[string]StationConfig{"G0ABC": {Key: "key"}}
+	config.ReplayWindow = 0
+	_, err = New(config)
+	assert.Error(t, err)
+}
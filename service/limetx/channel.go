@@ -0,0 +1,271 @@
+package limetx
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+)
+
+// txChannel one lime transmit channel with its own settings, sample sources and buffer,
+// all channels key up and down together as the lime streams them together
+type txChannel struct {
+	busy        int32 // 1 while fill has sources queued or samples it could not buffer yet
+	name        string
+	index       int // ChannelA or ChannelB
+	frequency   float64
+	gain        float64
+	antenna     string
+	lpf         float64
+	sampleport  string // empty for no listen socket
+	file        string
+	loopFile    bool
+	bindAddress string
+	tlsServer   *tls.Config
+	rate        float64
+	sources     *fcio.SourceQueue // read one after another, the samples of sources must not be interleaved
+	ring        *SampleRing
+	envelope    *Envelope
+}
+
+// ChannelConfig the settings of one [channels.<name>] table, settings left nil fall back to the
+// top level ones, apart from the sample port and file as sources are never shared
+type ChannelConfig struct {
+	Frequency  *float64 `koanf:"frequency"`
+	Gain       *float64 `koanf:"gain"`
+	Antenna    *string  `koanf:"antenna"`
+	LPF        *float64 `koanf:"lpf"`
+	Channel    *int     `koanf:"channel"`
+	SamplePort *int     `koanf:"sampleport"`
+	File       string   `koanf:"file"`
+	LoopFile   *bool    `koanf:"loopfile"`
+}
+
+// configureChannels builds the transmit channels from the channel tables of the config, with no
+// tables there is a single channel using the top level settings
+func configureChannels(config Config) ([]*txChannel, error) {
+	var names []string
+	for name := range config.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var channels []*txChannel
+	for _, name := range names {
+		table := config.Channels[name]
+		channels = append(channels, newTxChannel(config, name, &table))
+	}
+	if len(channels) == 0 {
+		channels = append(channels, newTxChannel(config, "", nil))
+	}
+
+	used := map[int]string{}
+	ports := map[string]string{}
+	for _, ch := range channels {
+		if ch.index < 0 || ch.index > ChannelB {
+			return nil, fmt.Errorf("channel %s uses unknown lime channel %d", ch.name, ch.index)
+		}
+		if other, ok := used[ch.index]; ok {
+			return nil, fmt.Errorf("channels %s and %s both use lime channel %d", other, ch.name, ch.index)
+		}
+		used[ch.index] = ch.name
+		if ch.sampleport != "" {
+			if other, ok := ports[ch.sampleport]; ok {
+				return nil, fmt.Errorf("channels %s and %s both listen on sample port %s", other, ch.name, ch.sampleport)
+			}
+			ports[ch.sampleport] = ch.name
+		}
+	}
+	return channels, nil
+}
+
+// newTxChannel a channel from its table, or from the top level settings when table is nil
+func newTxChannel(config Config, name string, table *ChannelConfig) *txChannel {
+	ch := &txChannel{
+		name:        name,
+		index:       config.Channel,
+		frequency:   config.Frequency,
+		gain:        config.Gain,
+		antenna:     config.Antenna,
+		lpf:         config.LPF,
+		sampleport:  strconv.Itoa(config.SamplePort),
+		file:        config.File,
+		loopFile:    config.LoopFile,
+		bindAddress: config.BindAddress,
+		rate:        config.Rate,
+		sources:     fcio.NewSourceQueue(4096, 4, fcio.Sequential),
+		ring:        NewSampleRing(config.ringSize()),
+		envelope:    NewEnvelope(config.rampLength()),
+	}
+	if table == nil {
+		ch.name = fmt.Sprintf("%d", ch.index)
+		return ch
+	}
+
+	// the lime channel is set by channel in the table, by a table named a or b, or the top level setting
+	switch {
+	case table.Channel != nil:
+		ch.index = *table.Channel
+	case strings.ToLower(name) == "a":
+		ch.index = ChannelA
+	case strings.ToLower(name) == "b":
+		ch.index = ChannelB
+	}
+	if table.Frequency != nil {
+		ch.frequency = *table.Frequency
+	}
+	if table.Gain != nil {
+		ch.gain = *table.Gain
+	}
+	if table.Antenna != nil {
+		ch.antenna = *table.Antenna
+	}
+	if table.LPF != nil {
+		ch.lpf = *table.LPF
+	}
+	if table.LoopFile != nil {
+		ch.loopFile = *table.LoopFile
+	}
+	// sources are never shared, a named channel without its own port or file has no samples
+	ch.sampleport = ""
+	if table.SamplePort != nil {
+		ch.sampleport = strconv.Itoa(*table.SamplePort)
+	}
+	ch.file = table.File
+	return ch
+}
+
+func (ch *txChannel) String() string {
+	return fmt.Sprintf("channel %s (lime %d, %.0fHz)", ch.name, ch.index, ch.frequency)
+}
+
+// queueFile queues the channel file for transmission, the controller keys up once it has filled the prebuffer
+func (ch *txChannel) queueFile() error {
+	if len(ch.file) == 0 {
+		return nil
+	}
+	txfile, err := os.Open(ch.file)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s error:%v", ch.file, err)
+	}
+	return ch.sources.Add(txfile, fcio.SourceOptions{Name: ch.file, Loop: ch.loopFile})
+}
+
+func (ch *txChannel) listen(ctx context.Context) {
+	hostport := fcio.JoinAddress(ch.bindAddress, ch.sampleport)
+	log.Printf("Opening listen socket for %v...", ch)
+	lsock, err := fcio.Listen("tcp", hostport, ch.tlsServer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer lsock.Close()
+	defer fcio.CloseWhenDone(ctx, lsock)()
+	log.Printf("Listening on socket: %s", hostport)
+
+	for {
+		c, err := lsock.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		ch.handleConnection(c)
+	}
+}
+
+// handleConnection queues the samples of a connection after those already queued, it is dropped
+// after a second without data
+func (ch *txChannel) handleConnection(c net.Conn) {
+	log.Printf("Connection from: %v for %v", c.RemoteAddr(), ch)
+	if err := ch.sources.Add(c, fcio.SourceOptions{Name: c.RemoteAddr().String(), ReadTimeout: time.Second}); err != nil {
+		log.Printf("Failed to read connection, ignoring error:%v", err)
+	}
+}
+
+// isBusy are there samples on the way to the ring
+func (ch *txChannel) isBusy() bool {
+	return atomic.LoadInt32(&ch.busy) == 1
+}
+
+// isIdle has everything queued and buffered been sent
+func (ch *txChannel) isIdle() bool {
+	return !ch.isBusy() && ch.ring.Len() == 0
+}
+
+// fill reads the sources into the ring, converting float32 LE samples to complex, until ctx is
+// done, the sources still open are then closed
+func (ch *txChannel) fill(ctx context.Context) {
+	defer ch.closeReaders()
+	var pending []complex64
+	for ctx.Err() == nil {
+		// only clear busy once everything read has reached the ring
+		if len(pending) > 0 || ch.sources.Len() > 0 {
+			atomic.StoreInt32(&ch.busy, 1)
+		} else {
+			atomic.StoreInt32(&ch.busy, 0)
+		}
+
+		// push any samples the buffer couldn't take last time before reading more
+		if len(pending) > 0 {
+			pending = pending[ch.ring.Write(pending):]
+			if len(pending) > 0 {
+				time.Sleep(time.Millisecond * 10)
+			}
+			continue
+		}
+
+		// wait a little for samples then check again, so busy clears once the sources end
+		nextCtx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+		raw, ok := ch.sources.Next(nextCtx)
+		cancel()
+		if !ok {
+			continue
+		}
+
+		reader := bytes.NewReader(raw)
+		// create sample buffer based on data read
+		samples := make([]complex64, len(raw)/4)
+
+		// fill sample buffer
+		var sample float32
+		for i := 0; i < len(samples); i++ {
+			if err := binary.Read(reader, binary.LittleEndian, &sample); err != nil {
+				panic(err)
+			}
+			samples[i] = complex(sample, 0.0)
+		}
+		fmt.Printf(">")
+		pending = samples
+	}
+}
+
+func (ch *txChannel) closeReaders() {
+	ch.sources.Close()
+	atomic.StoreInt32(&ch.busy, 0)
+}
+
+// discard drops the buffered samples, only while the lime is not reading them
+func (ch *txChannel) discard() int {
+	return ch.ring.Discard()
+}
+
+func (ch *txChannel) logRingStats() {
+	rs := ch.ring.Stats()
+	latency := time.Duration(float64(rs.Buffered) / ch.rate * float64(time.Second))
+	peakLatency := time.Duration(float64(rs.PeakBuffered) / ch.rate * float64(time.Second))
+	log.Printf("Transmit buffer %s: %d/%d samples, latency: %v (peak %v), underruns: %d (%d samples zero filled), overruns: %d",
+		ch.name, rs.Buffered, rs.Capacity, latency, peakLatency, rs.Underruns, rs.UnderrunSamples, rs.Overruns)
+}
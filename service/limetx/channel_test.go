@@ -0,0 +1,54 @@
+package limetx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureChannels(t *testing.T) {
+	config := DefaultConfig()
+	channels, err := configureChannels(config)
+	assert.NoError(t, err)
+	if assert.Len(t, channels, 1) {
+		assert.Equal(t, "0", channels[0].name)
+		assert.Equal(t, "64516", channels[0].sampleport, "top level channel listens on the sample port")
+	}
+
+	// tables named a and b pick their lime channel, settings missing fall back to the top level
+	port, gain, loop, index := 64520, 0.8, true, ChannelA
+	config.File = "top.bin"
+	config.Channels = map[string]ChannelConfig{
+		"b":     {SamplePort: &port, Gain: &gain},
+		"a":     {File: "a.bin", LoopFile: &loop},
+		"extra": {Channel: &index},
+	}
+	_, err = configureChannels(config)
+	assert.Error(t, err, "extra and a both use lime channel A")
+
+	delete(config.Channels, "extra")
+	channels, err = configureChannels(config)
+	assert.NoError(t, err)
+	if assert.Len(t, channels, 2) {
+		a, b := channels[0], channels[1]
+		assert.Equal(t, ChannelA, a.index)
+		assert.Equal(t, ChannelB, b.index)
+		assert.Equal(t, 0.5, a.gain)
+		assert.Equal(t, 0.8, b.gain)
+		assert.Equal(t, config.Frequency, b.frequency)
+		assert.Equal(t, "", a.sampleport, "sources are never shared")
+		assert.Equal(t, "64520", b.sampleport)
+		assert.Equal(t, "a.bin", a.file)
+		assert.Equal(t, "", b.file)
+		assert.True(t, a.loopFile)
+	}
+
+	config.Channels["a"] = ChannelConfig{SamplePort: &port}
+	_, err = configureChannels(config)
+	assert.Error(t, err, "both listen on the same port")
+
+	index = 3
+	config.Channels = map[string]ChannelConfig{"c": {Channel: &index}}
+	_, err = configureChannels(config)
+	assert.Error(t, err, "unknown lime channel")
+}
@@ -0,0 +1,228 @@
+package limetx
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// temperatureHysteresis degrees below the ceiling the lime must cool to before transmitting again
+const temperatureHysteresis = 5.0
+
+// InterlockConfig limits applied to transmission, zero values disable a limit
+type InterlockConfig struct {
+	MaxContinuous  time.Duration // longest single transmission
+	Cooldown       time.Duration // wait after a MaxContinuous trip before transmitting again
+	MaxDutyCycle   float64       // fraction 0..1 of DutyWindow spent transmitting
+	DutyWindow     time.Duration // rolling window the duty cycle is measured over
+	MaxTemperature float64       // lime temperature ceiling in C
+	RequireArm     bool          // transmission needs an ARM command first
+}
+
+// InterlockStatus snapshot of the interlock state
+type InterlockStatus struct {
+	Armed         bool
+	EmergencyStop bool
+	Transmitting  bool
+	Continuous    time.Duration
+	DutyCycle     float64
+	Temperature   float64
+	LastTrip      string
+	LastTripTime  time.Time
+	InhibitReason string
+	TripCount     int
+}
+
+type txPeriod struct {
+	start time.Time
+	end   time.Time
+}
+
+// Interlock tracks transmit time, duty cycle, temperature and the armed state and decides
+// whether transmission is allowed, trips are recorded with their reason
+type Interlock struct {
+	mu              sync.Mutex
+	cfg             InterlockConfig
+	armed           bool
+	estop           bool
+	keyedAt         time.Time
+	history         []txPeriod
+	temperature     float64
+	temperatureTrip bool
+	continuousTrip  time.Time
+	lastTrip        string
+	lastTripTime    time.Time
+	tripCount       int
+}
+
+// NewInterlock creates an Interlock, disarmed if arming is required
+func NewInterlock(cfg InterlockConfig) *Interlock {
+	return &Interlock{cfg: cfg}
+}
+
+// Arm allows transmission and clears an emergency stop
+func (il *Interlock) Arm() {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	il.armed = true
+	il.estop = false
+}
+
+// Disarm prevents transmission until armed again (only enforced when arming is required)
+func (il *Interlock) Disarm() {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	il.armed = false
+}
+
+// EmergencyStop latches a trip that only Arm clears
+func (il *Interlock) EmergencyStop(now time.Time, reason string) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	il.estop = true
+	il.armed = false
+	il.recordTrip(now, "emergency stop: "+reason)
+}
+
+// KeyUp records the start of a transmission
+func (il *Interlock) KeyUp(now time.Time) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	if il.keyedAt.IsZero() {
+		il.keyedAt = now
+	}
+}
+
+// KeyDown records the end of a transmission
+func (il *Interlock) KeyDown(now time.Time) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	if il.keyedAt.IsZero() {
+		return
+	}
+	il.history = append(il.history, txPeriod{il.keyedAt, now})
+	il.keyedAt = time.Time{}
+	il.prune(now)
+}
+
+// SetTemperature records the latest lime temperature reading
+func (il *Interlock) SetTemperature(temperature float64) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	il.temperature = temperature
+}
+
+// Check tests the limits that apply during transmission, returns the trip reason or empty if all ok
+func (il *Interlock) Check(now time.Time) string {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	if il.keyedAt.IsZero() {
+		return ""
+	}
+
+	if il.cfg.MaxContinuous > 0 && now.Sub(il.keyedAt) >= il.cfg.MaxContinuous {
+		il.continuousTrip = now
+		return il.recordTrip(now, fmt.Sprintf("continuous transmission limit %v reached", il.cfg.MaxContinuous))
+	}
+	if il.cfg.MaxDutyCycle > 0 && il.dutyCycle(now) > il.cfg.MaxDutyCycle {
+		return il.recordTrip(now, fmt.Sprintf("duty cycle limit %.0f%% over %v exceeded", il.cfg.MaxDutyCycle*100, il.cfg.DutyWindow))
+	}
+	if il.cfg.MaxTemperature > 0 && il.temperature > il.cfg.MaxTemperature {
+		il.temperatureTrip = true
+		return il.recordTrip(now, fmt.Sprintf("temperature %.1fC above limit %.1fC", il.temperature, il.cfg.MaxTemperature))
+	}
+	return ""
+}
+
+// CanTransmit tests whether transmission may start, returns false and the reason if inhibited
+func (il *Interlock) CanTransmit(now time.Time) (bool, string) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	reason := il.inhibitReason(now)
+	return reason == "", reason
+}
+
+// Status returns a snapshot of the interlock state
+func (il *Interlock) Status(now time.Time) InterlockStatus {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	status := InterlockStatus{
+		Armed:         il.armed,
+		EmergencyStop: il.estop,
+		Transmitting:  !il.keyedAt.IsZero(),
+		DutyCycle:     il.dutyCycle(now),
+		Temperature:   il.temperature,
+		LastTrip:      il.lastTrip,
+		LastTripTime:  il.lastTripTime,
+		InhibitReason: il.inhibitReason(now),
+		TripCount:     il.tripCount,
+	}
+	if status.Transmitting {
+		status.Continuous = now.Sub(il.keyedAt)
+	}
+	return status
+}
+
+func (il *Interlock) inhibitReason(now time.Time) string {
+	if il.estop {
+		return "emergency stop, ARM to clear"
+	}
+	if il.cfg.RequireArm && !il.armed {
+		return "not armed"
+	}
+	if !il.continuousTrip.IsZero() && now.Sub(il.continuousTrip) < il.cfg.Cooldown {
+		return fmt.Sprintf("cooling down after continuous transmission limit, %v remaining", il.cfg.Cooldown-now.Sub(il.continuousTrip))
+	}
+	if il.cfg.MaxDutyCycle > 0 && il.dutyCycle(now) >= il.cfg.MaxDutyCycle {
+		return fmt.Sprintf("duty cycle %.0f%% at limit", il.dutyCycle(now)*100)
+	}
+	if il.temperatureTrip {
+		if il.temperature > il.cfg.MaxTemperature-temperatureHysteresis {
+			return fmt.Sprintf("temperature %.1fC, waiting to cool below %.1fC", il.temperature, il.cfg.MaxTemperature-temperatureHysteresis)
+		}
+		il.temperatureTrip = false
+	}
+	return ""
+}
+
+// dutyCycle fraction of the window spent transmitting, including any current transmission
+func (il *Interlock) dutyCycle(now time.Time) float64 {
+	if il.cfg.DutyWindow <= 0 {
+		return 0
+	}
+	windowStart := now.Add(-il.cfg.DutyWindow)
+	var total time.Duration
+	periods := il.history
+	if !il.keyedAt.IsZero() {
+		periods = append(periods[:len(periods):len(periods)], txPeriod{il.keyedAt, now})
+	}
+	for _, p := range periods {
+		start := p.start
+		if start.Before(windowStart) {
+			start = windowStart
+		}
+		if p.end.After(start) {
+			total += p.end.Sub(start)
+		}
+	}
+	return float64(total) / float64(il.cfg.DutyWindow)
+}
+
+// prune drops transmissions that ended before the duty cycle window
+func (il *Interlock) prune(now time.Time) {
+	windowStart := now.Add(-il.cfg.DutyWindow)
+	keep := il.history[:0]
+	for _, p := range il.history {
+		if p.end.After(windowStart) {
+			keep = append(keep, p)
+		}
+	}
+	il.history = keep
+}
+
+func (il *Interlock) recordTrip(now time.Time, reason string) string {
+	il.lastTrip = reason
+	il.lastTripTime = now
+	il.tripCount++
+	return reason
+}
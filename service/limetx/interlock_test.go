@@ -0,0 +1,95 @@
+package limetx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var epoch = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return epoch.Add(time.Duration(seconds) * time.Second)
+}
+
+func TestInterlock_MaxContinuous(t *testing.T) {
+	il := NewInterlock(InterlockConfig{MaxContinuous: 60 * time.Second, Cooldown: 30 * time.Second})
+
+	il.KeyUp(at(0))
+	assert.Equal(t, "", il.Check(at(59)))
+	assert.NotEqual(t, "", il.Check(at(60)))
+	il.KeyDown(at(60))
+
+	ok, _ := il.CanTransmit(at(89))
+	assert.False(t, ok)
+	ok, _ = il.CanTransmit(at(90))
+	assert.True(t, ok)
+	assert.Equal(t, 1, il.Status(at(90)).TripCount)
+}
+
+func TestInterlock_DutyCycle(t *testing.T) {
+	il := NewInterlock(InterlockConfig{MaxDutyCycle: 0.25, DutyWindow: 100 * time.Second})
+
+	// 20s on, 20s off is under the limit
+	il.KeyUp(at(0))
+	assert.Equal(t, "", il.Check(at(20)))
+	il.KeyDown(at(20))
+	ok, _ := il.CanTransmit(at(40))
+	assert.True(t, ok)
+
+	// second transmission takes it over 25% of the window
+	il.KeyUp(at(40))
+	assert.Equal(t, "", il.Check(at(45)))
+	assert.NotEqual(t, "", il.Check(at(46)))
+	il.KeyDown(at(46))
+	assert.InDelta(t, 0.26, il.Status(at(46)).DutyCycle, 0.001)
+
+	ok, _ = il.CanTransmit(at(50))
+	assert.False(t, ok)
+	// first transmission has slid partly out of the window
+	ok, _ = il.CanTransmit(at(102))
+	assert.True(t, ok)
+}
+
+func TestInterlock_Temperature(t *testing.T) {
+	il := NewInterlock(InterlockConfig{MaxTemperature: 60})
+
+	il.KeyUp(at(0))
+	il.SetTemperature(59)
+	assert.Equal(t, "", il.Check(at(1)))
+	il.SetTemperature(61)
+	assert.NotEqual(t, "", il.Check(at(2)))
+	il.KeyDown(at(2))
+
+	// must cool past the hysteresis before transmitting again
+	il.SetTemperature(57)
+	ok, _ := il.CanTransmit(at(3))
+	assert.False(t, ok)
+	il.SetTemperature(54)
+	ok, _ = il.CanTransmit(at(4))
+	assert.True(t, ok)
+}
+
+func TestInterlock_ArmAndEmergencyStop(t *testing.T) {
+	il := NewInterlock(InterlockConfig{RequireArm: true})
+
+	ok, reason := il.CanTransmit(at(0))
+	assert.False(t, ok)
+	assert.Equal(t, "not armed", reason)
+
+	il.Arm()
+	ok, _ = il.CanTransmit(at(1))
+	assert.True(t, ok)
+
+	il.EmergencyStop(at(2), "test")
+	ok, _ = il.CanTransmit(at(3))
+	assert.False(t, ok)
+	status := il.Status(at(3))
+	assert.True(t, status.EmergencyStop)
+	assert.Equal(t, "emergency stop: test", status.LastTrip)
+
+	il.Arm()
+	ok, _ = il.CanTransmit(at(4))
+	assert.True(t, ok)
+}
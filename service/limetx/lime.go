@@ -0,0 +1,119 @@
+//go:build !nolime
+// +build !nolime
+
+package limetx
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/funcube-dev/limedrv"
+)
+
+// openLime opens the lime with a serial or name matching query, see selectDevice
+func openLime(query string) (Transmitter, error) {
+	devices := limedrv.GetDevices()
+	if len(devices) == 0 {
+		return nil, errors.New("no lime device found, restart container if device added after start or check udev rules and that usbdev package is installed (--backend=sink runs without a lime)")
+	}
+	for _, d := range devices {
+		log.Printf("Found lime: %s serial:%s", d.DeviceName, d.Serial)
+	}
+
+	d, err := selectDevice(devices, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select lime: %v", err)
+	}
+	if len(devices) > 1 {
+		log.Printf("Found %d limes, using: %v", len(devices), d)
+	}
+
+	log.Printf("Opening %s\n", d.DeviceName)
+	return NewLimeTransmitter(d), nil // Open the selected device
+}
+
+// LimeTransmitter Transmitter backed by a LimeSDR
+type LimeTransmitter struct {
+	*limedrv.LMSDevice
+}
+
+// NewLimeTransmitter opens the lime device
+func NewLimeTransmitter(device limedrv.DeviceInfo) *LimeTransmitter {
+	return &LimeTransmitter{limedrv.Open(device)}
+}
+
+// selectDevice picks the lime with a serial or name equal to query (ignoring case), otherwise the
+// only one with a serial or name containing query, an empty query picks the first
+func selectDevice(devices []limedrv.DeviceInfo, query string) (limedrv.DeviceInfo, error) {
+	if len(devices) == 0 {
+		return limedrv.DeviceInfo{}, errors.New("no lime devices")
+	}
+	if query == "" {
+		return devices[0], nil
+	}
+
+	query = strings.ToLower(query)
+	var candidates []limedrv.DeviceInfo
+	for _, d := range devices {
+		serial, name := strings.ToLower(d.Serial), strings.ToLower(d.DeviceName)
+		if serial == query || name == query {
+			return d, nil
+		}
+		if strings.Contains(serial, query) || strings.Contains(name, query) {
+			candidates = append(candidates, d)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return limedrv.DeviceInfo{}, fmt.Errorf("no lime with serial or name matching %q", query)
+	case 1:
+		return candidates[0], nil
+	}
+	var found []string
+	for _, d := range candidates {
+		found = append(found, fmt.Sprintf("%s (serial %s)", d.DeviceName, d.Serial))
+	}
+	return limedrv.DeviceInfo{}, fmt.Errorf("%q matches more than one lime: %s", query, strings.Join(found, ", "))
+}
+
+// TXChannel gets the lime transmit channel
+func (lt *LimeTransmitter) TXChannel(channel int) TransmitChannel {
+	return limeChannel{lt.TXChannels[channel]}
+}
+
+type limeChannel struct {
+	ch *limedrv.LMSChannel
+}
+
+func (lc limeChannel) Enable() TransmitChannel {
+	lc.ch.Enable()
+	return lc
+}
+
+func (lc limeChannel) Disable() TransmitChannel {
+	lc.ch.Disable()
+	return lc
+}
+
+func (lc limeChannel) SetAntennaByName(name string) TransmitChannel {
+	lc.ch.SetAntennaByName(name)
+	return lc
+}
+
+func (lc limeChannel) SetGainNormalized(gain float64) TransmitChannel {
+	lc.ch.SetGainNormalized(gain)
+	return lc
+}
+
+func (lc limeChannel) SetCenterFrequency(centerFrequency float64) TransmitChannel {
+	lc.ch.SetCenterFrequency(centerFrequency)
+	return lc
+}
+
+func (lc limeChannel) SetLPF(bandwidth float64) TransmitChannel {
+	lc.ch.SetLPF(bandwidth)
+	return lc
+}
@@ -0,0 +1,11 @@
+//go:build nolime
+// +build nolime
+
+package limetx
+
+import "errors"
+
+// openLime built with -tags nolime there is no lime to open, only the sink
+func openLime(query string) (Transmitter, error) {
+	return nil, errors.New("built without lime support (-tags nolime), use --backend=sink")
+}
@@ -0,0 +1,41 @@
+//go:build !nolime
+// +build !nolime
+
+package limetx
+
+import (
+	"testing"
+
+	"github.com/funcube-dev/limedrv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectDevice(t *testing.T) {
+	devices := []limedrv.DeviceInfo{
+		{DeviceName: "LimeSDR-USB", Serial: "0009060B00471B22"},
+		{DeviceName: "LimeSDR Mini", Serial: "1D3AC3E7C4C8A5"},
+		{DeviceName: "LimeSDR Mini", Serial: "1D4C6D3A8E2F11"},
+	}
+
+	d, err := selectDevice(devices, "")
+	assert.NoError(t, err)
+	assert.Equal(t, devices[0], d)
+
+	d, err = selectDevice(devices, "1d4c6d3a8e2f11")
+	assert.NoError(t, err)
+	assert.Equal(t, devices[2], d)
+
+	d, err = selectDevice(devices, "usb")
+	assert.NoError(t, err)
+	assert.Equal(t, devices[0], d)
+
+	d, err = selectDevice(devices, "1D3A")
+	assert.NoError(t, err)
+	assert.Equal(t, devices[1], d)
+
+	_, err = selectDevice(devices, "mini")
+	assert.Error(t, err, "ambiguous name")
+
+	_, err = selectDevice(devices, "pluto")
+	assert.Error(t, err)
+}
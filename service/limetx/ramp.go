@@ -0,0 +1,103 @@
+package limetx
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Envelope applies raised cosine amplitude ramps to the transmit stream, ramping in when samples
+// start, out when they run dry, and out when a stop is requested, so the signal never starts or ends abruptly
+type Envelope struct {
+	rampOut      int32 // set by RampOut, read by Apply
+	silentBlocks int32 // blocks Apply has ended in silence since the last signal
+	shape        []float32
+	pos          int // ramp in progress, only used by Apply
+}
+
+// NewEnvelope creates an Envelope with ramps of length samples, 0 disables ramping
+func NewEnvelope(length int) *Envelope {
+	shape := make([]float32, length+1)
+	for i := 1; i <= length; i++ {
+		shape[i] = float32(0.5 * (1 - math.Cos(math.Pi*float64(i)/float64(length))))
+	}
+	return &Envelope{shape: shape}
+}
+
+// Length ramp length in samples
+func (e *Envelope) Length() int {
+	return len(e.shape) - 1
+}
+
+// Reset ramps in from silence on the next Apply, call before the stream starts
+func (e *Envelope) Reset() {
+	e.pos = 0
+	atomic.StoreInt32(&e.silentBlocks, 0)
+	atomic.StoreInt32(&e.rampOut, 0)
+}
+
+// RampOut asks Apply to ramp down and stay silent, SilentBlocks counts the blocks since
+func (e *Envelope) RampOut() {
+	atomic.StoreInt32(&e.silentBlocks, 0)
+	atomic.StoreInt32(&e.rampOut, 1)
+}
+
+// SilentBlocks number of blocks that have ended in silence, reset by new signal or RampOut
+func (e *Envelope) SilentBlocks() int {
+	return int(atomic.LoadInt32(&e.silentBlocks))
+}
+
+// Apply shapes data in place, available is the number of real samples at the start of data,
+// anything after them must already be zero
+func (e *Envelope) Apply(data []complex64, available int) {
+	length := e.Length()
+
+	ending := available < len(data)
+	if atomic.LoadInt32(&e.rampOut) == 1 {
+		// stopping, ramp down over the first samples (unless already silent) and drop the rest
+		limit := length
+		if atomic.LoadInt32(&e.silentBlocks) > 0 {
+			limit = 0
+		}
+		if available > limit {
+			zero(data[limit:available])
+			available = limit
+		}
+		ending = true
+	}
+
+	// the signal ends within this block, ramp down to finish at its last sample
+	rampDownFrom := len(data)
+	if ending {
+		rampDownFrom = available - length
+	}
+
+	// with no ramp length the samples pass through untouched
+	for i := 0; length > 0 && i < available; i++ {
+		if e.pos < length {
+			e.pos++
+		}
+		gain := e.shape[e.pos]
+		if i >= rampDownFrom {
+			if down := e.shape[available-i]; down < gain {
+				gain = down
+			}
+		}
+		if gain < 1 {
+			data[i] *= complex(gain, 0)
+		}
+	}
+
+	if ending {
+		// ramp in again when the signal returns
+		e.pos = 0
+		atomic.AddInt32(&e.silentBlocks, 1)
+	} else {
+		atomic.StoreInt32(&e.silentBlocks, 0)
+	}
+}
+
+func zero(data []complex64) {
+	for i := range data {
+		data[i] = 0
+	}
+}
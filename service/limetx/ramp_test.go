@@ -0,0 +1,91 @@
+package limetx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ones(count int) []complex64 {
+	data := make([]complex64, count)
+	for i := range data {
+		data[i] = 1
+	}
+	return data
+}
+
+func TestEnvelope_RampIn(t *testing.T) {
+	e := NewEnvelope(4)
+
+	data := ones(8)
+	e.Apply(data, len(data))
+	assert.InDelta(t, 0.1464, real(data[0]), 0.0001)
+	assert.InDelta(t, 0.5, real(data[1]), 0.0001)
+	assert.InDelta(t, 0.8536, real(data[2]), 0.0001)
+	assert.Equal(t, ones(5), data[3:])
+	assert.Equal(t, 0, e.SilentBlocks())
+
+	// already at full amplitude, untouched
+	data = ones(8)
+	e.Apply(data, len(data))
+	assert.Equal(t, ones(8), data)
+}
+
+func TestEnvelope_RampDownWhenDry(t *testing.T) {
+	e := NewEnvelope(4)
+	e.Apply(ones(8), 8)
+
+	// only 6 samples available, the last 4 ramp down to finish at the 6th
+	data := ones(8)
+	zero(data[6:])
+	e.Apply(data, 6)
+	assert.Equal(t, ones(2), data[:2])
+	assert.InDelta(t, 0.8536, real(data[3]), 0.0001)
+	assert.InDelta(t, 0.1464, real(data[5]), 0.0001)
+	assert.Equal(t, make([]complex64, 2), data[6:])
+	assert.Equal(t, 1, e.SilentBlocks())
+
+	// signal returns, ramps in again
+	data = ones(8)
+	e.Apply(data, 8)
+	assert.InDelta(t, 0.1464, real(data[0]), 0.0001)
+	assert.Equal(t, 0, e.SilentBlocks())
+}
+
+func TestEnvelope_RampOut(t *testing.T) {
+	e := NewEnvelope(4)
+	e.Apply(ones(8), 8)
+	e.RampOut()
+
+	data := ones(8)
+	e.Apply(data, 8)
+	assert.InDelta(t, 0.8536, real(data[1]), 0.0001)
+	assert.InDelta(t, 0.1464, real(data[3]), 0.0001)
+	assert.Equal(t, make([]complex64, 4), data[4:])
+	assert.Equal(t, 1, e.SilentBlocks())
+
+	// stays silent even with samples available
+	data = ones(8)
+	e.Apply(data, 8)
+	assert.Equal(t, make([]complex64, 8), data)
+	assert.Equal(t, 2, e.SilentBlocks())
+
+	e.Reset()
+	data = ones(8)
+	e.Apply(data, 8)
+	assert.InDelta(t, 0.1464, real(data[0]), 0.0001)
+}
+
+func TestEnvelope_NoRamp(t *testing.T) {
+	e := NewEnvelope(0)
+
+	data := ones(8)
+	e.Apply(data, 8)
+	assert.Equal(t, ones(8), data)
+
+	e.RampOut()
+	data = ones(8)
+	e.Apply(data, 8)
+	assert.Equal(t, make([]complex64, 8), data)
+	assert.Equal(t, 1, e.SilentBlocks())
+}
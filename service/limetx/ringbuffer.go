@@ -0,0 +1,118 @@
+package limetx
+
+import (
+	"sync/atomic"
+)
+
+// RingStats snapshot of the counters kept by a SampleRing
+type RingStats struct {
+	Buffered        int
+	Capacity        int
+	PeakBuffered    int
+	Underruns       uint64
+	UnderrunSamples uint64
+	Overruns        uint64
+}
+
+// SampleRing lock free ring buffer of samples, safe for one writer and one reader goroutine
+type SampleRing struct {
+	// 64 bit atomics first so they stay aligned on 32 bit arm (raspberry pi)
+	head            uint64 // total samples written, only changed by the writer
+	tail            uint64 // total samples read, only changed by the reader
+	underruns       uint64
+	underrunSamples uint64
+	overruns        uint64
+	peak            uint64
+	mask            uint64
+	buf             []complex64
+}
+
+// NewSampleRing creates a ring holding at least size samples (rounded up to a power of two)
+func NewSampleRing(size int) *SampleRing {
+	capacity := 1
+	for capacity < size {
+		capacity <<= 1
+	}
+	return &SampleRing{
+		mask: uint64(capacity - 1),
+		buf:  make([]complex64, capacity),
+	}
+}
+
+// Len number of samples waiting to be read
+func (r *SampleRing) Len() int {
+	return int(atomic.LoadUint64(&r.head) - atomic.LoadUint64(&r.tail))
+}
+
+// Cap total number of samples the ring can hold
+func (r *SampleRing) Cap() int {
+	return len(r.buf)
+}
+
+// Write copies as many samples as will fit, never blocks, returns the number written.
+// A write that cannot take all the samples is counted as an overrun, the caller keeps the rest.
+func (r *SampleRing) Write(samples []complex64) int {
+	head := atomic.LoadUint64(&r.head)
+	tail := atomic.LoadUint64(&r.tail)
+	free := len(r.buf) - int(head-tail)
+
+	count := len(samples)
+	if count > free {
+		count = free
+		atomic.AddUint64(&r.overruns, 1)
+	}
+
+	for i := 0; i < count; i++ {
+		r.buf[(head+uint64(i))&r.mask] = samples[i]
+	}
+	// publish the samples only once they are in place
+	atomic.StoreUint64(&r.head, head+uint64(count))
+
+	if buffered := head + uint64(count) - tail; buffered > atomic.LoadUint64(&r.peak) {
+		atomic.StoreUint64(&r.peak, buffered)
+	}
+	return count
+}
+
+// Read fills dst with buffered samples, never blocks, returns the number read.
+// A read that cannot fill dst is counted as an underrun.
+func (r *SampleRing) Read(dst []complex64) int {
+	tail := atomic.LoadUint64(&r.tail)
+	head := atomic.LoadUint64(&r.head)
+	available := int(head - tail)
+
+	count := len(dst)
+	if count > available {
+		count = available
+		atomic.AddUint64(&r.underruns, 1)
+		atomic.AddUint64(&r.underrunSamples, uint64(len(dst)-count))
+	}
+
+	for i := 0; i < count; i++ {
+		dst[i] = r.buf[(tail+uint64(i))&r.mask]
+	}
+	// release the space only once the samples have been copied out
+	atomic.StoreUint64(&r.tail, tail+uint64(count))
+	return count
+}
+
+// Stats returns a snapshot of the ring counters
+func (r *SampleRing) Stats() RingStats {
+	return RingStats{
+		Buffered:        r.Len(),
+		Capacity:        r.Cap(),
+		PeakBuffered:    int(atomic.LoadUint64(&r.peak)),
+		Underruns:       atomic.LoadUint64(&r.underruns),
+		UnderrunSamples: atomic.LoadUint64(&r.underrunSamples),
+		Overruns:        atomic.LoadUint64(&r.overruns),
+	}
+}
+
+// Discard drops all buffered samples, returns the number dropped.
+// Only call from the reading side, or while nothing is reading.
+func (r *SampleRing) Discard() int {
+	tail := atomic.LoadUint64(&r.tail)
+	head := atomic.LoadUint64(&r.head)
+	atomic.StoreUint64(&r.tail, head)
+	return int(head - tail)
+}
@@ -0,0 +1,92 @@
+package limetx
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeSamples(start, count int) []complex64 {
+	samples := make([]complex64, count)
+	for i := range samples {
+		samples[i] = complex(float32(start+i), 0)
+	}
+	return samples
+}
+
+func TestSampleRing_NewSampleRing(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want int
+	}{
+		{"power of two", 1024, 1024},
+		{"rounded up", 1000, 1024},
+		{"one", 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NewSampleRing(tt.size).Cap())
+		})
+	}
+}
+
+func TestSampleRing_PartialReadWrite(t *testing.T) {
+	r := NewSampleRing(8)
+
+	// longer than the ring, only part is taken
+	assert.Equal(t, 8, r.Write(makeSamples(0, 10)))
+	assert.Equal(t, uint64(1), r.Stats().Overruns)
+
+	// shorter read than buffered, remainder stays for the next read
+	dst := make([]complex64, 3)
+	assert.Equal(t, 3, r.Read(dst))
+	assert.Equal(t, makeSamples(0, 3), dst)
+	assert.Equal(t, 5, r.Len())
+
+	// wraps around the end of the buffer
+	assert.Equal(t, 3, r.Write(makeSamples(8, 3)))
+	dst = make([]complex64, 8)
+	assert.Equal(t, 8, r.Read(dst))
+	assert.Equal(t, makeSamples(3, 8), dst)
+	assert.Equal(t, uint64(0), r.Stats().Underruns)
+
+	// nothing left, read falls short
+	assert.Equal(t, 0, r.Read(dst))
+	stats := r.Stats()
+	assert.Equal(t, uint64(1), stats.Underruns)
+	assert.Equal(t, uint64(8), stats.UnderrunSamples)
+	assert.Equal(t, 8, stats.PeakBuffered)
+}
+
+func TestSampleRing_Concurrent(t *testing.T) {
+	r := NewSampleRing(64)
+	total := 10000
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for written := 0; written < total; {
+			end := written + 37
+			if end > total {
+				end = total
+			}
+			written += r.Write(makeSamples(written, end-written))
+		}
+	}()
+
+	dst := make([]complex64, 23)
+	for read := 0; read < total; {
+		n := r.Read(dst)
+		for i := 0; i < n; i++ {
+			if dst[i] != complex(float32(read+i), 0) {
+				t.Fatalf("sample %d, want:%v, got:%v", read+i, complex(float32(read+i), 0), dst[i])
+			}
+		}
+		read += n
+	}
+	wg.Wait()
+	assert.Equal(t, 0, r.Len())
+}
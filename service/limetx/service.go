@@ -0,0 +1,610 @@
+// Package limetx transmits dbpsk samples on a LimeSDR (or a sink writing them to a file), samples
+// are read from a file or from connections on the sample port of each transmit channel
+package limetx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brian-armstrong/gpio"
+	"github.com/funcube-dev/go/fcio"
+)
+
+// the lime transmit channels and the antenna used by default, numbered and named as limedrv does
+const (
+	ChannelA = 0
+	ChannelB = 1
+	BAND2    = "BAND2"
+)
+
+// Config settings for the limetx service, the koanf tags match the limetx flags
+type Config struct {
+	Frequency        float64 `koanf:"frequency"`
+	Rate             float64 `koanf:"rate"`
+	Oversample       int     `koanf:"oversample"`
+	Antenna          string  `koanf:"antenna"`
+	Channel          int     `koanf:"channel"`
+	LPF              float64 `koanf:"lpf"`
+	Gain             float64 `koanf:"gain"`
+	CalibrationDelay float64 `koanf:"calibrationdelay"`
+	BindAddress      string  `koanf:"bindaddress"`
+	SamplePort       int     `koanf:"sampleport"`
+	CommandPort      int     `koanf:"commandport"`
+	File             string  `koanf:"file"`
+	LoopFile         bool    `koanf:"loopfile"`
+	IdleTimeout      int     `koanf:"idletimeout"`
+	Prebuffer        float64 `koanf:"prebuffer"`
+	Buffer           float64 `koanf:"buffer"`
+	GPIO             int     `koanf:"gpio"`
+	MaxTxSeconds     float64 `koanf:"maxtxseconds"`
+	TxCooldown       float64 `koanf:"txcooldown"`
+	MaxDutyCycle     float64 `koanf:"maxdutycycle"`
+	DutyWindow       float64 `koanf:"dutywindow"`
+	MaxTemperature   float64 `koanf:"maxtemperature"`
+	TemperaturePoll  float64 `koanf:"temperaturepoll"`
+	PTTLead          float64 `koanf:"pttlead"`
+	PTTTail          float64 `koanf:"ptttail"`
+	RampMs           float64 `koanf:"rampms"`
+	RequireArm       bool    `koanf:"requirearm"`
+	SimulateGPIO     bool    `koanf:"simulategpio"`
+	Device           string  `koanf:"device"`
+	Backend          string  `koanf:"backend"`
+	ShutdownTimeout  float64 `koanf:"shutdowntimeout"`
+	SinkFile         string  `koanf:"sinkfile"`
+
+	// TLS for the sample and command listeners, with a ca only its clients can make us transmit
+	TLS fcio.TLSConfig `koanf:"tls"`
+
+	// Channels the [channels.<name>] tables, each drives its own lime channel
+	Channels map[string]ChannelConfig `koanf:"channels"`
+
+	// Transmitter nil to open the backend
+	Transmitter Transmitter `koanf:"-"`
+	// PTT nil to use the gpio (or simulategpio) setting
+	PTT PTTPin `koanf:"-"`
+}
+
+// DefaultConfig the settings limetx uses when none are given
+func DefaultConfig() Config {
+	return Config{
+		Frequency:        145.893e6,
+		Rate:             48000,
+		Oversample:       32,
+		Antenna:          BAND2,
+		Channel:          ChannelA,
+		LPF:              5e6,
+		Gain:             0.5,
+		CalibrationDelay: 5,
+		BindAddress:      "0.0.0.0",
+		SamplePort:       0xFC04,
+		CommandPort:      0xFC05,
+		IdleTimeout:      30,
+		Prebuffer:        1,
+		Buffer:           5,
+		GPIO:             -1,
+		TxCooldown:       60,
+		DutyWindow:       600,
+		TemperaturePoll:  5,
+		PTTLead:          0.1,
+		PTTTail:          0.1,
+		RampMs:           5,
+		Backend:          "lime",
+		ShutdownTimeout:  5,
+	}
+}
+
+// Service buffers the samples of each channel and keys the transmitter up and down around them
+type Service struct {
+	config    Config
+	tlsServer *tls.Config
+	channels  []*txChannel
+	lime      Transmitter
+	pttPin    PTTPin
+	interlock *Interlock
+	txMutex   sync.Mutex
+}
+
+// New configures the channels, queues their files and opens the ptt pin and transmitter
+func New(config Config) (*Service, error) {
+	tlsServer, err := config.TLS.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	channels, err := configureChannels(config)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		config:    config,
+		tlsServer: tlsServer,
+		channels:  channels,
+		lime:      config.Transmitter,
+		pttPin:    config.PTT,
+	}
+	for _, ch := range s.channels {
+		ch.tlsServer = tlsServer
+		log.Printf("Transmitting on %v, buffer %d samples, prebuffer %d samples", ch, ch.ring.Cap(), config.prebufferSize())
+	}
+	for _, ch := range s.channels {
+		if err := ch.queueFile(); err != nil {
+			s.closeReaders()
+			return nil, err
+		}
+	}
+
+	if s.pttPin == nil {
+		if config.SimulateGPIO {
+			s.pttPin = &SimulatedPin{}
+		} else if config.GPIO > 0 {
+			s.pttPin = gpio.NewOutput(uint(config.GPIO), false)
+		}
+	}
+
+	if s.lime == nil {
+		s.lime, err = openTransmitter(config)
+		if err != nil {
+			s.closeReaders()
+			if s.pttPin != nil {
+				s.pttPin.Cleanup()
+			}
+			return nil, err
+		}
+	}
+
+	log.Printf("Temp:%f/n", s.lime.GetTemperature())
+	log.Println(s.lime.String())
+
+	s.interlock = NewInterlock(config.interlockConfig())
+	s.interlock.SetTemperature(s.lime.GetTemperature())
+	if config.RequireArm {
+		log.Println("Transmission requires ARM on the command port")
+	}
+	return s, nil
+}
+
+// Run transmits until ctx is done, then stops the services and unkeys, closing the transmitter and
+// ptt pin, if the services are still running at the shutdown timeout the gpio is dropped regardless
+func (s *Service) Run(ctx context.Context) error {
+	var services fcio.Group
+	s.startServices(ctx, &services)
+
+	<-ctx.Done()
+	if !s.shutdown(&services, seconds(s.config.ShutdownTimeout)) {
+		if s.pttPin != nil {
+			s.pttPin.Cleanup()
+		}
+		return errors.New("shutdown timed out")
+	}
+	s.lime.Close()
+	if s.pttPin != nil {
+		s.pttPin.Cleanup()
+	}
+	return nil
+}
+
+// startServices starts the channel fillers and listeners, the transmit controller, the interlock
+// monitor and the command listener, they all stop once ctx is done
+func (s *Service) startServices(ctx context.Context, services *fcio.Group) {
+	for _, ch := range s.channels {
+		ch := ch
+		services.Go(func() { ch.fill(ctx) })
+		if ch.sampleport != "" {
+			services.Go(func() { ch.listen(ctx) })
+		}
+	}
+	services.Go(func() { s.controlTransmit(ctx) })
+	services.Go(func() { s.monitorInterlocks(ctx) })
+	services.Go(func() { s.commandListen(ctx) })
+}
+
+// shutdown waits for the services to stop then unkeys the transmitter, if the services are still
+// running at the timeout one of them may hold the transmitter so the gpio is dropped straight away
+// and false returned
+func (s *Service) shutdown(services *fcio.Group, timeout time.Duration) bool {
+	if !services.Wait(timeout) {
+		log.Println("Timed out waiting for services to stop, dropping gpio")
+		if s.pttPin != nil {
+			s.pttPin.Low()
+		}
+		return false
+	}
+	s.transmitStop()
+	return true
+}
+
+func (s *Service) closeReaders() {
+	for _, ch := range s.channels {
+		ch.closeReaders()
+	}
+}
+
+// openTransmitter opens the configured backend, the lime or a sink for running without hardware
+func openTransmitter(config Config) (Transmitter, error) {
+	switch config.Backend {
+	case "lime":
+		return openLime(config.Device)
+	case "sink":
+		log.Printf("Using sink transmitter, file:%q", config.SinkFile)
+		return NewSinkTransmitter(config.SinkFile), nil
+	}
+	return nil, fmt.Errorf("unknown transmitter backend: %s (use lime or sink)", config.Backend)
+}
+
+func (s *Service) commandListen(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.CommandPort))
+	log.Println("Opening command listen socket...")
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer lsock.Close()
+	defer fcio.CloseWhenDone(ctx, lsock)()
+	log.Printf("Listening for commands on socket: %s", hostport)
+
+	// connections are closed when ctx is done, wait for them before returning
+	var connections fcio.Group
+	defer connections.Wait(time.Second)
+	for {
+		c, err := lsock.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		connections.Go(func() {
+			defer fcio.CloseWhenDone(ctx, c)()
+			s.handleCommandConnection(c)
+		})
+	}
+}
+
+// transmitStart configures the channels and keys up, giving up without keying if ctx is done during calibration
+func (s *Service) transmitStart(ctx context.Context) {
+	s.txMutex.Lock()
+	defer s.txMutex.Unlock()
+
+	sampleRate := s.config.Rate
+	oversample := s.config.Oversample
+	calibrationDelay := time.Duration(s.config.CalibrationDelay) * time.Second
+
+	if s.pttPin != nil {
+		log.Printf("Set gpio Low...")
+		s.pttPin.Low()
+	}
+
+	log.Printf("Set Sample rate:%f oversample:%d", sampleRate, oversample)
+	s.lime.SetSampleRate(sampleRate, oversample)
+
+	// Set lpf starts calibration, so delay before starting tx
+	for _, ch := range s.channels {
+		log.Printf("Configuring %v gain:%.2f antenna:%s", ch, ch.gain, ch.antenna)
+		s.lime.TXChannel(ch.index).Enable().
+			SetAntennaByName(ch.antenna). // BAND2 by default
+			SetGainNormalized(ch.gain).
+			SetCenterFrequency(ch.frequency).
+			SetLPF(ch.lpf)
+	}
+
+	log.Println("Starting Calibration Delay:", calibrationDelay)
+	if !fcio.Sleep(ctx, calibrationDelay) {
+		log.Println("Shutting down during calibration")
+		s.disableChannels()
+		return
+	}
+	log.Println("Stopping Calibration Delay:", calibrationDelay)
+
+	// an interlock may have tripped (or emergency stop) during calibration
+	if ok, reason := s.interlock.CanTransmit(time.Now()); !ok {
+		log.Printf("Transmit inhibited after calibration: %s", reason)
+		s.disableChannels()
+		return
+	}
+
+	if s.pttPin != nil {
+		log.Printf("Set gpio High...")
+		s.pttPin.High()
+		// give relays and PA time to switch before any RF
+		time.Sleep(seconds(s.config.PTTLead))
+	}
+
+	log.Printf("Setting callback...")
+	for _, ch := range s.channels {
+		ch.envelope.Reset()
+	}
+	s.lime.SetTXCallback(s.realSampleCallback)
+	log.Printf("Starting...")
+	s.lime.Start()
+	s.interlock.KeyUp(time.Now())
+}
+
+// transmitStop ramps the signal down, stops streaming, then drops the gpio after the ptt tail delay
+func (s *Service) transmitStop() {
+	s.stopTransmit(true)
+}
+
+func (s *Service) stopTransmit(sequenced bool) {
+	s.txMutex.Lock()
+	defer s.txMutex.Unlock()
+
+	wasRunning := s.lime.IsRunning()
+	if wasRunning {
+		if sequenced {
+			s.rampOut()
+		}
+		s.lime.Stop()
+	}
+	s.disableChannels()
+	s.interlock.KeyDown(time.Now())
+
+	if s.pttPin != nil {
+		// keep relays and PA keyed until the RF has gone
+		if sequenced && wasRunning {
+			time.Sleep(seconds(s.config.PTTTail))
+		}
+		log.Printf("Set gpio Low...")
+		s.pttPin.Low()
+	}
+
+	log.Println("Stopped Transmit")
+	for _, ch := range s.channels {
+		ch.logRingStats()
+	}
+}
+
+func (s *Service) disableChannels() {
+	for _, ch := range s.channels {
+		s.lime.TXChannel(ch.index).Disable()
+	}
+}
+
+// rampOut waits for the callback to ramp the signal down and queue a silent block after it, on every channel
+func (s *Service) rampOut() {
+	for _, ch := range s.channels {
+		ch.envelope.RampOut()
+	}
+	deadline := time.Now().Add(time.Second + seconds(s.config.RampMs/1000))
+	for _, ch := range s.channels {
+		for ch.envelope.SilentBlocks() < 2 {
+			if time.Now().After(deadline) {
+				log.Printf("Timed out waiting for transmit ramp out on %v", ch)
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+}
+
+// discardBuffered drops the samples buffered on every channel so nothing stale is sent later
+func (s *Service) discardBuffered() int {
+	discarded := 0
+	for _, ch := range s.channels {
+		discarded += ch.discard()
+	}
+	return discarded
+}
+
+// emergencyStop drops the gpio and stops streaming at once, no ramp or tail delay
+func (s *Service) emergencyStop(reason string) {
+	if s.pttPin != nil {
+		s.pttPin.Low()
+	}
+	s.interlock.EmergencyStop(time.Now(), reason)
+	log.Printf("*** Emergency stop: %s ***", reason)
+	s.stopTransmit(false)
+	if discarded := s.discardBuffered(); discarded > 0 {
+		log.Printf("Discarded %d buffered samples", discarded)
+	}
+}
+
+// rampLength number of samples in the raised cosine ramp in and out
+func (config Config) rampLength() int {
+	return int(config.RampMs / 1000 * config.Rate)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// tripStop stops transmission because an interlock tripped, buffered samples are dropped
+// so nothing stale is sent when transmission resumes
+func (s *Service) tripStop(reason string) {
+	log.Printf("*** Interlock tripped: %s ***", reason)
+	s.transmitStop()
+	if discarded := s.discardBuffered(); discarded > 0 {
+		log.Printf("Discarded %d buffered samples", discarded)
+	}
+}
+
+// monitorInterlocks polls the temperature and checks the transmit limits
+func (s *Service) monitorInterlocks(ctx context.Context) {
+	temperaturePoll := seconds(s.config.TemperaturePoll)
+	lastPoll := time.Now()
+	ticker := time.NewTicker(time.Millisecond * 250)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		now := time.Now()
+		// keep polling when idle too, so a temperature trip can clear
+		if temperaturePoll > 0 && now.Sub(lastPoll) >= temperaturePoll {
+			s.interlock.SetTemperature(s.lime.GetTemperature())
+			lastPoll = now
+		}
+		if !s.lime.IsRunning() {
+			continue
+		}
+		if reason := s.interlock.Check(now); reason != "" {
+			s.tripStop(reason)
+		}
+	}
+}
+
+func (config Config) interlockConfig() InterlockConfig {
+	return InterlockConfig{
+		MaxContinuous:  seconds(config.MaxTxSeconds),
+		Cooldown:       seconds(config.TxCooldown),
+		MaxDutyCycle:   config.MaxDutyCycle,
+		DutyWindow:     seconds(config.DutyWindow),
+		MaxTemperature: config.MaxTemperature,
+		RequireArm:     config.RequireArm,
+	}
+}
+
+// prebufferSize number of samples to buffer before keying up
+func (config Config) prebufferSize() int {
+	return int(config.Prebuffer * config.Rate)
+}
+
+// ringSize number of samples the transmit buffer must hold, always more than the prebuffer
+func (config Config) ringSize() int {
+	size := int(config.Buffer * config.Rate)
+	if minSize := config.prebufferSize() + 4096; size < minSize {
+		size = minSize
+	}
+	return size
+}
+
+// handleCommandConnection reads one command per line:
+// ARM, DISARM, STOP (emergency stop, unkeys immediately) and STATUS
+func (s *Service) handleCommandConnection(c net.Conn) {
+	log.Printf("Connection from: %v", c)
+	defer c.Close()
+
+	scanner := bufio.NewScanner(c)
+	for {
+		c.SetReadDeadline(time.Now().Add(time.Minute))
+		if !scanner.Scan() {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(scanner.Text()))
+		if command == "" {
+			continue
+		}
+		log.Printf("Command: %s from: %v", command, c.RemoteAddr())
+		fmt.Fprintln(c, s.runCommand(command))
+	}
+}
+
+func (s *Service) runCommand(command string) string {
+	now := time.Now()
+	switch command {
+	case "ARM":
+		s.interlock.Arm()
+		return "OK armed"
+	case "DISARM":
+		s.interlock.Disarm()
+		if s.config.RequireArm {
+			s.tripStop("disarmed")
+		}
+		return "OK disarmed"
+	case "STOP", "ESTOP":
+		s.emergencyStop("command port")
+		return "OK stopped, ARM to clear"
+	case "STATUS":
+		status := s.interlock.Status(now)
+		return fmt.Sprintf("OK armed:%t estop:%t transmitting:%t continuous:%v duty:%.1f%% temperature:%.1fC trips:%d lasttrip:%q inhibit:%q",
+			status.Armed, status.EmergencyStop, status.Transmitting, status.Continuous.Round(time.Second), status.DutyCycle*100,
+			status.Temperature, status.TripCount, status.LastTrip, status.InhibitReason)
+	}
+	return "ERROR unknown command: " + command
+}
+
+// controlTransmit keys up once a channel has buffered enough, or early if its sources finished
+// before filling the prebuffer, and stops once every channel has been idle for the idle timeout
+func (s *Service) controlTransmit(ctx context.Context) {
+	idleTimeout := time.Duration(s.config.IdleTimeout) * time.Second
+	prebuffer := s.config.prebufferSize()
+	var idleSince time.Time
+	ticker := time.NewTicker(time.Millisecond * 20)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if !s.lime.IsRunning() {
+			idleSince = time.Time{}
+			if !s.readyToTransmit(prebuffer) {
+				continue
+			}
+			if ok, reason := s.interlock.CanTransmit(time.Now()); ok {
+				s.transmitStart(ctx)
+			} else {
+				log.Printf("Transmit inhibited (%s), discarded %d samples", reason, s.discardBuffered())
+			}
+			continue
+		}
+
+		// only count idle time once everything buffered has been sent
+		if !s.channelsIdle() {
+			idleSince = time.Time{}
+			continue
+		}
+		if idleSince.IsZero() {
+			idleSince = time.Now()
+		}
+		if time.Since(idleSince) > idleTimeout {
+			s.transmitStop()
+			idleSince = time.Time{}
+		}
+	}
+}
+
+func (s *Service) readyToTransmit(prebuffer int) bool {
+	for _, ch := range s.channels {
+		if buffered := ch.ring.Len(); buffered > 0 && (buffered >= prebuffer || !ch.isBusy()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) channelsIdle() bool {
+	for _, ch := range s.channels {
+		if !ch.isIdle() {
+			return false
+		}
+	}
+	return true
+}
+
+// realSampleCallback fills the lime transmit buffer of a channel from its ring, running short of
+// samples is an underrun which is padded with silence so the stream stays continuous, the envelope
+// ramps the signal in and out around any silence
+func (s *Service) realSampleCallback(data []complex64, channel int) int {
+	var ch *txChannel
+	for _, c := range s.channels {
+		if c.index == channel {
+			ch = c
+		}
+	}
+	if ch == nil {
+		zero(data)
+		return len(data)
+	}
+
+	sampleCount := ch.ring.Read(data)
+	if sampleCount < len(data) {
+		fmt.Printf("_")
+		zero(data[sampleCount:])
+	} else {
+		fmt.Printf("<")
+	}
+	ch.envelope.Apply(data, sampleCount)
+	return len(data)
+}
@@ -0,0 +1,400 @@
+package limetx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+)
+
+// Transmitter the device calls used to key up and stream samples, implemented by the lime and the sink
+type Transmitter interface {
+	SetSampleRate(sampleRate float64, oversample int)
+	TXChannel(channel int) TransmitChannel
+	SetTXCallback(cb func([]complex64, int) int)
+	Start()
+	Stop()
+	IsRunning() bool
+	GetTemperature() float64
+	Close()
+	String() string
+}
+
+// TransmitChannel the channel calls used when keying up
+type TransmitChannel interface {
+	Enable() TransmitChannel
+	Disable() TransmitChannel
+	SetAntennaByName(name string) TransmitChannel
+	SetGainNormalized(gain float64) TransmitChannel
+	SetCenterFrequency(centerFrequency float64) TransmitChannel
+	SetLPF(bandwidth float64) TransmitChannel
+}
+
+// PTTPin output driven high while transmitting, satisfied by gpio.Pin
+type PTTPin interface {
+	High() error
+	Low() error
+	Cleanup()
+}
+
+// sinkChunkSize samples requested from the callback per pass, small enough to pace smoothly
+const sinkChunkSize = 4096
+
+// SinkTransmitter Transmitter that writes the samples to a file (or discards them) at the real time rate
+type SinkTransmitter struct {
+	mu          sync.Mutex
+	fileName    string
+	sampleRate  float64
+	channels    []*SinkChannel
+	callback    func([]complex64, int) int
+	stop        chan struct{}
+	done        chan struct{}
+	samplesSent uint64
+}
+
+// SinkChannel records the settings applied to a sink channel
+type SinkChannel struct {
+	Enabled         bool
+	Antenna         string
+	Gain            float64
+	CenterFrequency float64
+	LPF             float64
+}
+
+// NewSinkTransmitter creates a sink, fileName ending .wav writes a two channel float wav,
+// any other name writes raw interleaved float32 IQ, an empty name discards the samples.
+// Channel A writes fileName, channel B a file named with _1 before the extension
+func NewSinkTransmitter(fileName string) *SinkTransmitter {
+	return &SinkTransmitter{
+		fileName:   fileName,
+		sampleRate: 48000,
+		channels:   []*SinkChannel{{}, {}},
+	}
+}
+
+// SetSampleRate sets the rate the samples are consumed at, oversample is ignored
+func (st *SinkTransmitter) SetSampleRate(sampleRate float64, oversample int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.sampleRate = sampleRate
+}
+
+// TXChannel gets the sink channel
+func (st *SinkTransmitter) TXChannel(channel int) TransmitChannel {
+	return sinkChannelRef{st, channel}
+}
+
+// Channel gets a copy of the settings of a sink channel
+func (st *SinkTransmitter) Channel(channel int) SinkChannel {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return *st.channels[channel]
+}
+
+// SetTXCallback sets the function called to fill each chunk of samples
+func (st *SinkTransmitter) SetTXCallback(cb func([]complex64, int) int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.callback = cb
+}
+
+// Start opens the output and starts consuming samples
+func (st *SinkTransmitter) Start() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.stop != nil {
+		return
+	}
+
+	// only channels enabled before starting are written
+	outs := make([]sinkOutput, len(st.channels))
+	for ch, channel := range st.channels {
+		outs[ch] = discardOutput{}
+		if !channel.Enabled {
+			continue
+		}
+		fileName := sinkChannelFile(st.fileName, ch)
+		out, err := newSinkOutput(fileName, int(st.sampleRate))
+		if err != nil {
+			log.Printf("Failed to open sink file %s, discarding samples, error:%v", fileName, err)
+			continue
+		}
+		outs[ch] = out
+	}
+
+	st.stop = make(chan struct{})
+	st.done = make(chan struct{})
+	go st.run(outs, st.callback, st.sampleRate, st.stop, st.done)
+}
+
+// Stop stops consuming samples and closes the output
+func (st *SinkTransmitter) Stop() {
+	st.mu.Lock()
+	stop, done := st.stop, st.done
+	st.stop, st.done = nil, nil
+	st.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// IsRunning are samples being consumed
+func (st *SinkTransmitter) IsRunning() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.stop != nil
+}
+
+// GetTemperature the sink never warms up
+func (st *SinkTransmitter) GetTemperature() float64 {
+	return 25.0
+}
+
+// SamplesSent total samples consumed by the sink
+func (st *SinkTransmitter) SamplesSent() uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.samplesSent
+}
+
+// Close stops the sink
+func (st *SinkTransmitter) Close() {
+	st.Stop()
+}
+
+func (st *SinkTransmitter) String() string {
+	if st.fileName == "" {
+		return "Sink transmitter (discarding samples)"
+	}
+	return fmt.Sprintf("Sink transmitter (writing to %s)", st.fileName)
+}
+
+func (st *SinkTransmitter) run(outs []sinkOutput, cb func([]complex64, int) int, sampleRate float64, stop, done chan struct{}) {
+	defer close(done)
+	defer func() {
+		for _, out := range outs {
+			if err := out.Close(); err != nil {
+				log.Printf("Failed to close sink file %s, error:%v", st.fileName, err)
+			}
+		}
+	}()
+
+	data := make([]complex64, sinkChunkSize)
+	started := time.Now()
+	var sent uint64
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		for ch, channel := range st.channelsEnabled() {
+			if !channel || cb == nil {
+				continue
+			}
+			count := cb(data, ch)
+			if count > len(data) {
+				count = len(data)
+			}
+			if err := outs[ch].Write(data[:count]); err != nil {
+				log.Printf("Failed to write sink file %s, error:%v", st.fileName, err)
+			}
+		}
+
+		// pace to the sample rate, as the lime hardware would
+		sent += sinkChunkSize
+		st.mu.Lock()
+		st.samplesSent = sent
+		st.mu.Unlock()
+		due := started.Add(time.Duration(float64(sent) / sampleRate * float64(time.Second)))
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Until(due)):
+		}
+	}
+}
+
+func (st *SinkTransmitter) channelsEnabled() []bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	enabled := make([]bool, len(st.channels))
+	for i, ch := range st.channels {
+		enabled[i] = ch.Enabled
+	}
+	return enabled
+}
+
+type sinkChannelRef struct {
+	st      *SinkTransmitter
+	channel int
+}
+
+func (sc sinkChannelRef) update(fn func(ch *SinkChannel)) TransmitChannel {
+	sc.st.mu.Lock()
+	defer sc.st.mu.Unlock()
+	fn(sc.st.channels[sc.channel])
+	return sc
+}
+
+func (sc sinkChannelRef) Enable() TransmitChannel {
+	return sc.update(func(ch *SinkChannel) { ch.Enabled = true })
+}
+
+func (sc sinkChannelRef) Disable() TransmitChannel {
+	return sc.update(func(ch *SinkChannel) { ch.Enabled = false })
+}
+
+func (sc sinkChannelRef) SetAntennaByName(name string) TransmitChannel {
+	return sc.update(func(ch *SinkChannel) { ch.Antenna = name })
+}
+
+func (sc sinkChannelRef) SetGainNormalized(gain float64) TransmitChannel {
+	return sc.update(func(ch *SinkChannel) { ch.Gain = gain })
+}
+
+func (sc sinkChannelRef) SetCenterFrequency(centerFrequency float64) TransmitChannel {
+	return sc.update(func(ch *SinkChannel) { ch.CenterFrequency = centerFrequency })
+}
+
+func (sc sinkChannelRef) SetLPF(bandwidth float64) TransmitChannel {
+	return sc.update(func(ch *SinkChannel) { ch.LPF = bandwidth })
+}
+
+// sinkChannelFile the file a channel writes, channel A uses fileName as is
+func sinkChannelFile(fileName string, channel int) string {
+	if fileName == "" || channel == 0 {
+		return fileName
+	}
+	ext := filepath.Ext(fileName)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(fileName, ext), channel, ext)
+}
+
+type sinkOutput interface {
+	Write(samples []complex64) error
+	Close() error
+}
+
+func newSinkOutput(fileName string, sampleRate int) (sinkOutput, error) {
+	if fileName == "" {
+		return discardOutput{}, nil
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(fileName), ".wav") {
+		ww, err := fcio.NewWavWriter(f, sampleRate, 2)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return wavOutput{ww, f}, nil
+	}
+	return &rawOutput{bufio.NewWriter(f), f}, nil
+}
+
+type discardOutput struct{}
+
+func (discardOutput) Write(samples []complex64) error { return nil }
+func (discardOutput) Close() error                    { return nil }
+
+type wavOutput struct {
+	ww   *fcio.WavWriter
+	file *os.File
+}
+
+func (wo wavOutput) Write(samples []complex64) error {
+	return wo.ww.WriteIQ(samples)
+}
+
+func (wo wavOutput) Close() error {
+	if err := wo.ww.Close(); err != nil {
+		wo.file.Close()
+		return err
+	}
+	return wo.file.Close()
+}
+
+// rawOutput interleaved float32 little endian I and Q, the usual complex64 file layout
+type rawOutput struct {
+	writer *bufio.Writer
+	file   io.Closer
+}
+
+func (ro *rawOutput) Write(samples []complex64) error {
+	return binary.Write(ro.writer, binary.LittleEndian, samples)
+}
+
+func (ro *rawOutput) Close() error {
+	if err := ro.writer.Flush(); err != nil {
+		ro.file.Close()
+		return err
+	}
+	return ro.file.Close()
+}
+
+// SimulatedPin PTTPin that records its state instead of driving a gpio
+type SimulatedPin struct {
+	mu          sync.Mutex
+	high        bool
+	transitions int
+}
+
+// High sets the simulated pin high
+func (sp *SimulatedPin) High() error {
+	sp.set(true)
+	return nil
+}
+
+// Low sets the simulated pin low
+func (sp *SimulatedPin) Low() error {
+	sp.set(false)
+	return nil
+}
+
+// Cleanup leaves the simulated pin low
+func (sp *SimulatedPin) Cleanup() {
+	sp.set(false)
+}
+
+// IsHigh current state of the simulated pin
+func (sp *SimulatedPin) IsHigh() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.high
+}
+
+// Transitions number of times the simulated pin has changed state
+func (sp *SimulatedPin) Transitions() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.transitions
+}
+
+func (sp *SimulatedPin) set(high bool) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.high == high {
+		return
+	}
+	sp.high = high
+	sp.transitions++
+	if high {
+		log.Printf("Simulated gpio High")
+	} else {
+		log.Printf("Simulated gpio Low")
+	}
+}
@@ -0,0 +1,186 @@
+package limetx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfig keys up quickly from a prebuffer of 0.05s, listening on loopback ephemeral ports
+func testConfig(sink *SinkTransmitter, pin *SimulatedPin) Config {
+	config := DefaultConfig()
+	config.CalibrationDelay = 0
+	config.Prebuffer = 0.05
+	config.PTTLead = 0
+	config.PTTTail = 0
+	config.BindAddress = "127.0.0.1"
+	config.SamplePort = 0
+	config.CommandPort = 0
+	config.Transmitter = sink
+	config.PTT = pin
+	return config
+}
+
+func newService(t *testing.T, config Config) *Service {
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// floatSource raw float32 LE samples start, start+step, ... as fcdecode would send them
+func floatSource(count int, start, step float32) *fcio.ReadSeekCloser {
+	raw := &bytes.Buffer{}
+	for i := 0; i < count; i++ {
+		_ = binary.Write(raw, binary.LittleEndian, start+float32(i)*step)
+	}
+	reader, _ := fcio.NewReadSeekCloser(ioutil.NopCloser(raw))
+	return reader
+}
+
+// readSent reads a raw IQ sink file, leaving out the zero fill of any underrun
+func readSent(t *testing.T, fileName string) []float32 {
+	written, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iq := make([]complex64, len(written)/8)
+	if err := binary.Read(bytes.NewReader(written), binary.LittleEndian, iq); err != nil {
+		t.Fatal(err)
+	}
+	var sent []float32
+	for _, s := range iq {
+		if real(s) != 0 {
+			sent = append(sent, real(s))
+		}
+	}
+	return sent
+}
+
+func assertSent(t *testing.T, sent []float32, count int, start, step float32) {
+	if assert.Equal(t, count, len(sent)) {
+		for i, s := range sent {
+			if want := start + float32(i)*step; s != want {
+				t.Fatalf("sample %d, want:%v, got:%v", i, want, s)
+			}
+		}
+	}
+}
+
+func TestSinkTransmitter_EndToEnd(t *testing.T) {
+	before := runtime.NumGoroutine()
+	dir := t.TempDir()
+	sinkFile := filepath.Join(dir, "tx.iq")
+	sink := NewSinkTransmitter(sinkFile)
+	pin := &SimulatedPin{}
+	config := testConfig(sink, pin)
+	config.IdleTimeout = 0
+	config.RampMs = 0
+
+	// two channels with their own frequency and sources, gain falls back to the top level setting on a
+	frequencyA, frequencyB, gainB := 435000000.0, 145900000.0, 0.8
+	config.Channels = map[string]ChannelConfig{
+		"a": {Frequency: &frequencyA},
+		"b": {Frequency: &frequencyB, Gain: &gainB},
+	}
+	s := newService(t, config)
+	if !assert.Len(t, s.channels, 2) {
+		return
+	}
+
+	// non zero samples so the zero fill of any underrun can be told apart
+	s.channels[0].sources.Add(floatSource(9600, 1, 1), fcio.SourceOptions{})
+	s.channels[1].sources.Add(floatSource(4800, -1, -1), fcio.SourceOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var services fcio.Group
+	s.startServices(ctx, &services)
+
+	testutil.WaitFor(t, "key up", 5*time.Second, pin.IsHigh)
+	a, b := sink.Channel(ChannelA), sink.Channel(ChannelB)
+	assert.True(t, a.Enabled)
+	assert.True(t, b.Enabled)
+	assert.Equal(t, float64(435000000), a.CenterFrequency)
+	assert.Equal(t, float64(145900000), b.CenterFrequency)
+	assert.Equal(t, 0.5, a.Gain)
+	assert.Equal(t, 0.8, b.Gain)
+
+	// the gpio drops last once stopped
+	testutil.WaitFor(t, "idle stop", 10*time.Second, func() bool { return !pin.IsHigh() })
+	assert.False(t, sink.IsRunning())
+	assert.Equal(t, 2, pin.Transitions())
+	assert.False(t, sink.Channel(ChannelA).Enabled)
+
+	assertSent(t, readSent(t, sinkFile), 9600, 1, 1)
+	assertSent(t, readSent(t, filepath.Join(dir, "tx_1.iq")), 4800, -1, -1)
+
+	cancel()
+	assert.True(t, s.shutdown(&services, 5*time.Second))
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestShutdown_Unkeys(t *testing.T) {
+	before := runtime.NumGoroutine()
+	sink := NewSinkTransmitter("")
+	pin := &SimulatedPin{}
+	s := newService(t, testConfig(sink, pin))
+	s.channels[0].sources.Add(floatSource(48000, 1, 0), fcio.SourceOptions{})
+
+	// Run, stopped while still transmitting, ramps out, stops streaming and drops the gpio
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+	testutil.WaitFor(t, "key up", 5*time.Second, pin.IsHigh)
+
+	cancel()
+	assert.NoError(t, <-result)
+	assert.False(t, sink.IsRunning())
+	assert.False(t, pin.IsHigh())
+	assert.Equal(t, 2, pin.Transitions())
+	assert.False(t, s.interlock.Status(time.Now()).Transmitting)
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestShutdown_DuringCalibration(t *testing.T) {
+	before := runtime.NumGoroutine()
+	sink := NewSinkTransmitter("")
+	pin := &SimulatedPin{}
+	config := testConfig(sink, pin)
+	config.CalibrationDelay = 60
+	s := newService(t, config)
+	s.channels[0].sources.Add(floatSource(48000, 1, 0), fcio.SourceOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var services fcio.Group
+	s.startServices(ctx, &services)
+	testutil.WaitFor(t, "configure", 5*time.Second, func() bool { return sink.Channel(ChannelA).Enabled })
+
+	cancel()
+	assert.True(t, s.shutdown(&services, 5*time.Second), "calibration delay is cut short")
+	assert.Equal(t, 0, pin.Transitions(), "never keyed")
+	assert.False(t, sink.Channel(ChannelA).Enabled)
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestNew_Errors(t *testing.T) {
+	config := DefaultConfig()
+	config.Backend = "pluto"
+	_, err := New(config)
+	assert.Error(t, err, "unknown backend")
+
+	config.Backend = "sink"
+	config.File = filepath.Join(t.TempDir(), "missing.iq")
+	_, err = New(config)
+	assert.Error(t, err, "missing file")
+}
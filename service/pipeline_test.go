@@ -0,0 +1,256 @@
+package service_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/fcio/tlstest"
+	"github.com/funcube-dev/go/fclib"
+	"github.com/funcube-dev/go/internal/testutil"
+	"github.com/funcube-dev/go/service/decode"
+	"github.com/funcube-dev/go/service/encode"
+	"github.com/funcube-dev/go/service/limetx"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDongle decode.DongleLibrary that is always present and decodes whatever it is handed
+type fakeDongle struct {
+	mu      sync.Mutex
+	started bool
+	ready   func(event fclib.DecodeEvent)
+}
+
+func (fd *fakeDongle) DongleInitialize() bool                 { return true }
+func (fd *fakeDongle) DongleExists() bool                     { return true }
+func (fd *fakeDongle) DongleGetFrequency() uint32             { return 145860000 }
+func (fd *fakeDongle) DongleSetFrequency(uint32) bool         { return true }
+func (fd *fakeDongle) DongleBiasT(bool)                       {}
+func (fd *fakeDongle) DongleShutdown()                        {}
+func (fd *fakeDongle) DecodeInitialize() bool                 { return true }
+func (fd *fakeDongle) DecodeListDevices() []fclib.AudioDevice { return nil }
+func (fd *fakeDongle) DecodeSetWorkerCount(uint32) bool       { return true }
+func (fd *fakeDongle) DecodeExcludePeaks([]float32) bool      { return true }
+func (fd *fakeDongle) DecodeFftOutput() []float32             { return []float32{1} }
+func (fd *fakeDongle) DecodeShutdown()                        {}
+func (fd *fakeDongle) DecodeWorkerPeaks() []float32           { return nil }
+func (fd *fakeDongle) DecodeWorkerAvailability() []uint       { return nil }
+func (fd *fakeDongle) DecodeStreamSamples(capacity int) *fclib.SampleStream {
+	return fclib.NewSampleStream(capacity)
+}
+func (fd *fakeDongle) DecodeSetCallback(ready func(event fclib.DecodeEvent)) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.ready = ready
+}
+func (fd *fakeDongle) DecodeStart(audioIn, audioOut int) bool {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.started = true
+	return true
+}
+func (fd *fakeDongle) DecodeIsStarted() bool {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return fd.started
+}
+func (fd *fakeDongle) DecodeStop() {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.started = false
+}
+
+// decode hands the frame to the callback once the decoder has started, as the library would
+func (fd *fakeDongle) decode(frame []byte) bool {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if !fd.started || fd.ready == nil {
+		return false
+	}
+	fd.ready(fclib.DecodeEvent{Time: time.Now(), Worker: -1, Data: frame})
+	return true
+}
+
+// echoEncoder encode.Encoder whose samples are the frame itself
+type echoEncoder struct {
+	mu      sync.Mutex
+	pending []byte
+}
+
+func (ee *echoEncoder) Initialize() int  { return 1 }
+func (ee *echoEncoder) Shutdown()        {}
+func (ee *echoEncoder) CanCollect() bool { return !ee.AllDataCollected() }
+func (ee *echoEncoder) PushData(frame []byte) {
+	ee.mu.Lock()
+	defer ee.mu.Unlock()
+	ee.pending = append(ee.pending, frame...)
+}
+func (ee *echoEncoder) AllDataCollected() bool {
+	ee.mu.Lock()
+	defer ee.mu.Unlock()
+	return len(ee.pending) == 0
+}
+func (ee *echoEncoder) CollectSamples(buf []byte) int {
+	ee.mu.Lock()
+	defer ee.mu.Unlock()
+	n := copy(buf, ee.pending)
+	ee.pending = ee.pending[n:]
+	return n
+}
+
+func freePort(t *testing.T) int {
+	lsock, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsock.Close()
+	return lsock.Addr().(*net.TCPAddr).Port
+}
+
+// TestPipeline a frame decoded by fcdecode is encoded by fcencode and transmitted by limetx, the
+// encoder echoes the frame so the samples transmitted are the frame read as float32 LE
+func TestPipeline(t *testing.T) {
+	testPipeline(t, "127.0.0.1", fcio.TLSConfig{}, nil)
+}
+
+// TestPipeline_IPv6 the services connected over the IPv6 loopback
+func TestPipeline_IPv6(t *testing.T) {
+	lsock, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	lsock.Close()
+	testPipeline(t, "::1", fcio.TLSConfig{}, nil)
+}
+
+// TestPipeline_Unix the services connected through unix sockets in a directory
+func TestPipeline_Unix(t *testing.T) {
+	testPipeline(t, "unix://"+t.TempDir(), fcio.TLSConfig{}, nil)
+}
+
+// TestPipeline_Pipe the services connected through in-process pipes, as when embedded in one binary
+func TestPipeline_Pipe(t *testing.T) {
+	testPipeline(t, "pipe://pipeline", fcio.TLSConfig{}, nil)
+}
+
+// TestPipeline_TLS each link uses mutual TLS, a sender without a certificate can't make limetx transmit
+func TestPipeline_TLS(t *testing.T) {
+	files := tlstest.Write(t, t.TempDir())
+	config := fcio.TLSConfig{Cert: files.ServerCert, Key: files.ServerKey, CA: files.CA}
+	testPipeline(t, "127.0.0.1", config, func(samplePort int, pin *limetx.SimulatedPin) {
+		var c net.Conn
+		testutil.WaitFor(t, "limetx listening", 5*time.Second, func() bool {
+			var err error
+			c, err = net.Dial("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(samplePort)))
+			return err == nil
+		})
+		c.Write(make([]byte, 4096))
+		c.Close()
+		time.Sleep(500 * time.Millisecond)
+		assert.Equal(t, 0, pin.Transitions(), "plaintext samples transmitted")
+	})
+}
+
+// testPipeline runs the pipeline on host with the TLS config on every link, intrude is called with
+// the limetx sample port once it is listening, before the frame is decoded
+func testPipeline(t *testing.T, host string, tls fcio.TLSConfig, intrude func(samplePort int, pin *limetx.SimulatedPin)) {
+	before := runtime.NumGoroutine()
+	dataPort, samplePort := freePort(t), freePort(t)
+
+	txConfig := limetx.DefaultConfig()
+	txConfig.CalibrationDelay = 0
+	txConfig.IdleTimeout = 0
+	txConfig.Prebuffer = 0.05
+	txConfig.RampMs = 0
+	txConfig.PTTLead = 0
+	txConfig.PTTTail = 0
+	txConfig.BindAddress = host
+	txConfig.SamplePort = samplePort
+	txConfig.CommandPort = freePort(t)
+	txConfig.Backend = "sink"
+	txConfig.SinkFile = filepath.Join(t.TempDir(), "tx.iq")
+	txConfig.TLS = tls
+	pin := &limetx.SimulatedPin{}
+	txConfig.PTT = pin
+	transmitter, err := limetx.New(txConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encConfig := encode.DefaultConfig()
+	encConfig.LimeTxServer = host
+	encConfig.LimeTxPort = samplePort
+	encConfig.BindAddress = host
+	encConfig.DataPort = dataPort
+	encConfig.CommandPort = freePort(t)
+	encConfig.Encoder = &echoEncoder{}
+	encConfig.TLS = tls
+	encoder, err := encode.New(encConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decConfig := decode.DefaultConfig()
+	decConfig.ConnectAddress = host
+	decConfig.ConnectPort = dataPort
+	decConfig.BindAddress = host
+	decConfig.CommandPort = freePort(t)
+	dongle := &fakeDongle{}
+	decConfig.Library = dongle
+	decConfig.TLS = tls
+	decoder, err := decode.New(decConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopTransmitter := testutil.Run(transmitter)
+	stopEncoder := testutil.Run(encoder)
+	stopDecoder := testutil.Run(decoder)
+	if intrude != nil {
+		intrude(samplePort, pin)
+	}
+
+	frame := &bytes.Buffer{}
+	for i := 1; i <= 64; i++ {
+		_ = binary.Write(frame, binary.LittleEndian, float32(i))
+	}
+	testutil.WaitFor(t, "decoder started", 5*time.Second, func() bool { return dongle.decode(frame.Bytes()) })
+
+	// keyed up for the frame then down again once idle
+	testutil.WaitFor(t, "transmitted", 10*time.Second, func() bool { return pin.Transitions() == 2 })
+
+	assert.NoError(t, stopDecoder())
+	assert.NoError(t, stopEncoder())
+	assert.NoError(t, stopTransmitter())
+
+	written, err := ioutil.ReadFile(txConfig.SinkFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var sent []float32
+	for i := 0; i+8 <= len(written); i += 8 {
+		if s := math.Float32frombits(binary.LittleEndian.Uint32(written[i:])); s != 0 {
+			sent = append(sent, s)
+		}
+	}
+	if assert.Len(t, sent, 64) {
+		for i, s := range sent {
+			assert.Equal(t, float32(i+1), s)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "goroutines leaked")
+}
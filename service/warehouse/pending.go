@@ -0,0 +1,80 @@
+package warehouse
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/funcube-dev/go/fcio"
+)
+
+// savePending appends the frame being sent and any still waiting in dataChan to the pending file,
+// they are sent at the next start
+func (s *Service) savePending(frame *Frame) {
+	var pending [][]byte
+	if frame != nil {
+		pending = append(pending, frame.data)
+	}
+	for {
+		select {
+		case frameData, ok := <-s.dataChan:
+			if ok {
+				pending = append(pending, frameData)
+				continue
+			}
+		default:
+		}
+		break
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	fileName := s.config.PendingFile
+	if len(fileName) == 0 {
+		log.Printf("Dropping %d unsent frames, set pendingfile to keep them", len(pending))
+		return
+	}
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Failed to open pending file %s, dropping %d unsent frames error:%v", fileName, len(pending), err)
+		return
+	}
+	for _, frameData := range pending {
+		if _, err = f.Write(frameData); err != nil {
+			break
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Failed to write pending file %s error:%v", fileName, err)
+		return
+	}
+	log.Printf("Saved %d unsent frames to %s", len(pending), fileName)
+}
+
+// queuePending queues the frames saved by the last shutdown, the file is removed so they are only sent once
+func (s *Service) queuePending() error {
+	fileName := s.config.PendingFile
+	if len(fileName) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read pending file %s error:%v", fileName, err)
+	}
+	if err = os.Remove(fileName); err != nil {
+		return fmt.Errorf("failed to remove pending file %s error:%v", fileName, err)
+	}
+	// ahead of the file and any connections
+	s.sources.Add(ioutil.NopCloser(bytes.NewReader(data)), fcio.SourceOptions{Name: fileName, Priority: 1})
+	log.Printf("Queued %d unsent frames from %s", len(data)/frameSize, fileName)
+	return nil
+}
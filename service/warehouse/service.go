@@ -0,0 +1,469 @@
+// Package warehouse uploads 256 byte FUNcube frames to the data warehouse, frames are read from
+// a file or from connections on the data port
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/funcube-dev/go/fcio"
+	"github.com/funcube-dev/go/fcstore"
+	"github.com/gin-gonic/gin"
+)
+
+// Config settings for the warehouse service, the koanf tags match the fcwarehouse flags
+type Config struct {
+	SiteID           string  `koanf:"siteid"`
+	AuthCode         string  `koanf:"authcode"`
+	IgnoreCheck      bool    `koanf:"ignorecheck"`
+	URL              string  `koanf:"url"`
+	RetryAttempts    int     `koanf:"retryattempts"`
+	RetryWaitSeconds int     `koanf:"retrywaitseconds"`
+	BindAddress      string  `koanf:"bindaddress"`
+	DataPort         int     `koanf:"dataport"`
+	CommandPort      int     `koanf:"commandport"`
+	File             string  `koanf:"file"`
+	PendingFile      string  `koanf:"pendingfile"`
+	ShutdownTimeout  float64 `koanf:"shutdowntimeout"`
+	DedupeWindow     float64 `koanf:"dedupewindow"`
+
+	// Store records every frame queued and whether it was uploaded, frames it has still to upload
+	// are queued at startup when there is no pendingfile, none without a path
+	Store fcstore.Config `koanf:"store"`
+
+	// TLS for the data and command listeners
+	TLS fcio.TLSConfig `koanf:"tls"`
+}
+
+// DefaultConfig the settings fcwarehouse uses when none are given
+func DefaultConfig() Config {
+	return Config{
+		URL:              "http://data.amsat-uk.org/",
+		RetryAttempts:    -1,
+		RetryWaitSeconds: 60,
+		BindAddress:      "0.0.0.0",
+		DataPort:         0xFC06,
+		CommandPort:      0xFC07,
+		ShutdownTimeout:  5,
+		Store:            fcstore.Config{MaxAge: 30},
+	}
+}
+
+// Service reads frames from its sources and uploads them to the warehouse
+type Service struct {
+	config    Config
+	tlsServer *tls.Config
+	sources   *fcio.SourceQueue
+	dedupe    *fcio.Dedupe
+	store     *fcstore.Store
+	dataChan  chan []byte
+}
+
+// storeDestination the frames' uploads are recorded as in the store
+const storeDestination = "warehouse"
+
+// New creates the service, queuing the configured file and any frames left unsent by the last shutdown
+func New(config Config) (*Service, error) {
+	tlsServer, err := config.TLS.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		config:    config,
+		tlsServer: tlsServer,
+		sources:   fcio.NewSourceQueue(frameSize, frameSize, fcio.Fair),
+		dataChan:  make(chan []byte, 64),
+	}
+	if config.DedupeWindow > 0 {
+		s.dedupe = fcio.NewDedupe(seconds(config.DedupeWindow), nil, func(frame []byte, errors int, value interface{}) { s.queueFrame(frame) })
+	}
+
+	fileName := config.File
+	if len(fileName) > 0 {
+		fcbinfile, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file %s error:%v", fileName, err)
+		}
+		s.sources.Add(fcbinfile, fcio.SourceOptions{Name: fileName})
+	}
+	if err := s.queuePending(); err != nil {
+		s.sources.Close()
+		return nil, err
+	}
+	if config.Store.Path != "" {
+		storeConfig := config.Store
+		if storeConfig.Station == "" {
+			storeConfig.Station = config.SiteID
+		}
+		if s.store, err = fcstore.Open(storeConfig); err != nil {
+			s.sources.Close()
+			return nil, err
+		}
+		if config.PendingFile == "" {
+			s.queueStored()
+		}
+	}
+	return s, nil
+}
+
+// Run uploads frames until ctx is done, then stops reading and carries on sending the frames
+// already read until the shutdown timeout, any left are saved to the pending file
+func (s *Service) Run(ctx context.Context) error {
+	drainCtx, abandon := context.WithCancel(context.Background())
+	defer abandon()
+
+	var inputs, senders fcio.Group
+	senders.Go(func() { s.sendData(drainCtx) })
+	inputs.Go(func() { s.readData(ctx) })
+	if s.dedupe != nil {
+		inputs.Go(func() { s.dedupe.Run(ctx) })
+	}
+	inputs.Go(func() { s.listen(ctx) })
+	inputs.Go(func() { s.serveAPI(ctx) })
+	if s.store != nil {
+		defer s.store.Close()
+		inputs.Go(func() { s.store.Run(ctx, time.Hour) })
+	}
+
+	<-ctx.Done()
+	if !fcio.Drain(seconds(s.config.ShutdownTimeout), time.Second, abandon, &inputs, &senders) {
+		return errors.New("shutdown timed out")
+	}
+	return nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// CredentialsOk checks the site id and auth code are accepted by the warehouse
+func (s *Service) CredentialsOk() bool {
+	baseURL := s.config.URL
+	siteID := s.config.SiteID
+	authCode := s.config.AuthCode
+
+	if siteID == "" || authCode == "" {
+		log.Printf("Warehouse Credentials check failed, missing authcode and site id, please see:\n\nhttp://warehouse.funcube.org.uk/registration\n\n")
+		return false
+	}
+
+	// dont use the NewFrame method as that checks for valid data sizes, we need a zero length data frame.
+	frame := &Frame{[]byte{}, 0}
+	digest, _ := frame.GetWarehouseDigest(authCode)
+
+	warehouseURL := baseURL + "api/data/hex/" + siteID + "/?digest=" + digest
+
+	resp, err := http.Get(warehouseURL)
+	if err != nil {
+		log.Printf("Warehouse Credentials check failed, cannot connect to: %s (%+v)\n", warehouseURL, err)
+		return false
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		log.Print("Warehouse Credentials check failed, please ensure you have configured the correct values for siteid and authcode\n")
+		return false
+	}
+	if resp.StatusCode >= 400 {
+		log.Printf("Warehouse Credentials check failed, error code getting: %s (%d)\n", warehouseURL, resp.StatusCode)
+		return false
+	}
+	return true
+}
+
+// readData takes frames from the sources until the context is done, then closes the sources
+// and dataChan so the sender knows it has everything
+func (s *Service) readData(ctx context.Context) {
+	defer close(s.dataChan)
+	defer s.sources.Close()
+	for {
+		raw, ok := s.sources.Next(ctx)
+		if !ok {
+			break
+		}
+
+		fmt.Printf("<")
+		// the stations send no error counts, the first copy is kept
+		if s.dedupe != nil {
+			s.dedupe.Add(raw, 0, nil)
+			continue
+		}
+		s.queueFrame(raw)
+	}
+	if s.dedupe != nil {
+		s.dedupe.Flush()
+		log.Printf("Suppressed %d duplicate frames", s.dedupe.Stats().Suppressed)
+	}
+}
+
+// queueFrame stores and queues a frame for sending, dropping it if the sender has fallen behind
+func (s *Service) queueFrame(raw []byte) {
+	s.storeFrame(raw)
+	select {
+	case s.dataChan <- raw:
+	default:
+		fmt.Println(" Discarded send channel full.")
+		s.markUpload(raw, fcstore.Failed)
+	}
+}
+
+// storeFrame records the frame as waiting to upload, unless it is one queued from the store
+func (s *Service) storeFrame(raw []byte) {
+	if s.store == nil {
+		return
+	}
+	if record, ok := s.store.Find(raw); ok && record.Uploads[storeDestination] == fcstore.Pending {
+		return
+	}
+	_, err := s.store.Add(fcstore.Record{
+		Errors:  -1,
+		Uploads: map[string]fcstore.UploadStatus{storeDestination: fcstore.Pending},
+		Frame:   raw,
+	})
+	if err != nil {
+		log.Printf("Failed to store frame: %v", err)
+	}
+}
+
+func (s *Service) markUpload(raw []byte, status fcstore.UploadStatus) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.SetFrameUpload(raw, storeDestination, status); err != nil {
+		log.Printf("Failed to store upload status: %v", err)
+	}
+}
+
+// queueStored queues the frames the store has still to upload, ahead of the file and any connections
+func (s *Service) queueStored() {
+	var data []byte
+	for _, record := range s.store.Query(fcstore.Query{Destination: storeDestination, Status: fcstore.Pending}) {
+		data = append(data, record.Frame...)
+	}
+	if len(data) == 0 {
+		return
+	}
+	s.sources.Add(ioutil.NopCloser(bytes.NewReader(data)), fcio.SourceOptions{Name: s.config.Store.Path, Priority: 1})
+	log.Printf("Queued %d unsent frames from the store", len(data)/frameSize)
+}
+
+// sendData sends frames to the warehouse until dataChan is closed and empty, when the context
+// is done first the unsent frames are saved to the pending file
+func (s *Service) sendData(ctx context.Context) {
+	log.Println("Ready to Send...")
+
+	var frame *Frame
+	var err error
+	for {
+		if ctx.Err() != nil {
+			s.savePending(frame)
+			return
+		}
+
+		retryAttempts := s.config.RetryAttempts
+		retryWaitSeconds := s.config.RetryWaitSeconds
+
+		// not nil so must have been tried already, decrement retries and check it
+		if frame != nil {
+			frame.DecrementRetry()
+			if frame.CanRetry() {
+				// wait before retrying frame
+				log.Printf("Retry waiting: %d  attempts remaining: %d of %d, backlog: %d\n", retryWaitSeconds, frame.RemainingRetry(), retryAttempts, len(s.dataChan))
+				if !fcio.Sleep(ctx, time.Duration(retryWaitSeconds)*time.Second) {
+					continue
+				}
+			} else {
+				// discard frame
+				s.markUpload(frame.data, fcstore.Failed)
+				frame = nil
+				fmt.Printf("x")
+			}
+		}
+
+		// if we haven't got a frame, try and get one
+		if frame == nil {
+			select {
+			case frameData, ok := <-s.dataChan:
+				if !ok {
+					log.Println("All frames sent")
+					return
+				}
+				frame, err = NewFrame(frameData, retryAttempts)
+				if err != nil {
+					frame = nil // if NewFrame returns an error, ensure frame will be nil
+					log.Printf("Failed to create frame: (%+v)\n", err)
+					continue
+				}
+			case <-ctx.Done():
+				continue
+			case <-time.After(time.Millisecond * 500):
+				fmt.Printf("*")
+				continue
+			}
+		}
+
+		if err := Upload(ctx, s.config.URL, s.config.SiteID, s.config.AuthCode, frame); err != nil {
+			log.Println(err)
+			continue
+		}
+
+		fmt.Printf(">")
+		s.markUpload(frame.data, fcstore.Uploaded)
+		// success clear the current frame so new one is retrieved
+		frame = nil
+	}
+}
+
+// Upload submits the frame to the warehouse at baseURL as the site, an error if it was not accepted
+func Upload(ctx context.Context, baseURL, siteID, authCode string, frame *Frame) error {
+	digest, err := frame.GetWarehouseDigest(authCode)
+	if err != nil {
+		return fmt.Errorf("Failed to get digest: (%+v)", err)
+	}
+
+	warehouseURL := baseURL + "api/data/hex/" + siteID + "/?digest=" + digest
+
+	req, err := http.NewRequest("POST", warehouseURL, frame.GetWarehousePayload())
+	if err != nil {
+		return fmt.Errorf("Failed to create request for: %s (%+v)", warehouseURL, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Failed to connect to: %s (%+v)", warehouseURL, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Error code sending to: %s (%d)", warehouseURL, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *Service) listen(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.DataPort))
+	log.Println("Opening listen socket...")
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer lsock.Close()
+	defer fcio.CloseWhenDone(ctx, lsock)()
+	log.Printf("Listening on socket: %s", hostport)
+
+	for {
+		c, err := lsock.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		s.handleConnection(c)
+	}
+}
+
+// Response wraps the data served by the api
+type Response struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// serveAPI serves the api on the command port until ctx is done
+func (s *Service) serveAPI(ctx context.Context) {
+	hostport := fcio.JoinAddress(s.config.BindAddress, strconv.Itoa(s.config.CommandPort))
+	log.Println("Opening command listen socket...")
+
+	lsock, err := fcio.Listen("tcp", hostport, s.tlsServer)
+	if err != nil {
+		log.Printf("Command listen socket failed: %v", err)
+		return
+	}
+	server := &http.Server{Handler: s.router()}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(lsock) }()
+
+	select {
+	case err := <-served:
+		log.Printf("Command listen socket failed: %v", err)
+		return
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to stop command listen socket: %v", err)
+	}
+	<-served
+}
+
+func (s *Service) router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Logger())
+	r.Use(gin.Recovery())
+
+	apiv1 := r.Group("/api/v1")
+	{
+		apiv1.GET("/store", func(c *gin.Context) {
+			if s.store == nil {
+				c.JSON(404, Response{Error: "no store configured"})
+				return
+			}
+			c.JSON(200, Response{
+				Data: s.store.Stats(),
+			})
+		})
+		apiv1.GET("/frames", func(c *gin.Context) {
+			if s.store == nil {
+				c.JSON(404, Response{Error: "no store configured"})
+				return
+			}
+			query, err := fcstore.ParseQuery(c.Request.URL.Query())
+			if err != nil {
+				c.JSON(400, Response{Error: err.Error()})
+				return
+			}
+			c.JSON(200, Response{
+				Data: s.store.Query(query),
+			})
+		})
+		apiv1.GET("/frames/:id", func(c *gin.Context) {
+			if s.store == nil {
+				c.JSON(404, Response{Error: "no store configured"})
+				return
+			}
+			id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+			if err != nil {
+				c.JSON(400, Response{Error: "invalid id"})
+				return
+			}
+			record, ok := s.store.Get(id)
+			if !ok {
+				c.JSON(404, Response{Error: "no such frame"})
+				return
+			}
+			c.JSON(200, Response{
+				Data: record,
+			})
+		})
+	}
+	return r
+}
+
+// handleConnection reads the frames of a connection alongside the other sources, it is dropped
+// after a second without data
+func (s *Service) handleConnection(c net.Conn) {
+	log.Printf("Connection from: %s", c.RemoteAddr().String())
+	if err := s.sources.Add(c, fcio.SourceOptions{Name: c.RemoteAddr().String(), ReadTimeout: time.Second}); err != nil {
+		log.Printf("Failed to read connection, ignoring error:%v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package warehouse
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/funcube-dev/go/fcstore"
+	"github.com/funcube-dev/go/internal/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfig uploads to url from a file of the frames, listening on loopback ephemeral ports
+func testConfig(t *testing.T, url string, frames []byte) Config {
+	file := testutil.TempFile(t, "frames.bin", frames)
+	config := DefaultConfig()
+	config.URL = url + "/"
+	config.SiteID = "test"
+	config.AuthCode = "secret"
+	config.BindAddress = "127.0.0.1"
+	config.DataPort = 0
+	config.CommandPort = 0
+	config.File = file
+	config.PendingFile = filepath.Join(t.TempDir(), "pending.bin")
+	return config
+}
+
+// runService runs the service until the returned stop is called, which returns the Run result
+func runService(t *testing.T, config Config) (*Service, func() error) {
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, testutil.Run(s)
+}
+
+func testFrames(count int) []byte {
+	var frames []byte
+	for i := 0; i < count; i++ {
+		frames = append(frames, bytes.Repeat([]byte{byte(i + 1)}, frameSize)...)
+	}
+	return frames
+}
+
+func TestShutdown_SavesPending(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var posts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	frames := testFrames(3)
+	config := testConfig(t, server.URL, frames)
+	config.ShutdownTimeout = 0.1
+	s, stop := runService(t, config)
+	testutil.WaitFor(t, "first post", 5*time.Second, func() bool { return atomic.LoadInt32(&posts) > 0 })
+	testutil.WaitFor(t, "frames read", 5*time.Second, func() bool { return len(s.dataChan) == 2 })
+
+	start := time.Now()
+	assert.NoError(t, stop())
+	assert.True(t, time.Since(start) < 2*time.Second, "retry wait is abandoned at the deadline")
+
+	saved, err := ioutil.ReadFile(config.PendingFile)
+	assert.NoError(t, err)
+	assert.Equal(t, frames, saved, "frame being retried first then the backlog")
+	server.Close()
+	testutil.AssertNoLeaks(t, before)
+
+	// next start sends them first and only once
+	config.File = ""
+	s, err = New(config)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, s.sources.Len())
+	_, err = ioutil.ReadFile(config.PendingFile)
+	assert.Error(t, err, "pending file removed once queued")
+	s.sources.Close()
+}
+
+func TestShutdown_DrainsFrames(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var posts int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		atomic.AddInt32(&posts, 1)
+	}))
+	defer server.Close()
+
+	// stop while the first frame is being sent and the others are waiting
+	config := testConfig(t, server.URL, testFrames(3))
+	s, stop := runService(t, config)
+	testutil.WaitFor(t, "frames read", 5*time.Second, func() bool { return len(s.dataChan) == 2 })
+	stopped := make(chan error)
+	go func() { stopped <- stop() }()
+	close(release)
+	assert.NoError(t, <-stopped)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&posts), "frames already read are sent before stopping")
+	_, err := ioutil.ReadFile(config.PendingFile)
+	assert.Error(t, err, "nothing left to save")
+	server.Close()
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestRun_Dedupe(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var posts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+	}))
+	defer server.Close()
+
+	// the same frames from two stations
+	frames := testFrames(2)
+	config := testConfig(t, server.URL, append(frames, frames...))
+	config.DedupeWindow = 0.1
+	s, stop := runService(t, config)
+	testutil.WaitFor(t, "frames sent", 5*time.Second, func() bool { return atomic.LoadInt32(&posts) == 2 })
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, stop())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&posts), "each sent once")
+	assert.Equal(t, uint64(2), s.dedupe.Stats().Suppressed)
+	server.Close()
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestRun_Store(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	before := runtime.NumGoroutine()
+
+	var failing int32 = 1
+	var posts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	// the warehouse is down, the frames stay waiting in the store
+	frames := testFrames(2)
+	config := testConfig(t, server.URL, frames)
+	config.PendingFile = ""
+	config.ShutdownTimeout = 0.1
+	config.Store.Path = filepath.Join(t.TempDir(), "frames.log")
+	s, stop := runService(t, config)
+	testutil.WaitFor(t, "first post", 5*time.Second, func() bool { return atomic.LoadInt32(&posts) > 0 })
+	testutil.WaitFor(t, "frames stored", 5*time.Second, func() bool { return s.store.Stats().Frames == 2 })
+	assert.NoError(t, stop())
+
+	// the next start sends them from the store, once
+	atomic.StoreInt32(&failing, 0)
+	config.File = ""
+	s, stop = runService(t, config)
+	testutil.WaitFor(t, "frames sent", 5*time.Second, func() bool {
+		return len(s.store.Query(fcstore.Query{Status: fcstore.Uploaded})) == 2
+	})
+
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/frames?destination=warehouse&status=uploaded", nil))
+	var response struct {
+		Data []fcstore.Record `json:"data"`
+	}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response)) && assert.Len(t, response.Data, 2) {
+		assert.Equal(t, "test", response.Data[0].Station)
+		assert.Equal(t, -1, response.Data[0].Errors)
+		assert.Equal(t, frames[:frameSize], response.Data[0].Frame)
+	}
+	assert.NoError(t, stop())
+	assert.Equal(t, 2, s.store.Stats().Frames, "not stored again")
+	server.Close()
+	testutil.AssertNoLeaks(t, before)
+}
+
+func TestNew_MissingFile(t *testing.T) {
+	config := DefaultConfig()
+	config.File = filepath.Join(t.TempDir(), "missing.bin")
+	_, err := New(config)
+	assert.Error(t, err)
+}